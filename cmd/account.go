@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/internal/config"
+	"github.com/spf13/cobra"
+)
+
+const rekeyRecoveryFile = "master.key.rekey"
+
+var accountRekeyNewKey string
+
+var accountCmd = &cobra.Command{
+	Use:   "account",
+	Short: "账号存储管理",
+}
+
+var accountRekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "轮换主密钥并重新加密所有账号 (请先停止 serve)",
+	Args:  cobra.NoArgs,
+	RunE:  runAccountRekey,
+}
+
+func init() {
+	rootCmd.AddCommand(accountCmd)
+	accountCmd.AddCommand(accountRekeyCmd)
+	accountRekeyCmd.Flags().StringVar(&accountRekeyNewKey, "new-key", "", "新主密钥 (base64 编码的 32 字节，默认随机生成)")
+}
+
+// openAccountManager opens the configured storage with secrets encrypted
+// under the resolved master key.
+func openAccountManager(cfg *config.Config) (*account.Manager, error) {
+	key, err := resolveMasterKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	manager, err := account.OpenManager(cfg.Storage, cfg.DataDir, key)
+	if err != nil {
+		return nil, fmt.Errorf("open account storage: %w", err)
+	}
+	return manager, nil
+}
+
+func resolveMasterKey(cfg *config.Config) (*account.MasterKey, error) {
+	return account.ResolveMasterKey(account.MasterKeyOptions{
+		Key:     cfg.MasterKey,
+		File:    cfg.MasterKeyFile,
+		DataDir: cfg.DataDir,
+	})
+}
+
+func runAccountRekey(cmd *cobra.Command, _ []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	current, err := resolveMasterKey(cfg)
+	if err != nil {
+		return err
+	}
+	var next *account.MasterKey
+	if encoded := strings.TrimSpace(accountRekeyNewKey); encoded != "" {
+		next, err = account.ParseMasterKey(encoded)
+	} else {
+		next, err = account.GenerateMasterKey()
+	}
+	if err != nil {
+		return err
+	}
+	if next.ID() == current.ID() {
+		return fmt.Errorf("rekey: new key equals the current key")
+	}
+
+	// Keep the new key on disk until it has replaced the current one, so an
+	// interrupted rekey can be resumed with --new-key.
+	recovery := filepath.Join(cfg.DataDir, rekeyRecoveryFile)
+	if err := os.MkdirAll(cfg.DataDir, 0o700); err != nil {
+		return fmt.Errorf("rekey: %w", err)
+	}
+	if err := os.WriteFile(recovery, []byte(next.Encoded()+"\n"), 0o600); err != nil {
+		return fmt.Errorf("rekey: save recovery key: %w", err)
+	}
+
+	store, err := account.OpenStore(cfg.Storage, cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("open account storage: %w", err)
+	}
+	defer store.Close()
+
+	count, err := account.Rekey(store, current, next)
+	if err != nil {
+		return fmt.Errorf("%w (new key saved in %s, rerun with --new-key to resume)", err, recovery)
+	}
+
+	out := cmd.OutOrStdout()
+	if err := current.Replace(next); err != nil {
+		if !errors.Is(err, account.ErrMasterKeyFromEnv) {
+			return fmt.Errorf("%w (accounts use the key saved in %s)", err, recovery)
+		}
+		fmt.Fprintf(out, "Set IFLOW_MASTER_KEY=%s before starting serve again.\n", next.Encoded())
+	}
+	if err := os.Remove(recovery); err != nil {
+		return fmt.Errorf("rekey: remove recovery key: %w", err)
+	}
+
+	fmt.Fprintf(out, "Rekeyed %d account(s): master key %s -> %s (%s).\n", count, current.ID(), next.ID(), next.Source())
+	return nil
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+)
+
+func TestAccountRekey(t *testing.T) {
+	dataDir := t.TempDir()
+	keyFile := filepath.Join(dataDir, "custom.key")
+	t.Setenv("IFLOW_DATA_DIR", dataDir)
+	t.Setenv("IFLOW_MASTER_KEY_FILE", keyFile)
+
+	manager, err := newAccountManager()
+	if err != nil {
+		t.Fatalf("open account manager: %v", err)
+	}
+	acct, err := manager.Create("sk-rekey", "")
+	if err != nil {
+		t.Fatalf("create account: %v", err)
+	}
+	before, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatalf("read key file: %v", err)
+	}
+
+	out, err := executeForTest("account", "rekey")
+	if err != nil {
+		t.Fatalf("account rekey error: %v", err)
+	}
+	if !strings.Contains(out, "Rekeyed 1 account(s)") || !strings.Contains(out, keyFile) {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	after, _ := os.ReadFile(keyFile)
+	if string(after) == string(before) {
+		t.Fatal("key file was not replaced")
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, rekeyRecoveryFile)); !os.IsNotExist(err) {
+		t.Fatalf("recovery key should be removed, stat error = %v", err)
+	}
+
+	reopened, err := newAccountManager()
+	if err != nil {
+		t.Fatalf("reopen account manager: %v", err)
+	}
+	got, err := reopened.Get(acct.UUID)
+	if err != nil {
+		t.Fatalf("get account with new key: %v", err)
+	}
+	if got.APIKey != "sk-rekey" {
+		t.Fatalf("api key = %q, want sk-rekey", got.APIKey)
+	}
+}
+
+func TestAccountRekeyEnvKey(t *testing.T) {
+	dataDir := t.TempDir()
+	current, err := account.GenerateMasterKey()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	t.Setenv("IFLOW_DATA_DIR", dataDir)
+	t.Setenv("IFLOW_MASTER_KEY", current.Encoded())
+
+	next, _ := account.GenerateMasterKey()
+	out, err := executeForTest("account", "rekey", "--new-key", next.Encoded())
+	accountRekeyNewKey = ""
+	if err != nil {
+		t.Fatalf("account rekey error: %v", err)
+	}
+	if !strings.Contains(out, "Set IFLOW_MASTER_KEY="+next.Encoded()) {
+		t.Fatalf("output should tell the operator to update the env key: %s", out)
+	}
+}
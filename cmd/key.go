@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/internal/apikey"
+	"github.com/rogeecn/iflow-go/internal/config"
+	"github.com/rogeecn/iflow-go/internal/ratelimit"
+	"github.com/spf13/cobra"
+)
+
+var (
+	keyAccount string
+	keyPool    bool
+	keyLabel   string
+	keyExpires string
+	keyModels  []string
+	keyRoutes  []string
+	keyCIDRs   []string
+	keyLimits  ratelimit.Limits
+)
+
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "客户端 API Key 管理",
+}
+
+var keyCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "签发客户端 API Key (仅显示一次)",
+	Args:  cobra.NoArgs,
+	RunE:  runKeyCreate,
+}
+
+var keyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "列出客户端 API Key",
+	Args:  cobra.NoArgs,
+	RunE:  runKeyList,
+}
+
+var keyRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "吊销客户端 API Key",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runKeyRevoke,
+}
+
+func init() {
+	rootCmd.AddCommand(keyCmd)
+	keyCmd.AddCommand(keyCreateCmd)
+	keyCmd.AddCommand(keyListCmd)
+	keyCmd.AddCommand(keyRevokeCmd)
+	keyCreateCmd.Flags().StringVar(&keyAccount, "account", "", "绑定的账号 UUID")
+	keyCreateCmd.Flags().BoolVar(&keyPool, "pool", false, "绑定账号池 (与 --account 二选一)")
+	keyCreateCmd.Flags().StringVar(&keyLabel, "label", "", "备注")
+	keyCreateCmd.Flags().StringVar(&keyExpires, "expires", "", "过期时间: 时长 (如 720h) 或日期 (YYYY-MM-DD / RFC3339)，默认不过期")
+	keyCreateCmd.Flags().StringSliceVar(&keyModels, "models", nil, "允许的模型，逗号分隔，支持 glm-* 前缀匹配 (默认全部)")
+	keyCreateCmd.Flags().StringSliceVar(&keyRoutes, "routes", nil, "允许的路由，逗号分隔，如 /v1/chat/completions (默认全部)")
+	keyCreateCmd.Flags().StringSliceVar(&keyCIDRs, "cidrs", nil, "允许的客户端 IP 段，逗号分隔，如 10.0.0.0/8 (默认不限制)")
+	addLimitFlags(keyCreateCmd, &keyLimits)
+}
+
+func newKeyStore() (*apikey.Store, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	return apikey.NewStore(cfg.DataDir), nil
+}
+
+func runKeyCreate(cmd *cobra.Command, _ []string) error {
+	expiresAt, err := parseKeyExpiry(keyExpires, time.Now())
+	if err != nil {
+		return err
+	}
+
+	uuid := strings.TrimSpace(keyAccount)
+	if uuid != "" {
+		if !account.IsValidUUID(uuid) {
+			return fmt.Errorf("invalid uuid: %s", uuid)
+		}
+		manager, err := newAccountManager()
+		if err != nil {
+			return err
+		}
+		_, err = manager.Get(uuid)
+		manager.Close()
+		if err != nil {
+			return fmt.Errorf("load account: %w", err)
+		}
+	}
+
+	store, err := newKeyStore()
+	if err != nil {
+		return err
+	}
+	secret, key, err := store.Create(apikey.Options{
+		Label:     keyLabel,
+		Account:   uuid,
+		Pool:      keyPool,
+		Models:    keyModels,
+		Routes:    keyRoutes,
+		CIDRs:     keyCIDRs,
+		Limits:    keyLimits,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Client key created: %s (%s)\n", key.ID, key.Target())
+	fmt.Fprintf(out, "Key: %s\n", secret)
+	fmt.Fprintln(out, "Store it now; it cannot be shown again.")
+	return nil
+}
+
+func runKeyList(cmd *cobra.Command, _ []string) error {
+	store, err := newKeyStore()
+	if err != nil {
+		return err
+	}
+	keys, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	if len(keys) == 0 {
+		fmt.Fprintln(out, "No client keys found.")
+		return nil
+	}
+
+	now := time.Now()
+	fmt.Fprintln(out, "ID\tKEY\tTARGET\tLABEL\tMODELS\tROUTES\tCIDRS\tLIMITS\tEXPIRES_AT\tSTATUS")
+	for _, key := range keys {
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			key.ID,
+			key.Hint,
+			key.Target(),
+			orDash(key.Label),
+			orDash(strings.Join(key.Models, ",")),
+			orDash(strings.Join(key.Routes, ",")),
+			orDash(strings.Join(key.CIDRs, ",")),
+			key.Limits,
+			formatKeyTime(key.ExpiresAt),
+			keyStatus(&key, now),
+		)
+	}
+	return nil
+}
+
+func runKeyRevoke(cmd *cobra.Command, args []string) error {
+	store, err := newKeyStore()
+	if err != nil {
+		return err
+	}
+	key, err := store.Revoke(strings.TrimSpace(args[0]))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Client key revoked: %s\n", key.ID)
+	return nil
+}
+
+// parseKeyExpiry accepts a duration from now, a date or an RFC 3339 time.
+func parseKeyExpiry(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid expires %q: want a duration, YYYY-MM-DD or RFC3339", value)
+}
+
+func keyStatus(key *apikey.Key, now time.Time) string {
+	switch key.Check(now) {
+	case apikey.ErrRevoked:
+		return "revoked"
+	case apikey.ErrExpired:
+		return "expired"
+	default:
+		return "active"
+	}
+}
+
+func formatKeyTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
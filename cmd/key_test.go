@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rogeecn/iflow-go/internal/apikey"
+	"github.com/rogeecn/iflow-go/internal/ratelimit"
+)
+
+func resetKeyFlags() {
+	keyAccount, keyPool, keyLabel, keyExpires = "", false, "", ""
+	keyModels, keyRoutes, keyCIDRs = nil, nil, nil
+	keyLimits = ratelimit.Limits{}
+}
+
+func TestKeyCreateListRevoke(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("IFLOW_DATA_DIR", dataDir)
+	t.Cleanup(resetKeyFlags)
+
+	manager, err := newAccountManager()
+	if err != nil {
+		t.Fatalf("open account manager: %v", err)
+	}
+	acct, err := manager.Create("sk-test", "")
+	manager.Close()
+	if err != nil {
+		t.Fatalf("create account: %v", err)
+	}
+
+	out, err := executeForTest("key", "create", "--account", acct.UUID, "--label", "ci", "--models", "glm-*,kimi-k2", "--expires", "24h", "--rpm", "60", "--daily-tokens", "100000")
+	if err != nil {
+		t.Fatalf("key create error: %v", err)
+	}
+	var secret string
+	for _, line := range strings.Split(out, "\n") {
+		if rest, ok := strings.CutPrefix(line, "Key: "); ok {
+			secret = rest
+		}
+	}
+	if !strings.HasPrefix(secret, apikey.Prefix) {
+		t.Fatalf("key create output missing secret: %s", out)
+	}
+
+	key, err := apikey.NewStore(dataDir).Authenticate(secret)
+	if err != nil {
+		t.Fatalf("authenticate created key: %v", err)
+	}
+	if key.Account != acct.UUID || key.Label != "ci" || len(key.Models) != 2 || key.ExpiresAt.IsZero() || key.Limits.RPM != 60 || key.Limits.DailyTokens != 100000 {
+		t.Fatalf("unexpected key: %+v", key)
+	}
+
+	out, err = executeForTest("key", "list")
+	if err != nil {
+		t.Fatalf("key list error: %v", err)
+	}
+	if !strings.Contains(out, key.ID) || !strings.Contains(out, "active") || strings.Contains(out, secret) {
+		t.Fatalf("unexpected key list output: %s", out)
+	}
+
+	if _, err := executeForTest("key", "revoke", key.ID); err != nil {
+		t.Fatalf("key revoke error: %v", err)
+	}
+	out, _ = executeForTest("key", "list")
+	if !strings.Contains(out, "revoked") {
+		t.Fatalf("key list should show revoked key: %s", out)
+	}
+}
+
+func TestKeyCreateRequiresKnownAccount(t *testing.T) {
+	t.Setenv("IFLOW_DATA_DIR", t.TempDir())
+	t.Cleanup(resetKeyFlags)
+
+	if _, err := executeForTest("key", "create", "--account", "6a1f3c2e-4b5d-4e6f-8a9b-0c1d2e3f4a5b"); err == nil {
+		t.Fatal("expected error for unknown account")
+	}
+	resetKeyFlags()
+	if _, err := executeForTest("key", "create"); err == nil {
+		t.Fatal("expected error without --account or --pool")
+	}
+	resetKeyFlags()
+	if _, err := executeForTest("key", "create", "--pool", "--expires", "soon"); err == nil {
+		t.Fatal("expected error for invalid expiry")
+	}
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateFrom string
+	migrateTo   string
+)
+
+var migrateStorageCmd = &cobra.Command{
+	Use:   "migrate-storage",
+	Short: "在存储后端之间迁移账号 (file/sqlite)",
+	Args:  cobra.NoArgs,
+	RunE:  runMigrateStorage,
+}
+
+func init() {
+	rootCmd.AddCommand(migrateStorageCmd)
+	migrateStorageCmd.Flags().StringVar(&migrateFrom, "from", account.StoreFile, "源存储 (file/sqlite)")
+	migrateStorageCmd.Flags().StringVar(&migrateTo, "to", account.StoreSQLite, "目标存储 (file/sqlite)")
+}
+
+func runMigrateStorage(cmd *cobra.Command, _ []string) error {
+	from := strings.ToLower(strings.TrimSpace(migrateFrom))
+	to := strings.ToLower(strings.TrimSpace(migrateTo))
+	if from == to {
+		return fmt.Errorf("migrate storage: source and target are both %q", from)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	source, err := account.OpenStore(from, cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("migrate storage: %w", err)
+	}
+	defer source.Close()
+
+	target, err := account.OpenStore(to, cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("migrate storage: %w", err)
+	}
+	defer target.Close()
+
+	accounts, err := source.List()
+	if err != nil {
+		return fmt.Errorf("migrate storage: %w", err)
+	}
+	// Save overwrites, so re-running the migration is safe.
+	for _, acct := range accounts {
+		if err := target.Save(acct); err != nil {
+			return fmt.Errorf("migrate storage: %s: %w", acct.UUID, err)
+		}
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Migrated %d account(s) from %s to %s.\n", len(accounts), from, to)
+	if to != strings.ToLower(strings.TrimSpace(cfg.Storage)) {
+		fmt.Fprintf(cmd.OutOrStdout(), "Set IFLOW_STORAGE=%s to use the migrated accounts.\n", to)
+	}
+	return nil
+}
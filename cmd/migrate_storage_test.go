@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+)
+
+func TestMigrateStorage(t *testing.T) {
+	dataDir := t.TempDir()
+	manager := account.NewManager(dataDir)
+	acct, err := manager.Create("sk-test", "")
+	if err != nil {
+		t.Fatalf("create account: %v", err)
+	}
+	if err := manager.SetWeight(acct.UUID, 4); err != nil {
+		t.Fatalf("set weight: %v", err)
+	}
+
+	t.Setenv("IFLOW_DATA_DIR", dataDir)
+	t.Setenv("IFLOW_STORAGE", "file")
+	out, err := executeForTest("migrate-storage", "--from", "file", "--to", "sqlite")
+	if err != nil {
+		t.Fatalf("migrate-storage error: %v", err)
+	}
+	if !strings.Contains(out, "Migrated 1 account(s) from file to sqlite.") || !strings.Contains(out, "IFLOW_STORAGE=sqlite") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+
+	t.Setenv("IFLOW_STORAGE", "sqlite")
+	out, err = executeForTest("token", "list")
+	if err != nil {
+		t.Fatalf("token list error: %v", err)
+	}
+	if !strings.Contains(out, acct.UUID) {
+		t.Fatalf("sqlite store missing migrated account: %s", out)
+	}
+
+	sqliteManager, err := account.OpenManager(account.StoreSQLite, dataDir, nil)
+	if err != nil {
+		t.Fatalf("open sqlite manager: %v", err)
+	}
+	defer sqliteManager.Close()
+	migrated, err := sqliteManager.Get(acct.UUID)
+	if err != nil {
+		t.Fatalf("get migrated account: %v", err)
+	}
+	if migrated.APIKey != "sk-test" || migrated.Weight != 4 {
+		t.Fatalf("unexpected migrated account: %+v", migrated)
+	}
+
+	if _, err := executeForTest("migrate-storage", "--from", "sqlite", "--to", "sqlite"); err == nil {
+		t.Fatal("expected error for identical source and target")
+	}
+	migrateFrom, migrateTo = account.StoreFile, account.StoreSQLite
+}
@@ -2,10 +2,19 @@ package cmd
 
 import (
 	"bytes"
+	"os"
 	"strings"
 	"testing"
+
+	"github.com/zalando/go-keyring"
 )
 
+func TestMain(m *testing.M) {
+	// Commands resolve the master key; keep tests out of the real keyring.
+	keyring.MockInit()
+	os.Exit(m.Run())
+}
+
 func executeForTest(args ...string) (string, error) {
 	var buf bytes.Buffer
 	rootCmd.SetOut(&buf)
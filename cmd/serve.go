@@ -12,7 +12,9 @@ import (
 	"github.com/rogeecn/iflow-go/internal/account"
 	"github.com/rogeecn/iflow-go/internal/config"
 	"github.com/rogeecn/iflow-go/internal/oauth"
+	"github.com/rogeecn/iflow-go/internal/proxy"
 	"github.com/rogeecn/iflow-go/internal/server"
+	"github.com/rogeecn/iflow-go/internal/transport"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
@@ -27,10 +29,6 @@ type serveRefresher interface {
 	Stop()
 }
 
-type accountManagerProvider interface {
-	AccountManager() *account.Manager
-}
-
 var (
 	serveHost        string
 	servePort        int
@@ -38,8 +36,8 @@ var (
 )
 
 var (
-	newServeServer = func(cfg *config.Config) serveRunner {
-		return server.New(cfg)
+	newServeServer = func(cfg *config.Config, manager *account.Manager) serveRunner {
+		return server.NewWithManager(cfg, manager)
 	}
 	newServeRefresher = func(manager *account.Manager) serveRefresher {
 		return oauth.NewRefresher(manager)
@@ -57,10 +55,10 @@ func init() {
 	rootCmd.AddCommand(serveCmd)
 	serveCmd.Flags().StringVar(&serveHost, "host", "", "监听地址 (默认: 从 IFLOW_HOST 读取)")
 	serveCmd.Flags().IntVar(&servePort, "port", 0, "监听端口 (默认: 从 IFLOW_PORT 读取)")
-	serveCmd.Flags().IntVar(&serveConcurrency, "concurrency", 0, "并发数 (默认: 从 IFLOW_CONCURRENCY 读取)")
+	serveCmd.Flags().IntVar(&serveConcurrency, "concurrency", 0, "每个账号的并发数，0 表示不限制 (默认: 从 IFLOW_CONCURRENCY 读取)")
 }
 
-func runServe(_ *cobra.Command, _ []string) error {
+func runServe(cmd *cobra.Command, _ []string) error {
 	cfg, err := config.Load()
 	if err != nil {
 		return fmt.Errorf("load config: %w", err)
@@ -72,7 +70,8 @@ func runServe(_ *cobra.Command, _ []string) error {
 	if servePort > 0 {
 		cfg.Port = servePort
 	}
-	if serveConcurrency > 0 {
+	// --concurrency 0 must be able to lift a limit set in the environment.
+	if cmd.Flags().Changed("concurrency") {
 		cfg.Concurrency = serveConcurrency
 	}
 
@@ -81,18 +80,48 @@ func runServe(_ *cobra.Command, _ []string) error {
 		Str("log_level", cfg.LogLevel).
 		Msg("logger initialized")
 
-	srv := newServeServer(cfg)
-	manager := account.NewManager(cfg.DataDir)
-	if provider, ok := srv.(accountManagerProvider); ok {
-		if provided := provider.AccountManager(); provided != nil {
-			manager = provided
+	if err := applyTransport(cfg); err != nil {
+		return err
+	}
+	if cfg.Proxy != "" {
+		log.Info().
+			Str("proxy_url", transport.RedactProxyURL(cfg.Proxy)).
+			Str("no_proxy", cfg.NoProxy).
+			Msg("upstream proxy enabled")
+	}
+
+	manager, err := openAccountManager(cfg)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+	log.Info().
+		Str("storage", cfg.Storage).
+		Msg("account storage opened")
+	if cfg.AccountCache {
+		if err := manager.EnableCache(cfg.UsageFlushInterval); err != nil {
+			log.Warn().
+				Err(err).
+				Msg("account cache disabled, reading accounts from storage on every request")
+		} else {
+			log.Info().
+				Dur("usage_flush_interval", cfg.UsageFlushInterval).
+				Msg("account cache enabled")
 		}
 	}
 
+	srv := newServeServer(cfg, manager)
+
 	refresher := newServeRefresher(manager)
 	refresher.Start()
 	defer refresher.Stop()
 	log.Info().Msg("oauth refresher attached to serve lifecycle")
+	// Upstream 401/403s on OAuth accounts share the refresher, so on-demand
+	// refreshes are collapsed with each other.
+	if credentials, ok := refresher.(proxy.CredentialRefresher); ok {
+		proxy.ConfigureCredentialRefresher(credentials)
+		defer proxy.ConfigureCredentialRefresher(nil)
+	}
 
 	startErrCh := make(chan error, 1)
 	go func() {
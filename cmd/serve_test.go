@@ -12,9 +12,8 @@ import (
 )
 
 type fakeServeRunner struct {
-	startFn    func() error
-	stopFn     func(ctx context.Context) error
-	accountMgr *account.Manager
+	startFn func() error
+	stopFn  func(ctx context.Context) error
 }
 
 func (f *fakeServeRunner) Start() error {
@@ -31,10 +30,6 @@ func (f *fakeServeRunner) Stop(ctx context.Context) error {
 	return nil
 }
 
-func (f *fakeServeRunner) AccountManager() *account.Manager {
-	return f.accountMgr
-}
-
 type fakeServeRefresher struct {
 	startCalls int
 	stopCalls  int
@@ -58,26 +53,30 @@ func TestRunServeStartReturns(t *testing.T) {
 		newServeRefresher = origNewServeRefresher
 		signalNotifyContext = origSignalNotifyContext
 		serveHost, servePort, serveConcurrency = origHost, origPort, origConcurrency
+		serveCmd.Flags().Lookup("concurrency").Changed = false
 	})
 
 	t.Setenv("IFLOW_DATA_DIR", t.TempDir())
 	t.Setenv("IFLOW_HOST", "0.0.0.0")
 	t.Setenv("IFLOW_PORT", "28000")
+	t.Setenv("IFLOW_CONCURRENCY", "4")
 
 	serveHost = "127.0.0.1"
 	servePort = 19000
-	serveConcurrency = 3
+	// An explicit --concurrency 0 lifts the limit set in the environment.
+	if err := serveCmd.Flags().Set("concurrency", "0"); err != nil {
+		t.Fatalf("set concurrency flag: %v", err)
+	}
 
 	var capturedCfg *config.Config
 	var refresher *fakeServeRefresher
-	var refresherManager *account.Manager
-	expectedManager := account.NewManager(t.TempDir())
-	newServeServer = func(cfg *config.Config) serveRunner {
+	var serverManager, refresherManager *account.Manager
+	newServeServer = func(cfg *config.Config, manager *account.Manager) serveRunner {
 		copied := *cfg
 		capturedCfg = &copied
+		serverManager = manager
 		return &fakeServeRunner{
-			startFn:    func() error { return nil },
-			accountMgr: expectedManager,
+			startFn: func() error { return nil },
 		}
 	}
 	newServeRefresher = func(manager *account.Manager) serveRefresher {
@@ -86,13 +85,13 @@ func TestRunServeStartReturns(t *testing.T) {
 		return refresher
 	}
 
-	if err := runServe(nil, nil); err != nil {
+	if err := runServe(serveCmd, nil); err != nil {
 		t.Fatalf("runServe error: %v", err)
 	}
 	if capturedCfg == nil {
 		t.Fatal("newServeServer was not called")
 	}
-	if capturedCfg.Host != "127.0.0.1" || capturedCfg.Port != 19000 || capturedCfg.Concurrency != 3 {
+	if capturedCfg.Host != "127.0.0.1" || capturedCfg.Port != 19000 || capturedCfg.Concurrency != 0 {
 		t.Fatalf("unexpected cfg overrides: %+v", *capturedCfg)
 	}
 	if refresher == nil {
@@ -101,7 +100,7 @@ func TestRunServeStartReturns(t *testing.T) {
 	if refresher.startCalls != 1 || refresher.stopCalls != 1 {
 		t.Fatalf("unexpected refresher calls: start=%d stop=%d", refresher.startCalls, refresher.stopCalls)
 	}
-	if refresherManager != expectedManager {
+	if serverManager == nil || refresherManager != serverManager {
 		t.Fatal("refresher should use server account manager")
 	}
 }
@@ -122,7 +121,7 @@ func TestRunServeShutdownPath(t *testing.T) {
 
 	stopCh := make(chan struct{})
 	var refresher *fakeServeRefresher
-	newServeServer = func(cfg *config.Config) serveRunner {
+	newServeServer = func(cfg *config.Config, _ *account.Manager) serveRunner {
 		return &fakeServeRunner{
 			startFn: func() error {
 				<-stopCh
@@ -145,7 +144,7 @@ func TestRunServeShutdownPath(t *testing.T) {
 		return ctx, func() {}
 	}
 
-	if err := runServe(nil, nil); err != nil {
+	if err := runServe(serveCmd, nil); err != nil {
 		t.Fatalf("runServe shutdown path error: %v", err)
 	}
 	if refresher == nil {
@@ -172,7 +171,7 @@ func TestRunServeShutdownError(t *testing.T) {
 
 	stopErr := fmt.Errorf("stop failed")
 	var refresher *fakeServeRefresher
-	newServeServer = func(cfg *config.Config) serveRunner {
+	newServeServer = func(cfg *config.Config, _ *account.Manager) serveRunner {
 		return &fakeServeRunner{
 			startFn: func() error {
 				time.Sleep(20 * time.Millisecond)
@@ -194,7 +193,7 @@ func TestRunServeShutdownError(t *testing.T) {
 		return ctx, func() {}
 	}
 
-	err := runServe(nil, nil)
+	err := runServe(serveCmd, nil)
 	if err == nil {
 		t.Fatal("expected shutdown error, got nil")
 	}
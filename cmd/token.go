@@ -6,18 +6,22 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/rogeecn/iflow-go/internal/account"
 	"github.com/rogeecn/iflow-go/internal/config"
 	"github.com/rogeecn/iflow-go/internal/oauth"
+	"github.com/rogeecn/iflow-go/internal/ratelimit"
+	"github.com/rogeecn/iflow-go/internal/transport"
 	"github.com/spf13/cobra"
 )
 
 type oauthClient interface {
 	Login(ctx context.Context) (*account.Account, error)
 	Refresh(ctx context.Context, refreshToken string) (*oauth.Token, error)
+	GetUserInfo(ctx context.Context, accessToken string) (*oauth.UserInfo, error)
 }
 
 var newOAuthClient = func(manager *account.Manager) oauthClient {
@@ -56,12 +60,46 @@ var tokenRefreshCmd = &cobra.Command{
 	RunE:  runTokenRefresh,
 }
 
+var tokenWeightCmd = &cobra.Command{
+	Use:   "weight <uuid> <weight>",
+	Short: "设置账号在账号池中的权重（正整数，默认 1）",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runTokenWeight,
+}
+
+var tokenProxyCmd = &cobra.Command{
+	Use:   "proxy <uuid> [proxy-url]",
+	Short: "设置账号专用的上游代理 (省略 proxy-url 则清除)",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE:  runTokenProxy,
+}
+
+var tokenLimitsCmd = &cobra.Command{
+	Use:   "limits <uuid>",
+	Short: "设置账号的限流与 token 预算 (未指定的项不限制)",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runTokenLimits,
+}
+
+var tokenLimits ratelimit.Limits
+
 func init() {
 	rootCmd.AddCommand(tokenCmd)
 	tokenCmd.AddCommand(tokenListCmd)
 	tokenCmd.AddCommand(tokenImportCmd)
 	tokenCmd.AddCommand(tokenDeleteCmd)
 	tokenCmd.AddCommand(tokenRefreshCmd)
+	tokenCmd.AddCommand(tokenWeightCmd)
+	tokenCmd.AddCommand(tokenProxyCmd)
+	tokenCmd.AddCommand(tokenLimitsCmd)
+	addLimitFlags(tokenLimitsCmd, &tokenLimits)
+}
+
+func addLimitFlags(cmd *cobra.Command, limits *ratelimit.Limits) {
+	cmd.Flags().IntVar(&limits.RPM, "rpm", 0, "每分钟请求数上限，0 表示不限制")
+	cmd.Flags().IntVar(&limits.TPM, "tpm", 0, "每分钟 token 数上限，0 表示不限制")
+	cmd.Flags().Int64Var(&limits.DailyTokens, "daily-tokens", 0, "每日 (UTC) token 预算，0 表示不限制")
+	cmd.Flags().Int64Var(&limits.MonthlyTokens, "monthly-tokens", 0, "每月 (UTC) token 预算，0 表示不限制")
 }
 
 func runTokenList(cmd *cobra.Command, _ []string) error {
@@ -69,6 +107,7 @@ func runTokenList(cmd *cobra.Command, _ []string) error {
 	if err != nil {
 		return err
 	}
+	defer manager.Close()
 
 	accounts, err := manager.List()
 	if err != nil {
@@ -80,23 +119,34 @@ func runTokenList(cmd *cobra.Command, _ []string) error {
 		return nil
 	}
 
-	fmt.Fprintln(cmd.OutOrStdout(), "UUID\tAUTH\tREQUESTS\tUPDATED_AT")
+	now := time.Now()
+	fmt.Fprintln(cmd.OutOrStdout(), "UUID\tAUTH\tREQUESTS\tCOOLDOWN\tUPDATED_AT")
 	for _, acct := range accounts {
-		fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\t%d\t%s\n",
+		fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\t%d\t%s\t%s\n",
 			acct.UUID,
 			acct.AuthType,
 			acct.RequestCount,
+			cooldownState(acct, now),
 			acct.UpdatedAt.Format(time.RFC3339),
 		)
 	}
 	return nil
 }
 
+func cooldownState(acct *account.Account, now time.Time) string {
+	if !acct.InCooldown(now) {
+		return "-"
+	}
+	remaining := acct.CooldownUntil.Sub(now).Round(time.Second)
+	return fmt.Sprintf("%s (failures=%d)", remaining, acct.FailureCount)
+}
+
 func runTokenImport(cmd *cobra.Command, args []string) error {
 	manager, err := newAccountManager()
 	if err != nil {
 		return err
 	}
+	defer manager.Close()
 
 	if len(args) == 1 {
 		acct, err := importFromSettingsFile(manager, args[0])
@@ -127,6 +177,7 @@ func runTokenDelete(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	defer manager.Close()
 
 	if err := manager.Delete(uuid); err != nil {
 		return fmt.Errorf("delete account: %w", err)
@@ -146,38 +197,103 @@ func runTokenRefresh(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	defer manager.Close()
 
 	acct, err := manager.Get(uuid)
 	if err != nil {
 		return fmt.Errorf("load account: %w", err)
 	}
 
-	refreshToken := strings.TrimSpace(acct.OAuthRefreshToken)
-	if refreshToken == "" {
+	if strings.TrimSpace(acct.OAuthRefreshToken) == "" {
 		return fmt.Errorf("account %s has no refresh token", uuid)
 	}
 
-	client := newOAuthClient(manager)
-	token, err := client.Refresh(context.Background(), refreshToken)
+	updated, err := oauth.RefreshAccount(context.Background(), newOAuthClient(manager), manager, acct)
 	if err != nil {
-		return fmt.Errorf("refresh token: %w", err)
+		return err
+	}
+	if updated.APIKey != acct.APIKey {
+		fmt.Fprintf(cmd.OutOrStdout(), "API key rotated: %s\n", uuid)
 	}
 
-	newRefreshToken := strings.TrimSpace(token.RefreshToken)
-	if newRefreshToken == "" {
-		newRefreshToken = refreshToken
+	fmt.Fprintf(cmd.OutOrStdout(), "Token refreshed: %s\n", uuid)
+	return nil
+}
+
+func runTokenWeight(cmd *cobra.Command, args []string) error {
+	uuid := strings.TrimSpace(args[0])
+	if !account.IsValidUUID(uuid) {
+		return fmt.Errorf("invalid uuid: %s", uuid)
 	}
 
-	expiresAt := token.ExpiresAt
-	if expiresAt.IsZero() {
-		expiresAt = time.Now().UTC().Add(24 * time.Hour)
+	weight, err := strconv.Atoi(strings.TrimSpace(args[1]))
+	if err != nil || weight < 1 {
+		return fmt.Errorf("invalid weight: %s (must be at least 1)", args[1])
 	}
 
-	if err := manager.UpdateToken(uuid, token.AccessToken, newRefreshToken, expiresAt); err != nil {
-		return fmt.Errorf("persist refreshed token: %w", err)
+	manager, err := newAccountManager()
+	if err != nil {
+		return err
 	}
+	defer manager.Close()
 
-	fmt.Fprintf(cmd.OutOrStdout(), "Token refreshed: %s\n", uuid)
+	if err := manager.SetWeight(uuid, weight); err != nil {
+		return fmt.Errorf("set weight: %w", err)
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Account weight updated: %s -> %d\n", uuid, weight)
+	return nil
+}
+
+func runTokenLimits(cmd *cobra.Command, args []string) error {
+	uuid := strings.TrimSpace(args[0])
+	if !account.IsValidUUID(uuid) {
+		return fmt.Errorf("invalid uuid: %s", uuid)
+	}
+
+	manager, err := newAccountManager()
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	if err := manager.SetLimits(uuid, tokenLimits); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Account limits updated: %s -> %s\n", uuid, tokenLimits)
+	return nil
+}
+
+func runTokenProxy(cmd *cobra.Command, args []string) error {
+	uuid := strings.TrimSpace(args[0])
+	if !account.IsValidUUID(uuid) {
+		return fmt.Errorf("invalid uuid: %s", uuid)
+	}
+
+	proxyURL := ""
+	if len(args) == 2 {
+		proxyURL = strings.TrimSpace(args[1])
+	}
+	if _, err := transport.ParseProxyURL(proxyURL); err != nil {
+		return fmt.Errorf("invalid proxy url: %w", err)
+	}
+
+	manager, err := newAccountManager()
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	if err := manager.SetProxyURL(uuid, proxyURL); err != nil {
+		return fmt.Errorf("set proxy: %w", err)
+	}
+
+	if proxyURL == "" {
+		fmt.Fprintf(cmd.OutOrStdout(), "Account proxy cleared: %s\n", uuid)
+		return nil
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Account proxy updated: %s -> %s\n", uuid, transport.RedactProxyURL(proxyURL))
 	return nil
 }
 
@@ -186,7 +302,17 @@ func newAccountManager() (*account.Manager, error) {
 	if err != nil {
 		return nil, fmt.Errorf("load config: %w", err)
 	}
-	return account.NewManager(cfg.DataDir), nil
+	if err := applyTransport(cfg); err != nil {
+		return nil, err
+	}
+	return openAccountManager(cfg)
+}
+
+func applyTransport(cfg *config.Config) error {
+	if err := transport.SetDefault(transport.Settings{ProxyURL: cfg.Proxy, NoProxy: cfg.NoProxy}); err != nil {
+		return fmt.Errorf("configure upstream proxy: %w", err)
+	}
+	return nil
 }
 
 type iflowSettingsFile struct {
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/rogeecn/iflow-go/internal/config"
+	"github.com/rogeecn/iflow-go/internal/usage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	usageFrom    string
+	usageTo      string
+	usageAccount string
+	usageModel   string
+	usageFormat  string
+)
+
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "查看按账号、模型、日期统计的 token 用量",
+	Args:  cobra.NoArgs,
+	RunE:  runUsage,
+}
+
+func init() {
+	rootCmd.AddCommand(usageCmd)
+	usageCmd.Flags().StringVar(&usageFrom, "from", "", "起始日期 YYYY-MM-DD (UTC，含当天)")
+	usageCmd.Flags().StringVar(&usageTo, "to", "", "结束日期 YYYY-MM-DD (UTC，含当天)")
+	usageCmd.Flags().StringVar(&usageAccount, "account", "", "只显示指定账号 UUID")
+	usageCmd.Flags().StringVar(&usageModel, "model", "", "只显示指定模型")
+	usageCmd.Flags().StringVar(&usageFormat, "format", "table", "输出格式 (table/json/csv)")
+}
+
+func runUsage(cmd *cobra.Command, _ []string) error {
+	from, err := usage.ParseDate(usageFrom)
+	if err != nil {
+		return err
+	}
+	to, err := usage.ParseDate(usageTo)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	records, err := usage.NewLedger(cfg.DataDir).Query(usage.Filter{
+		From:    from,
+		To:      to,
+		Account: strings.TrimSpace(usageAccount),
+		Model:   strings.TrimSpace(usageModel),
+	})
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	switch strings.ToLower(strings.TrimSpace(usageFormat)) {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(map[string]interface{}{
+			"data":  records,
+			"total": usage.Total(records),
+		})
+	case "csv":
+		return usage.WriteCSV(out, records)
+	case "", "table":
+		if len(records) == 0 {
+			fmt.Fprintln(out, "No usage recorded.")
+			return nil
+		}
+		fmt.Fprintln(out, "DATE\tACCOUNT\tMODEL\tREQUESTS\tPROMPT\tCOMPLETION\tREASONING\tTOTAL")
+		for _, r := range records {
+			fmt.Fprintf(out, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\n",
+				r.Date, r.AccountUUID, r.Model, r.Requests, r.PromptTokens, r.CompletionTokens, r.ReasoningTokens, r.TotalTokens)
+		}
+		total := usage.Total(records)
+		fmt.Fprintf(out, "TOTAL\t-\t-\t%d\t%d\t%d\t%d\t%d\n",
+			total.Requests, total.PromptTokens, total.CompletionTokens, total.ReasoningTokens, total.TotalTokens)
+		return nil
+	default:
+		return fmt.Errorf("invalid format: %s", usageFormat)
+	}
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rogeecn/iflow-go/internal/usage"
+)
+
+func TestUsageCommand(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("IFLOW_DATA_DIR", dataDir)
+
+	ledger := usage.NewLedger(dataDir)
+	if err := ledger.Record("acct-a", "glm-5", usage.Tokens{Prompt: 10, Completion: 5}); err != nil {
+		t.Fatalf("record usage: %v", err)
+	}
+	if err := ledger.Record("acct-b", "kimi-k2", usage.Tokens{Prompt: 1, Completion: 1}); err != nil {
+		t.Fatalf("record usage: %v", err)
+	}
+
+	out, err := executeForTest("usage", "--format", "table", "--account", "", "--model", "")
+	if err != nil {
+		t.Fatalf("usage command error: %v", err)
+	}
+	if !strings.Contains(out, "acct-a\tglm-5\t1\t10\t5\t0\t15") {
+		t.Fatalf("usage output missing acct-a row: %s", out)
+	}
+	if !strings.Contains(out, "TOTAL\t-\t-\t2\t11\t6\t0\t17") {
+		t.Fatalf("usage output missing total row: %s", out)
+	}
+
+	out, err = executeForTest("usage", "--format", "csv", "--model", "kimi-k2")
+	if err != nil {
+		t.Fatalf("usage csv error: %v", err)
+	}
+	if strings.Contains(out, "glm-5") || !strings.Contains(out, "acct-b,kimi-k2,1,1,1,0,2") {
+		t.Fatalf("unexpected csv output: %s", out)
+	}
+
+	if _, err := executeForTest("usage", "--from", "yesterday", "--model", ""); err == nil {
+		t.Fatal("expected invalid date error, got nil")
+	}
+	usageFrom = ""
+}
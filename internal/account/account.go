@@ -1,17 +1,33 @@
 package account
 
-import "time"
+import (
+	"time"
+
+	"github.com/rogeecn/iflow-go/internal/ratelimit"
+)
 
 type Account struct {
-	UUID              string    `json:"uuid"`
-	APIKey            string    `json:"api_key"`
-	BaseURL           string    `json:"base_url"`
-	AuthType          string    `json:"auth_type"`
-	OAuthAccessToken  string    `json:"oauth_access_token,omitempty"`
-	OAuthRefreshToken string    `json:"oauth_refresh_token,omitempty"`
-	OAuthExpiresAt    time.Time `json:"oauth_expires_at,omitempty"`
-	CreatedAt         time.Time `json:"created_at"`
-	UpdatedAt         time.Time `json:"updated_at"`
-	LastUsedAt        time.Time `json:"last_used_at,omitempty"`
-	RequestCount      int       `json:"request_count"`
+	UUID              string           `json:"uuid"`
+	APIKey            string           `json:"api_key"`
+	BaseURL           string           `json:"base_url"`
+	AuthType          string           `json:"auth_type"`
+	Username          string           `json:"username,omitempty"`
+	Phone             string           `json:"phone,omitempty"`
+	OAuthAccessToken  string           `json:"oauth_access_token,omitempty"`
+	OAuthRefreshToken string           `json:"oauth_refresh_token,omitempty"`
+	OAuthExpiresAt    time.Time        `json:"oauth_expires_at,omitempty"`
+	CreatedAt         time.Time        `json:"created_at"`
+	UpdatedAt         time.Time        `json:"updated_at"`
+	LastUsedAt        time.Time        `json:"last_used_at,omitempty"`
+	RequestCount      int              `json:"request_count"`
+	Weight            int              `json:"weight,omitempty"`
+	ProxyURL          string           `json:"proxy_url,omitempty"`
+	CooldownUntil     time.Time        `json:"cooldown_until,omitempty"`
+	FailureCount      int              `json:"failure_count,omitempty"`
+	DataKey           string           `json:"data_key,omitempty"`
+	Limits            ratelimit.Limits `json:"limits,omitzero"`
+}
+
+func (a *Account) InCooldown(now time.Time) bool {
+	return a != nil && !a.CooldownUntil.IsZero() && now.Before(a.CooldownUntil)
 }
@@ -0,0 +1,252 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// usageDelta is usage recorded in memory but not yet written to the store.
+type usageDelta struct {
+	requests int
+	lastUsed time.Time
+}
+
+func (d *usageDelta) apply(account *Account) {
+	account.RequestCount += d.requests
+	if d.lastUsed.After(account.LastUsedAt) {
+		account.LastUsedAt = d.lastUsed
+	}
+	if d.lastUsed.After(account.UpdatedAt) {
+		account.UpdatedAt = d.lastUsed
+	}
+	account.FailureCount = 0
+	account.CooldownUntil = time.Time{}
+}
+
+// watchedStore is implemented by stores that live on the local filesystem.
+// changedAccount maps a changed path under watchDir to the account it
+// holds; an empty uuid with ok set means any account may have changed.
+// writtenFiles lists the files a write to the account touches.
+type watchedStore interface {
+	watchDir() string
+	changedAccount(path string) (uuid string, ok bool)
+	writtenFiles(uuid string) []string
+}
+
+// fileStamp identifies one version of a file; nil info means it is missing.
+type fileStamp struct {
+	info os.FileInfo
+}
+
+func statFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{info: info}
+}
+
+func (s fileStamp) equal(other fileStamp) bool {
+	if s.info == nil || other.info == nil {
+		return s.info == nil && other.info == nil
+	}
+	return os.SameFile(s.info, other.info) &&
+		s.info.ModTime().Equal(other.info.ModTime()) &&
+		s.info.Size() == other.info.Size()
+}
+
+// EnableCache keeps accounts in memory and batches usage writes, flushing
+// them every flushInterval (a non-positive interval writes usage through).
+// Writes made by other processes, such as token import, delete or refresh,
+// are picked up through filesystem notifications.
+func (m *Manager) EnableCache(flushInterval time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cached != nil {
+		return nil
+	}
+
+	watched, ok := m.store.(watchedStore)
+	if !ok || watched.watchDir() == "" {
+		return fmt.Errorf("enable account cache: store does not support change notifications")
+	}
+	dir := watched.watchDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("enable account cache: ensure watch dir: %w", err)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("enable account cache: %w", err)
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return fmt.Errorf("enable account cache: watch %s: %w", dir, err)
+	}
+
+	m.cached = map[string]*Account{}
+	m.listed = false
+	m.pending = map[string]*usageDelta{}
+	m.ownWrites = map[string]fileStamp{}
+	m.flushInterval = flushInterval
+	m.watcher = watcher
+	m.stopChan = make(chan struct{})
+
+	m.wg.Add(1)
+	go m.watchLoop(watcher, watched)
+	if flushInterval > 0 {
+		m.wg.Add(1)
+		go m.flushLoop(flushInterval, m.stopChan)
+	}
+	return nil
+}
+
+// Flush writes pending usage to the store.
+func (m *Manager) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var errs []error
+	for uuid, delta := range m.pending {
+		account, err := m.store.Update(uuid, func(account *Account) error {
+			delta.apply(account)
+			return nil
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			// Deleted by another process; its usage has nowhere to go.
+			delete(m.pending, uuid)
+			delete(m.cached, uuid)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(m.pending, uuid)
+		m.cached[uuid] = cloneAccount(account)
+		m.noteWriteLocked(uuid)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("flush usage: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// invalidate drops a cached account, or every account when uuid is empty,
+// so the next read goes to the store. Pending usage is kept and reapplied.
+func (m *Manager) invalidate(uuid string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cached == nil {
+		return
+	}
+	if uuid == "" {
+		m.cached = map[string]*Account{}
+	} else {
+		delete(m.cached, uuid)
+	}
+	m.listed = false
+}
+
+// noteWriteLocked records the files this process just wrote for uuid, so the
+// notifications they cause do not invalidate the cache.
+func (m *Manager) noteWriteLocked(uuid string) {
+	if m.ownWrites == nil {
+		return
+	}
+	watched, ok := m.store.(watchedStore)
+	if !ok {
+		return
+	}
+	for _, path := range watched.writtenFiles(uuid) {
+		path = filepath.Clean(path)
+		m.ownWrites[path] = statFile(path)
+	}
+}
+
+// ownWrite reports whether path is still exactly as this process last wrote
+// it. Any later write by another process changes its stamp.
+func (m *Manager) ownWrite(path string) bool {
+	path = filepath.Clean(path)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stamp, ok := m.ownWrites[path]
+	return ok && stamp.equal(statFile(path))
+}
+
+func (m *Manager) watchLoop(watcher *fsnotify.Watcher, watched watchedStore) {
+	defer m.wg.Done()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) && !event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) {
+				continue
+			}
+			uuid, ok := watched.changedAccount(event.Name)
+			if !ok || m.ownWrite(event.Name) {
+				continue
+			}
+			m.invalidate(uuid)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			// Events may have been dropped; start over from the store.
+			log.Warn().
+				Err(err).
+				Msg("account watcher error, invalidating account cache")
+			m.invalidate("")
+		}
+	}
+}
+
+func (m *Manager) flushLoop(interval time.Duration, stopChan <-chan struct{}) {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Flush(); err != nil {
+				log.Warn().
+					Err(err).
+					Msg("flush account usage failed, will retry")
+			}
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+// stopCache stops the background loops and flushes pending usage.
+func (m *Manager) stopCache() error {
+	m.mu.Lock()
+	watcher := m.watcher
+	stopChan := m.stopChan
+	m.watcher = nil
+	m.stopChan = nil
+	m.mu.Unlock()
+
+	if watcher == nil {
+		return nil
+	}
+	close(stopChan)
+	watcher.Close()
+	m.wg.Wait()
+	return m.Flush()
+}
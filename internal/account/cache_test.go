@@ -0,0 +1,186 @@
+package account
+
+import (
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestManagerCacheBatchesUsage(t *testing.T) {
+	dataDir := t.TempDir()
+	manager := NewManager(dataDir)
+	created, err := manager.Create("sk-test", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := manager.EnableCache(time.Hour); err != nil {
+		t.Fatalf("EnableCache() error = %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := manager.UpdateUsage(created.UUID); err != nil {
+			t.Fatalf("UpdateUsage() error = %v", err)
+		}
+	}
+
+	got, err := manager.Get(created.UUID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.RequestCount != 5 || got.LastUsedAt.IsZero() {
+		t.Fatalf("cached account = %+v, want 5 requests", got)
+	}
+	stored, err := NewStorage(dataDir).Load(created.UUID)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if stored.RequestCount != 0 {
+		t.Fatalf("stored RequestCount = %d before flush, want 0", stored.RequestCount)
+	}
+
+	// Failures are written through and must land after the pending usage.
+	if _, err := manager.MarkFailure(created.UUID, 0); err != nil {
+		t.Fatalf("MarkFailure() error = %v", err)
+	}
+	stored, _ = NewStorage(dataDir).Load(created.UUID)
+	if stored.RequestCount != 5 || stored.FailureCount != 1 || stored.CooldownUntil.IsZero() {
+		t.Fatalf("stored account after MarkFailure = %+v", stored)
+	}
+
+	if err := manager.UpdateUsage(created.UUID); err != nil {
+		t.Fatalf("UpdateUsage() error = %v", err)
+	}
+	if err := manager.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	stored, _ = NewStorage(dataDir).Load(created.UUID)
+	if stored.RequestCount != 6 || stored.FailureCount != 0 || !stored.CooldownUntil.IsZero() {
+		t.Fatalf("stored account after Close = %+v, want 6 requests and no cooldown", stored)
+	}
+}
+
+func TestManagerCacheSeesOtherProcessWrites(t *testing.T) {
+	dataDir := t.TempDir()
+	manager := NewManager(dataDir)
+	if err := manager.EnableCache(time.Hour); err != nil {
+		t.Fatalf("EnableCache() error = %v", err)
+	}
+	defer manager.Close()
+
+	other := NewManager(dataDir)
+	created, err := other.Create("sk-test", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	waitFor(t, "imported account to be listed", func() bool {
+		accounts, err := manager.List()
+		return err == nil && len(accounts) == 1
+	})
+
+	if err := manager.UpdateUsage(created.UUID); err != nil {
+		t.Fatalf("UpdateUsage() error = %v", err)
+	}
+	if err := other.SetWeight(created.UUID, 7); err != nil {
+		t.Fatalf("SetWeight() error = %v", err)
+	}
+	waitFor(t, "weight change to be visible", func() bool {
+		got, err := manager.Get(created.UUID)
+		return err == nil && got.Weight == 7
+	})
+	got, _ := manager.Get(created.UUID)
+	if got.RequestCount != 1 {
+		t.Fatalf("pending usage lost on reload, RequestCount = %d", got.RequestCount)
+	}
+
+	if err := other.Delete(created.UUID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	waitFor(t, "deleted account to disappear", func() bool {
+		_, err := manager.Get(created.UUID)
+		return err != nil
+	})
+	if err := manager.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+}
+
+func TestManagerCacheSQLite(t *testing.T) {
+	dataDir := t.TempDir()
+	manager, err := OpenManager(StoreSQLite, dataDir, nil)
+	if err != nil {
+		t.Fatalf("OpenManager() error = %v", err)
+	}
+	defer manager.Close()
+	if err := manager.EnableCache(time.Hour); err != nil {
+		t.Fatalf("EnableCache() error = %v", err)
+	}
+
+	other, err := OpenManager(StoreSQLite, dataDir, nil)
+	if err != nil {
+		t.Fatalf("OpenManager() error = %v", err)
+	}
+	defer other.Close()
+	created, err := other.Create("sk-test", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := manager.Get(created.UUID); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	if err := other.SetWeight(created.UUID, 2); err != nil {
+		t.Fatalf("SetWeight() error = %v", err)
+	}
+	waitFor(t, "sqlite weight change to be visible", func() bool {
+		got, err := manager.Get(created.UUID)
+		return err == nil && got.Weight == 2
+	})
+}
+
+func TestManagerCacheIgnoresOwnWrites(t *testing.T) {
+	for _, kind := range []string{StoreFile, StoreSQLite} {
+		t.Run(kind, func(t *testing.T) {
+			manager, err := OpenManager(kind, t.TempDir(), nil)
+			if err != nil {
+				t.Fatalf("OpenManager() error = %v", err)
+			}
+			defer manager.Close()
+			if err := manager.EnableCache(time.Hour); err != nil {
+				t.Fatalf("EnableCache() error = %v", err)
+			}
+			created, err := manager.Create("sk-test", "")
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			if _, err := manager.List(); err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+
+			if err := manager.UpdateUsage(created.UUID); err != nil {
+				t.Fatalf("UpdateUsage() error = %v", err)
+			}
+			if err := manager.Flush(); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+			// Give the watcher time to deliver the flush's own events.
+			time.Sleep(200 * time.Millisecond)
+
+			manager.mu.RLock()
+			listed, cached := manager.listed, len(manager.cached)
+			manager.mu.RUnlock()
+			if !listed || cached != 1 {
+				t.Fatalf("flush reloaded the cache: listed=%v cached=%d", listed, cached)
+			}
+		})
+	}
+}
@@ -5,19 +5,63 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rogeecn/iflow-go/internal/ratelimit"
 )
 
-const defaultBaseURL = "https://apis.iflow.cn/v1"
+const (
+	defaultBaseURL    = "https://apis.iflow.cn/v1"
+	baseCooldown      = 5 * time.Second
+	maxCooldown       = 5 * time.Minute
+	maxCooldownShifts = 6
+)
 
 type Manager struct {
-	storage *Storage
-	mu      sync.RWMutex
+	store Store
+	mu    sync.RWMutex
+
+	// Populated by EnableCache. cached holds accounts with pending usage
+	// already applied; listed reports whether it holds every stored account.
+	cached        map[string]*Account
+	listed        bool
+	pending       map[string]*usageDelta
+	ownWrites     map[string]fileStamp
+	flushInterval time.Duration
+	watcher       *fsnotify.Watcher
+	stopChan      chan struct{}
+	wg            sync.WaitGroup
 }
 
+// NewManager returns a manager backed by the file store.
 func NewManager(dataDir string) *Manager {
-	return &Manager{
-		storage: NewStorage(dataDir),
+	return NewManagerWithStore(NewStorage(dataDir))
+}
+
+func NewManagerWithStore(store Store) *Manager {
+	return &Manager{store: store}
+}
+
+// OpenManager opens the storage kind ("file" or "sqlite") under dataDir.
+// Account secrets are encrypted with key unless it is nil.
+func OpenManager(kind, dataDir string, key *MasterKey) (*Manager, error) {
+	store, err := OpenStore(kind, dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		store = NewEncryptedStore(store, key)
+	}
+	return NewManagerWithStore(store), nil
+}
+
+// Close stops the cache, flushes pending usage and closes the store.
+func (m *Manager) Close() error {
+	flushErr := m.stopCache()
+	if err := m.store.Close(); err != nil {
+		return err
 	}
+	return flushErr
 }
 
 func (m *Manager) Create(apiKey, baseURL string) (*Account, error) {
@@ -45,62 +89,121 @@ func (m *Manager) Create(apiKey, baseURL string) (*Account, error) {
 		RequestCount: 0,
 	}
 
-	if err := m.storage.Save(account); err != nil {
+	if err := m.store.Save(account); err != nil {
 		return nil, fmt.Errorf("create account: %w", err)
 	}
+	if m.cached != nil {
+		m.cached[account.UUID] = cloneAccount(account)
+	}
+	m.noteWriteLocked(account.UUID)
 
 	return account, nil
 }
 
 func (m *Manager) Get(uuid string) (*Account, error) {
 	m.mu.RLock()
-	defer m.mu.RUnlock()
+	if m.cached == nil {
+		defer m.mu.RUnlock()
+		account, err := m.store.Load(uuid)
+		if err != nil {
+			return nil, fmt.Errorf("get account: %w", err)
+		}
+		return account, nil
+	}
+	if account, ok := m.cached[uuid]; ok {
+		m.mu.RUnlock()
+		return cloneAccount(account), nil
+	}
+	m.mu.RUnlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	account, err := m.storage.Load(uuid)
+	account, err := m.loadLocked(uuid)
 	if err != nil {
 		return nil, fmt.Errorf("get account: %w", err)
 	}
-	return account, nil
+	return cloneAccount(account), nil
 }
 
 func (m *Manager) Delete(uuid string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if err := m.storage.Delete(uuid); err != nil {
+	if err := m.store.Delete(uuid); err != nil {
 		return fmt.Errorf("delete account: %w", err)
 	}
+	delete(m.cached, uuid)
+	delete(m.pending, uuid)
+	m.noteWriteLocked(uuid)
 	return nil
 }
 
 func (m *Manager) List() ([]*Account, error) {
 	m.mu.RLock()
-	defer m.mu.RUnlock()
+	if m.cached != nil && m.listed {
+		accounts := make([]*Account, 0, len(m.cached))
+		for _, account := range m.cached {
+			accounts = append(accounts, cloneAccount(account))
+		}
+		m.mu.RUnlock()
+		sortAccounts(accounts)
+		return accounts, nil
+	}
+	m.mu.RUnlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	accounts, err := m.storage.List()
+	accounts, err := m.store.List()
 	if err != nil {
 		return nil, fmt.Errorf("list accounts: %w", err)
 	}
+	if m.cached == nil {
+		return accounts, nil
+	}
+
+	m.cached = make(map[string]*Account, len(accounts))
+	for i, account := range accounts {
+		if delta := m.pending[account.UUID]; delta != nil {
+			delta.apply(account)
+		}
+		m.cached[account.UUID] = account
+		accounts[i] = cloneAccount(account)
+	}
+	m.listed = true
 	return accounts, nil
 }
 
+// UpdateUsage records a completed request. With the cache enabled the write
+// is batched and persisted by the next flush.
 func (m *Manager) UpdateUsage(uuid string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	account, err := m.storage.Load(uuid)
-	if err != nil {
-		return fmt.Errorf("update usage: %w", err)
+	if m.cached == nil || m.flushInterval <= 0 {
+		_, err := m.updateLocked(uuid, func(account *Account) {
+			(&usageDelta{requests: 1, lastUsed: time.Now().UTC()}).apply(account)
+		})
+		if err != nil {
+			return fmt.Errorf("update usage: %w", err)
+		}
+		return nil
 	}
 
-	now := time.Now().UTC()
-	account.LastUsedAt = now
-	account.UpdatedAt = now
-	account.RequestCount++
-
-	if err := m.storage.Save(account); err != nil {
+	account, err := m.loadLocked(uuid)
+	if err != nil {
 		return fmt.Errorf("update usage: %w", err)
 	}
+	delta := m.pending[uuid]
+	if delta == nil {
+		delta = &usageDelta{}
+		m.pending[uuid] = delta
+	}
+	now := time.Now().UTC()
+	delta.requests++
+	delta.lastUsed = now
+	(&usageDelta{requests: 1, lastUsed: now}).apply(account)
 
 	return nil
 }
@@ -109,19 +212,185 @@ func (m *Manager) UpdateToken(uuid string, accessToken, refreshToken string, exp
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	account, err := m.storage.Load(uuid)
+	_, err := m.updateLocked(uuid, func(account *Account) {
+		account.OAuthAccessToken = strings.TrimSpace(accessToken)
+		account.OAuthRefreshToken = strings.TrimSpace(refreshToken)
+		account.OAuthExpiresAt = expiresAt.UTC()
+		account.UpdatedAt = time.Now().UTC()
+	})
 	if err != nil {
 		return fmt.Errorf("update token: %w", err)
 	}
 
-	account.OAuthAccessToken = strings.TrimSpace(accessToken)
-	account.OAuthRefreshToken = strings.TrimSpace(refreshToken)
-	account.OAuthExpiresAt = expiresAt.UTC()
-	account.UpdatedAt = time.Now().UTC()
+	return nil
+}
 
-	if err := m.storage.Save(account); err != nil {
-		return fmt.Errorf("update token: %w", err)
+// Credentials is what an OAuth token refresh yields for an account.
+type Credentials struct {
+	APIKey       string
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+	Username     string
+	Phone        string
+}
+
+// UpdateCredentials replaces the API key together with the OAuth tokens it
+// was issued with, so readers never see a new token paired with a stale key.
+// An empty username or phone keeps the stored value.
+func (m *Manager) UpdateCredentials(uuid string, creds Credentials) (*Account, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	apiKey := strings.TrimSpace(creds.APIKey)
+	if apiKey == "" {
+		return nil, fmt.Errorf("update credentials: empty api key")
+	}
+
+	account, err := m.updateLocked(uuid, func(account *Account) {
+		account.APIKey = apiKey
+		account.OAuthAccessToken = strings.TrimSpace(creds.AccessToken)
+		account.OAuthRefreshToken = strings.TrimSpace(creds.RefreshToken)
+		account.OAuthExpiresAt = creds.ExpiresAt.UTC()
+		if username := strings.TrimSpace(creds.Username); username != "" {
+			account.Username = username
+		}
+		if phone := strings.TrimSpace(creds.Phone); phone != "" {
+			account.Phone = phone
+		}
+		account.UpdatedAt = time.Now().UTC()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("update credentials: %w", err)
+	}
+
+	return cloneAccount(account), nil
+}
+
+func (m *Manager) SetWeight(uuid string, weight int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// A stored zero means "unset" and picks like weight 1, so it cannot
+	// also mean "never pick".
+	if weight < 1 {
+		return fmt.Errorf("set weight: weight must be at least 1")
+	}
+
+	_, err := m.updateLocked(uuid, func(account *Account) {
+		account.Weight = weight
+		account.UpdatedAt = time.Now().UTC()
+	})
+	if err != nil {
+		return fmt.Errorf("set weight: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) SetLimits(uuid string, limits ratelimit.Limits) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := limits.Validate(); err != nil {
+		return fmt.Errorf("set limits: %w", err)
+	}
+
+	_, err := m.updateLocked(uuid, func(account *Account) {
+		account.Limits = limits
+		account.UpdatedAt = time.Now().UTC()
+	})
+	if err != nil {
+		return fmt.Errorf("set limits: %w", err)
 	}
+	return nil
+}
+
+func (m *Manager) SetProxyURL(uuid, proxyURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
+	_, err := m.updateLocked(uuid, func(account *Account) {
+		account.ProxyURL = strings.TrimSpace(proxyURL)
+		account.UpdatedAt = time.Now().UTC()
+	})
+	if err != nil {
+		return fmt.Errorf("set proxy url: %w", err)
+	}
 	return nil
 }
+
+// MarkFailure puts the account into cooldown after an upstream failure. The
+// cooldown honours retryAfter when the upstream provided one and otherwise
+// grows exponentially with consecutive failures.
+func (m *Manager) MarkFailure(uuid string, retryAfter time.Duration) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	account, err := m.updateLocked(uuid, func(account *Account) {
+		account.FailureCount++
+		cooldown := retryAfter
+		if cooldown <= 0 {
+			shift := account.FailureCount - 1
+			if shift > maxCooldownShifts {
+				shift = maxCooldownShifts
+			}
+			cooldown = baseCooldown << shift
+		}
+		if cooldown > maxCooldown {
+			cooldown = maxCooldown
+		}
+
+		now := time.Now().UTC()
+		account.CooldownUntil = now.Add(cooldown)
+		account.UpdatedAt = now
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("mark failure: %w", err)
+	}
+	return account.CooldownUntil, nil
+}
+
+// updateLocked writes fn through to the store, folding in any pending usage
+// first so the stored order of events is preserved.
+func (m *Manager) updateLocked(uuid string, fn func(*Account)) (*Account, error) {
+	delta := m.pending[uuid]
+	account, err := m.store.Update(uuid, func(account *Account) error {
+		if delta != nil {
+			delta.apply(account)
+		}
+		fn(account)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	delete(m.pending, uuid)
+	if m.cached != nil {
+		m.cached[uuid] = cloneAccount(account)
+	}
+	m.noteWriteLocked(uuid)
+	return account, nil
+}
+
+// loadLocked returns the cached account, loading it on a miss. Callers must
+// hold the write lock and must not hand the result out without cloning.
+func (m *Manager) loadLocked(uuid string) (*Account, error) {
+	if account, ok := m.cached[uuid]; ok {
+		return account, nil
+	}
+	account, err := m.store.Load(uuid)
+	if err != nil {
+		return nil, err
+	}
+	if delta := m.pending[uuid]; delta != nil {
+		delta.apply(account)
+	}
+	m.cached[uuid] = account
+	return account, nil
+}
+
+func cloneAccount(account *Account) *Account {
+	copied := *account
+	return &copied
+}
@@ -110,3 +110,51 @@ func TestManagerUpdateUsageConcurrent(t *testing.T) {
 		t.Fatalf("RequestCount = %d, want %d", updated.RequestCount, workers)
 	}
 }
+
+func TestManagerMarkFailureCooldown(t *testing.T) {
+	manager := NewManager(t.TempDir())
+
+	created, err := manager.Create("sk-test", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	first, err := manager.MarkFailure(created.UUID, 0)
+	if err != nil {
+		t.Fatalf("MarkFailure() error = %v", err)
+	}
+	second, err := manager.MarkFailure(created.UUID, 0)
+	if err != nil {
+		t.Fatalf("MarkFailure() error = %v", err)
+	}
+	if !second.After(first) {
+		t.Fatalf("cooldown should grow: first=%s second=%s", first, second)
+	}
+
+	until, err := manager.MarkFailure(created.UUID, 30*time.Second)
+	if err != nil {
+		t.Fatalf("MarkFailure() error = %v", err)
+	}
+	if wait := time.Until(until); wait < 25*time.Second || wait > 30*time.Second {
+		t.Fatalf("retry-after cooldown = %s, want about 30s", wait)
+	}
+
+	acct, err := manager.Get(created.UUID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !acct.InCooldown(time.Now()) || acct.FailureCount != 3 {
+		t.Fatalf("unexpected cooldown state: until=%s failures=%d", acct.CooldownUntil, acct.FailureCount)
+	}
+
+	if err := manager.UpdateUsage(created.UUID); err != nil {
+		t.Fatalf("UpdateUsage() error = %v", err)
+	}
+	acct, err = manager.Get(created.UUID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if acct.InCooldown(time.Now()) || acct.FailureCount != 0 {
+		t.Fatalf("UpdateUsage should clear cooldown: until=%s failures=%d", acct.CooldownUntil, acct.FailureCount)
+	}
+}
@@ -0,0 +1,236 @@
+package account
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zalando/go-keyring"
+)
+
+const (
+	masterKeySize     = 32
+	masterKeyFileName = "master.key"
+	keyringService    = "iflow-go"
+)
+
+// Master key sources.
+const (
+	KeySourceEnv     = "env"
+	KeySourceFile    = "file"
+	KeySourceKeyring = "keyring"
+)
+
+// ErrMasterKeyFromEnv is returned when a key supplied through the
+// environment would have to be replaced; only the operator can do that.
+var ErrMasterKeyFromEnv = errors.New("master key comes from IFLOW_MASTER_KEY and cannot be replaced automatically")
+
+var (
+	keyringGet = keyring.Get
+	keyringSet = keyring.Set
+)
+
+// MasterKey wraps the per-account data keys that encrypt account secrets.
+type MasterKey struct {
+	key    []byte
+	id     string
+	source string
+	path   string
+	user   string
+}
+
+type MasterKeyOptions struct {
+	// Key is a base64 encoded 32-byte key and wins over every other source.
+	Key string
+	// File holds a base64 encoded key; it is created when missing.
+	File string
+	// DataDir scopes the keyring entry and holds the fallback key file.
+	DataDir string
+}
+
+// ResolveMasterKey finds the master key: IFLOW_MASTER_KEY, then the
+// configured key file, then the OS keyring, falling back to
+// <DataDir>/master.key when no keyring is available. Keyring and file keys
+// are generated on first use.
+func ResolveMasterKey(opts MasterKeyOptions) (*MasterKey, error) {
+	if value := strings.TrimSpace(opts.Key); value != "" {
+		key, err := ParseMasterKey(value)
+		if err != nil {
+			return nil, fmt.Errorf("resolve master key: IFLOW_MASTER_KEY: %w", err)
+		}
+		key.source = KeySourceEnv
+		return key, nil
+	}
+	if path := strings.TrimSpace(opts.File); path != "" {
+		return loadOrCreateKeyFile(path)
+	}
+
+	// A fallback file written earlier wins, so a host that lost its keyring
+	// keeps using the key its accounts were encrypted with.
+	fallback := filepath.Join(opts.DataDir, masterKeyFileName)
+	if _, err := os.Stat(fallback); err == nil {
+		return loadOrCreateKeyFile(fallback)
+	}
+
+	user, err := filepath.Abs(opts.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("resolve master key: %w", err)
+	}
+	key, err := loadOrCreateKeyringKey(user)
+	if err == nil {
+		return key, nil
+	}
+	log.Debug().
+		Err(err).
+		Str("path", fallback).
+		Msg("os keyring unavailable, using master key file")
+	return loadOrCreateKeyFile(fallback)
+}
+
+// GenerateMasterKey returns a new random key that is not bound to a source.
+func GenerateMasterKey() (*MasterKey, error) {
+	raw := make([]byte, masterKeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("generate master key: %w", err)
+	}
+	return newMasterKey(raw), nil
+}
+
+// ParseMasterKey decodes a base64 encoded 32-byte key.
+func ParseMasterKey(encoded string) (*MasterKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("parse master key: %w", err)
+	}
+	if len(raw) != masterKeySize {
+		return nil, fmt.Errorf("parse master key: want %d bytes, got %d", masterKeySize, len(raw))
+	}
+	return newMasterKey(raw), nil
+}
+
+func newMasterKey(raw []byte) *MasterKey {
+	sum := sha256.Sum256(raw)
+	return &MasterKey{key: raw, id: hex.EncodeToString(sum[:4])}
+}
+
+// ID is a short fingerprint that identifies the key without revealing it.
+func (k *MasterKey) ID() string {
+	return k.id
+}
+
+func (k *MasterKey) Encoded() string {
+	return base64.StdEncoding.EncodeToString(k.key)
+}
+
+// Source describes where the key is kept, e.g. "file /data/master.key".
+func (k *MasterKey) Source() string {
+	switch k.source {
+	case KeySourceFile:
+		return KeySourceFile + " " + k.path
+	case KeySourceKeyring:
+		return KeySourceKeyring + " " + keyringService + "/" + k.user
+	default:
+		return k.source
+	}
+}
+
+// Replace stores next in place of k so later runs resolve next.
+func (k *MasterKey) Replace(next *MasterKey) error {
+	switch k.source {
+	case KeySourceFile:
+		if err := writeKeyFile(k.path, next); err != nil {
+			return fmt.Errorf("replace master key: %w", err)
+		}
+	case KeySourceKeyring:
+		if err := keyringSet(keyringService, k.user, next.Encoded()); err != nil {
+			return fmt.Errorf("replace master key: %w", err)
+		}
+	default:
+		return ErrMasterKeyFromEnv
+	}
+	next.source, next.path, next.user = k.source, k.path, k.user
+	return nil
+}
+
+func loadOrCreateKeyringKey(user string) (*MasterKey, error) {
+	value, err := keyringGet(keyringService, user)
+	if errors.Is(err, keyring.ErrNotFound) {
+		generated, genErr := GenerateMasterKey()
+		if genErr != nil {
+			return nil, genErr
+		}
+		if err := keyringSet(keyringService, user, generated.Encoded()); err != nil {
+			return nil, fmt.Errorf("store master key in keyring: %w", err)
+		}
+		generated.source, generated.user = KeySourceKeyring, user
+		return generated, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read master key from keyring: %w", err)
+	}
+
+	key, err := ParseMasterKey(value)
+	if err != nil {
+		return nil, fmt.Errorf("read master key from keyring: %w", err)
+	}
+	key.source, key.user = KeySourceKeyring, user
+	return key, nil
+}
+
+func loadOrCreateKeyFile(path string) (*MasterKey, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create master key file: %w", err)
+	}
+
+	generated, err := GenerateMasterKey()
+	if err != nil {
+		return nil, err
+	}
+	// O_EXCL keeps two processes starting at once from writing different keys.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	switch {
+	case err == nil:
+		_, writeErr := file.WriteString(generated.Encoded() + "\n")
+		closeErr := file.Close()
+		if writeErr != nil || closeErr != nil {
+			os.Remove(path)
+			return nil, fmt.Errorf("create master key file: %w", errors.Join(writeErr, closeErr))
+		}
+		log.Info().
+			Str("path", path).
+			Msg("generated master key file, back it up together with the data dir")
+		generated.source, generated.path = KeySourceFile, path
+		return generated, nil
+	case !errors.Is(err, os.ErrExist):
+		return nil, fmt.Errorf("create master key file: %w", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read master key file: %w", err)
+	}
+	key, err := ParseMasterKey(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("read master key file %s: %w", path, err)
+	}
+	key.source, key.path = KeySourceFile, path
+	return key, nil
+}
+
+func writeKeyFile(path string, key *MasterKey) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(key.Encoded()+"\n"), 0o600); err != nil {
+		return fmt.Errorf("write key file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename key file: %w", err)
+	}
+	return nil
+}
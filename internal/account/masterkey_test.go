@@ -0,0 +1,110 @@
+package account
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zalando/go-keyring"
+)
+
+func TestMain(m *testing.M) {
+	keyring.MockInit()
+	os.Exit(m.Run())
+}
+
+func TestResolveMasterKeyFromEnv(t *testing.T) {
+	generated := mustGenerateKey(t)
+
+	key, err := ResolveMasterKey(MasterKeyOptions{Key: generated.Encoded(), DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("ResolveMasterKey() error = %v", err)
+	}
+	if key.ID() != generated.ID() || key.Source() != KeySourceEnv {
+		t.Fatalf("ResolveMasterKey() = %s from %s", key.ID(), key.Source())
+	}
+	if err := key.Replace(mustGenerateKey(t)); !errors.Is(err, ErrMasterKeyFromEnv) {
+		t.Fatalf("Replace() error = %v, want ErrMasterKeyFromEnv", err)
+	}
+
+	if _, err := ResolveMasterKey(MasterKeyOptions{Key: "c2hvcnQ="}); err == nil {
+		t.Fatal("expected error for a short key")
+	}
+}
+
+func TestResolveMasterKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "master.key")
+
+	first, err := ResolveMasterKey(MasterKeyOptions{File: path})
+	if err != nil {
+		t.Fatalf("ResolveMasterKey() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("key file not created: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("key file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	second, err := ResolveMasterKey(MasterKeyOptions{File: path})
+	if err != nil {
+		t.Fatalf("ResolveMasterKey() error = %v", err)
+	}
+	if first.ID() != second.ID() {
+		t.Fatalf("key file not reused: %s != %s", first.ID(), second.ID())
+	}
+
+	next := mustGenerateKey(t)
+	if err := second.Replace(next); err != nil {
+		t.Fatalf("Replace() error = %v", err)
+	}
+	third, _ := ResolveMasterKey(MasterKeyOptions{File: path})
+	if third.ID() != next.ID() {
+		t.Fatalf("Replace() not persisted: %s != %s", third.ID(), next.ID())
+	}
+}
+
+func TestResolveMasterKeyKeyring(t *testing.T) {
+	dataDir := t.TempDir()
+
+	first, err := ResolveMasterKey(MasterKeyOptions{DataDir: dataDir})
+	if err != nil {
+		t.Fatalf("ResolveMasterKey() error = %v", err)
+	}
+	if first.source != KeySourceKeyring {
+		t.Fatalf("source = %s, want keyring", first.Source())
+	}
+	second, _ := ResolveMasterKey(MasterKeyOptions{DataDir: dataDir})
+	if second.ID() != first.ID() {
+		t.Fatalf("keyring key not reused: %s != %s", second.ID(), first.ID())
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, masterKeyFileName)); !os.IsNotExist(err) {
+		t.Fatalf("fallback key file should not exist, stat error = %v", err)
+	}
+}
+
+func TestResolveMasterKeyFallsBackToFile(t *testing.T) {
+	origGet := keyringGet
+	t.Cleanup(func() { keyringGet = origGet })
+	keyringGet = func(string, string) (string, error) {
+		return "", errors.New("no secret service")
+	}
+
+	dataDir := t.TempDir()
+	key, err := ResolveMasterKey(MasterKeyOptions{DataDir: dataDir})
+	if err != nil {
+		t.Fatalf("ResolveMasterKey() error = %v", err)
+	}
+	if key.source != KeySourceFile || key.path != filepath.Join(dataDir, masterKeyFileName) {
+		t.Fatalf("source = %s, want fallback file", key.Source())
+	}
+
+	// Once written, the fallback file wins even if the keyring comes back.
+	keyringGet = origGet
+	again, _ := ResolveMasterKey(MasterKeyOptions{DataDir: dataDir})
+	if again.ID() != key.ID() || again.source != KeySourceFile {
+		t.Fatalf("fallback file not preferred: %s from %s", again.ID(), again.Source())
+	}
+}
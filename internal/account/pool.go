@@ -0,0 +1,195 @@
+package account
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type PoolStrategy string
+
+const (
+	StrategyRoundRobin        PoolStrategy = "round_robin"
+	StrategyLeastRecentlyUsed PoolStrategy = "least_recently_used"
+	StrategyLeastInFlight     PoolStrategy = "least_in_flight"
+	StrategyWeighted          PoolStrategy = "weighted"
+)
+
+func ParsePoolStrategy(value string) (PoolStrategy, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "round_robin", "round-robin", "rr":
+		return StrategyRoundRobin, nil
+	case "least_recently_used", "least-recently-used", "lru":
+		return StrategyLeastRecentlyUsed, nil
+	case "least_in_flight", "least-in-flight", "least_inflight":
+		return StrategyLeastInFlight, nil
+	case "weighted":
+		return StrategyWeighted, nil
+	default:
+		return "", fmt.Errorf("unknown pool strategy %q", value)
+	}
+}
+
+// Pool selects one account per request from all stored accounts.
+type Pool struct {
+	manager  *Manager
+	strategy PoolStrategy
+
+	mu             sync.Mutex
+	next           int
+	inFlight       map[string]int
+	lastPicked     map[string]time.Time
+	currentWeights map[string]int
+}
+
+type Lease struct {
+	Account *Account
+
+	pool *Pool
+	once sync.Once
+}
+
+func NewPool(manager *Manager, strategy PoolStrategy) *Pool {
+	if strategy == "" {
+		strategy = StrategyRoundRobin
+	}
+	return &Pool{
+		manager:        manager,
+		strategy:       strategy,
+		inFlight:       map[string]int{},
+		lastPicked:     map[string]time.Time{},
+		currentWeights: map[string]int{},
+	}
+}
+
+func (p *Pool) Strategy() PoolStrategy {
+	return p.strategy
+}
+
+// Acquire leases a healthy account, skipping accounts in cooldown and the
+// UUIDs listed in exclude.
+func (p *Pool) Acquire(exclude ...string) (*Lease, error) {
+	all, err := p.manager.List()
+	if err != nil {
+		return nil, fmt.Errorf("acquire account: %w", err)
+	}
+	if len(all) == 0 {
+		return nil, fmt.Errorf("acquire account: no accounts available")
+	}
+
+	now := time.Now()
+	accounts := make([]*Account, 0, len(all))
+	for _, acct := range all {
+		if acct.InCooldown(now) || containsString(exclude, acct.UUID) {
+			continue
+		}
+		accounts = append(accounts, acct)
+	}
+	if len(accounts) == 0 {
+		return nil, fmt.Errorf("acquire account: no healthy accounts available")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var picked *Account
+	switch p.strategy {
+	case StrategyLeastRecentlyUsed:
+		picked = p.pickLeastRecentlyUsed(accounts)
+	case StrategyLeastInFlight:
+		picked = p.pickLeastInFlight(accounts)
+	case StrategyWeighted:
+		picked = p.pickWeighted(accounts)
+	default:
+		picked = accounts[p.next%len(accounts)]
+		p.next++
+	}
+
+	p.inFlight[picked.UUID]++
+	p.lastPicked[picked.UUID] = time.Now()
+	return &Lease{Account: picked, pool: p}, nil
+}
+
+func (p *Pool) InFlight(uuid string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.inFlight[uuid]
+}
+
+func (l *Lease) Release() {
+	if l == nil || l.pool == nil || l.Account == nil {
+		return
+	}
+	l.once.Do(func() {
+		l.pool.release(l.Account.UUID)
+	})
+}
+
+func (p *Pool) release(uuid string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.inFlight[uuid]--
+	if p.inFlight[uuid] <= 0 {
+		delete(p.inFlight, uuid)
+	}
+}
+
+func (p *Pool) pickLeastRecentlyUsed(accounts []*Account) *Account {
+	var picked *Account
+	var pickedAt time.Time
+	for _, acct := range accounts {
+		usedAt := acct.LastUsedAt
+		if last, ok := p.lastPicked[acct.UUID]; ok && last.After(usedAt) {
+			usedAt = last
+		}
+		if picked == nil || usedAt.Before(pickedAt) {
+			picked = acct
+			pickedAt = usedAt
+		}
+	}
+	return picked
+}
+
+func (p *Pool) pickLeastInFlight(accounts []*Account) *Account {
+	var picked *Account
+	offset := p.next % len(accounts)
+	p.next++
+	for i := range accounts {
+		acct := accounts[(offset+i)%len(accounts)]
+		if picked == nil || p.inFlight[acct.UUID] < p.inFlight[picked.UUID] {
+			picked = acct
+		}
+	}
+	return picked
+}
+
+// pickWeighted implements smooth weighted round-robin so that accounts are
+// interleaved in proportion to their weight instead of picked in bursts.
+func (p *Pool) pickWeighted(accounts []*Account) *Account {
+	total := 0
+	var picked *Account
+	for _, acct := range accounts {
+		weight := acct.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		total += weight
+		p.currentWeights[acct.UUID] += weight
+		if picked == nil || p.currentWeights[acct.UUID] > p.currentWeights[picked.UUID] {
+			picked = acct
+		}
+	}
+	p.currentWeights[picked.UUID] -= total
+	return picked
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
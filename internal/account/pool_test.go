@@ -0,0 +1,185 @@
+package account
+
+import (
+	"testing"
+	"time"
+)
+
+func createPoolAccounts(t *testing.T, manager *Manager, n int) []*Account {
+	t.Helper()
+
+	accounts := make([]*Account, 0, n)
+	for i := 0; i < n; i++ {
+		acct, err := manager.Create("sk-test", "")
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		accounts = append(accounts, acct)
+	}
+	return accounts
+}
+
+func TestParsePoolStrategy(t *testing.T) {
+	cases := map[string]PoolStrategy{
+		"":                    StrategyRoundRobin,
+		"rr":                  StrategyRoundRobin,
+		"LRU":                 StrategyLeastRecentlyUsed,
+		"least_in_flight":     StrategyLeastInFlight,
+		"weighted":            StrategyWeighted,
+		"least_recently_used": StrategyLeastRecentlyUsed,
+	}
+	for input, want := range cases {
+		got, err := ParsePoolStrategy(input)
+		if err != nil {
+			t.Fatalf("ParsePoolStrategy(%q) error = %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("ParsePoolStrategy(%q) = %q, want %q", input, got, want)
+		}
+	}
+	if _, err := ParsePoolStrategy("random"); err == nil {
+		t.Fatal("ParsePoolStrategy(random) error = nil, want non-nil")
+	}
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	manager := NewManager(t.TempDir())
+	createPoolAccounts(t, manager, 3)
+	pool := NewPool(manager, StrategyRoundRobin)
+
+	seen := map[string]int{}
+	for i := 0; i < 6; i++ {
+		lease, err := pool.Acquire()
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		seen[lease.Account.UUID]++
+		lease.Release()
+	}
+	if len(seen) != 3 {
+		t.Fatalf("distinct accounts = %d, want 3", len(seen))
+	}
+	for uuid, count := range seen {
+		if count != 2 {
+			t.Fatalf("account %s picked %d times, want 2", uuid, count)
+		}
+	}
+}
+
+func TestPoolLeastInFlight(t *testing.T) {
+	manager := NewManager(t.TempDir())
+	createPoolAccounts(t, manager, 2)
+	pool := NewPool(manager, StrategyLeastInFlight)
+
+	first, err := pool.Acquire()
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	second, err := pool.Acquire()
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if first.Account.UUID == second.Account.UUID {
+		t.Fatal("least_in_flight should spread concurrent leases across accounts")
+	}
+	if pool.InFlight(first.Account.UUID) != 1 {
+		t.Fatalf("InFlight = %d, want 1", pool.InFlight(first.Account.UUID))
+	}
+
+	first.Release()
+	first.Release()
+	if pool.InFlight(first.Account.UUID) != 0 {
+		t.Fatalf("InFlight after release = %d, want 0", pool.InFlight(first.Account.UUID))
+	}
+
+	third, err := pool.Acquire()
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if third.Account.UUID != first.Account.UUID {
+		t.Fatal("least_in_flight should pick the idle account")
+	}
+}
+
+func TestPoolLeastRecentlyUsed(t *testing.T) {
+	manager := NewManager(t.TempDir())
+	accounts := createPoolAccounts(t, manager, 2)
+	if err := manager.UpdateUsage(accounts[0].UUID); err != nil {
+		t.Fatalf("UpdateUsage() error = %v", err)
+	}
+	time.Sleep(time.Millisecond)
+
+	pool := NewPool(manager, StrategyLeastRecentlyUsed)
+	lease, err := pool.Acquire()
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if lease.Account.UUID != accounts[1].UUID {
+		t.Fatal("least_recently_used should pick the never-used account")
+	}
+
+	next, err := pool.Acquire()
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if next.Account.UUID != accounts[0].UUID {
+		t.Fatal("least_recently_used should account for in-process picks")
+	}
+}
+
+func TestPoolWeighted(t *testing.T) {
+	manager := NewManager(t.TempDir())
+	accounts := createPoolAccounts(t, manager, 2)
+	if err := manager.SetWeight(accounts[0].UUID, 3); err != nil {
+		t.Fatalf("SetWeight() error = %v", err)
+	}
+	if err := manager.SetWeight(accounts[1].UUID, 0); err == nil {
+		t.Fatal("SetWeight(0) should be rejected")
+	}
+
+	pool := NewPool(manager, StrategyWeighted)
+	seen := map[string]int{}
+	for i := 0; i < 8; i++ {
+		lease, err := pool.Acquire()
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		seen[lease.Account.UUID]++
+		lease.Release()
+	}
+	if seen[accounts[0].UUID] != 6 || seen[accounts[1].UUID] != 2 {
+		t.Fatalf("weighted distribution = %v, want 6/2", seen)
+	}
+}
+
+func TestPoolEmpty(t *testing.T) {
+	pool := NewPool(NewManager(t.TempDir()), StrategyRoundRobin)
+	if _, err := pool.Acquire(); err == nil {
+		t.Fatal("Acquire() error = nil, want non-nil")
+	}
+}
+
+func TestPoolSkipsCooldownAndExcluded(t *testing.T) {
+	manager := NewManager(t.TempDir())
+	accounts := createPoolAccounts(t, manager, 3)
+	pool := NewPool(manager, StrategyRoundRobin)
+
+	if _, err := manager.MarkFailure(accounts[0].UUID, time.Minute); err != nil {
+		t.Fatalf("MarkFailure() error = %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		lease, err := pool.Acquire(accounts[1].UUID)
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		if lease.Account.UUID != accounts[2].UUID {
+			t.Fatalf("Acquire() = %s, want %s", lease.Account.UUID, accounts[2].UUID)
+		}
+		lease.Release()
+	}
+
+	if _, err := pool.Acquire(accounts[1].UUID, accounts[2].UUID); err == nil {
+		t.Fatal("Acquire() error = nil, want no healthy accounts")
+	}
+}
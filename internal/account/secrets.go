@@ -0,0 +1,285 @@
+package account
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Secret fields are sealed with a random per-account data key (AES-256-GCM)
+// and the data key is wrapped with the master key. Versioned prefixes let
+// plaintext accounts be read and upgraded on their next write:
+//
+//	data_key: "v1:<master key id>:<base64 nonce|ciphertext>"
+//	secrets:  "enc:v1:<base64 nonce|ciphertext>"
+const (
+	dataKeyVersion = "v1"
+	secretPrefix   = "enc:v1:"
+)
+
+// secretFields lists the account fields that are encrypted at rest.
+var secretFields = []struct {
+	name string
+	ref  func(*Account) *string
+}{
+	{"api_key", func(a *Account) *string { return &a.APIKey }},
+	{"oauth_access_token", func(a *Account) *string { return &a.OAuthAccessToken }},
+	{"oauth_refresh_token", func(a *Account) *string { return &a.OAuthRefreshToken }},
+}
+
+// encryptedStore seals secrets on the way into the wrapped store and opens
+// them on the way out, so the Manager only ever sees plaintext.
+type encryptedStore struct {
+	Store
+	key *MasterKey
+}
+
+// NewEncryptedStore encrypts account secrets stored in store with key.
+func NewEncryptedStore(store Store, key *MasterKey) Store {
+	return &encryptedStore{Store: store, key: key}
+}
+
+func (s *encryptedStore) Save(account *Account) error {
+	if account == nil {
+		return fmt.Errorf("save account: nil account")
+	}
+	sealed, err := sealAccount(account, s.key)
+	if err != nil {
+		return fmt.Errorf("save account: %w", err)
+	}
+	return s.Store.Save(sealed)
+}
+
+func (s *encryptedStore) Load(uuid string) (*Account, error) {
+	account, err := s.Store.Load(uuid)
+	if err != nil {
+		return nil, err
+	}
+	opened, err := openAccount(account, s.key)
+	if err != nil {
+		return nil, fmt.Errorf("load account: %w", err)
+	}
+	return opened, nil
+}
+
+func (s *encryptedStore) List() ([]*Account, error) {
+	accounts, err := s.Store.List()
+	if err != nil {
+		return nil, err
+	}
+	for i, account := range accounts {
+		opened, err := openAccount(account, s.key)
+		if err != nil {
+			return nil, fmt.Errorf("list accounts: %w", err)
+		}
+		accounts[i] = opened
+	}
+	return accounts, nil
+}
+
+func (s *encryptedStore) Update(uuid string, fn func(*Account) error) (*Account, error) {
+	var plain *Account
+	_, err := s.Store.Update(uuid, func(account *Account) error {
+		opened, err := openAccount(account, s.key)
+		if err != nil {
+			return err
+		}
+		if err := fn(opened); err != nil {
+			return err
+		}
+		sealed, err := sealAccount(opened, s.key)
+		if err != nil {
+			return err
+		}
+		*account = *sealed
+		plain = opened
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plain, nil
+}
+
+func (s *encryptedStore) watchDir() string {
+	if watched, ok := s.Store.(watchedStore); ok {
+		return watched.watchDir()
+	}
+	return ""
+}
+
+func (s *encryptedStore) changedAccount(path string) (string, bool) {
+	if watched, ok := s.Store.(watchedStore); ok {
+		return watched.changedAccount(path)
+	}
+	return "", false
+}
+
+func (s *encryptedStore) writtenFiles(uuid string) []string {
+	if watched, ok := s.Store.(watchedStore); ok {
+		return watched.writtenFiles(uuid)
+	}
+	return nil
+}
+
+// Rekey re-wraps the data key of every account in store under to. Accounts
+// already wrapped under to are accepted, so an interrupted rekey can be run
+// again with the same new key. Plaintext accounts are encrypted.
+func Rekey(store Store, from, to *MasterKey) (int, error) {
+	accounts, err := store.List()
+	if err != nil {
+		return 0, fmt.Errorf("rekey: %w", err)
+	}
+	for _, account := range accounts {
+		opened, err := openAccount(account, from, to)
+		if err != nil {
+			return 0, fmt.Errorf("rekey: %w", err)
+		}
+		sealed, err := sealAccount(opened, to, from)
+		if err != nil {
+			return 0, fmt.Errorf("rekey: %s: %w", account.UUID, err)
+		}
+		if err := store.Save(sealed); err != nil {
+			return 0, fmt.Errorf("rekey: %w", err)
+		}
+	}
+	return len(accounts), nil
+}
+
+// sealAccount returns a copy of account with its secrets encrypted under
+// key. An existing data key wrapped by key or one of previous is reused.
+func sealAccount(account *Account, key *MasterKey, previous ...*MasterKey) (*Account, error) {
+	sealed := cloneAccount(account)
+
+	dataKey, err := unwrapDataKey(sealed, append([]*MasterKey{key}, previous...))
+	if err != nil || dataKey == nil {
+		dataKey = make([]byte, masterKeySize)
+		if _, err := rand.Read(dataKey); err != nil {
+			return nil, fmt.Errorf("generate data key: %w", err)
+		}
+	}
+	wrapped, err := seal(key.key, dataKey, dataKeyAAD(sealed.UUID))
+	if err != nil {
+		return nil, fmt.Errorf("wrap data key: %w", err)
+	}
+	sealed.DataKey = dataKeyVersion + ":" + key.id + ":" + wrapped
+
+	for _, field := range secretFields {
+		value := field.ref(sealed)
+		if *value == "" {
+			continue
+		}
+		if strings.HasPrefix(*value, secretPrefix) {
+			return nil, fmt.Errorf("encrypt %s: value is already encrypted", field.name)
+		}
+		encrypted, err := seal(dataKey, []byte(*value), secretAAD(sealed.UUID, field.name))
+		if err != nil {
+			return nil, fmt.Errorf("encrypt %s: %w", field.name, err)
+		}
+		*value = secretPrefix + encrypted
+	}
+	return sealed, nil
+}
+
+// openAccount returns a copy of account with its secrets decrypted by
+// whichever of keys wrapped its data key. Plaintext values pass through.
+func openAccount(account *Account, keys ...*MasterKey) (*Account, error) {
+	opened := cloneAccount(account)
+
+	dataKey, err := unwrapDataKey(opened, keys)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt account %s: %w", account.UUID, err)
+	}
+	for _, field := range secretFields {
+		value := field.ref(opened)
+		ciphertext, ok := strings.CutPrefix(*value, secretPrefix)
+		if !ok {
+			continue
+		}
+		if dataKey == nil {
+			return nil, fmt.Errorf("decrypt account %s: %s is encrypted but the account has no data key", account.UUID, field.name)
+		}
+		plaintext, err := open(dataKey, ciphertext, secretAAD(opened.UUID, field.name))
+		if err != nil {
+			return nil, fmt.Errorf("decrypt account %s: %s: %w", account.UUID, field.name, err)
+		}
+		*value = string(plaintext)
+	}
+	return opened, nil
+}
+
+// unwrapDataKey returns nil without error for accounts that were never
+// encrypted.
+func unwrapDataKey(account *Account, keys []*MasterKey) ([]byte, error) {
+	if account.DataKey == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(account.DataKey, ":", 3)
+	if len(parts) != 3 || parts[0] != dataKeyVersion {
+		return nil, fmt.Errorf("unsupported data key format")
+	}
+	for _, key := range keys {
+		if key == nil || key.id != parts[1] {
+			continue
+		}
+		dataKey, err := open(key.key, parts[2], dataKeyAAD(account.UUID))
+		if err != nil {
+			return nil, fmt.Errorf("unwrap data key: %w", err)
+		}
+		return dataKey, nil
+	}
+
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key != nil {
+			ids = append(ids, key.id)
+		}
+	}
+	return nil, fmt.Errorf("encrypted with master key %s, have %s", parts[1], strings.Join(ids, ", "))
+}
+
+func dataKeyAAD(uuid string) []byte {
+	return []byte("iflow-go/data-key/" + uuid)
+}
+
+func secretAAD(uuid, field string) []byte {
+	return []byte("iflow-go/" + uuid + "/" + field)
+}
+
+func seal(key, plaintext, aad []byte) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, aad)), nil
+}
+
+func open(key []byte, encoded string, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return aead.Open(nil, payload[:aead.NonceSize()], payload[aead.NonceSize():], aad)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
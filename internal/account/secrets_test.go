@@ -0,0 +1,155 @@
+package account
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustGenerateKey(t *testing.T) *MasterKey {
+	t.Helper()
+	key, err := GenerateMasterKey()
+	if err != nil {
+		t.Fatalf("GenerateMasterKey() error = %v", err)
+	}
+	return key
+}
+
+func TestEncryptedStoreRoundTrip(t *testing.T) {
+	dataDir := t.TempDir()
+	key := mustGenerateKey(t)
+	manager := NewManagerWithStore(NewEncryptedStore(NewStorage(dataDir), key))
+
+	created, err := manager.Create("sk-secret", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if created.APIKey != "sk-secret" {
+		t.Fatalf("Create() returned sealed account: %+v", created)
+	}
+	if err := manager.UpdateToken(created.UUID, "access-secret", "refresh-secret", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("UpdateToken() error = %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dataDir, "accounts", created.UUID+".json"))
+	if err != nil {
+		t.Fatalf("read account file: %v", err)
+	}
+	for _, secret := range []string{"sk-secret", "access-secret", "refresh-secret"} {
+		if strings.Contains(string(content), secret) {
+			t.Fatalf("account file contains plaintext %q: %s", secret, content)
+		}
+	}
+	if !strings.Contains(string(content), `"data_key": "v1:`+key.ID()+`:`) {
+		t.Fatalf("account file missing data key: %s", content)
+	}
+
+	got, err := manager.Get(created.UUID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.APIKey != "sk-secret" || got.OAuthAccessToken != "access-secret" || got.OAuthRefreshToken != "refresh-secret" {
+		t.Fatalf("Get() = %+v, want decrypted secrets", got)
+	}
+
+	other := NewManagerWithStore(NewEncryptedStore(NewStorage(dataDir), mustGenerateKey(t)))
+	if _, err := other.Get(created.UUID); err == nil || !strings.Contains(err.Error(), key.ID()) {
+		t.Fatalf("Get() with wrong key error = %v, want key id mismatch", err)
+	}
+}
+
+func TestEncryptedStoreUpgradesPlaintext(t *testing.T) {
+	dataDir := t.TempDir()
+	created, err := NewManager(dataDir).Create("sk-plain", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	manager := NewManagerWithStore(NewEncryptedStore(NewStorage(dataDir), mustGenerateKey(t)))
+	got, err := manager.Get(created.UUID)
+	if err != nil {
+		t.Fatalf("Get() plaintext account error = %v", err)
+	}
+	if got.APIKey != "sk-plain" {
+		t.Fatalf("APIKey = %q, want sk-plain", got.APIKey)
+	}
+
+	if err := manager.SetWeight(created.UUID, 2); err != nil {
+		t.Fatalf("SetWeight() error = %v", err)
+	}
+	raw, err := NewStorage(dataDir).Load(created.UUID)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !strings.HasPrefix(raw.APIKey, secretPrefix) || raw.DataKey == "" || raw.Weight != 2 {
+		t.Fatalf("account not upgraded on write: %+v", raw)
+	}
+}
+
+func TestRekey(t *testing.T) {
+	dataDir := t.TempDir()
+	oldKey := mustGenerateKey(t)
+	newKey := mustGenerateKey(t)
+	store := NewStorage(dataDir)
+
+	sealed, err := NewManagerWithStore(NewEncryptedStore(store, oldKey)).Create("sk-old", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	plain, err := NewManager(dataDir).Create("sk-plain", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		// The second run covers resuming an interrupted rekey.
+		count, err := Rekey(store, oldKey, newKey)
+		if err != nil {
+			t.Fatalf("Rekey() #%d error = %v", i, err)
+		}
+		if count != 2 {
+			t.Fatalf("Rekey() count = %d, want 2", count)
+		}
+	}
+
+	manager := NewManagerWithStore(NewEncryptedStore(store, newKey))
+	for uuid, want := range map[string]string{sealed.UUID: "sk-old", plain.UUID: "sk-plain"} {
+		got, err := manager.Get(uuid)
+		if err != nil {
+			t.Fatalf("Get() after rekey error = %v", err)
+		}
+		if got.APIKey != want {
+			t.Fatalf("APIKey = %q, want %q", got.APIKey, want)
+		}
+	}
+	if _, err := NewManagerWithStore(NewEncryptedStore(store, oldKey)).Get(sealed.UUID); err == nil {
+		t.Fatal("old key should no longer decrypt the account")
+	}
+}
+
+func TestEncryptedSQLiteStore(t *testing.T) {
+	key := mustGenerateKey(t)
+	manager, err := OpenManager(StoreSQLite, t.TempDir(), key)
+	if err != nil {
+		t.Fatalf("OpenManager() error = %v", err)
+	}
+	defer manager.Close()
+
+	created, err := manager.Create("sk-sqlite", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	raw, err := manager.store.(*encryptedStore).Store.Load(created.UUID)
+	if err != nil {
+		t.Fatalf("raw Load() error = %v", err)
+	}
+	if raw.APIKey == "sk-sqlite" || raw.DataKey == "" {
+		t.Fatalf("sqlite row not encrypted: %+v", raw)
+	}
+	got, err := manager.Get(created.UUID)
+	if err != nil || got.APIKey != "sk-sqlite" {
+		t.Fatalf("Get() = %+v, %v", got, err)
+	}
+}
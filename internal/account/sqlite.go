@@ -0,0 +1,320 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteFileName = "accounts.db"
+
+// sqliteMigrations are applied in order; the index plus one is the schema
+// version recorded in schema_migrations. Never edit a released migration,
+// append a new one instead.
+var sqliteMigrations = []string{
+	`CREATE TABLE accounts (
+		uuid                TEXT PRIMARY KEY,
+		api_key             TEXT NOT NULL,
+		base_url            TEXT NOT NULL DEFAULT '',
+		auth_type           TEXT NOT NULL DEFAULT '',
+		oauth_access_token  TEXT NOT NULL DEFAULT '',
+		oauth_refresh_token TEXT NOT NULL DEFAULT '',
+		oauth_expires_at    INTEGER NOT NULL DEFAULT 0,
+		created_at          INTEGER NOT NULL,
+		updated_at          INTEGER NOT NULL,
+		last_used_at        INTEGER NOT NULL DEFAULT 0,
+		request_count       INTEGER NOT NULL DEFAULT 0,
+		weight              INTEGER NOT NULL DEFAULT 0,
+		proxy_url           TEXT NOT NULL DEFAULT '',
+		cooldown_until      INTEGER NOT NULL DEFAULT 0,
+		failure_count       INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX idx_accounts_created_at ON accounts (created_at, uuid);
+	CREATE INDEX idx_accounts_api_key ON accounts (api_key);`,
+	`ALTER TABLE accounts ADD COLUMN data_key TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE accounts ADD COLUMN rate_rpm INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE accounts ADD COLUMN rate_tpm INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE accounts ADD COLUMN daily_tokens INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE accounts ADD COLUMN monthly_tokens INTEGER NOT NULL DEFAULT 0;`,
+	`ALTER TABLE accounts ADD COLUMN username TEXT NOT NULL DEFAULT '';
+	ALTER TABLE accounts ADD COLUMN phone TEXT NOT NULL DEFAULT '';`,
+	// Encrypted api_key values use a random nonce per row, so the index can
+	// never serve a lookup.
+	`DROP INDEX IF EXISTS idx_accounts_api_key;`,
+}
+
+const accountColumns = `uuid, api_key, base_url, auth_type, oauth_access_token, oauth_refresh_token,
+	oauth_expires_at, created_at, updated_at, last_used_at, request_count, weight, proxy_url,
+	cooldown_until, failure_count, data_key, rate_rpm, rate_tpm, daily_tokens, monthly_tokens,
+	username, phone`
+
+// SQLiteStore keeps accounts in <dataDir>/accounts.db using the pure-Go
+// SQLite driver.
+type SQLiteStore struct {
+	db      *sql.DB
+	dataDir string
+}
+
+func OpenSQLiteStore(dataDir string) (*SQLiteStore, error) {
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return nil, fmt.Errorf("open sqlite store: ensure data dir: %w", err)
+	}
+
+	// Immediate transactions take the write lock up front so concurrent
+	// read-modify-write updates wait on busy_timeout instead of failing.
+	dsn := "file:" + filepath.Join(dataDir, sqliteFileName) +
+		"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite store: %w", err)
+	}
+
+	store := &SQLiteStore{db: db, dataDir: dataDir}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// SchemaVersion returns the highest applied migration.
+func (s *SQLiteStore) SchemaVersion() (int, error) {
+	var version int
+	err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("schema version: %w", err)
+	}
+	return version, nil
+}
+
+func (s *SQLiteStore) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at INTEGER NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("migrate sqlite store: create migrations table: %w", err)
+	}
+
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("migrate sqlite store: %w", err)
+	}
+	for i := current; i < len(sqliteMigrations); i++ {
+		version := i + 1
+		tx, err := s.db.Begin()
+		if err != nil {
+			return fmt.Errorf("migrate sqlite store: begin v%d: %w", version, err)
+		}
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrate sqlite store: apply v%d: %w", version, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, version, time.Now().UTC().Unix()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrate sqlite store: record v%d: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migrate sqlite store: commit v%d: %w", version, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Save(account *Account) error {
+	if account == nil {
+		return fmt.Errorf("save account: nil account")
+	}
+	if !IsValidUUID(account.UUID) {
+		return fmt.Errorf("save account: invalid uuid %q", account.UUID)
+	}
+	if err := upsertAccount(s.db, account); err != nil {
+		return fmt.Errorf("save account: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Load(uuid string) (*Account, error) {
+	if !IsValidUUID(uuid) {
+		return nil, fmt.Errorf("load account: invalid uuid %q", uuid)
+	}
+	account, err := scanAccount(s.db.QueryRow(`SELECT `+accountColumns+` FROM accounts WHERE uuid = ?`, uuid))
+	if err != nil {
+		return nil, fmt.Errorf("load account: %w", err)
+	}
+	return account, nil
+}
+
+func (s *SQLiteStore) Delete(uuid string) error {
+	if !IsValidUUID(uuid) {
+		return fmt.Errorf("delete account: invalid uuid %q", uuid)
+	}
+	if _, err := s.db.Exec(`DELETE FROM accounts WHERE uuid = ?`, uuid); err != nil {
+		return fmt.Errorf("delete account: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) List() ([]*Account, error) {
+	rows, err := s.db.Query(`SELECT ` + accountColumns + ` FROM accounts ORDER BY created_at, uuid`)
+	if err != nil {
+		return nil, fmt.Errorf("list accounts: %w", err)
+	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+	for rows.Next() {
+		account, err := scanAccount(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list accounts: %w", err)
+		}
+		accounts = append(accounts, account)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list accounts: %w", err)
+	}
+	return accounts, nil
+}
+
+func (s *SQLiteStore) Exists(uuid string) bool {
+	if !IsValidUUID(uuid) {
+		return false
+	}
+	var found int
+	err := s.db.QueryRow(`SELECT 1 FROM accounts WHERE uuid = ?`, uuid).Scan(&found)
+	return err == nil
+}
+
+// Update runs the read-modify-write in one transaction.
+func (s *SQLiteStore) Update(uuid string, fn func(*Account) error) (*Account, error) {
+	if !IsValidUUID(uuid) {
+		return nil, fmt.Errorf("update account: invalid uuid %q", uuid)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("update account: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	account, err := scanAccount(tx.QueryRow(`SELECT `+accountColumns+` FROM accounts WHERE uuid = ?`, uuid))
+	if err != nil {
+		return nil, fmt.Errorf("update account: %w", err)
+	}
+	if err := fn(account); err != nil {
+		return nil, err
+	}
+	if err := upsertAccount(tx, account); err != nil {
+		return nil, fmt.Errorf("update account: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("update account: commit: %w", err)
+	}
+	return account, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) watchDir() string {
+	return s.dataDir
+}
+
+// File events cannot tell which row changed, so any write to the database or
+// its WAL invalidates every account.
+func (s *SQLiteStore) changedAccount(path string) (string, bool) {
+	return "", strings.HasPrefix(filepath.Base(path), sqliteFileName)
+}
+
+// Any write may touch the database, its WAL and the shared-memory index.
+func (s *SQLiteStore) writtenFiles(string) []string {
+	path := filepath.Join(s.dataDir, sqliteFileName)
+	return []string{path, path + "-wal", path + "-shm"}
+}
+
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func upsertAccount(db sqlExecer, a *Account) error {
+	_, err := db.Exec(`INSERT INTO accounts (`+accountColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (uuid) DO UPDATE SET
+			api_key = excluded.api_key,
+			base_url = excluded.base_url,
+			auth_type = excluded.auth_type,
+			oauth_access_token = excluded.oauth_access_token,
+			oauth_refresh_token = excluded.oauth_refresh_token,
+			oauth_expires_at = excluded.oauth_expires_at,
+			created_at = excluded.created_at,
+			updated_at = excluded.updated_at,
+			last_used_at = excluded.last_used_at,
+			request_count = excluded.request_count,
+			weight = excluded.weight,
+			proxy_url = excluded.proxy_url,
+			cooldown_until = excluded.cooldown_until,
+			failure_count = excluded.failure_count,
+			data_key = excluded.data_key,
+			rate_rpm = excluded.rate_rpm,
+			rate_tpm = excluded.rate_tpm,
+			daily_tokens = excluded.daily_tokens,
+			monthly_tokens = excluded.monthly_tokens,
+			username = excluded.username,
+			phone = excluded.phone`,
+		a.UUID, a.APIKey, a.BaseURL, a.AuthType, a.OAuthAccessToken, a.OAuthRefreshToken,
+		toUnixNano(a.OAuthExpiresAt), toUnixNano(a.CreatedAt), toUnixNano(a.UpdatedAt), toUnixNano(a.LastUsedAt),
+		a.RequestCount, a.Weight, a.ProxyURL, toUnixNano(a.CooldownUntil), a.FailureCount, a.DataKey,
+		a.Limits.RPM, a.Limits.TPM, a.Limits.DailyTokens, a.Limits.MonthlyTokens,
+		a.Username, a.Phone,
+	)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAccount(row rowScanner) (*Account, error) {
+	var a Account
+	var expiresAt, createdAt, updatedAt, lastUsedAt, cooldownUntil int64
+	err := row.Scan(
+		&a.UUID, &a.APIKey, &a.BaseURL, &a.AuthType, &a.OAuthAccessToken, &a.OAuthRefreshToken,
+		&expiresAt, &createdAt, &updatedAt, &lastUsedAt, &a.RequestCount, &a.Weight, &a.ProxyURL,
+		&cooldownUntil, &a.FailureCount, &a.DataKey,
+		&a.Limits.RPM, &a.Limits.TPM, &a.Limits.DailyTokens, &a.Limits.MonthlyTokens,
+		&a.Username, &a.Phone,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("account not found: %w", os.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.OAuthExpiresAt = fromUnixNano(expiresAt)
+	a.CreatedAt = fromUnixNano(createdAt)
+	a.UpdatedAt = fromUnixNano(updatedAt)
+	a.LastUsedAt = fromUnixNano(lastUsedAt)
+	a.CooldownUntil = fromUnixNano(cooldownUntil)
+	return &a, nil
+}
+
+// Zero times are stored as 0 so they read back as time.Time{}.
+func toUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
@@ -0,0 +1,199 @@
+package account
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rogeecn/iflow-go/internal/ratelimit"
+)
+
+func TestSQLiteStoreCRUD(t *testing.T) {
+	store, err := OpenSQLiteStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenSQLiteStore() error = %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now().UTC()
+	account := &Account{
+		UUID:              GenerateUUID(),
+		APIKey:            "sk-test",
+		BaseURL:           "https://apis.iflow.cn/v1",
+		AuthType:          "oauth-iflow",
+		Username:          "tester",
+		Phone:             "138****0000",
+		OAuthRefreshToken: "refresh",
+		OAuthExpiresAt:    now.Add(time.Hour),
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		Weight:            3,
+		ProxyURL:          "socks5://127.0.0.1:1080",
+		Limits:            ratelimit.Limits{RPM: 60, DailyTokens: 1000},
+	}
+
+	if err := store.Save(account); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if !store.Exists(account.UUID) {
+		t.Fatalf("Exists() = false, want true")
+	}
+
+	loaded, err := store.Load(account.UUID)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.APIKey != account.APIKey || loaded.Weight != 3 || loaded.ProxyURL != account.ProxyURL || loaded.Limits != account.Limits || loaded.Username != account.Username || loaded.Phone != account.Phone {
+		t.Fatalf("Load() = %+v, want %+v", loaded, account)
+	}
+	if !loaded.OAuthExpiresAt.Equal(account.OAuthExpiresAt) || !loaded.CreatedAt.Equal(now) {
+		t.Fatalf("times not preserved: %+v", loaded)
+	}
+	if !loaded.LastUsedAt.IsZero() || !loaded.CooldownUntil.IsZero() {
+		t.Fatalf("zero times should stay zero: %+v", loaded)
+	}
+
+	older := &Account{UUID: GenerateUUID(), APIKey: "sk-old", CreatedAt: now.Add(-time.Hour), UpdatedAt: now}
+	if err := store.Save(older); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	accounts, err := store.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(accounts) != 2 || accounts[0].UUID != older.UUID {
+		t.Fatalf("List() should be ordered by created_at, got %d accounts", len(accounts))
+	}
+
+	if err := store.Delete(account.UUID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if store.Exists(account.UUID) {
+		t.Fatalf("Exists() = true, want false")
+	}
+	if _, err := store.Load(account.UUID); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Load() after delete error = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestSQLiteStoreUpdateIsTransactional(t *testing.T) {
+	dataDir := t.TempDir()
+
+	// Two handles on the same database stand in for two processes; only the
+	// transaction keeps their increments from being lost.
+	first, err := OpenSQLiteStore(dataDir)
+	if err != nil {
+		t.Fatalf("OpenSQLiteStore() error = %v", err)
+	}
+	defer first.Close()
+	second, err := OpenSQLiteStore(dataDir)
+	if err != nil {
+		t.Fatalf("OpenSQLiteStore() error = %v", err)
+	}
+	defer second.Close()
+
+	created, err := NewManagerWithStore(first).Create("sk-test", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	const workers = 20
+	var wg sync.WaitGroup
+	errCh := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		store := first
+		if i%2 == 1 {
+			store = second
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.Update(created.UUID, func(a *Account) error {
+				a.RequestCount++
+				return nil
+			})
+			errCh <- err
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		if err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+
+	loaded, err := first.Load(created.UUID)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.RequestCount != workers {
+		t.Fatalf("RequestCount = %d, want %d", loaded.RequestCount, workers)
+	}
+
+	if _, err := first.Update(created.UUID, func(a *Account) error {
+		a.Weight = 9
+		return errors.New("abort")
+	}); err == nil {
+		t.Fatal("Update() should return the callback error")
+	}
+	loaded, _ = first.Load(created.UUID)
+	if loaded.Weight != 0 {
+		t.Fatalf("failed Update() should not persist, weight = %d", loaded.Weight)
+	}
+}
+
+func TestSQLiteStoreMigrationsIdempotent(t *testing.T) {
+	dataDir := t.TempDir()
+
+	for i := 0; i < 2; i++ {
+		store, err := OpenSQLiteStore(dataDir)
+		if err != nil {
+			t.Fatalf("OpenSQLiteStore() #%d error = %v", i, err)
+		}
+		version, err := store.SchemaVersion()
+		if err != nil {
+			t.Fatalf("SchemaVersion() error = %v", err)
+		}
+		if version != len(sqliteMigrations) {
+			t.Fatalf("SchemaVersion() = %d, want %d", version, len(sqliteMigrations))
+		}
+		var indexes int
+		if err := store.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_accounts_api_key'`).Scan(&indexes); err != nil {
+			t.Fatalf("query indexes: %v", err)
+		}
+		if indexes != 0 {
+			t.Fatal("api_key index should be dropped")
+		}
+		store.Close()
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	if _, err := OpenStore("redis", t.TempDir()); err == nil {
+		t.Fatal("expected unknown storage error")
+	}
+
+	manager, err := OpenManager(StoreSQLite, t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("OpenManager() error = %v", err)
+	}
+	defer manager.Close()
+
+	created, err := manager.Create("sk-test", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := manager.UpdateUsage(created.UUID); err != nil {
+		t.Fatalf("UpdateUsage() error = %v", err)
+	}
+	got, err := manager.Get(created.UUID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.RequestCount != 1 || got.LastUsedAt.IsZero() {
+		t.Fatalf("unexpected account after UpdateUsage: %+v", got)
+	}
+}
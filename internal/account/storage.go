@@ -9,6 +9,8 @@ import (
 	"strings"
 )
 
+// Storage is the file-backed Store: one JSON file per account under
+// <dataDir>/accounts.
 type Storage struct {
 	dataDir string
 }
@@ -109,14 +111,37 @@ func (s *Storage) List() ([]*Account, error) {
 		accounts = append(accounts, &account)
 	}
 
+	sortAccounts(accounts)
+	return accounts, nil
+}
+
+func sortAccounts(accounts []*Account) {
 	sort.Slice(accounts, func(i, j int) bool {
 		if accounts[i].CreatedAt.Equal(accounts[j].CreatedAt) {
 			return accounts[i].UUID < accounts[j].UUID
 		}
 		return accounts[i].CreatedAt.Before(accounts[j].CreatedAt)
 	})
+}
 
-	return accounts, nil
+// Update relies on the Manager lock for atomicity; the file store has no
+// cross-process locking.
+func (s *Storage) Update(uuid string, fn func(*Account) error) (*Account, error) {
+	account, err := s.Load(uuid)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(account); err != nil {
+		return nil, err
+	}
+	if err := s.Save(account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func (s *Storage) Close() error {
+	return nil
 }
 
 func (s *Storage) Exists(uuid string) bool {
@@ -128,6 +153,22 @@ func (s *Storage) Exists(uuid string) bool {
 	return err == nil
 }
 
+func (s *Storage) watchDir() string {
+	return s.accountsDir()
+}
+
+func (s *Storage) changedAccount(path string) (string, bool) {
+	uuid, ok := strings.CutSuffix(filepath.Base(path), ".json")
+	if !ok || !IsValidUUID(uuid) {
+		return "", false
+	}
+	return uuid, true
+}
+
+func (s *Storage) writtenFiles(uuid string) []string {
+	return []string{s.accountPath(uuid)}
+}
+
 func (s *Storage) ensureAccountsDir() error {
 	return os.MkdirAll(s.accountsDir(), 0o755)
 }
@@ -0,0 +1,35 @@
+package account
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Store persists accounts. Update must apply fn and save the result as one
+// atomic step with respect to other writers of the same store.
+type Store interface {
+	Save(account *Account) error
+	Load(uuid string) (*Account, error)
+	Delete(uuid string) error
+	List() ([]*Account, error)
+	Exists(uuid string) bool
+	Update(uuid string, fn func(*Account) error) (*Account, error)
+	Close() error
+}
+
+const (
+	StoreFile   = "file"
+	StoreSQLite = "sqlite"
+)
+
+// OpenStore opens the store kind ("file" or "sqlite") under dataDir.
+func OpenStore(kind, dataDir string) (Store, error) {
+	switch strings.ToLower(strings.TrimSpace(kind)) {
+	case "", StoreFile:
+		return NewStorage(dataDir), nil
+	case StoreSQLite:
+		return OpenSQLiteStore(dataDir)
+	default:
+		return nil, fmt.Errorf("open store: unknown storage %q (want file or sqlite)", kind)
+	}
+}
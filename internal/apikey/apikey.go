@@ -0,0 +1,405 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rogeecn/iflow-go/internal/ratelimit"
+)
+
+// Prefix marks issued client keys so they are easy to tell apart from
+// account UUIDs and upstream API keys.
+const Prefix = "sk-iflowgo-"
+
+const (
+	keysFileName   = "client_keys.json"
+	reloadInterval = time.Second
+)
+
+var (
+	ErrNotFound = errors.New("api key not found")
+	ErrRevoked  = errors.New("api key revoked")
+	ErrExpired  = errors.New("api key expired")
+)
+
+// Key is an issued client key. Only the SHA-256 of the secret is stored; the
+// secret itself is shown once by Create.
+type Key struct {
+	ID        string           `json:"id"`
+	Hash      string           `json:"hash"`
+	Hint      string           `json:"hint"`
+	Label     string           `json:"label,omitempty"`
+	Account   string           `json:"account,omitempty"`
+	Pool      bool             `json:"pool,omitempty"`
+	Models    []string         `json:"models,omitempty"`
+	Routes    []string         `json:"routes,omitempty"`
+	CIDRs     []string         `json:"cidrs,omitempty"`
+	Limits    ratelimit.Limits `json:"limits,omitzero"`
+	ExpiresAt time.Time        `json:"expires_at"`
+	CreatedAt time.Time        `json:"created_at"`
+	RevokedAt time.Time        `json:"revoked_at"`
+}
+
+// Options describes a key to issue. Exactly one of Account and Pool must be
+// set; empty Models and Routes allow everything.
+type Options struct {
+	Label     string
+	Account   string
+	Pool      bool
+	Models    []string
+	Routes    []string
+	CIDRs     []string
+	Limits    ratelimit.Limits
+	ExpiresAt time.Time
+}
+
+// Check reports whether the key can still be used at now.
+func (k *Key) Check(now time.Time) error {
+	if !k.RevokedAt.IsZero() {
+		return ErrRevoked
+	}
+	if !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt) {
+		return ErrExpired
+	}
+	return nil
+}
+
+func (k *Key) AllowsModel(model string) bool {
+	return matchAny(k.Models, strings.ToLower(strings.TrimSpace(model)), strings.ToLower)
+}
+
+// AllowsRoute matches the route pattern the request was registered under,
+// e.g. /v1/chat/completions or /v1/models/{id}.
+func (k *Key) AllowsRoute(route string) bool {
+	return matchAny(k.Routes, route, nil)
+}
+
+func (k *Key) AllowsAddr(addr netip.Addr) bool {
+	if len(k.CIDRs) == 0 {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, cidr := range k.CIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Target describes what the key is bound to, for listings.
+func (k *Key) Target() string {
+	if k.Pool {
+		return "pool"
+	}
+	return k.Account
+}
+
+// matchAny treats an empty pattern list as allow-all and a trailing "*" as a
+// prefix match.
+func matchAny(patterns []string, value string, normalize func(string) string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if normalize != nil {
+			pattern = normalize(pattern)
+		}
+		if pattern == "*" || pattern == value {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Store keeps client keys in <dataDir>/client_keys.json. The CLI writes the
+// file while the server only reads it, so the server reloads whenever the
+// file changes on disk.
+type Store struct {
+	path string
+	now  func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]*Key
+	modTime   time.Time
+	size      int64
+	checkedAt time.Time
+}
+
+func NewStore(dataDir string) *Store {
+	return &Store{
+		path: filepath.Join(dataDir, keysFileName),
+		now:  time.Now,
+	}
+}
+
+// Create issues a new key and returns its secret, which is not recoverable
+// afterwards.
+func (s *Store) Create(opts Options) (string, *Key, error) {
+	key, err := newKey(opts, s.now().UTC())
+	if err != nil {
+		return "", nil, fmt.Errorf("create api key: %w", err)
+	}
+	secret, err := randomHex(20)
+	if err != nil {
+		return "", nil, fmt.Errorf("create api key: %w", err)
+	}
+	secret = Prefix + secret
+	key.Hash = hashSecret(secret)
+	key.Hint = secret[:len(Prefix)+4] + "..." + secret[len(secret)-4:]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, err := s.readFile()
+	if err != nil {
+		return "", nil, fmt.Errorf("create api key: %w", err)
+	}
+	keys = append(keys, key)
+	if err := s.writeFile(keys); err != nil {
+		return "", nil, fmt.Errorf("create api key: %w", err)
+	}
+
+	copied := *key
+	return secret, &copied, nil
+}
+
+// List returns all keys, including revoked and expired ones, oldest first.
+func (s *Store) List() ([]Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, err := s.readFile()
+	if err != nil {
+		return nil, fmt.Errorf("list api keys: %w", err)
+	}
+	result := make([]Key, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, *key)
+	}
+	return result, nil
+}
+
+// Revoke marks the key with the given id as revoked. Revoked keys stay in the
+// file so listings keep their history.
+func (s *Store) Revoke(id string) (*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, err := s.readFile()
+	if err != nil {
+		return nil, fmt.Errorf("revoke api key: %w", err)
+	}
+	for _, key := range keys {
+		if key.ID != id {
+			continue
+		}
+		if key.RevokedAt.IsZero() {
+			key.RevokedAt = s.now().UTC()
+			if err := s.writeFile(keys); err != nil {
+				return nil, fmt.Errorf("revoke api key: %w", err)
+			}
+		}
+		copied := *key
+		return &copied, nil
+	}
+	return nil, fmt.Errorf("revoke api key %s: %w", id, ErrNotFound)
+}
+
+// Authenticate resolves a client secret to its key. Revoked and expired keys
+// are returned together with ErrRevoked or ErrExpired.
+func (s *Store) Authenticate(secret string) (*Key, error) {
+	if !strings.HasPrefix(secret, Prefix) {
+		return nil, ErrNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reloadLocked(); err != nil {
+		return nil, fmt.Errorf("authenticate api key: %w", err)
+	}
+	key, ok := s.keys[hashSecret(secret)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *key
+	return &copied, key.Check(s.now())
+}
+
+func (s *Store) reloadLocked() error {
+	now := s.now()
+	if s.keys != nil && now.Sub(s.checkedAt) < reloadInterval {
+		return nil
+	}
+	s.checkedAt = now
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.keys = map[string]*Key{}
+			s.modTime, s.size = time.Time{}, 0
+			return nil
+		}
+		return fmt.Errorf("stat keys file: %w", err)
+	}
+	if s.keys != nil && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+
+	keys, err := s.readFile()
+	if err != nil {
+		return err
+	}
+	byHash := make(map[string]*Key, len(keys))
+	for _, key := range keys {
+		byHash[key.Hash] = key
+	}
+	s.keys = byHash
+	s.modTime, s.size = info.ModTime(), info.Size()
+	return nil
+}
+
+func (s *Store) readFile() ([]*Key, error) {
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read keys file: %w", err)
+	}
+
+	var keys []*Key
+	if err := json.Unmarshal(content, &keys); err != nil {
+		return nil, fmt.Errorf("unmarshal keys file: %w", err)
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (s *Store) writeFile(keys []*Key) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("ensure data dir: %w", err)
+	}
+	payload, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal keys file: %w", err)
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, payload, 0o600); err != nil {
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("rename temp file: %w", err)
+	}
+
+	// Force the next Authenticate in this process to pick up the change.
+	s.keys = nil
+	return nil
+}
+
+func newKey(opts Options, now time.Time) (*Key, error) {
+	account := strings.TrimSpace(opts.Account)
+	if (account == "") == !opts.Pool {
+		return nil, fmt.Errorf("exactly one of account or pool is required")
+	}
+	if err := opts.Limits.Validate(); err != nil {
+		return nil, err
+	}
+	if !opts.ExpiresAt.IsZero() && !opts.ExpiresAt.After(now) {
+		return nil, fmt.Errorf("expiry %s is in the past", opts.ExpiresAt.Format(time.RFC3339))
+	}
+
+	routes := cleanList(opts.Routes)
+	for _, route := range routes {
+		if !strings.HasPrefix(route, "/") {
+			return nil, fmt.Errorf("invalid route %q: must start with /", route)
+		}
+	}
+	cidrs := cleanList(opts.CIDRs)
+	for i, cidr := range cidrs {
+		prefix, err := parseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		cidrs[i] = prefix.String()
+	}
+
+	id, err := randomHex(6)
+	if err != nil {
+		return nil, err
+	}
+	key := &Key{
+		ID:        "key_" + id,
+		Label:     strings.TrimSpace(opts.Label),
+		Account:   account,
+		Pool:      opts.Pool,
+		Models:    cleanList(opts.Models),
+		Routes:    routes,
+		CIDRs:     cidrs,
+		Limits:    opts.Limits,
+		CreatedAt: now,
+	}
+	if !opts.ExpiresAt.IsZero() {
+		key.ExpiresAt = opts.ExpiresAt.UTC()
+	}
+	return key, nil
+}
+
+// parseCIDR accepts a prefix or a bare address, which is treated as a
+// single-host prefix.
+func parseCIDR(value string) (netip.Prefix, error) {
+	if !strings.Contains(value, "/") {
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid cidr %q: %w", value, err)
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid cidr %q: %w", value, err)
+	}
+	return prefix.Masked(), nil
+}
+
+func cleanList(values []string) []string {
+	var cleaned []string
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			cleaned = append(cleaned, value)
+		}
+	}
+	return cleaned
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("read random bytes: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
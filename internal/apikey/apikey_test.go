@@ -0,0 +1,124 @@
+package apikey
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreCreateAuthenticateRevoke(t *testing.T) {
+	dataDir := t.TempDir()
+	store := NewStore(dataDir)
+
+	secret, key, err := store.Create(Options{Label: "ci", Account: "acct-1", Models: []string{"glm-*"}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if !strings.HasPrefix(secret, Prefix) || !strings.HasPrefix(key.ID, "key_") {
+		t.Fatalf("Create() = %q, %+v", secret, key)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dataDir, keysFileName))
+	if err != nil {
+		t.Fatalf("read keys file: %v", err)
+	}
+	if strings.Contains(string(content), secret) {
+		t.Fatalf("keys file contains the plaintext secret: %s", content)
+	}
+
+	// A second store stands in for the server process reading the CLI's file.
+	server := NewStore(dataDir)
+	got, err := server.Authenticate(secret)
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if got.ID != key.ID || got.Account != "acct-1" {
+		t.Fatalf("Authenticate() = %+v", got)
+	}
+	if _, err := server.Authenticate(Prefix + "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Authenticate(unknown) error = %v, want ErrNotFound", err)
+	}
+
+	if _, err := store.Revoke(key.ID); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	server.checkedAt = time.Time{}
+	if _, err := server.Authenticate(secret); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("Authenticate() after revoke error = %v, want ErrRevoked", err)
+	}
+	if _, err := store.Revoke("key_missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Revoke(missing) error = %v, want ErrNotFound", err)
+	}
+
+	keys, err := store.List()
+	if err != nil || len(keys) != 1 || keys[0].RevokedAt.IsZero() {
+		t.Fatalf("List() = %+v, %v", keys, err)
+	}
+}
+
+func TestStoreExpiredKey(t *testing.T) {
+	store := NewStore(t.TempDir())
+	now := time.Now()
+	secret, _, err := store.Create(Options{Pool: true, ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	store.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if _, err := store.Authenticate(secret); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Authenticate() error = %v, want ErrExpired", err)
+	}
+}
+
+func TestCreateValidatesOptions(t *testing.T) {
+	store := NewStore(t.TempDir())
+	for name, opts := range map[string]Options{
+		"no target":    {},
+		"both targets": {Account: "acct-1", Pool: true},
+		"bad route":    {Pool: true, Routes: []string{"v1/models"}},
+		"bad cidr":     {Pool: true, CIDRs: []string{"10.0.0.0/33"}},
+		"past expiry":  {Pool: true, ExpiresAt: time.Now().Add(-time.Minute)},
+	} {
+		if _, _, err := store.Create(opts); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestKeyScopes(t *testing.T) {
+	store := NewStore(t.TempDir())
+	_, key, err := store.Create(Options{
+		Pool:   true,
+		Models: []string{"GLM-*", "kimi-k2"},
+		Routes: []string{"/v1/chat/completions", "/v1/models*"},
+		CIDRs:  []string{"10.1.0.0/16", "192.168.1.7"},
+	})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	for model, want := range map[string]bool{"glm-4.6": true, "kimi-k2": true, "kimi-k2-0905": false, "qwen3-max": false} {
+		if got := key.AllowsModel(model); got != want {
+			t.Errorf("AllowsModel(%q) = %v, want %v", model, got, want)
+		}
+	}
+	for route, want := range map[string]bool{"/v1/chat/completions": true, "/v1/models/{id}": true, "/v1/messages": false} {
+		if got := key.AllowsRoute(route); got != want {
+			t.Errorf("AllowsRoute(%q) = %v, want %v", route, got, want)
+		}
+	}
+	for addr, want := range map[string]bool{"10.1.2.3": true, "::ffff:10.1.2.3": true, "192.168.1.7": true, "192.168.1.8": false} {
+		if got := key.AllowsAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("AllowsAddr(%q) = %v, want %v", addr, got, want)
+		}
+	}
+
+	open := &Key{}
+	if !open.AllowsModel("anything") || !open.AllowsRoute("/v1/usage") || !open.AllowsAddr(netip.MustParseAddr("1.2.3.4")) {
+		t.Fatal("key without scopes should allow everything")
+	}
+}
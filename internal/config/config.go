@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/caarlos0/env/v10"
 	"github.com/joho/godotenv"
@@ -11,13 +12,38 @@ import (
 
 // Config defines all environment-driven runtime options.
 type Config struct {
-	Host                     string `env:"IFLOW_HOST" envDefault:"0.0.0.0"`
-	Port                     int    `env:"IFLOW_PORT" envDefault:"28000"`
-	Concurrency              int    `env:"IFLOW_CONCURRENCY" envDefault:"1"`
-	DataDir                  string `env:"IFLOW_DATA_DIR" envDefault:"./data"`
-	LogLevel                 string `env:"IFLOW_LOG_LEVEL" envDefault:"info"`
-	Proxy                    string `env:"IFLOW_UPSTREAM_PROXY"`
-	PreserveReasoningContent bool   `env:"IFLOW_PRESERVE_REASONING_CONTENT" envDefault:"true"`
+	Host                     string        `env:"IFLOW_HOST" envDefault:"0.0.0.0"`
+	Port                     int           `env:"IFLOW_PORT" envDefault:"28000"`
+	Concurrency              int           `env:"IFLOW_CONCURRENCY" envDefault:"0"`
+	GlobalConcurrency        int           `env:"IFLOW_GLOBAL_CONCURRENCY" envDefault:"0"`
+	QueueSize                int           `env:"IFLOW_QUEUE_SIZE" envDefault:"64"`
+	QueueTimeout             time.Duration `env:"IFLOW_QUEUE_TIMEOUT" envDefault:"30s"`
+	DataDir                  string        `env:"IFLOW_DATA_DIR" envDefault:"./data"`
+	Storage                  string        `env:"IFLOW_STORAGE" envDefault:"file"`
+	MasterKey                string        `env:"IFLOW_MASTER_KEY"`
+	MasterKeyFile            string        `env:"IFLOW_MASTER_KEY_FILE"`
+	AccountCache             bool          `env:"IFLOW_ACCOUNT_CACHE" envDefault:"true"`
+	UsageFlushInterval       time.Duration `env:"IFLOW_USAGE_FLUSH_INTERVAL" envDefault:"2s"`
+	ResponseRetention        time.Duration `env:"IFLOW_RESPONSE_RETENTION" envDefault:"720h"`
+	LogLevel                 string        `env:"IFLOW_LOG_LEVEL" envDefault:"info"`
+	Proxy                    string        `env:"IFLOW_UPSTREAM_PROXY"`
+	NoProxy                  string        `env:"IFLOW_NO_PROXY"`
+	PreserveReasoningContent bool          `env:"IFLOW_PRESERVE_REASONING_CONTENT" envDefault:"true"`
+	PoolKey                  string        `env:"IFLOW_POOL_KEY"`
+	PoolStrategy             string        `env:"IFLOW_POOL_STRATEGY" envDefault:"round_robin"`
+	LegacyUUIDAuth           bool          `env:"IFLOW_LEGACY_UUID_AUTH" envDefault:"false"`
+	Telemetry                string        `env:"IFLOW_TELEMETRY" envDefault:"upstream"`
+	TelemetryFile            string        `env:"IFLOW_TELEMETRY_FILE"`
+	SSEKeepalive             time.Duration `env:"IFLOW_SSE_KEEPALIVE" envDefault:"15s"`
+	ConnectTimeout           time.Duration `env:"IFLOW_UPSTREAM_CONNECT_TIMEOUT" envDefault:"15s"`
+	FirstTokenTimeout        time.Duration `env:"IFLOW_UPSTREAM_FIRST_TOKEN_TIMEOUT" envDefault:"180s"`
+	IdleTimeout              time.Duration `env:"IFLOW_UPSTREAM_IDLE_TIMEOUT" envDefault:"120s"`
+	RequestTimeout           time.Duration `env:"IFLOW_UPSTREAM_REQUEST_TIMEOUT" envDefault:"300s"`
+	PassthroughAllow         string        `env:"IFLOW_PASSTHROUGH_ALLOW"`
+	PassthroughDeny          string        `env:"IFLOW_PASSTHROUGH_DENY"`
+	ModelCatalogTTL          time.Duration `env:"IFLOW_MODEL_CATALOG_TTL" envDefault:"1h"`
+	ModelOverrides           string        `env:"IFLOW_MODEL_OVERRIDES"`
+	ModelProfiles            string        `env:"IFLOW_MODEL_PROFILES"`
 }
 
 // Load reads .env (if present) and parses environment variables into Config.
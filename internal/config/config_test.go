@@ -1,6 +1,9 @@
 package config
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestLoad(t *testing.T) {
 	t.Setenv("IFLOW_HOST", "127.0.0.1")
@@ -57,3 +60,41 @@ func TestLoadPreserveReasoningDefault(t *testing.T) {
 		t.Fatalf("PreserveReasoningContent = %v, want true", cfg.PreserveReasoningContent)
 	}
 }
+
+func TestLoadAdmissionSettings(t *testing.T) {
+	t.Setenv("IFLOW_GLOBAL_CONCURRENCY", "8")
+	t.Setenv("IFLOW_QUEUE_TIMEOUT", "1500ms")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.GlobalConcurrency != 8 {
+		t.Fatalf("GlobalConcurrency = %d, want 8", cfg.GlobalConcurrency)
+	}
+	if cfg.QueueSize != 64 {
+		t.Fatalf("QueueSize = %d, want default 64", cfg.QueueSize)
+	}
+	if cfg.QueueTimeout != 1500*time.Millisecond {
+		t.Fatalf("QueueTimeout = %s, want 1.5s", cfg.QueueTimeout)
+	}
+}
+
+func TestLoadStreamTimeouts(t *testing.T) {
+	t.Setenv("IFLOW_UPSTREAM_IDLE_TIMEOUT", "45s")
+	t.Setenv("IFLOW_SSE_KEEPALIVE", "0")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.IdleTimeout != 45*time.Second {
+		t.Fatalf("IdleTimeout = %s, want 45s", cfg.IdleTimeout)
+	}
+	if cfg.SSEKeepalive != 0 {
+		t.Fatalf("SSEKeepalive = %s, want 0", cfg.SSEKeepalive)
+	}
+	if cfg.ConnectTimeout != 15*time.Second || cfg.FirstTokenTimeout != 180*time.Second || cfg.RequestTimeout != 300*time.Second {
+		t.Fatalf("unexpected timeout defaults: %+v", cfg)
+	}
+}
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "iflow"
+
+// Registry holds every iflow-go collector plus the Go runtime and process
+// collectors. It is served by Handler on /metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route, status, model and account.",
+	}, []string{"route", "status", "model", "account"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, including the full stream duration.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"route", "status", "model", "account"})
+
+	StreamTTFT = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "stream_time_to_first_token_seconds",
+		Help:      "Time from request start to the first streamed chunk.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 15, 30, 60},
+	}, []string{"route", "model", "account"})
+
+	StreamsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "streams_in_flight",
+		Help:      "Streaming responses currently being written to clients.",
+	}, []string{"route"})
+
+	UpstreamResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_responses_total",
+		Help:      "Responses received from the iFlow API, by status code.",
+	}, []string{"status", "model", "account"})
+
+	SSEChunks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sse_chunks_total",
+		Help:      "SSE chunks forwarded from the iFlow API.",
+	}, []string{"model", "account"})
+
+	Tokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_total",
+		Help:      "Tokens reported in upstream usage, by type.",
+	}, []string{"type", "model", "account"})
+
+	OAuthRefresh = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "oauth_refresh_total",
+		Help:      "OAuth refresher outcomes (success, refresh_failed, userinfo_failed, persist_failed, list_failed).",
+	}, []string{"outcome"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		StreamTTFT,
+		StreamsInFlight,
+		UpstreamResponses,
+		SSEChunks,
+		Tokens,
+		OAuthRefresh,
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// AccountLabel shortens an account UUID so the bearer credential never ends
+// up in metric labels.
+func AccountLabel(uuid string) string {
+	uuid = strings.TrimSpace(uuid)
+	if uuid == "" {
+		return "unknown"
+	}
+	if len(uuid) > 8 {
+		return uuid[:8]
+	}
+	return uuid
+}
+
+// knownModels maps lower-cased model ids to the label reported for them.
+var knownModels sync.Map
+
+// RegisterModels marks ids as valid model labels: the built-in catalog,
+// models fetched from iFlow and configured aliases and profiles.
+func RegisterModels(ids ...string) {
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id != "" {
+			knownModels.LoadOrStore(strings.ToLower(id), id)
+		}
+	}
+}
+
+// ModelLabel reports a client-supplied model name as a registered id, or as
+// "other", so /metrics cannot be flooded with arbitrary series.
+func ModelLabel(model string) string {
+	model = strings.TrimSpace(model)
+	if model == "" {
+		return "unknown"
+	}
+	if id, ok := knownModels.Load(strings.ToLower(model)); ok {
+		return id.(string)
+	}
+	return "other"
+}
+
+func StatusLabel(status int) string {
+	if status <= 0 {
+		return "error"
+	}
+	return strconv.Itoa(status)
+}
+
+func ObserveUpstream(status int, model, account string) {
+	UpstreamResponses.WithLabelValues(StatusLabel(status), ModelLabel(model), AccountLabel(account)).Inc()
+}
+
+func ObserveTokens(model, account string, prompt, completion int) {
+	modelLabel, accountLabel := ModelLabel(model), AccountLabel(account)
+	if prompt > 0 {
+		Tokens.WithLabelValues("prompt", modelLabel, accountLabel).Add(float64(prompt))
+	}
+	if completion > 0 {
+		Tokens.WithLabelValues("completion", modelLabel, accountLabel).Add(float64(completion))
+	}
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLabels(t *testing.T) {
+	if got := AccountLabel("0f8fad5b-d9cb-469f-a165-70867728950e"); got != "0f8fad5b" {
+		t.Fatalf("AccountLabel() = %q", got)
+	}
+	if got := AccountLabel(""); got != "unknown" {
+		t.Fatalf("AccountLabel(empty) = %q", got)
+	}
+	if got := ModelLabel(" "); got != "unknown" {
+		t.Fatalf("ModelLabel(empty) = %q", got)
+	}
+	RegisterModels("GLM-Label-Test")
+	if got := ModelLabel("glm-label-test"); got != "GLM-Label-Test" {
+		t.Fatalf("ModelLabel(registered) = %q", got)
+	}
+	if got := ModelLabel("random-9f2c"); got != "other" {
+		t.Fatalf("ModelLabel(unregistered) = %q", got)
+	}
+	if got := StatusLabel(0); got != "error" {
+		t.Fatalf("StatusLabel(0) = %q", got)
+	}
+	if got := StatusLabel(429); got != "429" {
+		t.Fatalf("StatusLabel(429) = %q", got)
+	}
+}
+
+func TestObserveTokens(t *testing.T) {
+	RegisterModels("metrics-test-model")
+	ObserveTokens("metrics-test-model", "acct-1234567890", 7, 3)
+	ObserveTokens("metrics-test-model", "acct-1234567890", 0, 2)
+
+	if got := testutil.ToFloat64(Tokens.WithLabelValues("prompt", "metrics-test-model", "acct-123")); got != 7 {
+		t.Fatalf("prompt tokens = %v, want 7", got)
+	}
+	if got := testutil.ToFloat64(Tokens.WithLabelValues("completion", "metrics-test-model", "acct-123")); got != 5 {
+		t.Fatalf("completion tokens = %v, want 5", got)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/internal/transport"
 	"golang.org/x/oauth2"
 )
 
@@ -35,6 +36,7 @@ const (
 	defaultStartPort    = 11451
 	maxPortAttempts     = 50
 	defaultLoginTimeout = 60 * time.Second
+	defaultHTTPTimeout  = 30 * time.Second
 	defaultDataDir      = "./data"
 	defaultBaseURL      = "https://apis.iflow.cn/v1"
 )
@@ -121,7 +123,7 @@ func NewClientWithManager(manager *account.Manager) *Client {
 				TokenURL: TokenURL,
 			},
 		},
-		httpClient:            &http.Client{Timeout: 30 * time.Second},
+		httpClient:            transport.NewClient(defaultHTTPTimeout, ""),
 		manager:               manager,
 		authURL:               AuthURL,
 		tokenURL:              TokenURL,
@@ -133,6 +135,17 @@ func NewClientWithManager(manager *account.Manager) *Client {
 	}
 }
 
+// ForAccount returns a client that egresses through the account's own proxy,
+// or c itself when the account uses the default upstream proxy.
+func (c *Client) ForAccount(acct *account.Account) *Client {
+	if acct == nil || strings.TrimSpace(acct.ProxyURL) == "" {
+		return c
+	}
+	clone := *c
+	clone.httpClient = transport.NewClient(defaultHTTPTimeout, acct.ProxyURL)
+	return &clone
+}
+
 func (c *Client) GetAuthURL(redirectURI, state string) string {
 	if strings.TrimSpace(state) == "" {
 		state = generateRandomState(16)
@@ -258,13 +271,16 @@ func (c *Client) Login(ctx context.Context) (*account.Account, error) {
 		return nil, fmt.Errorf("oauth login: create account: %w", err)
 	}
 
-	if err := c.manager.UpdateToken(acct.UUID, token.AccessToken, token.RefreshToken, token.ExpiresAt); err != nil {
-		return nil, fmt.Errorf("oauth login: save token: %w", err)
-	}
-
-	stored, err := c.manager.Get(acct.UUID)
+	stored, err := c.manager.UpdateCredentials(acct.UUID, account.Credentials{
+		APIKey:       user.APIKey,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		ExpiresAt:    token.ExpiresAt,
+		Username:     user.Username,
+		Phone:        user.Phone,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("oauth login: reload account: %w", err)
+		return nil, fmt.Errorf("oauth login: save token: %w", err)
 	}
 
 	return stored, nil
@@ -2,13 +2,17 @@ package oauth
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/internal/metrics"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
@@ -26,6 +30,9 @@ type Refresher struct {
 
 	mu      sync.Mutex
 	running bool
+
+	// inflight collapses concurrent refreshes of one account.
+	inflight singleflight.Group
 }
 
 func NewRefresher(manager *account.Manager) *Refresher {
@@ -118,6 +125,7 @@ func (r *Refresher) refreshOnce() {
 	accounts, err := r.manager.List()
 	if err != nil {
 		log.Warn().Err(err).Msg("oauth refresher: list accounts failed")
+		metrics.OAuthRefresh.WithLabelValues("list_failed").Inc()
 		return
 	}
 
@@ -130,31 +138,13 @@ func (r *Refresher) refreshOnce() {
 		}
 		candidates++
 
-		token, err := r.client.Refresh(context.Background(), acct.OAuthRefreshToken)
-		if err != nil {
-			log.Warn().
-				Err(err).
-				Str("uuid", acct.UUID).
-				Msg("oauth refresher: refresh token failed")
-			continue
-		}
-
-		expiresAt := token.ExpiresAt
-		if expiresAt.IsZero() {
-			expiresAt = acct.OAuthExpiresAt
-		}
-
-		if err := r.manager.UpdateToken(acct.UUID, token.AccessToken, token.RefreshToken, expiresAt); err != nil {
+		if _, err := r.refresh(context.Background(), acct); err != nil {
 			log.Warn().
 				Err(err).
 				Str("uuid", acct.UUID).
-				Msg("oauth refresher: update account token failed")
+				Msg("oauth refresher: refresh account failed")
 			continue
 		}
-
-		log.Info().
-			Str("uuid", acct.UUID).
-			Msg("oauth refresher: token refreshed")
 		refreshed++
 	}
 
@@ -164,3 +154,146 @@ func (r *Refresher) refreshOnce() {
 		Int("refreshed", refreshed).
 		Msg("oauth refresher: cycle completed")
 }
+
+// RefreshCredentials renews the token of an account whose credentials iFlow
+// rejected mid-request, returning the account with its new API key.
+func (r *Refresher) RefreshCredentials(ctx context.Context, stale *account.Account) (*account.Account, error) {
+	if stale == nil || strings.TrimSpace(stale.OAuthRefreshToken) == "" {
+		return nil, fmt.Errorf("refresh credentials: account has no refresh token")
+	}
+
+	// Detach from the caller so one cancelled request does not fail the others
+	// waiting on the same refresh. The oauth client bounds each call itself.
+	updated, err := r.refresh(context.WithoutCancel(ctx), stale)
+	if err != nil {
+		return nil, fmt.Errorf("refresh credentials: %w", err)
+	}
+	copied := *updated
+	return &copied, nil
+}
+
+// refresh shares one RefreshAccount call between the scheduled cycle and any
+// concurrent on-demand refreshes of the same account, so a refresh token is
+// spent once. A caller holding credentials that were already renewed gets the
+// stored account back without another refresh.
+func (r *Refresher) refresh(ctx context.Context, stale *account.Account) (*account.Account, error) {
+	value, err, shared := r.inflight.Do(stale.UUID, func() (interface{}, error) {
+		current, err := r.manager.Get(stale.UUID)
+		if err != nil {
+			return nil, err
+		}
+		if current.OAuthAccessToken != stale.OAuthAccessToken || current.APIKey != stale.APIKey {
+			return current, nil
+		}
+
+		updated, err := RefreshAccount(ctx, r.client.ForAccount(current), r.manager, current)
+		if err != nil {
+			metrics.OAuthRefresh.WithLabelValues(refreshOutcome(err)).Inc()
+			return nil, err
+		}
+		log.Info().
+			Str("uuid", current.UUID).
+			Msg("oauth refresher: token refreshed")
+		metrics.OAuthRefresh.WithLabelValues("success").Inc()
+		return updated, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if shared {
+		log.Debug().
+			Str("uuid", stale.UUID).
+			Msg("oauth refresher: joined in-flight refresh")
+	}
+	return value.(*account.Account), nil
+}
+
+// TokenClient is the part of Client that RefreshAccount needs.
+type TokenClient interface {
+	Refresh(ctx context.Context, refreshToken string) (*Token, error)
+	GetUserInfo(ctx context.Context, accessToken string) (*UserInfo, error)
+}
+
+// refreshError records which step of RefreshAccount failed, as the outcome
+// label of metrics.OAuthRefresh.
+type refreshError struct {
+	outcome string
+	err     error
+}
+
+func (e *refreshError) Error() string { return e.err.Error() }
+
+func (e *refreshError) Unwrap() error { return e.err }
+
+func refreshOutcome(err error) string {
+	var refreshErr *refreshError
+	if errors.As(err, &refreshErr) {
+		return refreshErr.outcome
+	}
+	return "refresh_failed"
+}
+
+// RefreshAccount renews acct's token, then syncs the API key, username and
+// phone from the user info endpoint and stores them together with the new
+// token. iFlow may issue a new API key on refresh; the rotation is logged.
+// When the user info lookup fails the new token is still stored, because the
+// old refresh token may already be spent.
+func RefreshAccount(ctx context.Context, client TokenClient, manager *account.Manager, acct *account.Account) (*account.Account, error) {
+	refreshToken := strings.TrimSpace(acct.OAuthRefreshToken)
+	if refreshToken == "" {
+		return nil, &refreshError{"refresh_failed", fmt.Errorf("refresh account: account %s has no refresh token", acct.UUID)}
+	}
+
+	token, err := client.Refresh(ctx, refreshToken)
+	if err != nil {
+		return nil, &refreshError{"refresh_failed", fmt.Errorf("refresh account: %w", err)}
+	}
+	if newRefreshToken := strings.TrimSpace(token.RefreshToken); newRefreshToken != "" {
+		refreshToken = newRefreshToken
+	}
+	expiresAt := token.ExpiresAt
+	if expiresAt.IsZero() {
+		expiresAt = acct.OAuthExpiresAt
+	}
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().UTC().Add(24 * time.Hour)
+	}
+
+	user, err := client.GetUserInfo(ctx, token.AccessToken)
+	if err != nil {
+		if persistErr := manager.UpdateToken(acct.UUID, token.AccessToken, refreshToken, expiresAt); persistErr != nil {
+			return nil, &refreshError{"persist_failed", fmt.Errorf("refresh account: %w", persistErr)}
+		}
+		return nil, &refreshError{"userinfo_failed", fmt.Errorf("refresh account: %w", err)}
+	}
+
+	updated, err := manager.UpdateCredentials(acct.UUID, account.Credentials{
+		APIKey:       user.APIKey,
+		AccessToken:  token.AccessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt,
+		Username:     user.Username,
+		Phone:        user.Phone,
+	})
+	if err != nil {
+		return nil, &refreshError{"persist_failed", fmt.Errorf("refresh account: %w", err)}
+	}
+
+	if updated.APIKey != strings.TrimSpace(acct.APIKey) {
+		log.Warn().
+			Str("uuid", acct.UUID).
+			Str("username", updated.Username).
+			Str("old_api_key", maskKey(acct.APIKey)).
+			Str("new_api_key", maskKey(updated.APIKey)).
+			Msg("oauth: api key rotated")
+	}
+	return updated, nil
+}
+
+func maskKey(key string) string {
+	key = strings.TrimSpace(key)
+	if len(key) <= 8 {
+		return key
+	}
+	return key[:4] + "..." + key[len(key)-4:]
+}
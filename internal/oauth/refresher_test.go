@@ -1,7 +1,11 @@
 package oauth
 
 import (
+	"context"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -59,8 +63,15 @@ func TestRefreshOnceUpdatesToken(t *testing.T) {
 	refresher := NewRefresher(manager)
 	refresher.refreshBuffer = 24 * time.Hour
 	refresher.client.tokenURL = "https://example.com/oauth/token"
+	refresher.client.userInfoURL = "https://example.com/api/oauth/getUserInfo"
 	refresher.client.httpClient = &http.Client{
 		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if strings.HasSuffix(r.URL.Path, "/getUserInfo") {
+				if r.URL.Query().Get("accessToken") != "new-access" {
+					t.Fatalf("user info accessToken = %q", r.URL.Query().Get("accessToken"))
+				}
+				return newJSONResponse(http.StatusOK, `{"success":true,"data":{"apiKey":"sk-new","username":"tester","phone":"138****0000"}}`), nil
+			}
 			if err := r.ParseForm(); err != nil {
 				t.Fatalf("ParseForm error: %v", err)
 			}
@@ -89,6 +100,111 @@ func TestRefreshOnceUpdatesToken(t *testing.T) {
 	if time.Until(updated.OAuthExpiresAt) <= time.Hour {
 		t.Fatalf("expires_at not updated, got %s", updated.OAuthExpiresAt)
 	}
+	if updated.APIKey != "sk-new" || updated.Username != "tester" || updated.Phone != "138****0000" {
+		t.Fatalf("user info not synced: %+v", updated)
+	}
+}
+
+func TestRefreshAccountKeepsTokenWhenUserInfoFails(t *testing.T) {
+	manager := account.NewManager(t.TempDir())
+	acct, err := manager.Create("sk-old", "")
+	if err != nil {
+		t.Fatalf("create account: %v", err)
+	}
+	if err := manager.UpdateToken(acct.UUID, "old-access", "old-refresh", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("seed token: %v", err)
+	}
+	acct, err = manager.Get(acct.UUID)
+	if err != nil {
+		t.Fatalf("load account: %v", err)
+	}
+
+	client := NewClientWithManager(manager)
+	client.tokenURL = "https://example.com/oauth/token"
+	client.userInfoURL = "https://example.com/api/oauth/getUserInfo"
+	client.httpClient = &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if strings.HasSuffix(r.URL.Path, "/getUserInfo") {
+				return newJSONResponse(http.StatusInternalServerError, `{}`), nil
+			}
+			return newJSONResponse(http.StatusOK, `{"access_token":"new-access","refresh_token":"new-refresh","expires_in":7200}`), nil
+		}),
+	}
+
+	_, err = RefreshAccount(context.Background(), client, manager, acct)
+	if err == nil || refreshOutcome(err) != "userinfo_failed" {
+		t.Fatalf("error = %v, want userinfo_failed", err)
+	}
+	stored, err := manager.Get(acct.UUID)
+	if err != nil {
+		t.Fatalf("load account: %v", err)
+	}
+	if stored.APIKey != "sk-old" || stored.OAuthRefreshToken != "new-refresh" {
+		t.Fatalf("unexpected account after failed user info: %+v", stored)
+	}
+}
+
+func TestRefreshCredentialsSharesOneRefresh(t *testing.T) {
+	manager := account.NewManager(t.TempDir())
+	acct, err := manager.Create("sk-old", "")
+	if err != nil {
+		t.Fatalf("create account: %v", err)
+	}
+	if err := manager.UpdateToken(acct.UUID, "old-access", "old-refresh", time.Now().Add(48*time.Hour)); err != nil {
+		t.Fatalf("seed token: %v", err)
+	}
+	stale, err := manager.Get(acct.UUID)
+	if err != nil {
+		t.Fatalf("load account: %v", err)
+	}
+
+	var refreshes atomic.Int32
+	refresher := NewRefresher(manager)
+	refresher.client.tokenURL = "https://example.com/oauth/token"
+	refresher.client.userInfoURL = "https://example.com/api/oauth/getUserInfo"
+	refresher.client.httpClient = &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if strings.HasSuffix(r.URL.Path, "/getUserInfo") {
+				if r.URL.Query().Get("accessToken") != "new-access" {
+					return newJSONResponse(http.StatusUnauthorized, `{}`), nil
+				}
+				return newJSONResponse(http.StatusOK, `{"success":true,"data":{"apiKey":"sk-new"}}`), nil
+			}
+			refreshes.Add(1)
+			time.Sleep(20 * time.Millisecond)
+			return newJSONResponse(http.StatusOK, `{"access_token":"new-access","refresh_token":"new-refresh","expires_in":7200}`), nil
+		}),
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*account.Account, 8)
+	errs := make([]error, len(results))
+	for i := range results {
+		wg.Go(func() {
+			results[i], errs[i] = refresher.RefreshCredentials(context.Background(), stale)
+		})
+	}
+	wg.Wait()
+
+	if got := refreshes.Load(); got != 1 {
+		t.Fatalf("refresh calls = %d, want 1", got)
+	}
+	for i, updated := range results {
+		if errs[i] != nil {
+			t.Fatalf("RefreshCredentials error: %v", errs[i])
+		}
+		if updated.APIKey != "sk-new" || updated.OAuthAccessToken != "new-access" {
+			t.Fatalf("unexpected credentials: %+v", updated)
+		}
+	}
+
+	stored, err := manager.Get(acct.UUID)
+	if err != nil {
+		t.Fatalf("load updated account: %v", err)
+	}
+	if stored.APIKey != "sk-new" || stored.OAuthRefreshToken != "new-refresh" {
+		t.Fatalf("credentials not persisted: %+v", stored)
+	}
 }
 
 func TestRefresherStartStop(t *testing.T) {
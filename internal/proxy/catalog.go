@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rogeecn/iflow-go/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+const DefaultModelCatalogTTL = time.Hour
+
+// catalogRetryInterval is how long a failed fetch is remembered, so an iFlow
+// outage does not cost every /v1/models call a full upstream timeout.
+const catalogRetryInterval = 30 * time.Second
+
+// ModelOverride adjusts a catalog entry. Nil fields keep the upstream or
+// built-in value.
+type ModelOverride struct {
+	Name           *string `json:"name,omitempty"`
+	Description    *string `json:"description,omitempty"`
+	SupportsVision *bool   `json:"supports_vision,omitempty"`
+	ContextLength  *int    `json:"context_length,omitempty"`
+}
+
+// LoadModelOverrides reads a JSON object keyed by model id. A missing file
+// yields no overrides.
+func LoadModelOverrides(path string) (map[string]ModelOverride, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load model overrides: read file: %w", err)
+	}
+
+	var overrides map[string]ModelOverride
+	if err := json.Unmarshal(content, &overrides); err != nil {
+		return nil, fmt.Errorf("load model overrides: unmarshal json: %w", err)
+	}
+	normalized := make(map[string]ModelOverride, len(overrides))
+	for id, override := range overrides {
+		normalized[strings.ToLower(strings.TrimSpace(id))] = override
+	}
+	return normalized, nil
+}
+
+// ModelCatalog caches each account's upstream model list in memory and under
+// <dataDir>/models, refreshing it once the TTL has passed.
+type ModelCatalog struct {
+	dir       string
+	ttl       time.Duration
+	overrides map[string]ModelOverride
+	now       func() time.Time
+
+	mu       sync.Mutex
+	entries  map[string]*catalogEntry
+	failedAt map[string]time.Time
+}
+
+type catalogEntry struct {
+	FetchedAt time.Time     `json:"fetched_at"`
+	Models    []ModelConfig `json:"models"`
+}
+
+func NewModelCatalog(dataDir string, ttl time.Duration, overrides map[string]ModelOverride) *ModelCatalog {
+	if ttl <= 0 {
+		ttl = DefaultModelCatalogTTL
+	}
+	return &ModelCatalog{
+		dir:       filepath.Join(dataDir, "models"),
+		ttl:       ttl,
+		overrides: overrides,
+		now:       time.Now,
+		entries:   map[string]*catalogEntry{},
+		failedAt:  map[string]time.Time{},
+	}
+}
+
+// Models returns the merged catalog for an account. fetch is called when the
+// cached list is missing or expired; if it fails a stale list is served, and
+// without any cached list the built-in models are returned. After a failure
+// fetch is not retried for catalogRetryInterval.
+func (c *ModelCatalog) Models(ctx context.Context, accountUUID string, fetch func(context.Context) ([]ModelConfig, error)) []ModelConfig {
+	entry := c.cached(accountUUID)
+	if entry != nil && c.now().Sub(entry.FetchedAt) < c.ttl {
+		return c.merge(entry.Models)
+	}
+	if c.recentlyFailed(accountUUID) {
+		if entry != nil {
+			return c.merge(entry.Models)
+		}
+		return c.merge(nil)
+	}
+
+	fetched, err := fetch(ctx)
+	if err != nil {
+		c.mu.Lock()
+		c.failedAt[accountUUID] = c.now()
+		c.mu.Unlock()
+
+		event := log.Warn().
+			Err(err).
+			Str("account_uuid", accountUUID)
+		if entry != nil {
+			event.Msg("refresh model catalog failed, serving stale list")
+			return c.merge(entry.Models)
+		}
+		event.Msg("fetch model catalog failed, serving built-in list")
+		return c.merge(nil)
+	}
+
+	entry = &catalogEntry{FetchedAt: c.now(), Models: fetched}
+	c.store(accountUUID, entry)
+	return c.merge(entry.Models)
+}
+
+func (c *ModelCatalog) recentlyFailed(accountUUID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	failedAt, ok := c.failedAt[accountUUID]
+	return ok && c.now().Sub(failedAt) < catalogRetryInterval
+}
+
+func (c *ModelCatalog) cached(accountUUID string) *catalogEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[accountUUID]; ok {
+		return entry
+	}
+	content, err := os.ReadFile(c.path(accountUUID))
+	if err != nil {
+		return nil
+	}
+	var entry catalogEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		log.Warn().
+			Err(err).
+			Str("account_uuid", accountUUID).
+			Msg("ignore corrupt model catalog cache")
+		return nil
+	}
+	c.entries[accountUUID] = &entry
+	registerCatalogLabels(entry.Models)
+	return &entry
+}
+
+func (c *ModelCatalog) store(accountUUID string, entry *catalogEntry) {
+	registerCatalogLabels(entry.Models)
+	c.mu.Lock()
+	c.entries[accountUUID] = entry
+	delete(c.failedAt, accountUUID)
+	c.mu.Unlock()
+
+	if err := c.save(accountUUID, entry); err != nil {
+		log.Warn().
+			Err(err).
+			Str("account_uuid", accountUUID).
+			Msg("persist model catalog failed")
+	}
+}
+
+func (c *ModelCatalog) save(accountUUID string, entry *catalogEntry) error {
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return fmt.Errorf("save model catalog: ensure models dir: %w", err)
+	}
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("save model catalog: marshal json: %w", err)
+	}
+
+	path := c.path(accountUUID)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, payload, 0o600); err != nil {
+		return fmt.Errorf("save model catalog: write temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("save model catalog: rename temp file: %w", err)
+	}
+	return nil
+}
+
+func (c *ModelCatalog) path(accountUUID string) string {
+	return filepath.Join(c.dir, filepath.Base(accountUUID)+".json")
+}
+
+// merge fills upstream entries with built-in metadata and applies overrides.
+// A nil list means the built-in catalog.
+func (c *ModelCatalog) merge(upstream []ModelConfig) []ModelConfig {
+	if upstream == nil {
+		upstream = Models
+	}
+
+	result := make([]ModelConfig, 0, len(upstream))
+	for _, m := range upstream {
+		if builtin, ok := builtinModel(m.ID); ok {
+			if m.Name == "" {
+				m.Name = builtin.Name
+			}
+			if m.Description == "" {
+				m.Description = builtin.Description
+			}
+			if m.Encoding == "" {
+				m.Encoding = builtin.Encoding
+			}
+			if m.ContextLength == 0 {
+				m.ContextLength = builtin.ContextLength
+			}
+			m.SupportsVision = m.SupportsVision || builtin.SupportsVision
+		}
+		if m.Name == "" {
+			m.Name = m.ID
+		}
+
+		if override, ok := c.overrides[strings.ToLower(m.ID)]; ok {
+			if override.Name != nil {
+				m.Name = *override.Name
+			}
+			if override.Description != nil {
+				m.Description = *override.Description
+			}
+			if override.SupportsVision != nil {
+				m.SupportsVision = *override.SupportsVision
+			}
+			if override.ContextLength != nil {
+				m.ContextLength = *override.ContextLength
+			}
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+func registerCatalogLabels(models []ModelConfig) {
+	for _, m := range models {
+		metrics.RegisterModels(m.ID)
+	}
+}
+
+func builtinModel(id string) (ModelConfig, bool) {
+	for _, m := range Models {
+		if strings.EqualFold(m.ID, id) {
+			return m, true
+		}
+	}
+	return ModelConfig{}, false
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestModelCatalogCachesWithinTTL(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	catalog := NewModelCatalog(dir, time.Hour, nil)
+	catalog.now = func() time.Time { return now }
+
+	calls := 0
+	fetch := func(context.Context) ([]ModelConfig, error) {
+		calls++
+		return []ModelConfig{{ID: "glm-5"}, {ID: "new-model", ContextLength: 64000}}, nil
+	}
+
+	models := catalog.Models(context.Background(), "acct-1", fetch)
+	if len(models) != 2 || calls != 1 {
+		t.Fatalf("models = %+v, calls = %d", models, calls)
+	}
+	if models[0].Name != "GLM-5" || !models[0].SupportsVision {
+		t.Fatalf("built-in metadata not merged: %+v", models[0])
+	}
+	if models[1].Name != "new-model" || models[1].ContextLength != 64000 {
+		t.Fatalf("unknown model not kept: %+v", models[1])
+	}
+
+	now = now.Add(30 * time.Minute)
+	catalog.Models(context.Background(), "acct-1", fetch)
+	if calls != 1 {
+		t.Fatalf("fetch calls = %d, want cached", calls)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "models", "acct-1.json")); err != nil {
+		t.Fatalf("catalog cache not persisted: %v", err)
+	}
+
+	// A new catalog over the same data dir reuses the persisted list.
+	reloaded := NewModelCatalog(dir, time.Hour, nil)
+	reloaded.now = func() time.Time { return now }
+	reloaded.Models(context.Background(), "acct-1", func(context.Context) ([]ModelConfig, error) {
+		t.Fatal("persisted catalog should be reused")
+		return nil, nil
+	})
+
+	now = now.Add(time.Hour)
+	catalog.Models(context.Background(), "acct-1", fetch)
+	if calls != 2 {
+		t.Fatalf("fetch calls = %d, want refresh after TTL", calls)
+	}
+}
+
+func TestModelCatalogFallbacks(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	catalog := NewModelCatalog(t.TempDir(), time.Minute, nil)
+	catalog.now = func() time.Time { return now }
+	failing := func(context.Context) ([]ModelConfig, error) { return nil, errors.New("boom") }
+
+	if models := catalog.Models(context.Background(), "acct-1", failing); len(models) != len(Models) {
+		t.Fatalf("models = %d, want built-in list of %d", len(models), len(Models))
+	}
+
+	now = now.Add(catalogRetryInterval)
+	catalog.Models(context.Background(), "acct-1", func(context.Context) ([]ModelConfig, error) {
+		return []ModelConfig{{ID: "glm-5"}}, nil
+	})
+	now = now.Add(time.Hour)
+	models := catalog.Models(context.Background(), "acct-1", failing)
+	if len(models) != 1 || models[0].ID != "glm-5" {
+		t.Fatalf("stale list not served: %+v", models)
+	}
+}
+
+func TestModelCatalogBacksOffAfterFailure(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	catalog := NewModelCatalog(t.TempDir(), time.Minute, nil)
+	catalog.now = func() time.Time { return now }
+	catalog.Models(context.Background(), "acct-1", func(context.Context) ([]ModelConfig, error) {
+		return []ModelConfig{{ID: "glm-5"}}, nil
+	})
+
+	calls := 0
+	failing := func(context.Context) ([]ModelConfig, error) {
+		calls++
+		return nil, errors.New("boom")
+	}
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		models := catalog.Models(context.Background(), "acct-1", failing)
+		if len(models) != 1 || models[0].ID != "glm-5" {
+			t.Fatalf("stale list not served: %+v", models)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("fetch calls = %d, want 1 while backing off", calls)
+	}
+
+	// Accounts without any cached list fall back to the built-in models.
+	if models := catalog.Models(context.Background(), "acct-2", failing); len(models) != len(Models) {
+		t.Fatalf("models = %d, want built-in list of %d", len(models), len(Models))
+	}
+	catalog.Models(context.Background(), "acct-2", failing)
+	if calls != 2 {
+		t.Fatalf("fetch calls = %d, want 2", calls)
+	}
+
+	now = now.Add(catalogRetryInterval)
+	catalog.Models(context.Background(), "acct-1", failing)
+	if calls != 3 {
+		t.Fatalf("fetch calls = %d, want a retry after the interval", calls)
+	}
+}
+
+func TestModelCatalogOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model_overrides.json")
+	content := `{"GLM-5":{"name":"GLM 5 Pro","supports_vision":false,"context_length":200000}}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write overrides: %v", err)
+	}
+	overrides, err := LoadModelOverrides(path)
+	if err != nil {
+		t.Fatalf("LoadModelOverrides() error = %v", err)
+	}
+
+	catalog := NewModelCatalog(t.TempDir(), time.Hour, overrides)
+	models := catalog.Models(context.Background(), "acct-1", func(context.Context) ([]ModelConfig, error) {
+		return []ModelConfig{{ID: "glm-5"}}, nil
+	})
+	if models[0].Name != "GLM 5 Pro" || models[0].SupportsVision || models[0].ContextLength != 200000 {
+		t.Fatalf("override not applied: %+v", models[0])
+	}
+	if models[0].Description != "智谱 GLM-5 (推荐)" {
+		t.Fatalf("description should keep built-in value: %q", models[0].Description)
+	}
+
+	if missing, err := LoadModelOverrides(filepath.Join(t.TempDir(), "missing.json")); err != nil || missing != nil {
+		t.Fatalf("missing overrides file = %v, %v", missing, err)
+	}
+}
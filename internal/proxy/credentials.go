@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rs/zerolog/log"
+)
+
+// CredentialRefresher renews an OAuth account's token and API key after the
+// upstream rejected them, returning the account with its new credentials.
+type CredentialRefresher interface {
+	RefreshCredentials(ctx context.Context, acct *account.Account) (*account.Account, error)
+}
+
+var (
+	credentialsMu     sync.RWMutex
+	activeCredentials CredentialRefresher
+)
+
+// ConfigureCredentialRefresher sets the refresher used by proxies created
+// afterwards. A nil refresher surfaces upstream 401/403 responses as-is.
+func ConfigureCredentialRefresher(refresher CredentialRefresher) {
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+	activeCredentials = refresher
+}
+
+func currentCredentialRefresher() CredentialRefresher {
+	credentialsMu.RLock()
+	defer credentialsMu.RUnlock()
+	return activeCredentials
+}
+
+// canRefreshCredentials reports whether a response with status may be retried
+// after refreshing: only OAuth accounts can renew a rejected API key.
+func (p *IFlowProxy) canRefreshCredentials(status int) bool {
+	if status != http.StatusUnauthorized && status != http.StatusForbidden {
+		return false
+	}
+	return p.credentials != nil && strings.TrimSpace(p.account.OAuthRefreshToken) != ""
+}
+
+// refreshCredentials swaps in renewed credentials so the retry, and any later
+// call on this proxy, signs with the new API key.
+func (p *IFlowProxy) refreshCredentials(ctx context.Context, status int) bool {
+	updated, err := p.credentials.RefreshCredentials(ctx, p.account)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Int("status", status).
+			Str("account_uuid", strings.TrimSpace(p.account.UUID)).
+			Msg("proxy credential refresh failed")
+		return false
+	}
+
+	p.account = updated
+	p.headerBuilder.account = updated
+	log.Info().
+		Int("status", status).
+		Str("account_uuid", strings.TrimSpace(p.account.UUID)).
+		Msg("proxy credentials refreshed, retrying request")
+	return true
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+type fakeCredentialRefresher struct {
+	calls int
+	err   error
+}
+
+func (f *fakeCredentialRefresher) RefreshCredentials(_ context.Context, acct *account.Account) (*account.Account, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	updated := *acct
+	updated.APIKey = "sk-rotated"
+	updated.OAuthAccessToken = "new-access"
+	return &updated, nil
+}
+
+func newOAuthTestProxy(refresher CredentialRefresher, transport proxyRoundTripFunc) *IFlowProxy {
+	p := NewProxy(&account.Account{
+		UUID:              "6a1f3c2e-4b5d-4e6f-8a9b-0c1d2e3f4a5b",
+		APIKey:            "sk-stale",
+		OAuthAccessToken:  "old-access",
+		OAuthRefreshToken: "refresh",
+	})
+	p.telemetry = nil
+	p.credentials = refresher
+	p.client = &http.Client{Transport: transport}
+	return p
+}
+
+func TestChatCompletionsRefreshesCredentialsOnUnauthorized(t *testing.T) {
+	refresher := &fakeCredentialRefresher{}
+	var keys []string
+	p := newOAuthTestProxy(refresher, func(req *http.Request) (*http.Response, error) {
+		keys = append(keys, req.Header.Get("Authorization"))
+		if req.Header.Get("Authorization") != "Bearer sk-rotated" {
+			return newProxyResponse(http.StatusUnauthorized, `{"error":{"message":"invalid api key"}}`), nil
+		}
+		return newProxyResponse(http.StatusOK, `{"id":"chat-1","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`), nil
+	})
+
+	req := &types.ChatCompletionRequest{Model: "glm-5", Messages: []types.Message{{Role: "user", Content: "hi"}}}
+	resp, err := p.ChatCompletions(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ChatCompletions error: %v", err)
+	}
+	if resp.ID != "chat-1" || refresher.calls != 1 {
+		t.Fatalf("id = %q, refresh calls = %d", resp.ID, refresher.calls)
+	}
+	if len(keys) != 2 || keys[0] != "Bearer sk-stale" {
+		t.Fatalf("unexpected upstream attempts: %v", keys)
+	}
+
+	// Later calls on the same proxy sign with the rotated key straight away.
+	if _, err := p.ChatCompletionsStream(context.Background(), req); err != nil {
+		t.Fatalf("ChatCompletionsStream error: %v", err)
+	}
+	if refresher.calls != 1 || len(keys) != 3 {
+		t.Fatalf("refresh calls = %d, attempts = %v", refresher.calls, keys)
+	}
+}
+
+func TestChatCompletionsStreamRefreshFailureReturnsRejection(t *testing.T) {
+	refresher := &fakeCredentialRefresher{err: fmt.Errorf("refresh token revoked")}
+	attempts := 0
+	p := newOAuthTestProxy(refresher, func(*http.Request) (*http.Response, error) {
+		attempts++
+		return newProxyResponse(http.StatusForbidden, `{"error":{"message":"token expired"}}`), nil
+	})
+
+	req := &types.ChatCompletionRequest{Model: "glm-5", Messages: []types.Message{{Role: "user", Content: "hi"}}}
+	_, err := p.ChatCompletionsStream(context.Background(), req)
+	upstreamErr, ok := AsUpstreamError(err)
+	if !ok || upstreamErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("error = %v, want upstream 403", err)
+	}
+	if attempts != 1 || refresher.calls != 1 {
+		t.Fatalf("attempts = %d, refresh calls = %d", attempts, refresher.calls)
+	}
+
+	// API-key accounts have nothing to refresh.
+	p.account.OAuthRefreshToken = ""
+	if _, err := p.ChatCompletions(context.Background(), req); err == nil {
+		t.Fatal("expected upstream error")
+	}
+	if attempts != 2 || refresher.calls != 1 {
+		t.Fatalf("attempts = %d, refresh calls = %d", attempts, refresher.calls)
+	}
+}
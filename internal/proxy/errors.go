@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UpstreamError reports a failed call to the iFlow API. Code and Message are
+// taken from the error body when iFlow provides one.
+type UpstreamError struct {
+	Op         string
+	StatusCode int
+	Code       string
+	Message    string
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s: status=%d code=%s: %s", e.Op, e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("%s: status=%d body=%s", e.Op, e.StatusCode, e.Body)
+}
+
+// OpenAIError describes how an upstream failure is reported to clients.
+type OpenAIError struct {
+	Status  int
+	Type    string
+	Code    string
+	Message string
+}
+
+// statusOverloaded is the non-standard 529 some upstreams send when busy.
+const statusOverloaded = 529
+
+// OpenAI maps the upstream failure onto an OpenAI-compatible error.
+func (e *UpstreamError) OpenAI() OpenAIError {
+	message := e.Message
+	if message == "" {
+		message = e.Body
+	}
+	if message == "" {
+		message = http.StatusText(e.StatusCode)
+	}
+	if message == "" {
+		message = fmt.Sprintf("upstream status %d", e.StatusCode)
+	}
+	lowered := strings.ToLower(e.Code + " " + message)
+
+	switch {
+	case e.StatusCode == http.StatusRequestEntityTooLarge || containsAny(lowered, "context_length", "context length", "maximum context", "too many tokens", "token limit", "input is too long"):
+		return OpenAIError{Status: http.StatusBadRequest, Type: "invalid_request_error", Code: "context_length_exceeded", Message: message}
+	case e.StatusCode == http.StatusNotFound || containsAny(lowered, "model_not_found", "model not found", "model does not exist", "unsupported model"):
+		return OpenAIError{Status: http.StatusNotFound, Type: "invalid_request_error", Code: "model_not_found", Message: message}
+	case e.StatusCode == http.StatusUnauthorized:
+		return OpenAIError{Status: http.StatusUnauthorized, Type: "authentication_error", Code: "invalid_api_key", Message: message}
+	case e.StatusCode == http.StatusForbidden:
+		return OpenAIError{Status: http.StatusForbidden, Type: "permission_error", Code: "permission_denied", Message: message}
+	case e.StatusCode == http.StatusTooManyRequests:
+		return OpenAIError{Status: http.StatusTooManyRequests, Type: "rate_limit_error", Code: "rate_limit_exceeded", Message: message}
+	case e.StatusCode == http.StatusServiceUnavailable || e.StatusCode == statusOverloaded:
+		return OpenAIError{Status: http.StatusServiceUnavailable, Type: "api_error", Code: "overloaded", Message: message}
+	case e.StatusCode >= http.StatusInternalServerError:
+		return OpenAIError{Status: http.StatusInternalServerError, Type: "server_error", Code: "server_error", Message: message}
+	case e.StatusCode >= http.StatusBadRequest:
+		return OpenAIError{Status: http.StatusBadRequest, Type: "invalid_request_error", Code: "invalid_request", Message: message}
+	default:
+		return OpenAIError{Status: http.StatusBadGateway, Type: "api_error", Code: "upstream_error", Message: message}
+	}
+}
+
+func containsAny(value string, needles ...string) bool {
+	for _, needle := range needles {
+		if strings.Contains(value, needle) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retryable reports whether the same request may succeed on another account
+// or after a cooldown.
+func (e *UpstreamError) Retryable() bool {
+	if e == nil {
+		return false
+	}
+	return e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode == http.StatusUnauthorized ||
+		e.StatusCode >= http.StatusInternalServerError
+}
+
+func AsUpstreamError(err error) (*UpstreamError, bool) {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr, true
+	}
+	return nil, false
+}
+
+func newUpstreamError(op string, statusCode int, header http.Header, body []byte) *UpstreamError {
+	code, message := parseErrorBody(body)
+	return &UpstreamError{
+		Op:         op,
+		StatusCode: statusCode,
+		Code:       code,
+		Message:    message,
+		Body:       strings.TrimSpace(string(body)),
+		RetryAfter: parseRetryAfter(header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// iFlow answers either with an OpenAI style {"error":{...}} object or with a
+// flat {"status"/"code": ..., "msg"/"message": ...} body.
+type upstreamErrorBody struct {
+	Error *struct {
+		Message string          `json:"message"`
+		Type    string          `json:"type"`
+		Code    json.RawMessage `json:"code"`
+	} `json:"error"`
+	Status  json.RawMessage `json:"status"`
+	Code    json.RawMessage `json:"code"`
+	Msg     string          `json:"msg"`
+	Message string          `json:"message"`
+}
+
+func parseErrorBody(body []byte) (string, string) {
+	var parsed upstreamErrorBody
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return "", ""
+	}
+	if parsed.Error != nil {
+		code := rawString(parsed.Error.Code)
+		if code == "" {
+			code = parsed.Error.Type
+		}
+		return code, parsed.Error.Message
+	}
+
+	code := rawString(parsed.Code)
+	if code == "" {
+		code = rawString(parsed.Status)
+	}
+	message := parsed.Msg
+	if message == "" {
+		message = parsed.Message
+	}
+	return code, message
+}
+
+// embeddedError detects iFlow failures delivered with HTTP 200, where the
+// body carries an error instead of choices.
+func embeddedError(op string, header http.Header, body []byte) *UpstreamError {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return nil
+	}
+	if _, ok := probe["choices"]; ok {
+		return nil
+	}
+	code, message := parseErrorBody(body)
+	if message == "" && code == "" {
+		return nil
+	}
+
+	status := http.StatusBadGateway
+	if numeric, err := strconv.Atoi(code); err == nil && numeric >= http.StatusBadRequest && numeric <= 599 {
+		status = numeric
+	}
+	return newUpstreamError(op, status, header, body)
+}
+
+func rawString(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return strings.TrimSpace(text)
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := at.Sub(now); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
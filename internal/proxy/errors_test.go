@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := map[string]time.Duration{
+		"":        0,
+		"7":       7 * time.Second,
+		"-3":      0,
+		"garbage": 0,
+		now.Add(20 * time.Second).Format(http.TimeFormat): 20 * time.Second,
+		now.Add(-time.Minute).Format(http.TimeFormat):     0,
+	}
+	for input, want := range cases {
+		if got := parseRetryAfter(input, now); got != want {
+			t.Fatalf("parseRetryAfter(%q) = %s, want %s", input, got, want)
+		}
+	}
+}
+
+func TestUpstreamErrorRetryable(t *testing.T) {
+	cases := map[int]bool{
+		http.StatusBadRequest:          false,
+		http.StatusUnauthorized:        true,
+		http.StatusForbidden:           false,
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusBadGateway:          true,
+	}
+	for status, want := range cases {
+		err := &UpstreamError{StatusCode: status}
+		if got := err.Retryable(); got != want {
+			t.Fatalf("Retryable() for %d = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestChatCompletionsReturnsUpstreamError(t *testing.T) {
+	p := NewProxy(&account.Account{APIKey: "sk-test", BaseURL: "https://apis.iflow.cn/v1"})
+	p.telemetry = nil
+	p.client = &http.Client{
+		Transport: proxyRoundTripFunc(func(*http.Request) (*http.Response, error) {
+			resp := newProxyResponse(http.StatusTooManyRequests, `{"error":"slow down"}`)
+			resp.Header.Set("Retry-After", "12")
+			return resp, nil
+		}),
+	}
+
+	_, err := p.ChatCompletions(context.Background(), &types.ChatCompletionRequest{
+		Model:    "glm-5",
+		Messages: []types.Message{{Role: "user", Content: "hello"}},
+	})
+	upstreamErr, ok := AsUpstreamError(err)
+	if !ok {
+		t.Fatalf("error = %v, want *UpstreamError", err)
+	}
+	if upstreamErr.StatusCode != http.StatusTooManyRequests || upstreamErr.RetryAfter != 12*time.Second {
+		t.Fatalf("unexpected upstream error: %+v", upstreamErr)
+	}
+}
+
+func TestUpstreamErrorOpenAIMapping(t *testing.T) {
+	cases := []struct {
+		err    *UpstreamError
+		status int
+		code   string
+	}{
+		{&UpstreamError{StatusCode: http.StatusBadRequest, Message: "This model's maximum context length is 8192 tokens"}, http.StatusBadRequest, "context_length_exceeded"},
+		{&UpstreamError{StatusCode: http.StatusRequestEntityTooLarge}, http.StatusBadRequest, "context_length_exceeded"},
+		{&UpstreamError{StatusCode: http.StatusBadRequest, Code: "model_not_found"}, http.StatusNotFound, "model_not_found"},
+		{&UpstreamError{StatusCode: http.StatusUnauthorized}, http.StatusUnauthorized, "invalid_api_key"},
+		{&UpstreamError{StatusCode: http.StatusForbidden}, http.StatusForbidden, "permission_denied"},
+		{&UpstreamError{StatusCode: http.StatusTooManyRequests}, http.StatusTooManyRequests, "rate_limit_exceeded"},
+		{&UpstreamError{StatusCode: http.StatusServiceUnavailable}, http.StatusServiceUnavailable, "overloaded"},
+		{&UpstreamError{StatusCode: 529}, http.StatusServiceUnavailable, "overloaded"},
+		{&UpstreamError{StatusCode: http.StatusInternalServerError}, http.StatusInternalServerError, "server_error"},
+		{&UpstreamError{StatusCode: http.StatusBadGateway}, http.StatusInternalServerError, "server_error"},
+		{&UpstreamError{StatusCode: http.StatusUnprocessableEntity, Message: "bad temperature"}, http.StatusBadRequest, "invalid_request"},
+	}
+	for _, tc := range cases {
+		got := tc.err.OpenAI()
+		if got.Status != tc.status || got.Code != tc.code {
+			t.Fatalf("OpenAI() for %+v = %+v, want %d/%s", tc.err, got, tc.status, tc.code)
+		}
+		if got.Message == "" {
+			t.Fatalf("OpenAI() for %+v has empty message", tc.err)
+		}
+	}
+}
+
+func TestParseErrorBody(t *testing.T) {
+	cases := map[string][2]string{
+		`{"error":{"message":"no such model","type":"invalid_request_error","code":"model_not_found"}}`: {"model_not_found", "no such model"},
+		`{"error":{"message":"busy","type":"server_error"}}`:                                            {"server_error", "busy"},
+		`{"status":"434","msg":"Invalid apiKey"}`:                                                       {"434", "Invalid apiKey"},
+		`{"code":429,"message":"slow down"}`:                                                            {"429", "slow down"},
+		`not json`:                                                                                      {"", ""},
+	}
+	for body, want := range cases {
+		code, message := parseErrorBody([]byte(body))
+		if code != want[0] || message != want[1] {
+			t.Fatalf("parseErrorBody(%s) = %q, %q, want %q, %q", body, code, message, want[0], want[1])
+		}
+	}
+}
+
+func TestEmbeddedError(t *testing.T) {
+	if err := embeddedError("chat", http.Header{}, []byte(`{"choices":[],"status":"500"}`)); err != nil {
+		t.Fatalf("response with choices should not be an error: %+v", err)
+	}
+	if err := embeddedError("chat", http.Header{}, []byte(`{"id":"x","model":"glm-5"}`)); err != nil {
+		t.Fatalf("response without error fields should not be an error: %+v", err)
+	}
+
+	err := embeddedError("chat", http.Header{}, []byte(`{"status":"429","msg":"rate limited"}`))
+	if err == nil || err.StatusCode != http.StatusTooManyRequests || err.Message != "rate limited" {
+		t.Fatalf("unexpected embedded error: %+v", err)
+	}
+	err = embeddedError("chat", http.Header{}, []byte(`{"status":"434","msg":"Invalid apiKey"}`))
+	if err == nil || err.StatusCode != 434 {
+		t.Fatalf("unexpected embedded error: %+v", err)
+	}
+	err = embeddedError("chat", http.Header{}, []byte(`{"status":"-1","msg":"unknown"}`))
+	if err == nil || err.StatusCode != http.StatusBadGateway {
+		t.Fatalf("unexpected embedded error: %+v", err)
+	}
+}
+
+func TestChatCompletionsDetectsEmbeddedError(t *testing.T) {
+	p := NewProxy(&account.Account{APIKey: "sk-test", BaseURL: "https://apis.iflow.cn/v1"})
+	p.telemetry = nil
+	p.client = &http.Client{
+		Transport: proxyRoundTripFunc(func(*http.Request) (*http.Response, error) {
+			return newProxyResponse(http.StatusOK, `{"status":"404","msg":"Model not found"}`), nil
+		}),
+	}
+
+	_, err := p.ChatCompletions(context.Background(), &types.ChatCompletionRequest{
+		Model:    "glm-5",
+		Messages: []types.Message{{Role: "user", Content: "hello"}},
+	})
+	upstreamErr, ok := AsUpstreamError(err)
+	if !ok {
+		t.Fatalf("error = %v, want *UpstreamError", err)
+	}
+	if got := upstreamErr.OpenAI(); got.Status != http.StatusNotFound || got.Code != "model_not_found" {
+		t.Fatalf("unexpected mapping: %+v", got)
+	}
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+// Fields the proxy needs regardless of the passthrough lists.
+var requiredRequestFields = map[string]struct{}{
+	"model":    {},
+	"messages": {},
+	"stream":   {},
+}
+
+var passthrough = struct {
+	mu    sync.RWMutex
+	allow map[string]struct{}
+	deny  map[string]struct{}
+}{}
+
+// ConfigurePassthrough sets which client request fields reach iFlow. Deny
+// applies to every top-level field except model, messages and stream. Allow,
+// when non-empty, restricts fields the typed request does not model (for
+// example response_format or vendor extensions) to the listed names.
+func ConfigurePassthrough(allow, deny []string) {
+	passthrough.mu.Lock()
+	defer passthrough.mu.Unlock()
+
+	passthrough.allow = fieldSet(allow)
+	passthrough.deny = fieldSet(deny)
+}
+
+// ParseFieldList splits a comma separated list of JSON field names.
+func ParseFieldList(value string) []string {
+	var fields []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			fields = append(fields, part)
+		}
+	}
+	return fields
+}
+
+func fieldSet(fields []string) map[string]struct{} {
+	if len(fields) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(fields))
+	for _, field := range fields {
+		set[field] = struct{}{}
+	}
+	return set
+}
+
+// filterClientFields removes request fields blocked by the passthrough lists
+// and returns the dropped names.
+func filterClientFields(body map[string]interface{}, extra types.Extra) []string {
+	passthrough.mu.RLock()
+	defer passthrough.mu.RUnlock()
+
+	var dropped []string
+	for key := range body {
+		if _, ok := requiredRequestFields[key]; ok {
+			continue
+		}
+		if _, denied := passthrough.deny[key]; denied {
+			delete(body, key)
+			dropped = append(dropped, key)
+			continue
+		}
+		if _, isExtra := extra[key]; !isExtra || passthrough.allow == nil {
+			continue
+		}
+		if _, allowed := passthrough.allow[key]; !allowed {
+			delete(body, key)
+			dropped = append(dropped, key)
+		}
+	}
+	return dropped
+}
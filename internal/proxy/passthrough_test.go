@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+func TestChatCompletionsPassthroughPolicy(t *testing.T) {
+	ConfigurePassthrough([]string{"response_format"}, []string{"user"})
+	t.Cleanup(func() { ConfigurePassthrough(nil, nil) })
+
+	var sent map[string]interface{}
+	p := NewProxy(&account.Account{APIKey: "sk-test"})
+	p.telemetry = nil
+	p.client = &http.Client{
+		Transport: proxyRoundTripFunc(func(req *http.Request) (*http.Response, error) {
+			raw, _ := io.ReadAll(req.Body)
+			if err := json.Unmarshal(raw, &sent); err != nil {
+				t.Errorf("decode upstream body: %v", err)
+			}
+			return newProxyResponse(http.StatusOK, `{"id":"c1","model":"glm-5","choices":[],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2},"vendor_trace":"abc"}`), nil
+		}),
+	}
+
+	var req types.ChatCompletionRequest
+	input := `{"model":"glm-5","messages":[{"role":"user","content":"hi"}],"user":"u1","response_format":{"type":"json_object"},"seed":7}`
+	if err := json.Unmarshal([]byte(input), &req); err != nil {
+		t.Fatalf("decode request: %v", err)
+	}
+
+	resp, err := p.ChatCompletions(context.Background(), &req)
+	if err != nil {
+		t.Fatalf("ChatCompletions error: %v", err)
+	}
+
+	if _, ok := sent["response_format"]; !ok {
+		t.Fatalf("allowed field missing upstream: %v", sent)
+	}
+	if _, ok := sent["seed"]; ok {
+		t.Fatalf("field outside allow list reached upstream: %v", sent)
+	}
+	if _, ok := sent["user"]; ok {
+		t.Fatalf("denied field reached upstream: %v", sent)
+	}
+	if string(resp.Extra["vendor_trace"]) != `"abc"` {
+		t.Fatalf("response extra lost: %v", resp.Extra)
+	}
+}
+
+func TestParseFieldList(t *testing.T) {
+	got := ParseFieldList(" seed, ,stop ")
+	if len(got) != 2 || got[0] != "seed" || got[1] != "stop" {
+		t.Fatalf("ParseFieldList = %v", got)
+	}
+}
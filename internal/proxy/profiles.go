@@ -0,0 +1,292 @@
+package proxy
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rogeecn/iflow-go/internal/metrics"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// profileReloadInterval bounds how often the profile file is checked for
+// changes.
+const profileReloadInterval = 2 * time.Second
+
+//go:embed model_profiles.yaml
+var defaultProfilesYAML []byte
+
+// ModelProfiles declares aliases and per-model request parameters. JSON files
+// are accepted as well since JSON is valid YAML.
+type ModelProfiles struct {
+	Aliases  map[string]string      `yaml:"aliases"`
+	Defaults map[string]interface{} `yaml:"defaults"`
+	Models   []ModelProfile         `yaml:"models"`
+}
+
+type ModelProfile struct {
+	Match          string                 `yaml:"match"`
+	Pattern        string                 `yaml:"pattern"`
+	UpstreamModel  string                 `yaml:"upstream_model"`
+	Defaults       map[string]interface{} `yaml:"defaults"`
+	Thinking       map[string]interface{} `yaml:"thinking"`
+	ThinkingOff    map[string]interface{} `yaml:"thinking_off"`
+	ThinkingBudget string                 `yaml:"thinking_budget"`
+	Force          map[string]interface{} `yaml:"force"`
+	Remove         []string               `yaml:"remove"`
+	MaxNewTokens   int                    `yaml:"max_new_tokens"`
+
+	pattern *regexp.Regexp
+}
+
+// ParseModelProfiles decodes and validates a profile document.
+func ParseModelProfiles(content []byte) (*ModelProfiles, error) {
+	var profiles ModelProfiles
+	if err := yaml.Unmarshal(content, &profiles); err != nil {
+		return nil, fmt.Errorf("parse model profiles: %w", err)
+	}
+
+	aliases := make(map[string]string, len(profiles.Aliases))
+	for alias, target := range profiles.Aliases {
+		alias = strings.ToLower(strings.TrimSpace(alias))
+		target = strings.TrimSpace(target)
+		if alias == "" || target == "" {
+			return nil, fmt.Errorf("parse model profiles: empty alias %q -> %q", alias, target)
+		}
+		aliases[alias] = target
+	}
+	profiles.Aliases = aliases
+
+	for i := range profiles.Models {
+		profile := &profiles.Models[i]
+		profile.Match = strings.TrimSpace(profile.Match)
+		switch {
+		case profile.Match != "" && profile.Pattern != "":
+			return nil, fmt.Errorf("parse model profiles: models[%d]: match and pattern are exclusive", i)
+		case profile.Match == "" && profile.Pattern == "":
+			return nil, fmt.Errorf("parse model profiles: models[%d]: match or pattern is required", i)
+		case profile.Pattern != "":
+			compiled, err := regexp.Compile("(?i)" + profile.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("parse model profiles: models[%d]: %w", i, err)
+			}
+			profile.pattern = compiled
+		}
+		profile.ThinkingBudget = strings.TrimSpace(profile.ThinkingBudget)
+		if strings.HasPrefix(profile.ThinkingBudget, ".") || strings.HasSuffix(profile.ThinkingBudget, ".") || strings.Contains(profile.ThinkingBudget, "..") {
+			return nil, fmt.Errorf("parse model profiles: models[%d]: invalid thinking_budget path %q", i, profile.ThinkingBudget)
+		}
+		if profile.MaxNewTokens < 0 {
+			return nil, fmt.Errorf("parse model profiles: models[%d]: negative max_new_tokens", i)
+		}
+	}
+	return &profiles, nil
+}
+
+// ResolveAlias returns the iFlow model a client model name maps to.
+func (p *ModelProfiles) ResolveAlias(model string) string {
+	model = strings.TrimSpace(model)
+	if target, ok := p.Aliases[strings.ToLower(model)]; ok {
+		return target
+	}
+	return model
+}
+
+// Lookup returns the first profile matching model.
+func (p *ModelProfiles) Lookup(model string) *ModelProfile {
+	model = strings.TrimSpace(model)
+	for i := range p.Models {
+		profile := &p.Models[i]
+		if profile.pattern != nil {
+			if profile.pattern.MatchString(model) {
+				return profile
+			}
+			continue
+		}
+		if strings.EqualFold(profile.Match, model) {
+			return profile
+		}
+	}
+	return nil
+}
+
+var profiles = &profileSource{active: mustDefaultProfiles()}
+
+// profileSource holds the active profiles and reloads them from path when
+// the file changes. A file that fails to load keeps the previous profiles.
+type profileSource struct {
+	mu        sync.Mutex
+	path      string
+	modTime   time.Time
+	checkedAt time.Time
+	active    *ModelProfiles
+}
+
+func mustDefaultProfiles() *ModelProfiles {
+	parsed, err := ParseModelProfiles(defaultProfilesYAML)
+	if err != nil {
+		panic(err)
+	}
+	parsed.registerModelLabels()
+	return parsed
+}
+
+// registerModelLabels lets aliases and exact profile matches appear as
+// metric labels.
+func (p *ModelProfiles) registerModelLabels() {
+	for alias, target := range p.Aliases {
+		metrics.RegisterModels(alias, target)
+	}
+	for _, profile := range p.Models {
+		metrics.RegisterModels(profile.Match, profile.UpstreamModel)
+	}
+}
+
+// ConfigureModelProfiles loads model profiles from path and watches it for
+// changes. An empty path restores the built-in profiles.
+func ConfigureModelProfiles(path string) error {
+	path = strings.TrimSpace(path)
+
+	profiles.mu.Lock()
+	defer profiles.mu.Unlock()
+
+	profiles.path = path
+	profiles.modTime = time.Time{}
+	profiles.checkedAt = time.Time{}
+	if path == "" {
+		profiles.active = mustDefaultProfiles()
+		return nil
+	}
+	return profiles.reloadLocked(time.Now())
+}
+
+func currentProfiles() *ModelProfiles {
+	profiles.mu.Lock()
+	defer profiles.mu.Unlock()
+
+	now := time.Now()
+	if profiles.path != "" && now.Sub(profiles.checkedAt) >= profileReloadInterval {
+		if err := profiles.reloadLocked(now); err != nil {
+			log.Warn().
+				Err(err).
+				Str("path", profiles.path).
+				Msg("reload model profiles failed, keeping previous profiles")
+		}
+	}
+	return profiles.active
+}
+
+func (s *profileSource) reloadLocked(now time.Time) error {
+	s.checkedAt = now
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("load model profiles: %w", err)
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+	// Record the attempt so a broken file is reported once per change.
+	s.modTime = info.ModTime()
+
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("load model profiles: %w", err)
+	}
+	parsed, err := ParseModelProfiles(content)
+	if err != nil {
+		return err
+	}
+	parsed.registerModelLabels()
+	s.active = parsed
+	log.Info().
+		Str("path", s.path).
+		Int("aliases", len(parsed.Aliases)).
+		Int("models", len(parsed.Models)).
+		Msg("model profiles loaded")
+	return nil
+}
+
+// apply sets the profile parameters on body. Parameters sent by the client
+// win over defaults and thinking toggles unless the client asked for a
+// thinking mode explicitly; forced parameters and removals always apply.
+func (p *ModelProfile) apply(body map[string]interface{}, globalDefaults map[string]interface{}, reasoning reasoningControl) {
+	if p != nil {
+		setAllIfAbsent(body, p.Defaults)
+		switch {
+		case !reasoning.set:
+			setAllIfAbsent(body, p.Thinking)
+		case reasoning.enabled:
+			mergeAll(body, p.Thinking)
+			if reasoning.budget > 0 && p.ThinkingBudget != "" {
+				setPath(body, p.ThinkingBudget, reasoning.budget)
+			}
+		default:
+			mergeAll(body, p.ThinkingOff)
+		}
+	}
+	setAllIfAbsent(body, globalDefaults)
+	if p == nil {
+		return
+	}
+
+	for key, value := range p.Force {
+		body[key] = cloneValue(value)
+	}
+	for _, key := range p.Remove {
+		delete(body, key)
+	}
+	if p.MaxNewTokens > 0 {
+		if current, ok := numericValue(body["max_new_tokens"]); !ok || current > float64(p.MaxNewTokens) {
+			body["max_new_tokens"] = p.MaxNewTokens
+		}
+	}
+}
+
+func setAllIfAbsent(target, values map[string]interface{}) {
+	for key, value := range values {
+		setIfAbsent(target, key, cloneValue(value))
+	}
+}
+
+// cloneValue deep-copies profile values so request bodies never share maps
+// with the active profiles.
+func cloneValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		cloned := make(map[string]interface{}, len(typed))
+		for k, v := range typed {
+			cloned[k] = cloneValue(v)
+		}
+		return cloned
+	case []interface{}:
+		cloned := make([]interface{}, len(typed))
+		for i, v := range typed {
+			cloned[i] = cloneValue(v)
+		}
+		return cloned
+	default:
+		return value
+	}
+}
+
+func numericValue(value interface{}) (float64, bool) {
+	switch typed := value.(type) {
+	case int:
+		return float64(typed), true
+	case int64:
+		return float64(typed), true
+	case float64:
+		return typed, true
+	case json.Number:
+		parsed, err := typed.Float64()
+		return parsed, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDefaultProfilesParse(t *testing.T) {
+	parsed, err := ParseModelProfiles(defaultProfilesYAML)
+	if err != nil {
+		t.Fatalf("ParseModelProfiles(default) error = %v", err)
+	}
+	if profile := parsed.Lookup("GLM-4.6"); profile == nil || profile.UpstreamModel != "glm-4.6-exp" {
+		t.Fatalf("glm-4.6 profile = %+v", profile)
+	}
+	if profile := parsed.Lookup("qwen3-4b-thinking"); profile == nil || len(profile.Remove) == 0 {
+		t.Fatalf("qwen 4b profile should win over thinking pattern: %+v", profile)
+	}
+	if profile := parsed.Lookup("unknown-model"); profile != nil {
+		t.Fatalf("unknown model matched %+v", profile)
+	}
+}
+
+func TestParseModelProfilesRejectsInvalid(t *testing.T) {
+	cases := []string{
+		"models:\n  - upstream_model: x\n",
+		"models:\n  - match: a\n    pattern: b\n",
+		"models:\n  - pattern: \"(\"\n",
+		"models:\n  - match: a\n    max_new_tokens: -1\n",
+		"aliases:\n  gpt-4o: \"\"\n",
+	}
+	for _, content := range cases {
+		if _, err := ParseModelProfiles([]byte(content)); err == nil {
+			t.Fatalf("ParseModelProfiles(%q) should fail", content)
+		}
+	}
+}
+
+func TestConfigureModelParamsWithProfileFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.yaml")
+	content := `
+aliases:
+  gpt-4o: glm-5
+defaults:
+  temperature: 0.5
+models:
+  - match: glm-5
+    thinking:
+      enable_thinking: true
+    force:
+      top_p: 0.9
+    remove: [seed]
+    max_new_tokens: 8000
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write profiles: %v", err)
+	}
+	if err := ConfigureModelProfiles(path); err != nil {
+		t.Fatalf("ConfigureModelProfiles() error = %v", err)
+	}
+	t.Cleanup(func() { _ = ConfigureModelProfiles("") })
+
+	got := ConfigureModelParams(map[string]interface{}{
+		"model":      "gpt-4o",
+		"max_tokens": 16000.0,
+		"seed":       7.0,
+		"top_p":      0.1,
+	}, "gpt-4o", "https://apis.iflow.cn/v1", "session-1")
+
+	if got["model"] != "glm-5" {
+		t.Fatalf("model = %#v, want glm-5", got["model"])
+	}
+	if got["temperature"] != 0.5 || got["enable_thinking"] != true || got["top_p"] != 0.9 {
+		t.Fatalf("profile parameters not applied: %#v", got)
+	}
+	if _, ok := got["seed"]; ok {
+		t.Fatalf("seed should be removed: %#v", got)
+	}
+	if got["max_new_tokens"] != 8000 {
+		t.Fatalf("max_new_tokens = %#v, want cap 8000", got["max_new_tokens"])
+	}
+	if _, ok := got["chat_template_kwargs"]; ok {
+		t.Fatalf("built-in glm-5 profile should be replaced: %#v", got)
+	}
+}
+
+func TestModelProfilesHotReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.yaml")
+	if err := os.WriteFile(path, []byte("aliases:\n  fast: glm-4.7\n"), 0o600); err != nil {
+		t.Fatalf("write profiles: %v", err)
+	}
+	if err := ConfigureModelProfiles(path); err != nil {
+		t.Fatalf("ConfigureModelProfiles() error = %v", err)
+	}
+	t.Cleanup(func() { _ = ConfigureModelProfiles("") })
+
+	if got := currentProfiles().ResolveAlias("fast"); got != "glm-4.7" {
+		t.Fatalf("alias = %q, want glm-4.7", got)
+	}
+
+	reload := func(content string, at time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("write profiles: %v", err)
+		}
+		if err := os.Chtimes(path, at, at); err != nil {
+			t.Fatalf("touch profiles: %v", err)
+		}
+		profiles.mu.Lock()
+		profiles.checkedAt = time.Time{}
+		profiles.mu.Unlock()
+	}
+
+	reload("aliases:\n  fast: glm-5\n", time.Now().Add(time.Minute))
+	if got := currentProfiles().ResolveAlias("fast"); got != "glm-5" {
+		t.Fatalf("alias after reload = %q, want glm-5", got)
+	}
+
+	reload("models: [", time.Now().Add(2*time.Minute))
+	if got := currentProfiles().ResolveAlias("fast"); got != "glm-5" {
+		t.Fatalf("broken file should keep previous profiles, alias = %q", got)
+	}
+}
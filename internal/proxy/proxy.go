@@ -15,6 +15,8 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/internal/metrics"
+	"github.com/rogeecn/iflow-go/internal/transport"
 	"github.com/rogeecn/iflow-go/pkg/types"
 	"github.com/rs/zerolog/log"
 )
@@ -29,6 +31,8 @@ type IFlowProxy struct {
 	baseURL                  string
 	headerBuilder            *HeaderBuilder
 	telemetry                *Telemetry
+	timeouts                 Timeouts
+	credentials              CredentialRefresher
 	preserveReasoningContent bool
 }
 
@@ -54,17 +58,24 @@ func NewProxyWithReasoning(acct *account.Account, preserveReasoningContent bool)
 	}
 	userID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte(telemetrySeed)).String()
 
+	timeouts := currentTimeouts()
 	p := &IFlowProxy{
 		account:                  acct,
-		client:                   &http.Client{Timeout: 300 * time.Second},
+		client:                   newUpstreamClient(acct.ProxyURL, timeouts),
 		baseURL:                  baseURL,
 		headerBuilder:            builder,
 		telemetry:                NewTelemetry(userID, builder.sessionID, builder.conversationID),
+		timeouts:                 timeouts,
+		credentials:              currentCredentialRefresher(),
 		preserveReasoningContent: preserveReasoningContent,
 	}
+	if strings.TrimSpace(acct.ProxyURL) != "" {
+		p.telemetry.client = transport.NewClient(telemetryTimeout, acct.ProxyURL)
+	}
 	log.Debug().
 		Str("account_uuid", strings.TrimSpace(acct.UUID)).
 		Str("base_url", baseURL).
+		Str("proxy_url", transport.RedactProxyURL(acct.ProxyURL)).
 		Msg("proxy initialized")
 	return p
 }
@@ -97,9 +108,14 @@ func (p *IFlowProxy) ChatCompletions(ctx context.Context, req *types.ChatComplet
 		Bool("stream", false).
 		Msg("proxy chat request started")
 
-	headers := p.headerBuilder.Build(false, traceparent)
-
-	responseBody, statusCode, err := p.doChatRequest(ctx, headers, requestBody)
+	requestCtx := ctx
+	if p.timeouts.Request > 0 {
+		var cancel context.CancelFunc
+		requestCtx, cancel = context.WithTimeout(ctx, p.timeouts.Request)
+		defer cancel()
+	}
+	responseBody, statusCode, responseHeader, err := p.doChatRequest(requestCtx, traceparent, requestBody)
+	metrics.ObserveUpstream(statusCode, model, p.account.UUID)
 	if err != nil {
 		if p.telemetry != nil && parentObservationID != "" {
 			p.telemetry.EmitRunError(ctx, model, traceID, parentObservationID, err.Error())
@@ -111,18 +127,22 @@ func (p *IFlowProxy) ChatCompletions(ctx context.Context, req *types.ChatComplet
 			Msg("proxy chat request failed")
 		return nil, err
 	}
+	upstreamErr := embeddedError("chat completions", responseHeader, responseBody)
 	if statusCode >= http.StatusBadRequest {
-		err := fmt.Errorf("chat completions: status=%d body=%s", statusCode, strings.TrimSpace(string(responseBody)))
+		upstreamErr = newUpstreamError("chat completions", statusCode, responseHeader, responseBody)
+	}
+	if upstreamErr != nil {
 		if p.telemetry != nil && parentObservationID != "" {
-			p.telemetry.EmitRunError(ctx, model, traceID, parentObservationID, err.Error())
+			p.telemetry.EmitRunError(ctx, model, traceID, parentObservationID, upstreamErr.Error())
 		}
 		log.Warn().
-			Int("status", statusCode).
+			Int("status", upstreamErr.StatusCode).
+			Str("code", upstreamErr.Code).
 			Str("account_uuid", strings.TrimSpace(p.account.UUID)).
 			Str("model", model).
 			Int("response_bytes", len(responseBody)).
 			Msg("proxy chat request returned upstream error")
-		return nil, err
+		return nil, upstreamErr
 	}
 
 	var normalized map[string]interface{}
@@ -132,8 +152,11 @@ func (p *IFlowProxy) ChatCompletions(ctx context.Context, req *types.ChatComplet
 		}
 		return nil, fmt.Errorf("chat completions: decode response: %w", err)
 	}
-	normalized = NormalizeResponse(normalized, p.preserveReasoningContent)
-	ensureUsage(normalized)
+	ensureUsage(normalized, req)
+	if req.HasTools() {
+		applyEmbeddedToolCalls(normalized)
+	}
+	normalized = NormalizeResponse(normalized, p.preserveReasoning(req))
 
 	normalizedBytes, err := json.Marshal(normalized)
 	if err != nil {
@@ -144,6 +167,7 @@ func (p *IFlowProxy) ChatCompletions(ctx context.Context, req *types.ChatComplet
 	if err := json.Unmarshal(normalizedBytes, &parsed); err != nil {
 		return nil, fmt.Errorf("chat completions: parse response type: %w", err)
 	}
+	metrics.ObserveTokens(model, p.account.UUID, parsed.Usage.PromptTokens, parsed.Usage.CompletionTokens)
 	log.Debug().
 		Str("account_uuid", strings.TrimSpace(p.account.UUID)).
 		Str("model", model).
@@ -184,23 +208,29 @@ func (p *IFlowProxy) ChatCompletionsStream(ctx context.Context, req *types.ChatC
 		Bool("stream", true).
 		Msg("proxy chat stream request started")
 
-	headers := p.headerBuilder.Build(true, traceparent)
-
 	reqBody, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("chat stream: encode request: %w", err)
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.chatCompletionsURL(), bytes.NewReader(reqBody))
-	if err != nil {
-		return nil, fmt.Errorf("chat stream: create request: %w", err)
-	}
-	for k, v := range headers {
-		httpReq.Header.Set(k, v)
-	}
+	// The watchdog starts before the request is sent so its first-token
+	// phase also covers the wait for response headers.
+	streamCtx, cancel := context.WithCancel(ctx)
+	watchdog := newStreamWatchdog(p.timeouts, cancel)
+	streaming := false
+	defer func() {
+		if !streaming {
+			watchdog.stop()
+			cancel()
+		}
+	}()
 
-	resp, err := p.client.Do(httpReq)
+	resp, err := p.sendChatRequest(streamCtx, true, traceparent, reqBody)
 	if err != nil {
+		if timeoutErr := watchdog.err(); timeoutErr != nil {
+			err = timeoutErr
+		}
+		metrics.ObserveUpstream(0, model, p.account.UUID)
 		if p.telemetry != nil && parentObservationID != "" {
 			p.telemetry.EmitRunError(ctx, model, traceID, parentObservationID, err.Error())
 		}
@@ -209,12 +239,13 @@ func (p *IFlowProxy) ChatCompletionsStream(ctx context.Context, req *types.ChatC
 			Str("account_uuid", strings.TrimSpace(p.account.UUID)).
 			Str("model", model).
 			Msg("proxy chat stream request failed")
-		return nil, fmt.Errorf("chat stream: send request: %w", err)
+		return nil, fmt.Errorf("chat stream: %w", err)
 	}
+	metrics.ObserveUpstream(resp.StatusCode, model, p.account.UUID)
 	if resp.StatusCode >= http.StatusBadRequest {
 		defer resp.Body.Close()
 		body, _ := readDecodedBody(resp)
-		err := fmt.Errorf("chat stream: status=%d body=%s", resp.StatusCode, strings.TrimSpace(string(body)))
+		err := newUpstreamError("chat stream", resp.StatusCode, resp.Header, body)
 		if p.telemetry != nil && parentObservationID != "" {
 			p.telemetry.EmitRunError(ctx, model, traceID, parentObservationID, err.Error())
 		}
@@ -242,7 +273,12 @@ func (p *IFlowProxy) ChatCompletionsStream(ctx context.Context, req *types.ChatC
 	}
 
 	out := make(chan []byte, 32)
-	go p.forwardSSE(ctx, streamBody, out, model, traceID, parentObservationID, startedAt)
+	streaming = true
+	context.AfterFunc(streamCtx, func() { _ = streamBody.Close() })
+	go func() {
+		defer cancel()
+		p.forwardSSE(ctx, streamBody, out, watchdog, newStreamTransform(req, p.preserveReasoning(req)), model, traceID, parentObservationID, startedAt)
+	}()
 	log.Debug().
 		Str("account_uuid", strings.TrimSpace(p.account.UUID)).
 		Str("model", model).
@@ -250,36 +286,99 @@ func (p *IFlowProxy) ChatCompletionsStream(ctx context.Context, req *types.ChatC
 	return out, nil
 }
 
+// preserveReasoning applies the request's override of the proxy-wide
+// reasoning_content policy.
+func (p *IFlowProxy) preserveReasoning(req *types.ChatCompletionRequest) bool {
+	if req != nil && req.PreserveReasoning != nil {
+		return *req.PreserveReasoning
+	}
+	return p.preserveReasoningContent
+}
+
 func (p *IFlowProxy) Models() []ModelConfig {
 	result := make([]ModelConfig, len(Models))
 	copy(result, Models)
 	return result
 }
 
-func (p *IFlowProxy) doChatRequest(ctx context.Context, headers map[string]string, body map[string]interface{}) ([]byte, int, error) {
-	start := time.Now()
-	payload, err := json.Marshal(body)
-	if err != nil {
-		return nil, 0, fmt.Errorf("chat completions: encode request: %w", err)
+// FetchModels lists the models available to the account from iFlow.
+func (p *IFlowProxy) FetchModels(ctx context.Context) ([]ModelConfig, error) {
+	requestCtx := ctx
+	if p.timeouts.Request > 0 {
+		var cancel context.CancelFunc
+		requestCtx, cancel = context.WithTimeout(ctx, p.timeouts.Request)
+		defer cancel()
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.chatCompletionsURL(), bytes.NewReader(payload))
+	req, err := http.NewRequestWithContext(requestCtx, http.MethodGet, p.baseURL+"/models", nil)
 	if err != nil {
-		return nil, 0, fmt.Errorf("chat completions: create request: %w", err)
+		return nil, fmt.Errorf("list models: create request: %w", err)
 	}
-	for k, v := range headers {
+	for k, v := range p.headerBuilder.Build(false, "") {
 		req.Header.Set(k, v)
 	}
 
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, 0, fmt.Errorf("chat completions: send request: %w", err)
+		return nil, fmt.Errorf("list models: send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	content, err := readDecodedBody(resp)
 	if err != nil {
-		return nil, 0, fmt.Errorf("chat completions: read response: %w", err)
+		return nil, fmt.Errorf("list models: read response: %w", err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, newUpstreamError("list models", resp.StatusCode, resp.Header, content)
+	}
+
+	var payload struct {
+		Data *[]struct {
+			ID            string `json:"id"`
+			ContextLength int    `json:"context_length"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(content, &payload); err != nil {
+		return nil, fmt.Errorf("list models: decode response: %w", err)
+	}
+	if payload.Data == nil {
+		if upstreamErr := embeddedError("list models", resp.Header, content); upstreamErr != nil {
+			return nil, upstreamErr
+		}
+		return nil, fmt.Errorf("list models: response has no data")
+	}
+
+	models := make([]ModelConfig, 0, len(*payload.Data))
+	for _, entry := range *payload.Data {
+		id := strings.TrimSpace(entry.ID)
+		if id == "" {
+			continue
+		}
+		models = append(models, ModelConfig{ID: id, ContextLength: entry.ContextLength})
+	}
+	log.Debug().
+		Str("account_uuid", strings.TrimSpace(p.account.UUID)).
+		Int("models", len(models)).
+		Msg("proxy upstream models fetched")
+	return models, nil
+}
+
+func (p *IFlowProxy) doChatRequest(ctx context.Context, traceparent string, body map[string]interface{}) ([]byte, int, http.Header, error) {
+	start := time.Now()
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("chat completions: encode request: %w", err)
+	}
+
+	resp, err := p.sendChatRequest(ctx, false, traceparent, payload)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("chat completions: %w", err)
+	}
+	defer resp.Body.Close()
+
+	content, err := readDecodedBody(resp)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("chat completions: read response: %w", err)
 	}
 
 	event := log.Debug()
@@ -294,7 +393,38 @@ func (p *IFlowProxy) doChatRequest(ctx context.Context, headers map[string]strin
 		Dur("latency", time.Since(start)).
 		Msg("proxy upstream response received")
 
-	return content, resp.StatusCode, nil
+	return content, resp.StatusCode, resp.Header, nil
+}
+
+// sendChatRequest posts payload to the chat endpoint. When iFlow rejects an
+// OAuth account's credentials it refreshes them once and replays the request;
+// if the refresh fails the original rejection is returned.
+func (p *IFlowProxy) sendChatRequest(ctx context.Context, stream bool, traceparent string, payload []byte) (*http.Response, error) {
+	for refreshed := false; ; refreshed = true {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.chatCompletionsURL(), bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+		for k, v := range p.headerBuilder.Build(stream, traceparent) {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("send request: %w", err)
+		}
+		if refreshed || !p.canRefreshCredentials(resp.StatusCode) {
+			return resp, nil
+		}
+
+		// Buffer the rejection so it can still be returned if the refresh fails.
+		rejected, readErr := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(rejected))
+		if readErr != nil || !p.refreshCredentials(ctx, resp.StatusCode) {
+			return resp, nil
+		}
+	}
 }
 
 func (p *IFlowProxy) chatCompletionsURL() string {
@@ -315,19 +445,38 @@ func requestToBodyMap(req *types.ChatCompletionRequest) (map[string]interface{},
 	if err := json.Unmarshal(raw, &body); err != nil {
 		return nil, fmt.Errorf("chat completions: decode request map: %w", err)
 	}
+	if dropped := filterClientFields(body, req.Extra); len(dropped) > 0 {
+		log.Debug().
+			Str("model", req.Model).
+			Strs("fields", dropped).
+			Msg("request fields blocked by passthrough policy")
+	}
 
 	return body, nil
 }
 
-func ensureUsage(response map[string]interface{}) {
-	if _, ok := response["usage"]; ok {
+// ensureUsage fills in an estimated usage block when upstream omitted it. It
+// runs before normalization so reasoning is still separate from content.
+func ensureUsage(response map[string]interface{}, req *types.ChatCompletionRequest) {
+	if usage, ok := response["usage"].(map[string]interface{}); ok && len(usage) > 0 {
 		return
 	}
-	response["usage"] = map[string]interface{}{
-		"prompt_tokens":     0,
-		"completion_tokens": 0,
-		"total_tokens":      0,
+
+	estimator := newUsageEstimator(req)
+	choices, _ := response["choices"].([]interface{})
+	for _, choice := range choices {
+		choiceMap, ok := choice.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if message, ok := choiceMap["message"].(map[string]interface{}); ok {
+			estimator.addMessage(message)
+		}
 	}
+	response["usage"] = estimator.usageMap()
+	log.Debug().
+		Str("model", req.Model).
+		Msg("upstream response missing usage, estimated locally")
 }
 
 func extractTraceID(traceparent string) string {
@@ -373,47 +522,82 @@ func normalizeStreamChunk(chunk map[string]interface{}, preserveReasoning bool)
 	return chunk
 }
 
-func (p *IFlowProxy) forwardSSE(ctx context.Context, in io.ReadCloser, out chan<- []byte, model, traceID, parentObservationID string, startedAt time.Time) {
+func (p *IFlowProxy) forwardSSE(ctx context.Context, in io.ReadCloser, out chan<- []byte, watchdog *streamWatchdog, transform *streamTransform, model, traceID, parentObservationID string, startedAt time.Time) {
 	defer close(out)
 	defer in.Close()
+	defer watchdog.stop()
 
 	reader := bufio.NewReader(in)
 	chunkCount := 0
+	chunkCounter := metrics.SSEChunks.WithLabelValues(metrics.ModelLabel(model), metrics.AccountLabel(p.account.UUID))
+	send := func(payload []byte) bool {
+		select {
+		case out <- payload:
+			chunkCount++
+			chunkCounter.Inc()
+			return true
+		case <-ctx.Done():
+			log.Debug().
+				Str("account_uuid", strings.TrimSpace(p.account.UUID)).
+				Int("chunks", chunkCount).
+				Msg("proxy sse forward cancelled by context")
+			return false
+		}
+	}
+
 	for {
 		line, err := reader.ReadString('\n')
 		if line != "" {
+			watchdog.touch()
 			payload := []byte(line)
 			trimmed := strings.TrimSpace(line)
 			if strings.HasPrefix(trimmed, "data:") {
 				dataPart := strings.TrimSpace(strings.TrimPrefix(trimmed, "data:"))
-				if dataPart != "" && dataPart != "[DONE]" {
+				if dataPart == "[DONE]" {
+					if usageChunk := transform.pendingUsageChunk(); usageChunk != nil && !send(usageChunk) {
+						return
+					}
+				} else if dataPart != "" {
 					var chunk map[string]interface{}
 					if jsonErr := json.Unmarshal([]byte(dataPart), &chunk); jsonErr == nil {
-						chunk = normalizeStreamChunk(chunk, p.preserveReasoningContent)
-						if chunkRaw, marshalErr := json.Marshal(chunk); marshalErr == nil {
+						chunk, keep := transform.apply(chunk)
+						if !keep {
+							payload = nil
+						} else if chunkRaw, marshalErr := json.Marshal(chunk); marshalErr == nil {
 							payload = []byte("data: " + string(chunkRaw) + "\n\n")
 						}
 					}
 				}
 			}
 
-			select {
-			case out <- payload:
-				chunkCount++
-			case <-ctx.Done():
-				log.Debug().
-					Str("account_uuid", strings.TrimSpace(p.account.UUID)).
-					Int("chunks", chunkCount).
-					Msg("proxy sse forward cancelled by context")
+			if payload != nil && !send(payload) {
 				return
 			}
 		}
 
+		if timeoutErr := watchdog.err(); err != nil && timeoutErr != nil {
+			log.Warn().
+				Err(timeoutErr).
+				Str("account_uuid", strings.TrimSpace(p.account.UUID)).
+				Str("model", model).
+				Int("chunks", chunkCount).
+				Msg("proxy sse forward timed out")
+			if p.telemetry != nil && parentObservationID != "" {
+				p.telemetry.EmitRunError(ctx, model, traceID, parentObservationID, timeoutErr.Error())
+			}
+			send(streamErrorEvent(timeoutErr.Error(), "timeout_error", "stream_timeout"))
+			return
+		}
 		if err == io.EOF {
 			log.Debug().
 				Str("account_uuid", strings.TrimSpace(p.account.UUID)).
 				Int("chunks", chunkCount).
 				Msg("proxy sse forward reached eof")
+			if usageChunk := transform.pendingUsageChunk(); usageChunk != nil && !send(usageChunk) {
+				return
+			}
+			usage := transform.finalUsage()
+			metrics.ObserveTokens(model, p.account.UUID, usageInt(usage, "prompt_tokens"), usageInt(usage, "completion_tokens"))
 			if p.telemetry != nil && parentObservationID != "" {
 				p.telemetry.EmitRunFinished(ctx, model, traceID, parentObservationID, time.Since(startedAt))
 			}
@@ -428,11 +612,46 @@ func (p *IFlowProxy) forwardSSE(ctx context.Context, in io.ReadCloser, out chan<
 			if p.telemetry != nil && parentObservationID != "" {
 				p.telemetry.EmitRunError(ctx, model, traceID, parentObservationID, err.Error())
 			}
+			send(streamErrorEvent(fmt.Sprintf("upstream stream interrupted: %v", err), "api_error", "stream_interrupted"))
 			return
 		}
 	}
 }
 
+// streamErrorEvent encodes an OpenAI-style error object as an SSE data line
+// for failures after the stream has started.
+func streamErrorEvent(message, errType, code string) []byte {
+	raw, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    errType,
+			"code":    code,
+		},
+	})
+	return []byte("data: " + string(raw) + "\n\n")
+}
+
+func chunkFinished(chunk map[string]interface{}) bool {
+	choices, _ := chunk["choices"].([]interface{})
+	for _, choice := range choices {
+		choiceMap, ok := choice.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if reason, ok := choiceMap["finish_reason"].(string); ok && reason != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func usageInt(usage map[string]interface{}, key string) int {
+	if value, ok := usage[key].(float64); ok {
+		return int(value)
+	}
+	return 0
+}
+
 type compositeReadCloser struct {
 	io.Reader
 	closers []io.Closer
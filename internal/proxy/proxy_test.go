@@ -99,8 +99,11 @@ func TestChatCompletions(t *testing.T) {
 	if resp.Choices[0].Message.ReasoningContent != "" {
 		t.Fatalf("reasoning_content = %q, want empty", resp.Choices[0].Message.ReasoningContent)
 	}
-	if resp.Usage.TotalTokens != 0 {
-		t.Fatalf("usage.total_tokens = %d, want 0", resp.Usage.TotalTokens)
+	if !resp.Usage.Estimated || resp.Usage.PromptTokens == 0 || resp.Usage.CompletionTokens == 0 {
+		t.Fatalf("usage = %+v, want local estimate", resp.Usage)
+	}
+	if resp.Usage.CompletionTokensDetails == nil || resp.Usage.CompletionTokensDetails.ReasoningTokens == 0 {
+		t.Fatalf("usage reasoning tokens missing: %+v", resp.Usage)
 	}
 }
 
@@ -284,6 +287,39 @@ func TestModelsReturnsCopy(t *testing.T) {
 	}
 }
 
+func TestFetchModels(t *testing.T) {
+	p := NewProxy(&account.Account{APIKey: "sk-test", BaseURL: "https://apis.iflow.cn/v1"})
+	p.telemetry = nil
+	p.client = &http.Client{
+		Transport: proxyRoundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Method != http.MethodGet || req.URL.String() != "https://apis.iflow.cn/v1/models" {
+				t.Fatalf("unexpected request: %s %s", req.Method, req.URL)
+			}
+			if req.Header.Get("Authorization") != "Bearer sk-test" {
+				t.Fatalf("missing authorization header")
+			}
+			return newProxyResponse(http.StatusOK, `{"object":"list","data":[{"id":"glm-5","context_length":128000},{"id":""},{"id":"kimi-k2"}]}`), nil
+		}),
+	}
+
+	models, err := p.FetchModels(context.Background())
+	if err != nil {
+		t.Fatalf("FetchModels() error = %v", err)
+	}
+	if len(models) != 2 || models[0].ID != "glm-5" || models[0].ContextLength != 128000 || models[1].ID != "kimi-k2" {
+		t.Fatalf("unexpected models: %+v", models)
+	}
+
+	p.client = &http.Client{
+		Transport: proxyRoundTripFunc(func(*http.Request) (*http.Response, error) {
+			return newProxyResponse(http.StatusOK, `{"status":"434","msg":"Invalid apiKey"}`), nil
+		}),
+	}
+	if _, err := p.FetchModels(context.Background()); err == nil {
+		t.Fatal("FetchModels() should fail on embedded error")
+	}
+}
+
 func TestNewProxyTelemetryUserIDPrefersAPIKey(t *testing.T) {
 	p := NewProxy(&account.Account{APIKey: "sk-test"})
 	if p.telemetry == nil {
@@ -388,6 +424,7 @@ func TestChatCompletionsTelemetrySequence(t *testing.T) {
 	if err != nil {
 		t.Fatalf("ChatCompletions error: %v", err)
 	}
+	p.telemetry.Flush()
 
 	if len(telemetryURLs) != 3 {
 		t.Fatalf("telemetry requests = %d, want 3", len(telemetryURLs))
@@ -402,3 +439,19 @@ func TestChatCompletionsTelemetrySequence(t *testing.T) {
 		t.Fatalf("telemetry[2] = %s", telemetryURLs[2])
 	}
 }
+
+func TestNewProxyUsesAccountProxyURL(t *testing.T) {
+	p := NewProxy(&account.Account{APIKey: "sk-test", ProxyURL: "socks5://127.0.0.1:1080"})
+
+	for name, client := range map[string]*http.Client{"api": p.client, "telemetry": p.telemetry.client} {
+		tr, ok := client.Transport.(*http.Transport)
+		if !ok {
+			t.Fatalf("%s transport = %T, want *http.Transport", name, client.Transport)
+		}
+		req, _ := http.NewRequest(http.MethodGet, "https://apis.iflow.cn/v1/models", nil)
+		proxyURL, err := tr.Proxy(req)
+		if err != nil || proxyURL == nil || proxyURL.String() != "socks5://127.0.0.1:1080" {
+			t.Fatalf("%s proxy = %v, %v", name, proxyURL, err)
+		}
+	}
+}
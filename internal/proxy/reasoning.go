@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+// Reasoning effort levels accepted from clients, following OpenAI's
+// reasoning_effort values.
+const (
+	ReasoningNone    = "none"
+	ReasoningMinimal = "minimal"
+	ReasoningLow     = "low"
+	ReasoningMedium  = "medium"
+	ReasoningHigh    = "high"
+)
+
+// reasoningBudgets maps effort levels to thinking budgets in tokens. High
+// leaves the budget to the model.
+var reasoningBudgets = map[string]int{
+	ReasoningMinimal: 1024,
+	ReasoningLow:     2048,
+	ReasoningMedium:  8192,
+	ReasoningHigh:    0,
+}
+
+// ParseReasoningEffort normalizes a reasoning effort value. "off" and
+// "disabled" are accepted as aliases of "none".
+func ParseReasoningEffort(value string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(value))
+	switch normalized {
+	case "off", "disabled":
+		return ReasoningNone, nil
+	case ReasoningNone, ReasoningMinimal, ReasoningLow, ReasoningMedium, ReasoningHigh:
+		return normalized, nil
+	default:
+		return "", fmt.Errorf("invalid reasoning effort %q (want none, minimal, low, medium or high)", value)
+	}
+}
+
+// reasoningControl is the thinking behaviour a client asked for.
+type reasoningControl struct {
+	set     bool
+	enabled bool
+	budget  int
+}
+
+// reasoningFromBody reads reasoning_effort, which wins, or an Anthropic-style
+// thinking object from a request body.
+func reasoningFromBody(body map[string]interface{}) reasoningControl {
+	if raw, ok := body["reasoning_effort"].(string); ok {
+		if effort, err := ParseReasoningEffort(raw); err == nil {
+			return reasoningControl{
+				set:     true,
+				enabled: effort != ReasoningNone,
+				budget:  reasoningBudgets[effort],
+			}
+		}
+	}
+
+	thinking, ok := body["thinking"].(map[string]interface{})
+	if !ok {
+		return reasoningControl{}
+	}
+	switch thinking["type"] {
+	case "disabled":
+		return reasoningControl{set: true}
+	case "enabled":
+		budget, _ := numericValue(thinking["budget_tokens"])
+		return reasoningControl{set: true, enabled: true, budget: int(budget)}
+	default:
+		return reasoningControl{}
+	}
+}
+
+// CheckReasoningSupport reports an error when the profile of req.Model cannot
+// honor the reasoning controls req asks for: turning thinking off needs
+// thinking_off, turning it on needs thinking and a budget needs
+// thinking_budget. Models without a profile receive the controls unchanged,
+// so iFlow decides for them.
+func CheckReasoningSupport(req *types.ChatCompletionRequest) error {
+	if req == nil {
+		return nil
+	}
+	active := currentProfiles()
+	model := active.ResolveAlias(req.Model)
+	profile := active.Lookup(model)
+	if profile == nil {
+		return nil
+	}
+
+	body := map[string]interface{}{}
+	if req.ReasoningEffort != "" {
+		body["reasoning_effort"] = req.ReasoningEffort
+	}
+	if req.Thinking != nil {
+		body["thinking"] = map[string]interface{}{"type": req.Thinking.Type, "budget_tokens": req.Thinking.BudgetTokens}
+	}
+	reasoning := reasoningFromBody(body)
+	switch {
+	case !reasoning.set:
+		return nil
+	case !reasoning.enabled && len(profile.ThinkingOff) == 0:
+		return fmt.Errorf("model %s does not support turning thinking off", req.Model)
+	case reasoning.enabled && len(profile.Thinking) == 0:
+		return fmt.Errorf("model %s does not support thinking", req.Model)
+	case reasoning.enabled && reasoning.budget > 0 && profile.ThinkingBudget == "":
+		return fmt.Errorf("model %s does not support thinking budgets, use reasoning_effort high instead", req.Model)
+	}
+	return nil
+}
+
+// setPath assigns value at a dotted path such as "thinking.budget_tokens",
+// creating intermediate objects.
+func setPath(target map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := target[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			target[key] = next
+		}
+		target = next
+	}
+	target[keys[len(keys)-1]] = value
+}
+
+// mergeAll writes values over target, merging nested objects so unrelated
+// client keys (e.g. other chat_template_kwargs) survive.
+func mergeAll(target, values map[string]interface{}) {
+	for key, value := range values {
+		nested, isMap := value.(map[string]interface{})
+		existing, hasMap := target[key].(map[string]interface{})
+		if isMap && hasMap {
+			merged := cloneMap(existing)
+			mergeAll(merged, nested)
+			target[key] = merged
+			continue
+		}
+		target[key] = cloneValue(value)
+	}
+}
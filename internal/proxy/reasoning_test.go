@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+func TestParseReasoningEffort(t *testing.T) {
+	cases := map[string]string{
+		"none":     ReasoningNone,
+		" OFF ":    ReasoningNone,
+		"disabled": ReasoningNone,
+		"Low":      ReasoningLow,
+		"high":     ReasoningHigh,
+	}
+	for input, want := range cases {
+		got, err := ParseReasoningEffort(input)
+		if err != nil || got != want {
+			t.Fatalf("ParseReasoningEffort(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseReasoningEffort("extreme"); err == nil {
+		t.Fatal("ParseReasoningEffort(extreme) should fail")
+	}
+}
+
+func TestConfigureModelParamsReasoningEffortNone(t *testing.T) {
+	got := ConfigureModelParams(map[string]interface{}{
+		"reasoning_effort":     "none",
+		"enable_thinking":      true,
+		"chat_template_kwargs": map[string]interface{}{"custom": "kept"},
+	}, "glm-5", "https://apis.iflow.cn/v1", "session-1")
+
+	if _, ok := got["reasoning_effort"]; ok {
+		t.Fatalf("reasoning_effort should be translated, got %#v", got)
+	}
+	if got["enable_thinking"] != false {
+		t.Fatalf("enable_thinking = %#v, want false", got["enable_thinking"])
+	}
+	thinking, _ := got["thinking"].(map[string]interface{})
+	if thinking["type"] != "disabled" {
+		t.Fatalf("thinking = %#v, want disabled", got["thinking"])
+	}
+	kwargs, _ := got["chat_template_kwargs"].(map[string]interface{})
+	if kwargs["enable_thinking"] != false || kwargs["custom"] != "kept" {
+		t.Fatalf("chat_template_kwargs = %#v", got["chat_template_kwargs"])
+	}
+}
+
+func TestConfigureModelParamsReasoningBudget(t *testing.T) {
+	got := ConfigureModelParams(map[string]interface{}{"reasoning_effort": "low"}, "kimi-k2.5", "https://apis.iflow.cn/v1", "session-1")
+	thinking, _ := got["thinking"].(map[string]interface{})
+	if thinking["type"] != "enabled" || thinking["budget_tokens"] != reasoningBudgets[ReasoningLow] {
+		t.Fatalf("thinking = %#v, want enabled with low budget", got["thinking"])
+	}
+
+	got = ConfigureModelParams(map[string]interface{}{
+		"thinking": map[string]interface{}{"type": "enabled", "budget_tokens": 3000.0},
+	}, "glm-5", "https://apis.iflow.cn/v1", "session-1")
+	thinking, _ = got["thinking"].(map[string]interface{})
+	if thinking["type"] != "enabled" || thinking["budget_tokens"] != 3000 {
+		t.Fatalf("thinking = %#v, want enabled with budget 3000", got["thinking"])
+	}
+}
+
+func TestConfigureModelParamsAnthropicThinkingDisabled(t *testing.T) {
+	got := ConfigureModelParams(map[string]interface{}{
+		"thinking": map[string]interface{}{"type": "disabled"},
+	}, "deepseek-v3.2-chat", "https://apis.iflow.cn/v1", "session-1")
+
+	if got["thinking_mode"] != false || got["reasoning"] != false {
+		t.Fatalf("deepseek thinking not disabled: %#v", got)
+	}
+	if _, ok := got["thinking"]; ok {
+		t.Fatalf("client thinking object should not be forwarded: %#v", got["thinking"])
+	}
+}
+
+func TestConfigureModelParamsReasoningWithoutProfile(t *testing.T) {
+	got := ConfigureModelParams(map[string]interface{}{"reasoning_effort": "high"}, "qwen3-coder-plus", "https://apis.iflow.cn/v1", "session-1")
+	if got["reasoning_effort"] != "high" {
+		t.Fatalf("models without a profile should receive reasoning_effort unchanged: %#v", got)
+	}
+}
+
+func TestPreserveReasoningOverride(t *testing.T) {
+	p := NewProxyWithReasoning(&account.Account{APIKey: "sk-test"}, true)
+	if !p.preserveReasoning(&types.ChatCompletionRequest{}) {
+		t.Fatal("proxy default should apply without an override")
+	}
+	off := false
+	if p.preserveReasoning(&types.ChatCompletionRequest{PreserveReasoning: &off}) {
+		t.Fatal("request override should win")
+	}
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"encoding/json"
+
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+// streamTransform carries the per-stream state forwardSSE needs to rewrite
+// upstream chunks: usage tracking, embedded tool call recovery and the
+// stream_options.include_usage final chunk.
+type streamTransform struct {
+	estimator         *usageEstimator
+	tools             *streamToolCallExtractor
+	includeUsage      bool
+	preserveReasoning bool
+
+	usage     map[string]interface{}
+	estimated map[string]interface{}
+	usageSent bool
+
+	id      interface{}
+	model   interface{}
+	created interface{}
+}
+
+func newStreamTransform(req *types.ChatCompletionRequest, preserveReasoning bool) *streamTransform {
+	t := &streamTransform{
+		estimator:         newUsageEstimator(req),
+		preserveReasoning: preserveReasoning,
+	}
+	if req.HasTools() {
+		t.tools = newStreamToolCallExtractor()
+	}
+	if req != nil && req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+		t.includeUsage = true
+	}
+	return t
+}
+
+// apply rewrites one decoded upstream chunk. It returns false when the chunk
+// should not be forwarded.
+//
+// Without include_usage, upstream usage is passed through and an estimate is
+// attached to the finish chunk when upstream reports none. With include_usage,
+// usage is withheld from content chunks and delivered by usageChunk instead.
+func (t *streamTransform) apply(chunk map[string]interface{}) (map[string]interface{}, bool) {
+	t.estimator.observe(chunk)
+	if t.tools != nil {
+		t.tools.process(chunk)
+	}
+	for key, target := range map[string]*interface{}{"id": &t.id, "model": &t.model, "created": &t.created} {
+		if value, ok := chunk[key]; ok && value != nil {
+			*target = value
+		}
+	}
+
+	choices, _ := chunk["choices"].([]interface{})
+	if chunkUsage, ok := chunk["usage"].(map[string]interface{}); ok && len(chunkUsage) > 0 {
+		t.usage = chunkUsage
+		if t.includeUsage {
+			delete(chunk, "usage")
+			if len(choices) == 0 {
+				return nil, false
+			}
+		}
+	} else if t.includeUsage {
+		delete(chunk, "usage")
+	} else if t.usage == nil && t.estimated == nil && chunkFinished(chunk) {
+		t.estimated = t.estimator.usageMap()
+		chunk["usage"] = t.estimated
+	}
+
+	return normalizeStreamChunk(chunk, t.preserveReasoning), true
+}
+
+// finalUsage returns the upstream usage, or a local estimate when upstream
+// reported none.
+func (t *streamTransform) finalUsage() map[string]interface{} {
+	if t.usage != nil {
+		return t.usage
+	}
+	if t.estimated == nil {
+		t.estimated = t.estimator.usageMap()
+	}
+	return t.estimated
+}
+
+// pendingUsageChunk returns the include_usage final chunk if it is still owed.
+func (t *streamTransform) pendingUsageChunk() []byte {
+	if !t.includeUsage || t.usageSent {
+		return nil
+	}
+	t.usageSent = true
+
+	chunk := map[string]interface{}{
+		"id":      t.id,
+		"object":  "chat.completion.chunk",
+		"created": t.created,
+		"model":   t.model,
+		"choices": []interface{}{},
+		"usage":   t.finalUsage(),
+	}
+	raw, err := json.Marshal(chunk)
+	if err != nil {
+		return nil
+	}
+	return []byte("data: " + string(raw) + "\n\n")
+}
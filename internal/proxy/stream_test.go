@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+func collectStreamChunks(t *testing.T, body string, req *types.ChatCompletionRequest) ([]types.ChatCompletionChunk, string) {
+	t.Helper()
+
+	p := NewProxy(&account.Account{APIKey: "sk-test"})
+	p.telemetry = nil
+	p.client = &http.Client{
+		Transport: proxyRoundTripFunc(func(*http.Request) (*http.Response, error) {
+			return newProxyResponse(http.StatusOK, body), nil
+		}),
+	}
+
+	stream, err := p.ChatCompletionsStream(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ChatCompletionsStream error: %v", err)
+	}
+
+	var raw strings.Builder
+	var chunks []types.ChatCompletionChunk
+	for payload := range stream {
+		raw.Write(payload)
+		line := strings.TrimSpace(string(payload))
+		if !strings.HasPrefix(line, "data: {") {
+			continue
+		}
+		var chunk types.ChatCompletionChunk
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &chunk); err != nil {
+			t.Fatalf("decode chunk %q: %v", line, err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, raw.String()
+}
+
+func includeUsageRequest() *types.ChatCompletionRequest {
+	return &types.ChatCompletionRequest{
+		Model:         "glm-5",
+		Messages:      []types.Message{{Role: "user", Content: "hi"}},
+		Stream:        true,
+		StreamOptions: &types.StreamOptions{IncludeUsage: true},
+	}
+}
+
+func TestStreamIncludeUsageForwardsUpstreamUsage(t *testing.T) {
+	body := "data: {\"id\":\"c1\",\"created\":1700000000,\"model\":\"glm-5\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hello\"},\"finish_reason\":\"stop\"}],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":2,\"total_tokens\":7}}\n\n" +
+		"data: [DONE]\n\n"
+
+	chunks, raw := collectStreamChunks(t, body, includeUsageRequest())
+	if len(chunks) != 2 {
+		t.Fatalf("chunks = %d, want 2: %s", len(chunks), raw)
+	}
+	if chunks[0].Usage != nil {
+		t.Fatalf("content chunk should not carry usage: %+v", chunks[0].Usage)
+	}
+	final := chunks[1]
+	if len(final.Choices) != 0 || final.Usage == nil || final.Usage.TotalTokens != 7 || final.Usage.Estimated {
+		t.Fatalf("unexpected final chunk: %+v", final)
+	}
+	if final.ID != "c1" || final.Model != "glm-5" || final.Object != "chat.completion.chunk" {
+		t.Fatalf("final chunk metadata = %+v", final)
+	}
+	if strings.Index(raw, `"choices":[]`) > strings.Index(raw, "[DONE]") {
+		t.Fatalf("usage chunk must precede [DONE]: %s", raw)
+	}
+}
+
+func TestStreamIncludeUsageEstimatesMissingUsage(t *testing.T) {
+	body := "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hello world\"},\"finish_reason\":\"stop\"}]}\n\n"
+
+	chunks, raw := collectStreamChunks(t, body, includeUsageRequest())
+	if len(chunks) != 2 {
+		t.Fatalf("chunks = %d, want 2: %s", len(chunks), raw)
+	}
+	if chunks[0].Usage != nil {
+		t.Fatalf("finish chunk should not carry usage with include_usage: %s", raw)
+	}
+	final := chunks[1].Usage
+	if final == nil || !final.Estimated || final.CompletionTokens != CountTokens("glm-5", "hello world") {
+		t.Fatalf("unexpected final usage: %+v", final)
+	}
+}
+
+func TestStreamIncludeUsageReusesUpstreamUsageChunk(t *testing.T) {
+	body := "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"},\"finish_reason\":\"stop\"}]}\n\n" +
+		"data: {\"id\":\"c1\",\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":1,\"total_tokens\":4}}\n\n" +
+		"data: [DONE]\n\n"
+
+	chunks, raw := collectStreamChunks(t, body, includeUsageRequest())
+	if len(chunks) != 2 {
+		t.Fatalf("chunks = %d, want 2: %s", len(chunks), raw)
+	}
+	if chunks[1].Usage == nil || chunks[1].Usage.TotalTokens != 4 {
+		t.Fatalf("unexpected final usage: %+v", chunks[1].Usage)
+	}
+}
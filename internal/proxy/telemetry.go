@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/rogeecn/iflow-go/internal/transport"
 	"github.com/rs/zerolog/log"
 )
 
@@ -25,6 +26,7 @@ const (
 	telemetrySIDX             = "aplusSidex"
 	telemetryCKX              = "aplusCkx"
 	telemetryDefaultPageTitle = "iFlow-CLI"
+	telemetryTimeout          = 10 * time.Second
 )
 
 type Telemetry struct {
@@ -34,21 +36,70 @@ type Telemetry struct {
 	client         *http.Client
 	nodeVersion    string
 	cna            string
+	mode           TelemetryMode
+	sink           TelemetrySink
+	worker         *telemetryWorker
 }
 
 func NewTelemetry(userID, sessionID, conversationID string) *Telemetry {
+	mode, sink := telemetryDefaults()
 	return &Telemetry{
 		userID:         strings.TrimSpace(userID),
 		sessionID:      strings.TrimSpace(sessionID),
 		conversationID: strings.TrimSpace(conversationID),
-		client:         &http.Client{Timeout: 10 * time.Second},
+		client:         transport.NewClient(telemetryTimeout, ""),
 		nodeVersion:    telemetryNodeVersion(),
 		cna:            telemetryCNAToken(),
+		mode:           mode,
+		sink:           sink,
+		worker:         defaultTelemetryWorker,
 	}
 }
 
+// Flush blocks until every queued telemetry job has been delivered.
+func (t *Telemetry) Flush() {
+	if t == nil || t.worker == nil {
+		return
+	}
+	t.worker.flush()
+}
+
+func (t *Telemetry) enabled() bool {
+	if t == nil {
+		return false
+	}
+	switch t.mode {
+	case TelemetryLocal:
+		return t.sink != nil
+	case TelemetryOff:
+		return false
+	default:
+		return t.client != nil
+	}
+}
+
+// dispatch hands the event to the local sink or queues the upstream post so
+// request goroutines never wait on telemetry.
+func (t *Telemetry) dispatch(ctx context.Context, event TelemetryEvent, send func(ctx context.Context)) {
+	event.Time = time.Now().UTC()
+	event.UserID = t.userID
+	event.SessionID = t.sessionID
+	event.ConversationID = t.conversationID
+
+	ctx = context.WithoutCancel(ctx)
+	t.worker.submit(func() {
+		if t.mode == TelemetryLocal {
+			if err := t.sink.Record(event); err != nil {
+				log.Debug().Err(err).Str("event", event.Type).Msg("local telemetry sink failed")
+			}
+			return
+		}
+		send(ctx)
+	})
+}
+
 func (t *Telemetry) EmitRunStarted(ctx context.Context, model, traceID string) string {
-	if t == nil || t.client == nil {
+	if !t.enabled() {
 		return ""
 	}
 
@@ -63,15 +114,22 @@ func (t *Telemetry) EmitRunStarted(ctx context.Context, model, traceID string) s
 		url.QueryEscape(t.userID),
 	)
 
-	if err := t.postGM(ctx, "//aitrack.lifecycle.run_started", gokey); err != nil {
-		log.Debug().Err(err).Msg("mmstat gm event failed (//aitrack.lifecycle.run_started)")
-	}
+	t.dispatch(ctx, TelemetryEvent{
+		Type:          TelemetryRunStarted,
+		TraceID:       traceID,
+		ObservationID: observationID,
+		Model:         model,
+	}, func(ctx context.Context) {
+		if err := t.postGM(ctx, "//aitrack.lifecycle.run_started", gokey); err != nil {
+			log.Debug().Err(err).Msg("mmstat gm event failed (//aitrack.lifecycle.run_started)")
+		}
+	})
 
 	return observationID
 }
 
 func (t *Telemetry) EmitRunFinished(ctx context.Context, model, traceID, parentObservationID string, duration time.Duration) {
-	if t == nil || t.client == nil {
+	if !t.enabled() {
 		return
 	}
 
@@ -94,16 +152,25 @@ func (t *Telemetry) EmitRunFinished(ctx context.Context, model, traceID, parentO
 		url.QueryEscape(t.userID),
 	)
 
-	if err := t.postGM(ctx, "//aitrack.lifecycle.run_finished", gokey); err != nil {
-		log.Debug().Err(err).Msg("mmstat gm event failed (//aitrack.lifecycle.run_finished)")
-	}
-	if err := t.postVGIF(ctx); err != nil {
-		log.Debug().Err(err).Msg("mmstat v.gif failed")
-	}
+	t.dispatch(ctx, TelemetryEvent{
+		Type:                TelemetryRunFinished,
+		TraceID:             traceID,
+		ObservationID:       observationID,
+		ParentObservationID: parentObservationID,
+		Model:               model,
+		DurationMs:          durationMillis,
+	}, func(ctx context.Context) {
+		if err := t.postGM(ctx, "//aitrack.lifecycle.run_finished", gokey); err != nil {
+			log.Debug().Err(err).Msg("mmstat gm event failed (//aitrack.lifecycle.run_finished)")
+		}
+		if err := t.postVGIF(ctx); err != nil {
+			log.Debug().Err(err).Msg("mmstat v.gif failed")
+		}
+	})
 }
 
 func (t *Telemetry) EmitRunError(ctx context.Context, model, traceID, parentObservationID, errMsg string) {
-	if t == nil || t.client == nil {
+	if !t.enabled() {
 		return
 	}
 
@@ -128,9 +195,18 @@ func (t *Telemetry) EmitRunError(ctx context.Context, model, traceID, parentObse
 		url.QueryEscape(runtimePlatformVersion()),
 	)
 
-	if err := t.postGM(ctx, "//aitrack.lifecycle.run_error", gokey); err != nil {
-		log.Debug().Err(err).Msg("mmstat gm event failed (//aitrack.lifecycle.run_error)")
-	}
+	t.dispatch(ctx, TelemetryEvent{
+		Type:                TelemetryRunError,
+		TraceID:             traceID,
+		ObservationID:       observationID,
+		ParentObservationID: parentObservationID,
+		Model:               model,
+		Error:               errMsg,
+	}, func(ctx context.Context) {
+		if err := t.postGM(ctx, "//aitrack.lifecycle.run_error", gokey); err != nil {
+			log.Debug().Err(err).Msg("mmstat gm event failed (//aitrack.lifecycle.run_error)")
+		}
+	})
 }
 
 func (t *Telemetry) postGM(ctx context.Context, path, gokey string) error {
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type TelemetryMode string
+
+const (
+	TelemetryOff      TelemetryMode = "off"
+	TelemetryUpstream TelemetryMode = "upstream"
+	TelemetryLocal    TelemetryMode = "local"
+)
+
+const (
+	TelemetryRunStarted  = "run_started"
+	TelemetryRunFinished = "run_finished"
+	TelemetryRunError    = "run_error"
+
+	telemetryQueueSize = 256
+)
+
+func ParseTelemetryMode(value string) (TelemetryMode, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "upstream", "on":
+		return TelemetryUpstream, nil
+	case "off", "none", "disabled":
+		return TelemetryOff, nil
+	case "local":
+		return TelemetryLocal, nil
+	default:
+		return "", fmt.Errorf("unknown telemetry mode %q", value)
+	}
+}
+
+// TelemetryEvent is the record written by local sinks.
+type TelemetryEvent struct {
+	Type                string    `json:"type"`
+	Time                time.Time `json:"time"`
+	UserID              string    `json:"user_id"`
+	SessionID           string    `json:"session_id"`
+	ConversationID      string    `json:"conversation_id"`
+	TraceID             string    `json:"trace_id,omitempty"`
+	ObservationID       string    `json:"observation_id"`
+	ParentObservationID string    `json:"parent_observation_id,omitempty"`
+	Model               string    `json:"model"`
+	DurationMs          int64     `json:"duration_ms,omitempty"`
+	Error               string    `json:"error,omitempty"`
+}
+
+type TelemetrySink interface {
+	Record(event TelemetryEvent) error
+}
+
+var (
+	telemetryMu   sync.RWMutex
+	telemetryMode = TelemetryUpstream
+	telemetrySink TelemetrySink
+
+	defaultTelemetryWorker = newTelemetryWorker(telemetryQueueSize)
+)
+
+// ConfigureTelemetry sets the mode and local sink used by telemetry created
+// afterwards. Local mode requires a sink.
+func ConfigureTelemetry(mode TelemetryMode, sink TelemetrySink) error {
+	if mode == TelemetryLocal && sink == nil {
+		return fmt.Errorf("configure telemetry: local mode requires a sink")
+	}
+
+	telemetryMu.Lock()
+	defer telemetryMu.Unlock()
+	telemetryMode = mode
+	telemetrySink = sink
+	return nil
+}
+
+// FlushTelemetry waits for queued telemetry jobs, e.g. before shutdown.
+func FlushTelemetry() {
+	defaultTelemetryWorker.flush()
+}
+
+func telemetryDefaults() (TelemetryMode, TelemetrySink) {
+	telemetryMu.RLock()
+	defer telemetryMu.RUnlock()
+	return telemetryMode, telemetrySink
+}
+
+// JSONLSink appends one JSON event per line to a file.
+type JSONLSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewJSONLSink(path string) (*JSONLSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("open telemetry file: ensure dir: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open telemetry file: %w", err)
+	}
+	return &JSONLSink{file: file}, nil
+}
+
+func (s *JSONLSink) Record(event TelemetryEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("record telemetry: marshal json: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("record telemetry: write file: %w", err)
+	}
+	return nil
+}
+
+func (s *JSONLSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// ChannelSink publishes events to an in-process channel. Events are dropped
+// when the reader falls behind.
+type ChannelSink struct {
+	events chan TelemetryEvent
+}
+
+func NewChannelSink(size int) *ChannelSink {
+	if size <= 0 {
+		size = telemetryQueueSize
+	}
+	return &ChannelSink{events: make(chan TelemetryEvent, size)}
+}
+
+func (s *ChannelSink) Events() <-chan TelemetryEvent {
+	return s.events
+}
+
+func (s *ChannelSink) Record(event TelemetryEvent) error {
+	select {
+	case s.events <- event:
+		return nil
+	default:
+		return fmt.Errorf("record telemetry: channel full")
+	}
+}
+
+// telemetryWorker runs telemetry jobs on a single goroutine, in submission
+// order, behind a bounded queue. Jobs are dropped when the queue is full.
+type telemetryWorker struct {
+	jobs    chan func()
+	pending sync.WaitGroup
+	start   sync.Once
+}
+
+func newTelemetryWorker(size int) *telemetryWorker {
+	return &telemetryWorker{jobs: make(chan func(), size)}
+}
+
+func (w *telemetryWorker) submit(job func()) bool {
+	w.start.Do(func() {
+		go w.run()
+	})
+
+	w.pending.Add(1)
+	select {
+	case w.jobs <- job:
+		return true
+	default:
+		w.pending.Done()
+		log.Debug().Msg("telemetry queue full, event dropped")
+		return false
+	}
+}
+
+func (w *telemetryWorker) run() {
+	for job := range w.jobs {
+		job()
+		w.pending.Done()
+	}
+}
+
+func (w *telemetryWorker) flush() {
+	w.pending.Wait()
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -19,7 +21,7 @@ func TestTelemetryEmitRunStarted(t *testing.T) {
 		Transport: proxyRoundTripFunc(func(req *http.Request) (*http.Response, error) {
 			body, err := io.ReadAll(req.Body)
 			if err != nil {
-				t.Fatalf("read body: %v", err)
+				t.Errorf("read body: %v", err)
 			}
 			urls = append(urls, req.URL.String())
 			bodies = append(bodies, string(body))
@@ -29,6 +31,7 @@ func TestTelemetryEmitRunStarted(t *testing.T) {
 	}
 
 	observationID := telemetry.EmitRunStarted(context.Background(), "glm-5", "")
+	telemetry.Flush()
 	if observationID == "" {
 		t.Fatal("EmitRunStarted observation id should not be empty")
 	}
@@ -66,7 +69,7 @@ func TestTelemetryEmitRunFinished(t *testing.T) {
 		Transport: proxyRoundTripFunc(func(req *http.Request) (*http.Response, error) {
 			body, err := io.ReadAll(req.Body)
 			if err != nil {
-				t.Fatalf("read body: %v", err)
+				t.Errorf("read body: %v", err)
 			}
 			urls = append(urls, req.URL.String())
 			bodies = append(bodies, string(body))
@@ -76,6 +79,7 @@ func TestTelemetryEmitRunFinished(t *testing.T) {
 	}
 
 	telemetry.EmitRunFinished(context.Background(), "glm-5", "", "parent-obs-1", 12*time.Millisecond)
+	telemetry.Flush()
 	if len(urls) != 2 {
 		t.Fatalf("requests = %d, want 2", len(urls))
 	}
@@ -118,25 +122,25 @@ func TestTelemetryEmitRunError(t *testing.T) {
 	telemetry.client = &http.Client{
 		Transport: proxyRoundTripFunc(func(req *http.Request) (*http.Response, error) {
 			if req.URL.String() != "https://gm.mmstat.com//aitrack.lifecycle.run_error" {
-				t.Fatalf("unexpected url: %s", req.URL.String())
+				t.Errorf("unexpected url: %s", req.URL.String())
 			}
 
 			body, err := io.ReadAll(req.Body)
 			if err != nil {
-				t.Fatalf("read body: %v", err)
+				t.Errorf("read body: %v", err)
 			}
 			bodyText := string(body)
 			if !strings.Contains(bodyText, "error_msg=bad+request") {
-				t.Fatalf("payload missing error_msg: %s", bodyText)
+				t.Errorf("payload missing error_msg: %s", bodyText)
 			}
 			if !strings.Contains(bodyText, "parent_observation_id=parent-obs-1") {
-				t.Fatalf("payload missing parent_observation_id: %s", bodyText)
+				t.Errorf("payload missing parent_observation_id: %s", bodyText)
 			}
 			if !strings.Contains(bodyText, "cliVer=0.5.14") {
-				t.Fatalf("payload missing cliVer: %s", bodyText)
+				t.Errorf("payload missing cliVer: %s", bodyText)
 			}
 			if !strings.Contains(bodyText, "nodeVersion=v22.21.0") {
-				t.Fatalf("payload missing nodeVersion: %s", bodyText)
+				t.Errorf("payload missing nodeVersion: %s", bodyText)
 			}
 
 			return newProxyResponse(http.StatusOK, `{"ok":true}`), nil
@@ -144,4 +148,98 @@ func TestTelemetryEmitRunError(t *testing.T) {
 	}
 
 	telemetry.EmitRunError(context.Background(), "glm-5", "", "parent-obs-1", "bad request")
+	telemetry.Flush()
+}
+
+func TestTelemetryLocalChannelSink(t *testing.T) {
+	sink := NewChannelSink(8)
+	if err := ConfigureTelemetry(TelemetryLocal, sink); err != nil {
+		t.Fatalf("ConfigureTelemetry() error = %v", err)
+	}
+	t.Cleanup(func() { _ = ConfigureTelemetry(TelemetryUpstream, nil) })
+
+	telemetry := NewTelemetry("user-1", "session-1", "conversation-1")
+	telemetry.client = &http.Client{
+		Transport: proxyRoundTripFunc(func(req *http.Request) (*http.Response, error) {
+			t.Errorf("local mode should not send upstream: %s", req.URL)
+			return newProxyResponse(http.StatusOK, `{}`), nil
+		}),
+	}
+
+	parent := telemetry.EmitRunStarted(context.Background(), "glm-5", "trace-1")
+	telemetry.EmitRunFinished(context.Background(), "glm-5", "trace-1", parent, 15*time.Millisecond)
+	telemetry.EmitRunError(context.Background(), "glm-5", "trace-1", parent, "boom")
+	telemetry.Flush()
+
+	wantTypes := []string{TelemetryRunStarted, TelemetryRunFinished, TelemetryRunError}
+	for _, want := range wantTypes {
+		event := <-sink.Events()
+		if event.Type != want {
+			t.Fatalf("event type = %s, want %s", event.Type, want)
+		}
+		if event.SessionID != "session-1" || event.Model != "glm-5" || event.TraceID != "trace-1" {
+			t.Fatalf("unexpected event: %+v", event)
+		}
+		if want != TelemetryRunStarted && event.ParentObservationID != parent {
+			t.Fatalf("parent_observation_id = %s, want %s", event.ParentObservationID, parent)
+		}
+	}
+}
+
+func TestTelemetryOffSendsNothing(t *testing.T) {
+	if err := ConfigureTelemetry(TelemetryOff, nil); err != nil {
+		t.Fatalf("ConfigureTelemetry() error = %v", err)
+	}
+	t.Cleanup(func() { _ = ConfigureTelemetry(TelemetryUpstream, nil) })
+
+	telemetry := NewTelemetry("user-1", "session-1", "conversation-1")
+	telemetry.client = &http.Client{
+		Transport: proxyRoundTripFunc(func(req *http.Request) (*http.Response, error) {
+			t.Errorf("off mode should not send: %s", req.URL)
+			return newProxyResponse(http.StatusOK, `{}`), nil
+		}),
+	}
+
+	if id := telemetry.EmitRunStarted(context.Background(), "glm-5", ""); id != "" {
+		t.Fatalf("EmitRunStarted() = %q, want empty", id)
+	}
+	telemetry.Flush()
+}
+
+func TestJSONLSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "telemetry.jsonl")
+	sink, err := NewJSONLSink(path)
+	if err != nil {
+		t.Fatalf("NewJSONLSink() error = %v", err)
+	}
+	for _, typ := range []string{TelemetryRunStarted, TelemetryRunFinished} {
+		if err := sink.Record(TelemetryEvent{Type: typ, Model: "glm-5"}); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"type":"run_finished"`) {
+		t.Fatalf("unexpected jsonl content: %s", content)
+	}
+}
+
+func TestParseTelemetryMode(t *testing.T) {
+	cases := map[string]TelemetryMode{"": TelemetryUpstream, "OFF": TelemetryOff, "local": TelemetryLocal, "upstream": TelemetryUpstream}
+	for input, want := range cases {
+		got, err := ParseTelemetryMode(input)
+		if err != nil || got != want {
+			t.Fatalf("ParseTelemetryMode(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseTelemetryMode("verbose"); err == nil {
+		t.Fatal("ParseTelemetryMode(verbose) error = nil, want non-nil")
+	}
 }
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rogeecn/iflow-go/internal/transport"
+)
+
+// Timeouts bounds each phase of an upstream call. A zero value disables that
+// bound.
+type Timeouts struct {
+	// Connect covers TCP dial and TLS handshake.
+	Connect time.Duration
+	// FirstToken bounds a stream from request start to its first SSE line,
+	// including the wait for response headers. Non-stream calls are bounded
+	// by Request alone.
+	FirstToken time.Duration
+	// Idle is the longest silence allowed between two stream lines.
+	Idle time.Duration
+	// Request bounds a whole non-stream call.
+	Request time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	Connect:    15 * time.Second,
+	FirstToken: 180 * time.Second,
+	Idle:       120 * time.Second,
+	Request:    300 * time.Second,
+}
+
+var (
+	timeoutsMu     sync.RWMutex
+	activeTimeouts = DefaultTimeouts
+)
+
+// ConfigureTimeouts sets the timeouts used by proxies created afterwards.
+func ConfigureTimeouts(timeouts Timeouts) {
+	timeoutsMu.Lock()
+	defer timeoutsMu.Unlock()
+	activeTimeouts = timeouts
+}
+
+func currentTimeouts() Timeouts {
+	timeoutsMu.RLock()
+	defer timeoutsMu.RUnlock()
+	return activeTimeouts
+}
+
+// newUpstreamClient has no overall Timeout and no response header bound:
+// either would cut off long calls. The transport only bounds connection
+// setup; non-stream calls are bounded by their request context and streams by
+// the watchdog.
+func newUpstreamClient(proxyURL string, timeouts Timeouts) *http.Client {
+	return &http.Client{Transport: transport.BoundedTransport(proxyURL, transport.Timeouts{
+		Dial:         timeouts.Connect,
+		TLSHandshake: timeouts.Connect,
+	})}
+}
+
+// streamWatchdog cancels the upstream stream request when it stays silent
+// for too long, which unblocks a pending send or read in forwardSSE.
+type streamWatchdog struct {
+	mu       sync.Mutex
+	timer    *time.Timer
+	idle     time.Duration
+	fired    bool
+	phase    string
+	duration time.Duration
+}
+
+func newStreamWatchdog(timeouts Timeouts, onTimeout func()) *streamWatchdog {
+	w := &streamWatchdog{idle: timeouts.Idle, phase: "first token", duration: timeouts.FirstToken}
+	if timeouts.FirstToken <= 0 && timeouts.Idle <= 0 {
+		return w
+	}
+	w.timer = time.AfterFunc(w.firstDelay(), func() {
+		w.mu.Lock()
+		w.fired = true
+		w.mu.Unlock()
+		onTimeout()
+	})
+	return w
+}
+
+func (w *streamWatchdog) firstDelay() time.Duration {
+	if w.duration > 0 {
+		return w.duration
+	}
+	// Only the idle bound is set; apply it from the start.
+	w.phase, w.duration = "idle", w.idle
+	return w.idle
+}
+
+// touch records upstream activity and re-arms the idle bound.
+func (w *streamWatchdog) touch() {
+	if w.timer == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.fired {
+		return
+	}
+	if w.idle <= 0 {
+		w.timer.Stop()
+		return
+	}
+	w.phase, w.duration = "idle", w.idle
+	w.timer.Reset(w.idle)
+}
+
+func (w *streamWatchdog) stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+// err reports the timeout that fired, if any.
+func (w *streamWatchdog) err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.fired {
+		return nil
+	}
+	return fmt.Errorf("upstream stream %s timeout after %s", w.phase, w.duration)
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+func streamWithBody(t *testing.T, timeouts Timeouts, body io.ReadCloser) string {
+	t.Helper()
+
+	p := NewProxy(&account.Account{APIKey: "sk-test"})
+	p.telemetry = nil
+	p.timeouts = timeouts
+	p.client = &http.Client{
+		Transport: proxyRoundTripFunc(func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: body}, nil
+		}),
+	}
+
+	stream, err := p.ChatCompletionsStream(context.Background(), &types.ChatCompletionRequest{
+		Model:    "glm-5",
+		Messages: []types.Message{{Role: "user", Content: "hi"}},
+		Stream:   true,
+	})
+	if err != nil {
+		t.Fatalf("ChatCompletionsStream error: %v", err)
+	}
+
+	var got strings.Builder
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case chunk, ok := <-stream:
+			if !ok {
+				return got.String()
+			}
+			got.Write(chunk)
+		case <-deadline:
+			t.Fatalf("stream did not finish, got: %s", got.String())
+		}
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	reader, writer := io.Pipe()
+	go func() {
+		_, _ = writer.Write([]byte("data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"}}]}\n\n"))
+	}()
+
+	out := streamWithBody(t, Timeouts{FirstToken: time.Second, Idle: 50 * time.Millisecond}, reader)
+	if !strings.Contains(out, `"content":"hi"`) {
+		t.Fatalf("first chunk missing: %s", out)
+	}
+	if !strings.Contains(out, `"code":"stream_timeout"`) || !strings.Contains(out, "idle timeout") {
+		t.Fatalf("idle timeout error event missing: %s", out)
+	}
+}
+
+func TestStreamFirstTokenTimeout(t *testing.T) {
+	reader, _ := io.Pipe()
+
+	out := streamWithBody(t, Timeouts{FirstToken: 50 * time.Millisecond, Idle: time.Second}, reader)
+	if !strings.Contains(out, "first token timeout") || !strings.Contains(out, `"type":"timeout_error"`) {
+		t.Fatalf("first token timeout error event missing: %s", out)
+	}
+}
+
+func TestStreamWatchdogDisabled(t *testing.T) {
+	body := io.NopCloser(strings.NewReader("data: {\"id\":\"c1\",\"choices\":[]}\n\ndata: [DONE]\n\n"))
+
+	out := streamWithBody(t, Timeouts{}, body)
+	if strings.Contains(out, "stream_timeout") || !strings.Contains(out, "[DONE]") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+func TestNewUpstreamClientSharesTransport(t *testing.T) {
+	timeouts := Timeouts{Connect: time.Second, FirstToken: 2 * time.Second}
+	first := newUpstreamClient("", timeouts)
+	second := newUpstreamClient("", timeouts)
+	if first.Transport != second.Transport {
+		t.Fatal("upstream clients with the same settings should share one transport")
+	}
+
+	base, ok := first.Transport.(*http.Transport)
+	if !ok || base.TLSHandshakeTimeout != time.Second || base.ResponseHeaderTimeout != 0 {
+		t.Fatalf("unexpected transport bounds: %+v", first.Transport)
+	}
+	if newUpstreamClient("", Timeouts{Connect: 3 * time.Second}).Transport == first.Transport {
+		t.Fatal("different timeouts should not share a transport")
+	}
+}
+
+func TestStreamFirstTokenCoversResponseHeaders(t *testing.T) {
+	p := NewProxy(&account.Account{APIKey: "sk-test"})
+	p.telemetry = nil
+	p.timeouts = Timeouts{FirstToken: 50 * time.Millisecond, Idle: time.Second}
+	p.client = &http.Client{
+		Transport: proxyRoundTripFunc(func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}),
+	}
+
+	_, err := p.ChatCompletionsStream(context.Background(), &types.ChatCompletionRequest{
+		Model:    "glm-5",
+		Messages: []types.Message{{Role: "user", Content: "hi"}},
+		Stream:   true,
+	})
+	if err == nil || !strings.Contains(err.Error(), "first token timeout") {
+		t.Fatalf("expected first token timeout, got %v", err)
+	}
+}
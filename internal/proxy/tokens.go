@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktokenloader "github.com/pkoukk/tiktoken-go-loader"
+	"github.com/rogeecn/iflow-go/pkg/types"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultEncoding = "cl100k_base"
+
+	// Chat framing overhead, following OpenAI's published counting recipe.
+	tokensPerMessage = 3
+	tokensPerName    = 1
+	tokensPerReply   = 3
+	tokensPerImage   = 85
+)
+
+var (
+	encodersMu sync.Mutex
+	encoders   = map[string]*tiktoken.Tiktoken{}
+)
+
+func init() {
+	// BPE ranks are embedded in the binary so estimation never hits the network.
+	tiktoken.SetBpeLoader(tiktokenloader.NewOfflineLoader())
+}
+
+// EncodingForModel returns the tokenizer encoding configured for model.
+func EncodingForModel(model string) string {
+	model = currentProfiles().ResolveAlias(model)
+	for _, m := range Models {
+		if strings.EqualFold(m.ID, model) && m.Encoding != "" {
+			return m.Encoding
+		}
+	}
+	return defaultEncoding
+}
+
+// CountTokens counts text tokens with the encoding configured for model. When
+// the encoding cannot be loaded it falls back to a rough 4 bytes per token.
+func CountTokens(model, text string) int {
+	if text == "" {
+		return 0
+	}
+	encoder := encoderFor(EncodingForModel(model))
+	if encoder == nil {
+		return (len(text) + 3) / 4
+	}
+	return len(encoder.EncodeOrdinary(text))
+}
+
+// EstimatePromptTokens estimates the prompt size of a chat request from its
+// messages and tool definitions.
+func EstimatePromptTokens(req *types.ChatCompletionRequest) int {
+	if req == nil {
+		return 0
+	}
+
+	total := tokensPerReply
+	for _, msg := range req.Messages {
+		total += tokensPerMessage
+		total += CountTokens(req.Model, msg.Role)
+		total += contentTokens(req.Model, msg.Content)
+		total += CountTokens(req.Model, msg.ReasoningContent)
+		if msg.Name != "" {
+			total += tokensPerName + CountTokens(req.Model, msg.Name)
+		}
+		for _, call := range msg.ToolCalls {
+			total += CountTokens(req.Model, call.Function.Name)
+			total += CountTokens(req.Model, call.Function.Arguments)
+		}
+		if msg.FunctionCall != nil {
+			total += CountTokens(req.Model, msg.FunctionCall.Name)
+			total += CountTokens(req.Model, msg.FunctionCall.Arguments)
+		}
+	}
+	if len(req.Tools) > 0 {
+		if raw, err := json.Marshal(req.Tools); err == nil {
+			total += CountTokens(req.Model, string(raw))
+		}
+	}
+	if len(req.Functions) > 0 {
+		if raw, err := json.Marshal(req.Functions); err == nil {
+			total += CountTokens(req.Model, string(raw))
+		}
+	}
+	return total
+}
+
+// EstimateUsage builds a usage block for a reply whose upstream response
+// carried none. The result is flagged as estimated.
+func EstimateUsage(req *types.ChatCompletionRequest, content, reasoning string) types.Usage {
+	model := ""
+	if req != nil {
+		model = req.Model
+	}
+
+	prompt := EstimatePromptTokens(req)
+	reasoningTokens := CountTokens(model, reasoning)
+	completion := CountTokens(model, content) + reasoningTokens
+
+	usage := types.Usage{
+		PromptTokens:     prompt,
+		CompletionTokens: completion,
+		TotalTokens:      prompt + completion,
+		Estimated:        true,
+	}
+	if reasoningTokens > 0 {
+		usage.CompletionTokensDetails = &types.CompletionTokensDetails{ReasoningTokens: reasoningTokens}
+	}
+	return usage
+}
+
+func contentTokens(model string, content interface{}) int {
+	switch value := content.(type) {
+	case string:
+		return CountTokens(model, value)
+	case []interface{}:
+		total := 0
+		for _, part := range value {
+			partMap, ok := part.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch partMap["type"] {
+			case "text", "input_text", "output_text":
+				text, _ := partMap["text"].(string)
+				total += CountTokens(model, text)
+			case "image_url", "input_image", "image":
+				total += tokensPerImage
+			}
+		}
+		return total
+	case nil:
+		return 0
+	default:
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return 0
+		}
+		return CountTokens(model, string(raw))
+	}
+}
+
+func encoderFor(name string) *tiktoken.Tiktoken {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+
+	if encoder, ok := encoders[name]; ok {
+		return encoder
+	}
+	encoder, err := tiktoken.GetEncoding(name)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("encoding", name).
+			Msg("tokenizer encoding unavailable, using byte estimate")
+	}
+	// Failed lookups are cached as nil so the warning is logged once.
+	encoders[name] = encoder
+	return encoder
+}
+
+// usageEstimator accumulates streamed reply text so usage can be estimated
+// when the upstream stream ends without reporting it.
+type usageEstimator struct {
+	req       *types.ChatCompletionRequest
+	content   strings.Builder
+	reasoning strings.Builder
+}
+
+func newUsageEstimator(req *types.ChatCompletionRequest) *usageEstimator {
+	return &usageEstimator{req: req}
+}
+
+// observe records the text of a raw (not yet normalized) stream chunk.
+func (e *usageEstimator) observe(chunk map[string]interface{}) {
+	choices, _ := chunk["choices"].([]interface{})
+	for _, choice := range choices {
+		choiceMap, ok := choice.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		delta, ok := choiceMap["delta"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		e.addMessage(delta)
+	}
+}
+
+func (e *usageEstimator) addMessage(message map[string]interface{}) {
+	if content, ok := message["content"].(string); ok {
+		e.content.WriteString(content)
+	}
+	if reasoning, ok := message["reasoning_content"].(string); ok {
+		e.reasoning.WriteString(reasoning)
+	}
+	toolCalls, _ := message["tool_calls"].([]interface{})
+	for _, call := range toolCalls {
+		if callMap, ok := call.(map[string]interface{}); ok {
+			e.addFunction(callMap["function"])
+		}
+	}
+	e.addFunction(message["function_call"])
+}
+
+func (e *usageEstimator) addFunction(value interface{}) {
+	function, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if name, ok := function["name"].(string); ok {
+		e.content.WriteString(name)
+	}
+	if args, ok := function["arguments"].(string); ok {
+		e.content.WriteString(args)
+	}
+}
+
+func (e *usageEstimator) usage() types.Usage {
+	return EstimateUsage(e.req, e.content.String(), e.reasoning.String())
+}
+
+func (e *usageEstimator) usageMap() map[string]interface{} {
+	usage := e.usage()
+	raw, err := json.Marshal(usage)
+	if err != nil {
+		return nil
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil
+	}
+	return result
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+func TestEncodingForModel(t *testing.T) {
+	if got := EncodingForModel("KIMI-K2"); got != "o200k_base" {
+		t.Fatalf("kimi encoding = %q, want o200k_base", got)
+	}
+	if got := EncodingForModel("unknown-model"); got != defaultEncoding {
+		t.Fatalf("unknown encoding = %q, want %q", got, defaultEncoding)
+	}
+}
+
+func TestCountTokens(t *testing.T) {
+	if got := CountTokens("glm-5", "hello world"); got != 2 {
+		t.Fatalf("CountTokens = %d, want 2", got)
+	}
+	if got := CountTokens("glm-5", ""); got != 0 {
+		t.Fatalf("CountTokens empty = %d, want 0", got)
+	}
+}
+
+func TestEstimatePromptTokens(t *testing.T) {
+	base := &types.ChatCompletionRequest{
+		Model:    "glm-5",
+		Messages: []types.Message{{Role: "user", Content: "hello world"}},
+	}
+	plain := EstimatePromptTokens(base)
+	if plain <= 2 {
+		t.Fatalf("prompt tokens = %d, want framing overhead included", plain)
+	}
+
+	withTools := *base
+	withTools.Tools = []types.Tool{{
+		Type:     "function",
+		Function: types.FunctionDefinition{Name: "get_weather", Parameters: json.RawMessage(`{"type":"object"}`)},
+	}}
+	if got := EstimatePromptTokens(&withTools); got <= plain {
+		t.Fatalf("prompt tokens with tools = %d, want > %d", got, plain)
+	}
+
+	withImage := *base
+	withImage.Messages = []types.Message{{Role: "user", Content: []interface{}{
+		map[string]interface{}{"type": "text", "text": "hello world"},
+		map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "data:..."}},
+	}}}
+	if got := EstimatePromptTokens(&withImage); got != plain+tokensPerImage {
+		t.Fatalf("prompt tokens with image = %d, want %d", got, plain+tokensPerImage)
+	}
+}
+
+func TestChatCompletionsStreamEstimatesMissingUsage(t *testing.T) {
+	p := NewProxy(&account.Account{APIKey: "sk-test"})
+	p.telemetry = nil
+	p.client = &http.Client{
+		Transport: proxyRoundTripFunc(func(req *http.Request) (*http.Response, error) {
+			body := "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"reasoning_content\":\"let me think\"},\"finish_reason\":null}]}\n\n" +
+				"data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hello world\"},\"finish_reason\":\"stop\"}]}\n\n" +
+				"data: [DONE]\n\n"
+			return newProxyResponse(http.StatusOK, body), nil
+		}),
+	}
+
+	stream, err := p.ChatCompletionsStream(context.Background(), &types.ChatCompletionRequest{
+		Model:    "glm-5",
+		Messages: []types.Message{{Role: "user", Content: "hi"}},
+		Stream:   true,
+	})
+	if err != nil {
+		t.Fatalf("ChatCompletionsStream error: %v", err)
+	}
+
+	var usage *types.Usage
+	for chunk := range stream {
+		line := strings.TrimSpace(string(chunk))
+		if !strings.HasPrefix(line, "data: {") {
+			continue
+		}
+		var parsed types.ChatCompletionChunk
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &parsed); err != nil {
+			t.Fatalf("decode chunk: %v", err)
+		}
+		if parsed.Usage != nil {
+			usage = parsed.Usage
+		}
+	}
+
+	if usage == nil || !usage.Estimated {
+		t.Fatalf("usage = %+v, want estimated usage on finish chunk", usage)
+	}
+	if usage.CompletionTokensDetails == nil || usage.CompletionTokensDetails.ReasoningTokens != CountTokens("glm-5", "let me think") {
+		t.Fatalf("reasoning tokens = %+v", usage.CompletionTokensDetails)
+	}
+	if usage.CompletionTokens != CountTokens("glm-5", "hello world")+CountTokens("glm-5", "let me think") {
+		t.Fatalf("completion tokens = %d", usage.CompletionTokens)
+	}
+}
+
+func TestChatCompletionsStreamKeepsUpstreamUsage(t *testing.T) {
+	p := NewProxy(&account.Account{APIKey: "sk-test"})
+	p.telemetry = nil
+	p.client = &http.Client{
+		Transport: proxyRoundTripFunc(func(req *http.Request) (*http.Response, error) {
+			body := "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"},\"finish_reason\":\"stop\"}],\"usage\":{\"prompt_tokens\":9,\"completion_tokens\":1,\"total_tokens\":10}}\n\n" +
+				"data: [DONE]\n\n"
+			return newProxyResponse(http.StatusOK, body), nil
+		}),
+	}
+
+	stream, err := p.ChatCompletionsStream(context.Background(), &types.ChatCompletionRequest{
+		Model:    "glm-5",
+		Messages: []types.Message{{Role: "user", Content: "hi"}},
+		Stream:   true,
+	})
+	if err != nil {
+		t.Fatalf("ChatCompletionsStream error: %v", err)
+	}
+	var got strings.Builder
+	for chunk := range stream {
+		got.Write(chunk)
+	}
+	if strings.Contains(got.String(), `"estimated"`) {
+		t.Fatalf("upstream usage should not be replaced: %s", got.String())
+	}
+	if !strings.Contains(got.String(), `"prompt_tokens":9`) {
+		t.Fatalf("stream missing upstream usage: %s", got.String())
+	}
+}
@@ -0,0 +1,358 @@
+package proxy
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// Some models (glm-*, kimi-k2*) occasionally emit tool calls as markup inside
+// content or reasoning_content instead of the tool_calls field. The helpers
+// below recover them so OpenAI-style clients see regular tool calls.
+
+const (
+	glmToolCallStart     = "<tool_call>"
+	glmToolCallEnd       = "</tool_call>"
+	kimiSectionStart     = "<|tool_calls_section_begin|>"
+	kimiSectionEnd       = "<|tool_calls_section_end|>"
+	kimiToolCallStart    = "<|tool_call_begin|>"
+	kimiToolCallEnd      = "<|tool_call_end|>"
+	kimiToolCallArgument = "<|tool_call_argument_begin|>"
+)
+
+var (
+	toolCallStartMarkers = []string{glmToolCallStart, kimiSectionStart, kimiToolCallStart}
+
+	glmToolCallPattern  = regexp.MustCompile(`(?s)<tool_call>(.*?)</tool_call>`)
+	glmArgumentPattern  = regexp.MustCompile(`(?s)<arg_key>(.*?)</arg_key>\s*<arg_value>(.*?)</arg_value>`)
+	kimiToolCallPattern = regexp.MustCompile(`(?s)<\|tool_call_begin\|>\s*(.*?)\s*<\|tool_call_argument_begin\|>(.*?)<\|tool_call_end\|>`)
+	kimiFunctionIDIndex = regexp.MustCompile(`:\d+$`)
+)
+
+type embeddedToolCall struct {
+	Name      string
+	Arguments string
+}
+
+// extractEmbeddedToolCalls splits text into plain text and the tool calls
+// found in it. Markup that does not parse is left in the text.
+func extractEmbeddedToolCalls(text string) (string, []embeddedToolCall) {
+	if !containsToolCallMarker(text) {
+		return text, nil
+	}
+
+	var calls []embeddedToolCall
+	text = glmToolCallPattern.ReplaceAllStringFunc(text, func(match string) string {
+		body := glmToolCallPattern.FindStringSubmatch(match)[1]
+		call, ok := parseGLMToolCall(body)
+		if !ok {
+			return match
+		}
+		calls = append(calls, call)
+		return ""
+	})
+	text = kimiToolCallPattern.ReplaceAllStringFunc(text, func(match string) string {
+		parts := kimiToolCallPattern.FindStringSubmatch(match)
+		name := strings.TrimPrefix(strings.TrimSpace(parts[1]), "functions.")
+		name = kimiFunctionIDIndex.ReplaceAllString(name, "")
+		if name == "" {
+			return match
+		}
+		calls = append(calls, embeddedToolCall{Name: name, Arguments: normalizeArguments(parts[2])})
+		return ""
+	})
+	if len(calls) == 0 {
+		return text, nil
+	}
+
+	text = strings.ReplaceAll(text, kimiSectionStart, "")
+	text = strings.ReplaceAll(text, kimiSectionEnd, "")
+	return strings.TrimSpace(text), calls
+}
+
+// parseGLMToolCall accepts both the JSON body used by hermes-style templates
+// and glm's "name\n<arg_key>k</arg_key><arg_value>v</arg_value>" form.
+func parseGLMToolCall(body string) (embeddedToolCall, bool) {
+	body = strings.TrimSpace(body)
+	if strings.HasPrefix(body, "{") {
+		var payload struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal([]byte(body), &payload); err != nil || payload.Name == "" {
+			return embeddedToolCall{}, false
+		}
+		arguments := string(payload.Arguments)
+		var encoded string
+		if json.Unmarshal(payload.Arguments, &encoded) == nil {
+			arguments = encoded
+		}
+		return embeddedToolCall{Name: payload.Name, Arguments: normalizeArguments(arguments)}, true
+	}
+
+	name, rest, _ := strings.Cut(body, "<arg_key>")
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsAny(name, "<>\n") {
+		return embeddedToolCall{}, false
+	}
+	if rest != "" {
+		rest = "<arg_key>" + rest
+	}
+
+	args := map[string]interface{}{}
+	for _, match := range glmArgumentPattern.FindAllStringSubmatch(rest, -1) {
+		key := strings.TrimSpace(match[1])
+		raw := strings.TrimSpace(match[2])
+		var value interface{}
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			value = raw
+		}
+		args[key] = value
+	}
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		return embeddedToolCall{}, false
+	}
+	return embeddedToolCall{Name: name, Arguments: string(encoded)}, true
+}
+
+func normalizeArguments(arguments string) string {
+	arguments = strings.TrimSpace(arguments)
+	if arguments == "" {
+		return "{}"
+	}
+	return arguments
+}
+
+func containsToolCallMarker(text string) bool {
+	for _, marker := range toolCallStartMarkers {
+		if strings.Contains(text, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func toolCallMaps(calls []embeddedToolCall, firstIndex int, withIndex bool) []interface{} {
+	result := make([]interface{}, 0, len(calls))
+	for i, call := range calls {
+		entry := map[string]interface{}{
+			"id":   "call_" + randomHex(12),
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":      call.Name,
+				"arguments": call.Arguments,
+			},
+		}
+		if withIndex {
+			entry["index"] = firstIndex + i
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+// applyEmbeddedToolCalls rewrites a non-stream response so tool calls found
+// in message text become message.tool_calls.
+func applyEmbeddedToolCalls(response map[string]interface{}) {
+	choices, _ := response["choices"].([]interface{})
+	for _, choice := range choices {
+		choiceMap, ok := choice.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		message, ok := choiceMap["message"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if existing, _ := message["tool_calls"].([]interface{}); len(existing) > 0 {
+			continue
+		}
+
+		var calls []embeddedToolCall
+		for _, field := range []string{"content", "reasoning_content"} {
+			text, ok := message[field].(string)
+			if !ok {
+				continue
+			}
+			plain, found := extractEmbeddedToolCalls(text)
+			if len(found) == 0 {
+				continue
+			}
+			calls = append(calls, found...)
+			if plain == "" && field == "content" {
+				message[field] = nil
+			} else {
+				message[field] = plain
+			}
+		}
+		if len(calls) == 0 {
+			continue
+		}
+
+		message["tool_calls"] = toolCallMaps(calls, 0, false)
+		if reason, _ := choiceMap["finish_reason"].(string); reason == "" || reason == "stop" {
+			choiceMap["finish_reason"] = "tool_calls"
+		}
+	}
+}
+
+// streamToolCallExtractor holds back streamed text from the first tool call
+// marker until the call is complete, then emits it as a tool_calls delta.
+type streamToolCallExtractor struct {
+	buffers   map[string]*toolCallBuffer
+	nextIndex int
+	found     bool
+}
+
+type toolCallBuffer struct {
+	pending strings.Builder
+	inCall  bool
+}
+
+func newStreamToolCallExtractor() *streamToolCallExtractor {
+	return &streamToolCallExtractor{buffers: map[string]*toolCallBuffer{}}
+}
+
+func (e *streamToolCallExtractor) process(chunk map[string]interface{}) {
+	choices, _ := chunk["choices"].([]interface{})
+	for _, choice := range choices {
+		choiceMap, ok := choice.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		delta, ok := choiceMap["delta"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if native, _ := delta["tool_calls"].([]interface{}); len(native) > 0 {
+			e.nextIndex += len(native)
+		}
+
+		finished := false
+		if reason, ok := choiceMap["finish_reason"].(string); ok && reason != "" {
+			finished = true
+		}
+
+		var calls []embeddedToolCall
+		for _, field := range []string{"content", "reasoning_content"} {
+			text, _ := delta[field].(string)
+			buffer := e.buffer(field)
+			emit, found := buffer.feed(text)
+			if finished {
+				emit += buffer.flush()
+			}
+			calls = append(calls, found...)
+			if emit == "" {
+				delete(delta, field)
+			} else {
+				delta[field] = emit
+			}
+		}
+
+		if len(calls) > 0 {
+			existing, _ := delta["tool_calls"].([]interface{})
+			delta["tool_calls"] = append(existing, toolCallMaps(calls, e.nextIndex, true)...)
+			e.nextIndex += len(calls)
+			e.found = true
+		}
+		if finished && e.found {
+			if reason, _ := choiceMap["finish_reason"].(string); reason == "stop" {
+				choiceMap["finish_reason"] = "tool_calls"
+			}
+		}
+	}
+}
+
+func (e *streamToolCallExtractor) buffer(field string) *toolCallBuffer {
+	buffer, ok := e.buffers[field]
+	if !ok {
+		buffer = &toolCallBuffer{}
+		e.buffers[field] = buffer
+	}
+	return buffer
+}
+
+// feed returns text safe to emit now and any tool calls completed by text.
+func (b *toolCallBuffer) feed(text string) (string, []embeddedToolCall) {
+	b.pending.WriteString(text)
+	buf := b.pending.String()
+
+	var emit strings.Builder
+	var calls []embeddedToolCall
+	for buf != "" {
+		if !b.inCall {
+			start := firstMarkerIndex(buf)
+			if start < 0 {
+				keep := partialMarkerSuffix(buf)
+				emit.WriteString(buf[:len(buf)-keep])
+				buf = buf[len(buf)-keep:]
+				break
+			}
+			emit.WriteString(buf[:start])
+			buf = buf[start:]
+			b.inCall = true
+		}
+
+		end := callEndMarker(buf)
+		endIndex := strings.Index(buf, end)
+		if endIndex < 0 {
+			break
+		}
+		segment := buf[:endIndex+len(end)]
+		buf = buf[endIndex+len(end):]
+		b.inCall = false
+
+		plain, found := extractEmbeddedToolCalls(segment)
+		calls = append(calls, found...)
+		emit.WriteString(plain)
+	}
+
+	b.pending.Reset()
+	b.pending.WriteString(buf)
+	return emit.String(), calls
+}
+
+// flush releases held text, e.g. an unterminated call when the stream ends.
+func (b *toolCallBuffer) flush() string {
+	text := b.pending.String()
+	b.pending.Reset()
+	b.inCall = false
+	return text
+}
+
+func firstMarkerIndex(text string) int {
+	first := -1
+	for _, marker := range toolCallStartMarkers {
+		if idx := strings.Index(text, marker); idx >= 0 && (first < 0 || idx < first) {
+			first = idx
+		}
+	}
+	return first
+}
+
+func callEndMarker(text string) string {
+	switch {
+	case strings.HasPrefix(text, kimiSectionStart):
+		return kimiSectionEnd
+	case strings.HasPrefix(text, kimiToolCallStart):
+		return kimiToolCallEnd
+	default:
+		return glmToolCallEnd
+	}
+}
+
+// partialMarkerSuffix returns the length of the longest suffix of text that
+// could still grow into a start marker.
+func partialMarkerSuffix(text string) int {
+	longest := 0
+	for _, marker := range toolCallStartMarkers {
+		for n := len(marker) - 1; n > longest; n-- {
+			if n <= len(text) && strings.HasSuffix(text, marker[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+var weatherTool = []types.Tool{{
+	Type:     "function",
+	Function: types.FunctionDefinition{Name: "get_weather", Parameters: json.RawMessage(`{"type":"object"}`)},
+}}
+
+func TestExtractEmbeddedToolCalls(t *testing.T) {
+	cases := []struct {
+		name  string
+		text  string
+		plain string
+		calls []embeddedToolCall
+	}{
+		{
+			name:  "glm arg pairs",
+			text:  "Let me check.<tool_call>get_weather\n<arg_key>city</arg_key>\n<arg_value>Hangzhou</arg_value>\n<arg_key>days</arg_key>\n<arg_value>3</arg_value>\n</tool_call>",
+			plain: "Let me check.",
+			calls: []embeddedToolCall{{Name: "get_weather", Arguments: `{"city":"Hangzhou","days":3}`}},
+		},
+		{
+			name:  "json body",
+			text:  `<tool_call>{"name":"get_weather","arguments":{"city":"Hangzhou"}}</tool_call>`,
+			calls: []embeddedToolCall{{Name: "get_weather", Arguments: `{"city":"Hangzhou"}`}},
+		},
+		{
+			name:  "kimi section",
+			text:  `ok<|tool_calls_section_begin|><|tool_call_begin|>functions.get_weather:0<|tool_call_argument_begin|>{"city":"Hangzhou"}<|tool_call_end|><|tool_call_begin|>functions.get_time:1<|tool_call_argument_begin|><|tool_call_end|><|tool_calls_section_end|>`,
+			plain: "ok",
+			calls: []embeddedToolCall{{Name: "get_weather", Arguments: `{"city":"Hangzhou"}`}, {Name: "get_time", Arguments: "{}"}},
+		},
+		{
+			name:  "no markup",
+			text:  "plain answer",
+			plain: "plain answer",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			plain, calls := extractEmbeddedToolCalls(tc.text)
+			if plain != tc.plain {
+				t.Fatalf("plain = %q, want %q", plain, tc.plain)
+			}
+			if len(calls) != len(tc.calls) {
+				t.Fatalf("calls = %+v, want %+v", calls, tc.calls)
+			}
+			for i := range calls {
+				if calls[i] != tc.calls[i] {
+					t.Fatalf("call[%d] = %+v, want %+v", i, calls[i], tc.calls[i])
+				}
+			}
+		})
+	}
+}
+
+func TestChatCompletionsExtractsEmbeddedToolCalls(t *testing.T) {
+	p := NewProxy(&account.Account{APIKey: "sk-test"})
+	p.telemetry = nil
+	p.client = &http.Client{
+		Transport: proxyRoundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return newProxyResponse(http.StatusOK, `{
+			  "id":"chatcmpl-1",
+			  "object":"chat.completion",
+			  "model":"glm-5",
+			  "choices":[{"index":0,"message":{"role":"assistant","content":"<tool_call>get_weather\n<arg_key>city</arg_key>\n<arg_value>Hangzhou</arg_value>\n</tool_call>"},"finish_reason":"stop"}],
+			  "usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}
+			}`), nil
+		}),
+	}
+
+	resp, err := p.ChatCompletions(context.Background(), &types.ChatCompletionRequest{
+		Model:    "glm-5",
+		Messages: []types.Message{{Role: "user", Content: "weather?"}},
+		Tools:    weatherTool,
+	})
+	if err != nil {
+		t.Fatalf("ChatCompletions error: %v", err)
+	}
+	choice := resp.Choices[0]
+	if choice.FinishReason == nil || *choice.FinishReason != "tool_calls" {
+		t.Fatalf("finish_reason = %v, want tool_calls", choice.FinishReason)
+	}
+	if len(choice.Message.ToolCalls) != 1 {
+		t.Fatalf("tool_calls = %+v, want 1", choice.Message.ToolCalls)
+	}
+	call := choice.Message.ToolCalls[0]
+	if call.Function.Name != "get_weather" || call.Function.Arguments != `{"city":"Hangzhou"}` || call.ID == "" {
+		t.Fatalf("unexpected tool call: %+v", call)
+	}
+	if choice.Message.Content != nil {
+		t.Fatalf("content = %#v, want nil", choice.Message.Content)
+	}
+}
+
+func TestChatCompletionsKeepsNativeToolCalls(t *testing.T) {
+	p := NewProxy(&account.Account{APIKey: "sk-test"})
+	p.telemetry = nil
+	p.client = &http.Client{
+		Transport: proxyRoundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return newProxyResponse(http.StatusOK, `{
+			  "id":"chatcmpl-1",
+			  "model":"kimi-k2",
+			  "choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{}"}}]},"finish_reason":"tool_calls"}],
+			  "usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}
+			}`), nil
+		}),
+	}
+
+	resp, err := p.ChatCompletions(context.Background(), &types.ChatCompletionRequest{
+		Model:    "kimi-k2",
+		Messages: []types.Message{{Role: "user", Content: "weather?"}},
+		Tools:    weatherTool,
+	})
+	if err != nil {
+		t.Fatalf("ChatCompletions error: %v", err)
+	}
+	calls := resp.Choices[0].Message.ToolCalls
+	if len(calls) != 1 || calls[0].ID != "call_1" {
+		t.Fatalf("unexpected tool calls: %+v", calls)
+	}
+}
+
+func TestChatCompletionsStreamAssemblesEmbeddedToolCalls(t *testing.T) {
+	p := NewProxy(&account.Account{APIKey: "sk-test"})
+	p.telemetry = nil
+	p.client = &http.Client{
+		Transport: proxyRoundTripFunc(func(req *http.Request) (*http.Response, error) {
+			pieces := []string{
+				"checking <|tool_calls_sec",
+				"tion_begin|><|tool_call_begin|>functions.get_weather:0<|tool_call_argument_begin|>{\\\"city\\\":",
+				"\\\"Hangzhou\\\"}<|tool_call_end|><|tool_calls_section_end|>",
+			}
+			var body strings.Builder
+			for _, piece := range pieces {
+				body.WriteString(`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"` + piece + `"},"finish_reason":null}]}` + "\n\n")
+			}
+			body.WriteString(`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}` + "\n\n")
+			body.WriteString("data: [DONE]\n\n")
+			return newProxyResponse(http.StatusOK, body.String()), nil
+		}),
+	}
+
+	stream, err := p.ChatCompletionsStream(context.Background(), &types.ChatCompletionRequest{
+		Model:    "kimi-k2",
+		Messages: []types.Message{{Role: "user", Content: "weather?"}},
+		Tools:    weatherTool,
+		Stream:   true,
+	})
+	if err != nil {
+		t.Fatalf("ChatCompletionsStream error: %v", err)
+	}
+
+	var content strings.Builder
+	var calls []types.ToolCall
+	finish := ""
+	for chunk := range stream {
+		line := strings.TrimSpace(string(chunk))
+		if !strings.HasPrefix(line, "data: {") {
+			continue
+		}
+		var parsed types.ChatCompletionChunk
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &parsed); err != nil {
+			t.Fatalf("decode chunk %q: %v", line, err)
+		}
+		for _, choice := range parsed.Choices {
+			if choice.Delta != nil {
+				content.WriteString(choice.Delta.Content)
+				calls = append(calls, choice.Delta.ToolCalls...)
+			}
+			if choice.FinishReason != nil {
+				finish = *choice.FinishReason
+			}
+		}
+	}
+
+	if content.String() != "checking " {
+		t.Fatalf("content = %q, want markup stripped", content.String())
+	}
+	if len(calls) != 1 || calls[0].Function.Name != "get_weather" || calls[0].Function.Arguments != `{"city":"Hangzhou"}` {
+		t.Fatalf("unexpected tool calls: %+v", calls)
+	}
+	if calls[0].Index == nil || *calls[0].Index != 0 {
+		t.Fatalf("tool call index = %v, want 0", calls[0].Index)
+	}
+	if finish != "tool_calls" {
+		t.Fatalf("finish_reason = %q, want tool_calls", finish)
+	}
+}
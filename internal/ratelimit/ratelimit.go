@@ -0,0 +1,443 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const budgetsFileName = "budgets.json"
+
+// Limits caps one client key or account. Zero fields are unlimited.
+type Limits struct {
+	RPM           int   `json:"rpm,omitempty"`
+	TPM           int   `json:"tpm,omitempty"`
+	DailyTokens   int64 `json:"daily_tokens,omitempty"`
+	MonthlyTokens int64 `json:"monthly_tokens,omitempty"`
+}
+
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+func (l Limits) hasBudget() bool {
+	return l.DailyTokens > 0 || l.MonthlyTokens > 0
+}
+
+func (l Limits) Validate() error {
+	if l.RPM < 0 || l.TPM < 0 || l.DailyTokens < 0 || l.MonthlyTokens < 0 {
+		return fmt.Errorf("rate limits must not be negative")
+	}
+	return nil
+}
+
+func (l Limits) String() string {
+	var parts []string
+	if l.RPM > 0 {
+		parts = append(parts, fmt.Sprintf("rpm=%d", l.RPM))
+	}
+	if l.TPM > 0 {
+		parts = append(parts, fmt.Sprintf("tpm=%d", l.TPM))
+	}
+	if l.DailyTokens > 0 {
+		parts = append(parts, fmt.Sprintf("daily=%d", l.DailyTokens))
+	}
+	if l.MonthlyTokens > 0 {
+		parts = append(parts, fmt.Sprintf("monthly=%d", l.MonthlyTokens))
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, ",")
+}
+
+// Subject is one party a request is counted against, e.g. "key:<id>" or
+// "account:<uuid>".
+type Subject struct {
+	Name   string
+	Limits Limits
+}
+
+// Status is what the x-ratelimit-* headers report. A zero limit means the
+// dimension is not limited and its headers are omitted.
+type Status struct {
+	LimitRequests     int
+	RemainingRequests int
+	ResetRequests     time.Duration
+	LimitTokens       int64
+	RemainingTokens   int64
+	ResetTokens       time.Duration
+}
+
+type Decision struct {
+	Allowed    bool
+	Reason     string
+	RetryAfter time.Duration
+	Status     Status
+}
+
+// Limiter enforces per-minute token buckets in memory and token budgets that
+// survive restarts in <dataDir>/budgets.json. Budgets are always counted in
+// memory; with StartFlusher running they reach the file on a ticker and on
+// Close, otherwise every Charge writes them through.
+//
+// The tokens a request uses are only known once it finishes, so the token
+// bucket admits requests while it is positive and Charge may drive it below
+// zero; the overdraft delays the next request instead of failing this one.
+type Limiter struct {
+	path string
+	now  func() time.Time
+
+	mu       sync.Mutex
+	subjects map[string]*subjectState
+	loaded   bool
+	dirty    bool
+	batched  bool
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+
+	// fileMu serialises writes of budgets.json without holding mu.
+	fileMu sync.Mutex
+}
+
+type subjectState struct {
+	requests bucket
+	tokens   bucket
+	budget   budgetUsage
+}
+
+type budgetUsage struct {
+	Day         string `json:"day"`
+	DayTokens   int64  `json:"day_tokens"`
+	Month       string `json:"month"`
+	MonthTokens int64  `json:"month_tokens"`
+}
+
+// bucket refills capacity per minute. A zero updated time means full.
+type bucket struct {
+	level   float64
+	updated time.Time
+}
+
+func (b *bucket) refill(capacity int, now time.Time) {
+	if b.updated.IsZero() {
+		b.level = float64(capacity)
+	} else if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.level += elapsed.Minutes() * float64(capacity)
+	}
+	b.level = math.Min(b.level, float64(capacity))
+	b.updated = now
+}
+
+// wait returns how long until the bucket holds at least need.
+func (b *bucket) wait(capacity int, need float64) time.Duration {
+	if b.level >= need || capacity <= 0 {
+		return 0
+	}
+	return time.Duration((need - b.level) / float64(capacity) * float64(time.Minute))
+}
+
+func NewLimiter(dataDir string) *Limiter {
+	return &Limiter{
+		path:     filepath.Join(dataDir, budgetsFileName),
+		now:      time.Now,
+		subjects: map[string]*subjectState{},
+	}
+}
+
+// Allow admits one request against every subject or none of them: a request
+// is only counted when all subjects have room for it.
+func (l *Limiter) Allow(subjects ...Subject) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.loadLocked()
+
+	var denied Decision
+	states := make([]*subjectState, len(subjects))
+	for i, subject := range subjects {
+		state := l.stateLocked(subject.Name)
+		states[i] = state
+		reason, retry := state.check(subject.Limits, now)
+		if reason != "" && retry > denied.RetryAfter {
+			denied.Reason, denied.RetryAfter = reason, retry
+		}
+	}
+
+	if denied.Reason == "" {
+		for i, subject := range subjects {
+			if subject.Limits.RPM > 0 {
+				states[i].requests.level--
+			}
+		}
+	}
+
+	decision := Decision{Allowed: denied.Reason == "", Reason: denied.Reason, RetryAfter: denied.RetryAfter}
+	for i, subject := range subjects {
+		mergeStatus(&decision.Status, states[i].status(subject.Limits, now))
+	}
+	return decision
+}
+
+// Charge records the tokens a finished request used.
+func (l *Limiter) Charge(subject Subject, tokens int64) error {
+	if tokens <= 0 || (subject.Limits.TPM == 0 && !subject.Limits.hasBudget()) {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := l.now()
+	l.loadLocked()
+	state := l.stateLocked(subject.Name)
+	if subject.Limits.TPM > 0 {
+		state.tokens.refill(subject.Limits.TPM, now)
+		state.tokens.level -= float64(tokens)
+	}
+	if !subject.Limits.hasBudget() {
+		l.mu.Unlock()
+		return nil
+	}
+	state.budget.roll(now)
+	state.budget.DayTokens += tokens
+	state.budget.MonthTokens += tokens
+	l.dirty = true
+	batched := l.batched
+	l.mu.Unlock()
+
+	if batched {
+		return nil
+	}
+	if err := l.Flush(); err != nil {
+		return fmt.Errorf("charge %s: %w", subject.Name, err)
+	}
+	return nil
+}
+
+// StartFlusher switches Charge to in-memory budgets and saves them every
+// interval. A non-positive interval keeps writing through.
+func (l *Limiter) StartFlusher(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.batched {
+		return
+	}
+	l.batched = true
+	l.stopChan = make(chan struct{})
+
+	l.wg.Add(1)
+	go l.flushLoop(interval, l.stopChan)
+}
+
+// Flush saves budgets charged since the last flush. A failed save leaves
+// them dirty for the next one.
+func (l *Limiter) Flush() error {
+	l.fileMu.Lock()
+	defer l.fileMu.Unlock()
+
+	l.mu.Lock()
+	if !l.dirty {
+		l.mu.Unlock()
+		return nil
+	}
+	budgets := l.snapshotLocked()
+	l.dirty = false
+	l.mu.Unlock()
+
+	if err := l.save(budgets); err != nil {
+		l.mu.Lock()
+		l.dirty = true
+		l.mu.Unlock()
+		return fmt.Errorf("flush budgets: %w", err)
+	}
+	return nil
+}
+
+// Close stops the flusher and saves pending budgets.
+func (l *Limiter) Close() error {
+	l.mu.Lock()
+	stopChan := l.stopChan
+	l.stopChan = nil
+	l.batched = false
+	l.mu.Unlock()
+
+	if stopChan != nil {
+		close(stopChan)
+		l.wg.Wait()
+	}
+	return l.Flush()
+}
+
+func (l *Limiter) flushLoop(interval time.Duration, stopChan <-chan struct{}) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.Flush(); err != nil {
+				log.Warn().
+					Err(err).
+					Msg("flush token budgets failed, will retry")
+			}
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+func (l *Limiter) stateLocked(name string) *subjectState {
+	state, ok := l.subjects[name]
+	if !ok {
+		state = &subjectState{}
+		l.subjects[name] = state
+	}
+	return state
+}
+
+// check returns why the subject cannot take another request, if it cannot.
+func (s *subjectState) check(limits Limits, now time.Time) (string, time.Duration) {
+	if limits.hasBudget() {
+		s.budget.roll(now)
+		if limits.DailyTokens > 0 && s.budget.DayTokens >= limits.DailyTokens {
+			return "daily token budget exhausted", nextDay(now).Sub(now)
+		}
+		if limits.MonthlyTokens > 0 && s.budget.MonthTokens >= limits.MonthlyTokens {
+			return "monthly token budget exhausted", nextMonth(now).Sub(now)
+		}
+	}
+	if limits.TPM > 0 {
+		s.tokens.refill(limits.TPM, now)
+		if s.tokens.level < 1 {
+			return "tokens per minute exceeded", s.tokens.wait(limits.TPM, 1)
+		}
+	}
+	if limits.RPM > 0 {
+		s.requests.refill(limits.RPM, now)
+		if s.requests.level < 1 {
+			return "requests per minute exceeded", s.requests.wait(limits.RPM, 1)
+		}
+	}
+	return "", 0
+}
+
+// status reports the request bucket and the tightest token limit: the
+// per-minute bucket when set, otherwise the daily or monthly budget.
+func (s *subjectState) status(limits Limits, now time.Time) Status {
+	var status Status
+	if limits.RPM > 0 {
+		s.requests.refill(limits.RPM, now)
+		status.LimitRequests = limits.RPM
+		status.RemainingRequests = max(int(s.requests.level), 0)
+		status.ResetRequests = s.requests.wait(limits.RPM, float64(limits.RPM))
+	}
+	switch {
+	case limits.TPM > 0:
+		s.tokens.refill(limits.TPM, now)
+		status.LimitTokens = int64(limits.TPM)
+		status.RemainingTokens = max(int64(s.tokens.level), 0)
+		status.ResetTokens = s.tokens.wait(limits.TPM, float64(limits.TPM))
+	case limits.DailyTokens > 0:
+		status.LimitTokens = limits.DailyTokens
+		status.RemainingTokens = max(limits.DailyTokens-s.budget.DayTokens, 0)
+		status.ResetTokens = nextDay(now).Sub(now)
+	case limits.MonthlyTokens > 0:
+		status.LimitTokens = limits.MonthlyTokens
+		status.RemainingTokens = max(limits.MonthlyTokens-s.budget.MonthTokens, 0)
+		status.ResetTokens = nextMonth(now).Sub(now)
+	}
+	return status
+}
+
+// mergeStatus keeps, per dimension, the subject with the least room left.
+func mergeStatus(into *Status, next Status) {
+	if next.LimitRequests > 0 && (into.LimitRequests == 0 || next.RemainingRequests < into.RemainingRequests) {
+		into.LimitRequests, into.RemainingRequests, into.ResetRequests = next.LimitRequests, next.RemainingRequests, next.ResetRequests
+	}
+	if next.LimitTokens > 0 && (into.LimitTokens == 0 || next.RemainingTokens < into.RemainingTokens) {
+		into.LimitTokens, into.RemainingTokens, into.ResetTokens = next.LimitTokens, next.RemainingTokens, next.ResetTokens
+	}
+}
+
+func (b *budgetUsage) roll(now time.Time) {
+	now = now.UTC()
+	if day := now.Format("2006-01-02"); b.Day != day {
+		b.Day, b.DayTokens = day, 0
+	}
+	if month := now.Format("2006-01"); b.Month != month {
+		b.Month, b.MonthTokens = month, 0
+	}
+}
+
+func nextDay(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+func nextMonth(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// loadLocked reads persisted budgets once. A broken file is ignored so a
+// corrupt budget never takes the proxy down; it is rewritten on next flush.
+func (l *Limiter) loadLocked() {
+	if l.loaded {
+		return
+	}
+	l.loaded = true
+
+	content, err := os.ReadFile(l.path)
+	if err != nil {
+		return
+	}
+	var budgets map[string]budgetUsage
+	if err := json.Unmarshal(content, &budgets); err != nil {
+		return
+	}
+	for name, usage := range budgets {
+		l.stateLocked(name).budget = usage
+	}
+}
+
+func (l *Limiter) snapshotLocked() map[string]budgetUsage {
+	budgets := make(map[string]budgetUsage, len(l.subjects))
+	for name, state := range l.subjects {
+		if state.budget.Day != "" {
+			budgets[name] = state.budget
+		}
+	}
+	return budgets
+}
+
+func (l *Limiter) save(budgets map[string]budgetUsage) error {
+	payload, err := json.MarshalIndent(budgets, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal budgets: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return fmt.Errorf("ensure data dir: %w", err)
+	}
+
+	tmpPath := l.path + ".tmp"
+	if err := os.WriteFile(tmpPath, payload, 0o600); err != nil {
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, l.path); err != nil {
+		return fmt.Errorf("rename temp file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(t *testing.T, now *time.Time) *Limiter {
+	t.Helper()
+	limiter := NewLimiter(t.TempDir())
+	limiter.now = func() time.Time { return *now }
+	return limiter
+}
+
+func TestRequestsPerMinute(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(t, &now)
+	subject := Subject{Name: "key:a", Limits: Limits{RPM: 2}}
+
+	for i := 0; i < 2; i++ {
+		if decision := limiter.Allow(subject); !decision.Allowed {
+			t.Fatalf("request %d denied: %+v", i, decision)
+		}
+	}
+	decision := limiter.Allow(subject)
+	if decision.Allowed || decision.RetryAfter != 30*time.Second {
+		t.Fatalf("third request = %+v, want denial with 30s retry", decision)
+	}
+	if decision.Status.LimitRequests != 2 || decision.Status.RemainingRequests != 0 || decision.Status.ResetRequests != time.Minute {
+		t.Fatalf("status = %+v", decision.Status)
+	}
+
+	now = now.Add(30 * time.Second)
+	if decision := limiter.Allow(subject); !decision.Allowed {
+		t.Fatalf("request after refill denied: %+v", decision)
+	}
+}
+
+func TestTokensPerMinuteOverdraft(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(t, &now)
+	subject := Subject{Name: "account:a", Limits: Limits{TPM: 600}}
+
+	if decision := limiter.Allow(subject); !decision.Allowed || decision.Status.RemainingTokens != 600 {
+		t.Fatalf("first request = %+v", decision)
+	}
+	if err := limiter.Charge(subject, 1200); err != nil {
+		t.Fatalf("Charge() error = %v", err)
+	}
+
+	decision := limiter.Allow(subject)
+	if decision.Allowed || decision.Status.RemainingTokens != 0 {
+		t.Fatalf("overdrawn bucket = %+v, want denial", decision)
+	}
+	// 601 tokens at 10 tokens/s before the bucket holds one token again.
+	if want := time.Duration(60.1 * float64(time.Second)); decision.RetryAfter != want {
+		t.Fatalf("RetryAfter = %s, want %s", decision.RetryAfter, want)
+	}
+}
+
+func TestAllowCountsAllSubjectsOrNone(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(t, &now)
+	key := Subject{Name: "key:a", Limits: Limits{RPM: 10}}
+	account := Subject{Name: "account:a", Limits: Limits{RPM: 1}}
+
+	if decision := limiter.Allow(key, account); !decision.Allowed || decision.Status.LimitRequests != 1 {
+		t.Fatalf("first request = %+v, want tighter account status", decision)
+	}
+	if decision := limiter.Allow(key, account); decision.Allowed {
+		t.Fatal("second request should hit the account limit")
+	}
+	if decision := limiter.Allow(key); decision.Status.RemainingRequests != 8 {
+		t.Fatalf("denied request consumed the key bucket: %+v", decision.Status)
+	}
+}
+
+func TestBudgetsPersistAndRoll(t *testing.T) {
+	dataDir := t.TempDir()
+	now := time.Date(2026, 3, 31, 23, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(dataDir)
+	limiter.now = func() time.Time { return now }
+	subject := Subject{Name: "key:a", Limits: Limits{DailyTokens: 100, MonthlyTokens: 150}}
+
+	if err := limiter.Charge(subject, 100); err != nil {
+		t.Fatalf("Charge() error = %v", err)
+	}
+
+	restarted := NewLimiter(dataDir)
+	restarted.now = func() time.Time { return now }
+	decision := restarted.Allow(subject)
+	if decision.Allowed || decision.Reason != "daily token budget exhausted" || decision.RetryAfter != time.Hour {
+		t.Fatalf("after restart = %+v, want daily budget denial", decision)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if decision := restarted.Allow(subject); !decision.Allowed || decision.Status.RemainingTokens != 100 {
+		t.Fatalf("next month = %+v, want fresh budgets", decision)
+	}
+}
+
+func TestBudgetsFlushOnClose(t *testing.T) {
+	dataDir := t.TempDir()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(dataDir)
+	limiter.now = func() time.Time { return now }
+	limiter.StartFlusher(time.Hour)
+	subject := Subject{Name: "key:a", Limits: Limits{DailyTokens: 100}}
+
+	if err := limiter.Charge(subject, 100); err != nil {
+		t.Fatalf("Charge() error = %v", err)
+	}
+	if decision := limiter.Allow(subject); decision.Allowed {
+		t.Fatal("in-memory budget should be exhausted before any flush")
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, budgetsFileName)); !os.IsNotExist(err) {
+		t.Fatalf("budgets written before flush: %v", err)
+	}
+
+	if err := limiter.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	restarted := NewLimiter(dataDir)
+	restarted.now = func() time.Time { return now }
+	if decision := restarted.Allow(subject); decision.Allowed {
+		t.Fatal("budget should survive Close and restart")
+	}
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	errQueueFull    = errors.New("admission queue is full")
+	errQueueTimeout = errors.New("admission queue wait timed out")
+)
+
+// Admission enforces global and per-account in-flight limits. Requests over
+// the limit wait in a bounded FIFO queue; a limit of 0 means unlimited.
+type Admission struct {
+	globalLimit  int
+	accountLimit int
+	queueSize    int
+	queueTimeout time.Duration
+
+	mu            sync.Mutex
+	active        int
+	accountActive map[string]int
+	accountQueued map[string]int
+	queue         *list.List
+
+	admitted  uint64
+	rejected  uint64
+	timedOut  uint64
+	waited    uint64
+	totalWait time.Duration
+	maxWait   time.Duration
+}
+
+type admissionWaiter struct {
+	account  string
+	ready    chan struct{}
+	admitted bool
+}
+
+type AdmissionStats struct {
+	GlobalLimit    int            `json:"global_limit"`
+	AccountLimit   int            `json:"account_limit"`
+	QueueSize      int            `json:"queue_size"`
+	QueueTimeoutMs int64          `json:"queue_timeout_ms"`
+	Active         int            `json:"active"`
+	Queued         int            `json:"queued"`
+	Admitted       uint64         `json:"admitted"`
+	Rejected       uint64         `json:"rejected"`
+	TimedOut       uint64         `json:"timed_out"`
+	AvgWaitMs      int64          `json:"avg_wait_ms"`
+	MaxWaitMs      int64          `json:"max_wait_ms"`
+	Accounts       map[string]int `json:"accounts"`
+}
+
+func NewAdmission(globalLimit, accountLimit, queueSize int, queueTimeout time.Duration) *Admission {
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	if queueTimeout <= 0 {
+		queueTimeout = 30 * time.Second
+	}
+	return &Admission{
+		globalLimit:   globalLimit,
+		accountLimit:  accountLimit,
+		queueSize:     queueSize,
+		queueTimeout:  queueTimeout,
+		accountActive: map[string]int{},
+		accountQueued: map[string]int{},
+		queue:         list.New(),
+	}
+}
+
+// Acquire blocks until the request may proceed and returns the release
+// function together with the time spent queued.
+func (a *Admission) Acquire(ctx context.Context, account string) (func(), time.Duration, error) {
+	a.mu.Lock()
+	// Only waiters for the same account take precedence: any other waiter is
+	// blocked by its own account limit, since dispatch admits all it can.
+	if a.accountQueued[account] == 0 && a.hasCapacity(account) {
+		a.admit(account)
+		a.mu.Unlock()
+		return a.releaseFunc(account), 0, nil
+	}
+	if a.queue.Len() >= a.queueSize {
+		a.rejected++
+		a.mu.Unlock()
+		return nil, 0, errQueueFull
+	}
+
+	waiter := &admissionWaiter{account: account, ready: make(chan struct{})}
+	elem := a.queue.PushBack(waiter)
+	a.accountQueued[account]++
+	a.dispatch()
+	a.mu.Unlock()
+
+	start := time.Now()
+	timer := time.NewTimer(a.queueTimeout)
+	defer timer.Stop()
+
+	var waitErr error
+	select {
+	case <-waiter.ready:
+	case <-timer.C:
+		waitErr = errQueueTimeout
+	case <-ctx.Done():
+		waitErr = ctx.Err()
+	}
+	wait := time.Since(start)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !waiter.admitted {
+		a.dequeue(elem)
+		if errors.Is(waitErr, errQueueTimeout) {
+			a.timedOut++
+		}
+		return nil, wait, waitErr
+	}
+
+	a.waited++
+	a.totalWait += wait
+	if wait > a.maxWait {
+		a.maxWait = wait
+	}
+	return a.releaseFunc(account), wait, nil
+}
+
+func (a *Admission) Stats() AdmissionStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats := AdmissionStats{
+		GlobalLimit:    a.globalLimit,
+		AccountLimit:   a.accountLimit,
+		QueueSize:      a.queueSize,
+		QueueTimeoutMs: a.queueTimeout.Milliseconds(),
+		Active:         a.active,
+		Queued:         a.queue.Len(),
+		Admitted:       a.admitted,
+		Rejected:       a.rejected,
+		TimedOut:       a.timedOut,
+		MaxWaitMs:      a.maxWait.Milliseconds(),
+		Accounts:       make(map[string]int, len(a.accountActive)),
+	}
+	if a.waited > 0 {
+		stats.AvgWaitMs = (a.totalWait / time.Duration(a.waited)).Milliseconds()
+	}
+	for uuid, n := range a.accountActive {
+		stats.Accounts[maskToken(uuid)] = n
+	}
+	return stats
+}
+
+// RetryAfter suggests how long a rejected client should wait, based on the
+// average queue wait observed so far.
+func (a *Admission) RetryAfter() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.waited == 0 {
+		return time.Second
+	}
+	avg := a.totalWait / time.Duration(a.waited)
+	if avg < time.Second {
+		return time.Second
+	}
+	return avg
+}
+
+func (a *Admission) hasCapacity(account string) bool {
+	if a.globalLimit > 0 && a.active >= a.globalLimit {
+		return false
+	}
+	if a.accountLimit > 0 && a.accountActive[account] >= a.accountLimit {
+		return false
+	}
+	return true
+}
+
+func (a *Admission) admit(account string) {
+	a.active++
+	a.accountActive[account]++
+	a.admitted++
+}
+
+func (a *Admission) releaseFunc(account string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+
+			a.active--
+			a.accountActive[account]--
+			if a.accountActive[account] <= 0 {
+				delete(a.accountActive, account)
+			}
+			a.dispatch()
+		})
+	}
+}
+
+// dispatch admits queued waiters in arrival order, skipping waiters whose
+// account is still saturated so one busy account cannot stall the others.
+func (a *Admission) dispatch() {
+	for elem := a.queue.Front(); elem != nil; {
+		if a.globalLimit > 0 && a.active >= a.globalLimit {
+			return
+		}
+		next := elem.Next()
+		waiter := elem.Value.(*admissionWaiter)
+		if a.hasCapacity(waiter.account) {
+			a.dequeue(elem)
+			a.admit(waiter.account)
+			waiter.admitted = true
+			close(waiter.ready)
+		}
+		elem = next
+	}
+}
+
+func (a *Admission) dequeue(elem *list.Element) {
+	account := a.queue.Remove(elem).(*admissionWaiter).account
+	a.accountQueued[account]--
+	if a.accountQueued[account] <= 0 {
+		delete(a.accountQueued, account)
+	}
+}
+
+func AdmissionMiddleware(admission *Admission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if admission == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			binding, ok := bindingFromContext(r.Context())
+			if !ok {
+				writeAPIError(w, http.StatusUnauthorized, "missing account context", "invalid_request_error", "invalid_api_key")
+				return
+			}
+			acct := binding.account
+
+			release, wait, err := admission.Acquire(r.Context(), acct.UUID)
+			if err != nil {
+				stats := admission.Stats()
+				if r.Context().Err() != nil {
+					log.Debug().
+						Str("account_uuid", acct.UUID).
+						Dur("wait", wait).
+						Msg("request cancelled while queued")
+					return
+				}
+
+				retryAfter := admission.RetryAfter()
+				log.Warn().
+					Err(err).
+					Str("path", r.URL.Path).
+					Str("account_uuid", acct.UUID).
+					Int("queue_depth", stats.Queued).
+					Int("active", stats.Active).
+					Dur("wait", wait).
+					Msg("request rejected by admission control")
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				writeAPIError(w, http.StatusTooManyRequests, "too many concurrent requests, please retry later", "rate_limit_error", "concurrency_limit_exceeded")
+				return
+			}
+			binding.admission, binding.slot = admission, release
+			defer binding.releaseSlot()
+
+			if wait > 0 {
+				log.Debug().
+					Str("path", r.URL.Path).
+					Str("account_uuid", acct.UUID).
+					Int("queue_depth", admission.Stats().Queued).
+					Dur("wait", wait).
+					Msg("request admitted after queueing")
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
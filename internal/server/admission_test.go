@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/internal/config"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+func TestAdmissionFIFOQueue(t *testing.T) {
+	a := NewAdmission(1, 0, 4, time.Second)
+
+	release, _, err := a.Acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	order := make(chan string, 2)
+	for i, name := range []string{"first", "second"} {
+		name := name
+		go func() {
+			rel, _, err := a.Acquire(context.Background(), name)
+			if err != nil {
+				t.Errorf("Acquire(%s) error = %v", name, err)
+				return
+			}
+			order <- name
+			rel()
+		}()
+		waitForQueued(t, a, i+1)
+	}
+
+	release()
+	if got := <-order; got != "first" {
+		t.Fatalf("first admitted = %s, want first", got)
+	}
+	if got := <-order; got != "second" {
+		t.Fatalf("second admitted = %s, want second", got)
+	}
+
+	stats := a.Stats()
+	if stats.Active != 0 || stats.Queued != 0 || stats.Admitted != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestAdmissionQueueFullAndTimeout(t *testing.T) {
+	a := NewAdmission(0, 1, 1, 20*time.Millisecond)
+
+	release, _, err := a.Acquire(context.Background(), "acct")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	defer release()
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := a.Acquire(context.Background(), "acct")
+		done <- err
+	}()
+	waitForQueued(t, a, 1)
+
+	if _, _, err := a.Acquire(context.Background(), "acct"); !errors.Is(err, errQueueFull) {
+		t.Fatalf("Acquire() error = %v, want errQueueFull", err)
+	}
+	if err := <-done; !errors.Is(err, errQueueTimeout) {
+		t.Fatalf("queued Acquire() error = %v, want errQueueTimeout", err)
+	}
+
+	other, _, err := a.Acquire(context.Background(), "other")
+	if err != nil {
+		t.Fatalf("other account should not be limited: %v", err)
+	}
+	other()
+
+	stats := a.Stats()
+	if stats.Rejected != 1 || stats.TimedOut != 1 || stats.Queued != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestAdmissionIdleAccountSkipsOtherAccountsQueue(t *testing.T) {
+	a := NewAdmission(0, 1, 4, time.Second)
+
+	busy, _, err := a.Acquire(context.Background(), "busy")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	queued := make(chan error, 1)
+	go func() {
+		rel, _, err := a.Acquire(context.Background(), "busy")
+		if err == nil {
+			rel()
+		}
+		queued <- err
+	}()
+	waitForQueued(t, a, 1)
+
+	// The idle account must not wait behind the busy account's queue.
+	idle, wait, err := a.Acquire(context.Background(), "idle")
+	if err != nil || wait != 0 {
+		t.Fatalf("idle account Acquire() = %v after %s, want immediate admission", err, wait)
+	}
+	idle()
+
+	busy()
+	if err := <-queued; err != nil {
+		t.Fatalf("queued Acquire() error = %v", err)
+	}
+}
+
+func TestAdmissionRejectsWith429(t *testing.T) {
+	cfg := &config.Config{
+		Host:           "127.0.0.1",
+		Port:           28000,
+		DataDir:        t.TempDir(),
+		Concurrency:    1,
+		QueueSize:      0,
+		LegacyUUIDAuth: true,
+	}
+	s := New(cfg)
+	acct := createTestAccount(t, s)
+
+	release, _, err := s.admission.Acquire(context.Background(), acct.UUID)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	defer release()
+
+	finish := "stop"
+	s.newProxy = func(*account.Account) proxyClient {
+		return &fakeProxy{chatResp: &types.ChatCompletionResponse{
+			Choices: []types.Choice{{Message: &types.Message{Role: "assistant", Content: "ok"}, FinishReason: &finish}},
+		}}
+	}
+
+	body := `{"model":"glm-5","messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatal("Retry-After header should be set")
+	}
+	if !strings.Contains(rec.Body.String(), `"code":"concurrency_limit_exceeded"`) {
+		t.Fatalf("unexpected body: %s", rec.Body.String())
+	}
+
+	statsReq := httptest.NewRequest(http.MethodGet, "/v1/stats", nil)
+	statsReq.Header.Set("Authorization", "Bearer "+acct.UUID)
+	statsRec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(statsRec, statsReq)
+
+	var payload struct {
+		Admission AdmissionStats `json:"admission"`
+	}
+	if err := json.Unmarshal(statsRec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode stats: %v", err)
+	}
+	if payload.Admission.Active != 1 || payload.Admission.Rejected != 1 || payload.Admission.AccountLimit != 1 {
+		t.Fatalf("unexpected stats: %+v", payload.Admission)
+	}
+}
+
+func waitForQueued(t *testing.T, a *Admission, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for a.Stats().Queued < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue depth did not reach %d", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,764 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rogeecn/iflow-go/pkg/types"
+	"github.com/rs/zerolog/log"
+)
+
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		log.Warn().
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Msg("messages endpoint rejected invalid method")
+		writeAnthropicError(w, http.StatusMethodNotAllowed, "invalid_request_error", "method not allowed")
+		return
+	}
+
+	acct, ok := accountFromContext(r.Context())
+	if !ok {
+		log.Error().
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Msg("messages endpoint missing account context")
+		writeAnthropicError(w, http.StatusUnauthorized, "authentication_error", "missing account context")
+		return
+	}
+
+	var reqBody types.AnthropicMessagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		if isBodyTooLarge(err) {
+			log.Warn().
+				Err(err).
+				Str("account_uuid", acct.UUID).
+				Msg("messages request body too large")
+			writeAnthropicError(w, http.StatusRequestEntityTooLarge, "request_too_large", "request body too large")
+			return
+		}
+		log.Warn().
+			Err(err).
+			Str("account_uuid", acct.UUID).
+			Msg("messages invalid request body")
+		writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", "invalid request body")
+		return
+	}
+	if reqBody.Model == "" || len(reqBody.Messages) == 0 {
+		log.Warn().
+			Str("account_uuid", acct.UUID).
+			Msg("messages missing required fields")
+		writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", "model and messages are required")
+		return
+	}
+	if !modelAllowed(r.Context(), reqBody.Model) {
+		log.Warn().
+			Str("account_uuid", acct.UUID).
+			Str("model", reqBody.Model).
+			Msg("messages model not allowed for client key")
+		writeAnthropicError(w, http.StatusForbidden, "permission_error", "api key is not allowed to use model "+reqBody.Model)
+		return
+	}
+
+	chatReq, err := anthropicToChatRequest(&reqBody)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("account_uuid", acct.UUID).
+			Msg("messages request conversion failed")
+		writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if err := applyReasoningOptions(r, chatReq); err != nil {
+		log.Warn().
+			Err(err).
+			Str("account_uuid", acct.UUID).
+			Msg("messages invalid reasoning options")
+		writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	observeModel(r.Context(), reqBody.Model)
+	log.Debug().
+		Str("account_uuid", acct.UUID).
+		Str("model", reqBody.Model).
+		Bool("stream", reqBody.Stream).
+		Int("messages", len(chatReq.Messages)).
+		Msg("messages request accepted")
+
+	if reqBody.Stream {
+		s.handleStreamMessages(r.Context(), w, chatReq, reqBody.StopSequences)
+		return
+	}
+
+	resp, acct, err := s.chatWithFailover(r.Context(), w, chatReq)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("account_uuid", acct.UUID).
+			Str("model", chatReq.Model).
+			Msg("messages upstream request failed")
+		writeAnthropicUpstreamError(w, err, "upstream request failed")
+		return
+	}
+
+	s.recordUsage(r.Context(), acct.UUID, reqBody.Model, &resp.Usage)
+	writeJSON(w, http.StatusOK, chatResponseToAnthropic(resp, reqBody.Model, reqBody.StopSequences))
+}
+
+func (s *Server) handleStreamMessages(ctx context.Context, w http.ResponseWriter, reqBody *types.ChatCompletionRequest, stopSequences []string) {
+	stream, acct, err := s.streamWithFailover(ctx, w, reqBody)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("account_uuid", acct.UUID).
+			Str("model", reqBody.Model).
+			Msg("messages stream request failed")
+		writeAnthropicUpstreamError(w, err, "upstream stream failed")
+		return
+	}
+	uuid := acct.UUID
+	defer trackStream(ctx)()
+
+	sse, err := NewSSEWriter(w)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("account_uuid", uuid).
+			Msg("failed to initialize sse writer")
+		writeAnthropicError(w, http.StatusInternalServerError, "api_error", err.Error())
+		return
+	}
+
+	pings := newHeartbeat(s.config.SSEKeepalive)
+	defer pings.Stop()
+
+	translator := newAnthropicStreamTranslator(sse, reqBody.Model, stopSequences)
+	if err := translator.start(); err != nil {
+		log.Warn().
+			Err(err).
+			Str("account_uuid", uuid).
+			Msg("messages stream write failed")
+		return
+	}
+
+	var usage *types.Usage
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debug().
+				Str("account_uuid", uuid).
+				Str("model", reqBody.Model).
+				Msg("messages stream cancelled by context")
+			return
+		case <-pings.C():
+			if err := sse.WriteNamedEvent("ping", `{"type":"ping"}`); err != nil {
+				log.Warn().
+					Err(err).
+					Str("account_uuid", uuid).
+					Msg("messages stream keepalive failed")
+				return
+			}
+		case chunk, ok := <-stream:
+			if !ok {
+				if err := translator.finish(); err != nil {
+					log.Warn().
+						Err(err).
+						Str("account_uuid", uuid).
+						Msg("messages stream write failed")
+				}
+				s.recordUsage(ctx, uuid, reqBody.Model, usage)
+				log.Debug().
+					Str("account_uuid", uuid).
+					Str("model", reqBody.Model).
+					Msg("messages stream finished")
+				return
+			}
+			observeFirstToken(ctx)
+			pings.Reset()
+
+			chunks, _ := decodeProxyChunk(chunk)
+			if streamErr := streamErrorFromChunks(chunks); streamErr != nil {
+				log.Warn().
+					Str("account_uuid", uuid).
+					Str("model", reqBody.Model).
+					Str("error", streamErr.Message).
+					Msg("messages stream failed upstream")
+				if err := translator.fail(streamErr); err != nil {
+					log.Warn().
+						Err(err).
+						Str("account_uuid", uuid).
+						Msg("messages stream write failed")
+				}
+				return
+			}
+			for _, parsed := range chunks {
+				if parsed.Usage != nil {
+					usage = parsed.Usage
+				}
+				if err := translator.consume(parsed); err != nil {
+					log.Warn().
+						Err(err).
+						Str("account_uuid", uuid).
+						Str("model", reqBody.Model).
+						Msg("messages stream write failed")
+					return
+				}
+			}
+		}
+	}
+}
+
+func anthropicToChatRequest(req *types.AnthropicMessagesRequest) (*types.ChatCompletionRequest, error) {
+	// Thinking blocks need reasoning_content kept apart from the answer.
+	preserve := true
+	chatReq := &types.ChatCompletionRequest{
+		Model:             req.Model,
+		Stream:            req.Stream,
+		Temperature:       req.Temperature,
+		TopP:              req.TopP,
+		Thinking:          req.Thinking,
+		PreserveReasoning: &preserve,
+	}
+	if req.MaxTokens > 0 {
+		maxTokens := req.MaxTokens
+		chatReq.MaxTokens = &maxTokens
+	}
+	if userID, ok := req.Metadata["user_id"].(string); ok {
+		chatReq.User = userID
+	}
+	// The chat request has no typed stop or top_k; both travel as extra
+	// members and so still obey the passthrough lists.
+	if len(req.StopSequences) > 0 {
+		setChatExtra(chatReq, "stop", req.StopSequences)
+	}
+	if req.TopK != nil {
+		setChatExtra(chatReq, "top_k", *req.TopK)
+	}
+
+	system, err := anthropicSystemText(req.System)
+	if err != nil {
+		return nil, err
+	}
+	if system != "" {
+		chatReq.Messages = append(chatReq.Messages, types.Message{Role: "system", Content: system})
+	}
+
+	for i, msg := range req.Messages {
+		converted, err := anthropicMessageToChat(msg)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		chatReq.Messages = append(chatReq.Messages, converted...)
+	}
+
+	for _, tool := range req.Tools {
+		parameters := json.RawMessage(`{"type":"object","properties":{}}`)
+		if len(tool.InputSchema) > 0 {
+			parameters = tool.InputSchema
+		}
+		chatReq.Tools = append(chatReq.Tools, types.Tool{
+			Type: "function",
+			Function: types.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  parameters,
+			},
+		})
+	}
+
+	if req.ToolChoice != nil {
+		switch req.ToolChoice.Type {
+		case "auto":
+			chatReq.ToolChoice = "auto"
+		case "any":
+			chatReq.ToolChoice = "required"
+		case "none":
+			chatReq.ToolChoice = "none"
+		case "tool":
+			chatReq.ToolChoice = map[string]interface{}{
+				"type":     "function",
+				"function": map[string]interface{}{"name": req.ToolChoice.Name},
+			}
+		}
+	}
+
+	return chatReq, nil
+}
+
+func setChatExtra(req *types.ChatCompletionRequest, key string, value interface{}) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	if req.Extra == nil {
+		req.Extra = types.Extra{}
+	}
+	req.Extra[key] = raw
+}
+
+func anthropicSystemText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+
+	var blocks []types.AnthropicContentBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return "", fmt.Errorf("system: expected string or content blocks")
+	}
+
+	parts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		if block.Type == "text" && block.Text != "" {
+			parts = append(parts, block.Text)
+		}
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+func anthropicMessageToChat(msg types.AnthropicMessage) ([]types.Message, error) {
+	var text string
+	if err := json.Unmarshal(msg.Content, &text); err == nil {
+		return []types.Message{{Role: msg.Role, Content: text}}, nil
+	}
+
+	var blocks []types.AnthropicContentBlock
+	if err := json.Unmarshal(msg.Content, &blocks); err != nil {
+		return nil, fmt.Errorf("content: expected string or content blocks")
+	}
+
+	if msg.Role == "assistant" {
+		return []types.Message{anthropicAssistantToChat(blocks)}, nil
+	}
+
+	messages := make([]types.Message, 0, 1)
+	parts := make([]interface{}, 0, len(blocks))
+	hasImage := false
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			parts = append(parts, map[string]interface{}{"type": "text", "text": block.Text})
+		case "image":
+			if block.Source == nil {
+				continue
+			}
+			imageURL := block.Source.URL
+			if block.Source.Type == "base64" {
+				imageURL = fmt.Sprintf("data:%s;base64,%s", block.Source.MediaType, block.Source.Data)
+			}
+			parts = append(parts, map[string]interface{}{
+				"type":      "image_url",
+				"image_url": map[string]interface{}{"url": imageURL},
+			})
+			hasImage = true
+		case "tool_result":
+			content, err := anthropicToolResultText(block.Content)
+			if err != nil {
+				return nil, err
+			}
+			if block.IsError && content != "" {
+				content = "Error: " + content
+			}
+			messages = append(messages, types.Message{
+				Role:       "tool",
+				Content:    content,
+				ToolCallID: block.ToolUseID,
+			})
+		}
+	}
+
+	if len(parts) == 0 {
+		return messages, nil
+	}
+	if hasImage {
+		return append(messages, types.Message{Role: msg.Role, Content: parts}), nil
+	}
+	return append(messages, types.Message{Role: msg.Role, Content: messageText(parts)}), nil
+}
+
+func anthropicAssistantToChat(blocks []types.AnthropicContentBlock) types.Message {
+	message := types.Message{Role: "assistant"}
+	var text strings.Builder
+	var reasoning strings.Builder
+
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "thinking":
+			reasoning.WriteString(block.Thinking)
+		case "tool_use":
+			arguments := "{}"
+			if len(block.Input) > 0 {
+				arguments = string(block.Input)
+			}
+			message.ToolCalls = append(message.ToolCalls, types.ToolCall{
+				ID:   block.ID,
+				Type: "function",
+				Function: types.ToolCallFunction{
+					Name:      block.Name,
+					Arguments: arguments,
+				},
+			})
+		}
+	}
+
+	message.ReasoningContent = reasoning.String()
+	if text.Len() > 0 || len(message.ToolCalls) == 0 {
+		message.Content = text.String()
+	}
+	return message
+}
+
+func anthropicToolResultText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+
+	var blocks []types.AnthropicContentBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return "", fmt.Errorf("tool_result content: expected string or content blocks")
+	}
+
+	var builder strings.Builder
+	for _, block := range blocks {
+		if block.Type == "text" {
+			builder.WriteString(block.Text)
+		}
+	}
+	return builder.String(), nil
+}
+
+func chatResponseToAnthropic(resp *types.ChatCompletionResponse, model string, stopSequences []string) *types.AnthropicMessagesResponse {
+	out := &types.AnthropicMessagesResponse{
+		ID:      newObjectID("msg_"),
+		Type:    "message",
+		Role:    "assistant",
+		Model:   model,
+		Content: []interface{}{},
+	}
+	if resp == nil {
+		stopReason := "end_turn"
+		out.StopReason = &stopReason
+		return out
+	}
+
+	out.Usage = types.AnthropicUsage{
+		InputTokens:  resp.Usage.PromptTokens,
+		OutputTokens: resp.Usage.CompletionTokens,
+	}
+
+	finishReason := ""
+	if len(resp.Choices) > 0 {
+		choice := resp.Choices[0]
+		if choice.FinishReason != nil {
+			finishReason = *choice.FinishReason
+		}
+		out.StopSequence = matchedStopSequence(choice, stopSequences)
+		if msg := choice.Message; msg != nil {
+			if msg.ReasoningContent != "" {
+				out.Content = append(out.Content, map[string]interface{}{
+					"type":      "thinking",
+					"thinking":  msg.ReasoningContent,
+					"signature": "",
+				})
+			}
+			if text := messageText(msg.Content); text != "" {
+				out.Content = append(out.Content, map[string]interface{}{
+					"type": "text",
+					"text": text,
+				})
+			}
+			for _, call := range msg.ToolCalls {
+				out.Content = append(out.Content, map[string]interface{}{
+					"type":  "tool_use",
+					"id":    call.ID,
+					"name":  call.Function.Name,
+					"input": toolArgumentsObject(call.Function.Arguments),
+				})
+			}
+			if len(msg.ToolCalls) > 0 && finishReason == "" {
+				finishReason = "tool_calls"
+			}
+		}
+	}
+
+	stopReason := anthropicStopReason(finishReason)
+	if out.StopSequence != nil {
+		stopReason = "stop_sequence"
+	}
+	out.StopReason = &stopReason
+	return out
+}
+
+// matchedStopSequence returns the client stop sequence that ended choice.
+// OpenAI-style finish reasons do not say which one matched, so this relies on
+// the vLLM stop_reason or SGLang matched_stop member when iFlow sends one.
+func matchedStopSequence(choice types.Choice, stopSequences []string) *string {
+	if len(stopSequences) == 0 || choice.FinishReason == nil || *choice.FinishReason != "stop" {
+		return nil
+	}
+	for _, key := range []string{"stop_reason", "matched_stop"} {
+		var matched string
+		if err := json.Unmarshal(choice.Extra[key], &matched); err != nil {
+			continue
+		}
+		for _, sequence := range stopSequences {
+			if sequence == matched {
+				return &sequence
+			}
+		}
+	}
+	return nil
+}
+
+func anthropicStopReason(finishReason string) string {
+	switch finishReason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	default:
+		return "end_turn"
+	}
+}
+
+func toolArgumentsObject(arguments string) json.RawMessage {
+	trimmed := strings.TrimSpace(arguments)
+	if trimmed == "" || !json.Valid([]byte(trimmed)) {
+		return json.RawMessage(`{}`)
+	}
+	return json.RawMessage(trimmed)
+}
+
+type anthropicStreamTranslator struct {
+	sse          *SSEWriter
+	model        string
+	messageID    string
+	blockIndex   int
+	blockType    string
+	toolIndex    int
+	stopReason   string
+	stopSequence *string
+	stopList     []string
+	outputTokens int
+	inputTokens  int
+}
+
+func newAnthropicStreamTranslator(sse *SSEWriter, model string, stopSequences []string) *anthropicStreamTranslator {
+	return &anthropicStreamTranslator{
+		sse:        sse,
+		model:      model,
+		stopList:   stopSequences,
+		messageID:  newObjectID("msg_"),
+		blockIndex: -1,
+		toolIndex:  -1,
+	}
+}
+
+func (t *anthropicStreamTranslator) start() error {
+	return t.emit("message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":            t.messageID,
+			"type":          "message",
+			"role":          "assistant",
+			"model":         t.model,
+			"content":       []interface{}{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage": map[string]int{
+				"input_tokens":  0,
+				"output_tokens": 0,
+			},
+		},
+	})
+}
+
+func (t *anthropicStreamTranslator) consume(chunk *types.ChatCompletionChunk) error {
+	if chunk.Usage != nil {
+		t.inputTokens = chunk.Usage.PromptTokens
+		t.outputTokens = chunk.Usage.CompletionTokens
+	}
+
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		if delta := choice.Delta; delta != nil {
+			if delta.ReasoningContent != "" {
+				if err := t.ensureBlock("thinking", map[string]interface{}{"type": "thinking", "thinking": "", "signature": ""}); err != nil {
+					return err
+				}
+				if err := t.delta(map[string]interface{}{"type": "thinking_delta", "thinking": delta.ReasoningContent}); err != nil {
+					return err
+				}
+			}
+			if delta.Content != "" {
+				if err := t.ensureBlock("text", map[string]interface{}{"type": "text", "text": ""}); err != nil {
+					return err
+				}
+				if err := t.delta(map[string]interface{}{"type": "text_delta", "text": delta.Content}); err != nil {
+					return err
+				}
+			}
+			for _, call := range delta.ToolCalls {
+				if err := t.consumeToolCall(call); err != nil {
+					return err
+				}
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			t.stopReason = anthropicStopReason(*choice.FinishReason)
+			if t.stopSequence = matchedStopSequence(choice, t.stopList); t.stopSequence != nil {
+				t.stopReason = "stop_sequence"
+			}
+		}
+	}
+	return nil
+}
+
+func (t *anthropicStreamTranslator) consumeToolCall(call types.ToolCall) error {
+	index := t.toolIndex
+	if call.Index != nil {
+		index = *call.Index
+	}
+	if t.blockType != "tool_use" || index != t.toolIndex || call.ID != "" {
+		if err := t.closeBlock(); err != nil {
+			return err
+		}
+		t.toolIndex = index
+		t.blockIndex++
+		t.blockType = "tool_use"
+		id := call.ID
+		if id == "" {
+			id = newObjectID("toolu_")
+		}
+		if err := t.emit("content_block_start", map[string]interface{}{
+			"type":  "content_block_start",
+			"index": t.blockIndex,
+			"content_block": map[string]interface{}{
+				"type":  "tool_use",
+				"id":    id,
+				"name":  call.Function.Name,
+				"input": map[string]interface{}{},
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	if call.Function.Arguments == "" {
+		return nil
+	}
+	return t.delta(map[string]interface{}{"type": "input_json_delta", "partial_json": call.Function.Arguments})
+}
+
+func (t *anthropicStreamTranslator) finish() error {
+	if err := t.closeBlock(); err != nil {
+		return err
+	}
+
+	stopReason := t.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	if err := t.emit("message_delta", map[string]interface{}{
+		"type": "message_delta",
+		"delta": map[string]interface{}{
+			"stop_reason":   stopReason,
+			"stop_sequence": t.stopSequence,
+		},
+		"usage": map[string]int{
+			"input_tokens":  t.inputTokens,
+			"output_tokens": t.outputTokens,
+		},
+	}); err != nil {
+		return err
+	}
+	return t.emit("message_stop", map[string]interface{}{"type": "message_stop"})
+}
+
+func (t *anthropicStreamTranslator) ensureBlock(blockType string, contentBlock map[string]interface{}) error {
+	if t.blockType == blockType {
+		return nil
+	}
+	if err := t.closeBlock(); err != nil {
+		return err
+	}
+
+	t.blockIndex++
+	t.blockType = blockType
+	return t.emit("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         t.blockIndex,
+		"content_block": contentBlock,
+	})
+}
+
+func (t *anthropicStreamTranslator) closeBlock() error {
+	if t.blockType == "" {
+		return nil
+	}
+
+	t.blockType = ""
+	return t.emit("content_block_stop", map[string]interface{}{
+		"type":  "content_block_stop",
+		"index": t.blockIndex,
+	})
+}
+
+func (t *anthropicStreamTranslator) delta(delta map[string]interface{}) error {
+	return t.emit("content_block_delta", map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": t.blockIndex,
+		"delta": delta,
+	})
+}
+
+func (t *anthropicStreamTranslator) emit(event string, payload interface{}) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("anthropic stream encode %s: %w", event, err)
+	}
+	return t.sse.WriteNamedEvent(event, string(raw))
+}
+
+func (t *anthropicStreamTranslator) fail(detail *types.ErrorDetail) error {
+	errType := "api_error"
+	switch detail.Type {
+	case "invalid_request_error", "authentication_error", "permission_error", "rate_limit_error", "overloaded_error":
+		errType = detail.Type
+	}
+	return t.emit("error", map[string]interface{}{
+		"type": "error",
+		"error": map[string]string{
+			"type":    errType,
+			"message": detail.Message,
+		},
+	})
+}
+
+func writeAnthropicError(w http.ResponseWriter, statusCode int, errType, message string) {
+	writeJSON(w, statusCode, map[string]interface{}{
+		"type": "error",
+		"error": map[string]string{
+			"type":    errType,
+			"message": message,
+		},
+	})
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+func TestAnthropicToChatRequest(t *testing.T) {
+	raw := `{
+	  "model":"glm-5",
+	  "max_tokens":1024,
+	  "system":[{"type":"text","text":"be brief"}],
+	  "messages":[
+	    {"role":"user","content":"weather?"},
+	    {"role":"assistant","content":[
+	      {"type":"thinking","thinking":"need tool","signature":"sig"},
+	      {"type":"tool_use","id":"toolu_1","name":"get_weather","input":{"city":"hz"}}
+	    ]},
+	    {"role":"user","content":[
+	      {"type":"tool_result","tool_use_id":"toolu_1","content":[{"type":"text","text":"sunny"}]},
+	      {"type":"text","text":"thanks"}
+	    ]}
+	  ],
+	  "tools":[{"name":"get_weather","description":"weather","input_schema":{"type":"object"}}],
+	  "tool_choice":{"type":"any"}
+	}`
+
+	var req types.AnthropicMessagesRequest
+	if err := json.Unmarshal([]byte(raw), &req); err != nil {
+		t.Fatalf("unmarshal request: %v", err)
+	}
+
+	chatReq, err := anthropicToChatRequest(&req)
+	if err != nil {
+		t.Fatalf("anthropicToChatRequest error: %v", err)
+	}
+
+	if chatReq.MaxTokens == nil || *chatReq.MaxTokens != 1024 {
+		t.Fatalf("max_tokens = %v, want 1024", chatReq.MaxTokens)
+	}
+	if len(chatReq.Messages) != 5 {
+		t.Fatalf("messages len = %d, want 5: %+v", len(chatReq.Messages), chatReq.Messages)
+	}
+	if chatReq.Messages[0].Role != "system" || chatReq.Messages[0].Content != "be brief" {
+		t.Fatalf("unexpected system message: %+v", chatReq.Messages[0])
+	}
+
+	assistant := chatReq.Messages[2]
+	if assistant.ReasoningContent != "need tool" {
+		t.Fatalf("reasoning_content = %q, want need tool", assistant.ReasoningContent)
+	}
+	if len(assistant.ToolCalls) != 1 || assistant.ToolCalls[0].Function.Arguments != `{"city":"hz"}` {
+		t.Fatalf("unexpected tool calls: %+v", assistant.ToolCalls)
+	}
+
+	toolMsg := chatReq.Messages[3]
+	if toolMsg.Role != "tool" || toolMsg.ToolCallID != "toolu_1" || toolMsg.Content != "sunny" {
+		t.Fatalf("unexpected tool message: %+v", toolMsg)
+	}
+	if chatReq.Messages[4].Content != "thanks" {
+		t.Fatalf("unexpected trailing user message: %+v", chatReq.Messages[4])
+	}
+	if chatReq.ToolChoice != "required" {
+		t.Fatalf("tool_choice = %v, want required", chatReq.ToolChoice)
+	}
+	if len(chatReq.Tools) != 1 {
+		t.Fatalf("tools len = %d, want 1", len(chatReq.Tools))
+	}
+}
+
+func TestHandleMessagesWithAPIKeyHeader(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+
+	finish := "tool_calls"
+	s.newProxy = func(*account.Account) proxyClient {
+		return &fakeProxy{
+			chatResp: &types.ChatCompletionResponse{
+				ID:    "chat-1",
+				Model: "glm-5",
+				Choices: []types.Choice{
+					{
+						Message: &types.Message{
+							Role:             "assistant",
+							Content:          "checking",
+							ReasoningContent: "thinking-text",
+							ToolCalls: []types.ToolCall{
+								{ID: "call_1", Type: "function", Function: types.ToolCallFunction{Name: "lookup", Arguments: `{"q":"x"}`}},
+							},
+						},
+						FinishReason: &finish,
+					},
+				},
+				Usage: types.Usage{PromptTokens: 3, CompletionTokens: 5, TotalTokens: 8},
+			},
+		}
+	}
+
+	body := `{"model":"glm-5","max_tokens":64,"messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	req.Header.Set("x-api-key", acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	var resp map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp["stop_reason"] != "tool_use" {
+		t.Fatalf("stop_reason = %v, want tool_use", resp["stop_reason"])
+	}
+	content, _ := resp["content"].([]interface{})
+	if len(content) != 3 {
+		t.Fatalf("content blocks = %d, want 3: %s", len(content), rec.Body.String())
+	}
+	wantTypes := []string{"thinking", "text", "tool_use"}
+	for i, want := range wantTypes {
+		block, _ := content[i].(map[string]interface{})
+		if block["type"] != want {
+			t.Fatalf("content[%d].type = %v, want %s", i, block["type"], want)
+		}
+	}
+	if !strings.Contains(rec.Body.String(), `"input":{"q":"x"}`) {
+		t.Fatalf("tool_use input not decoded: %s", rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"output_tokens":5`) {
+		t.Fatalf("usage not mapped: %s", rec.Body.String())
+	}
+}
+
+func TestHandleMessagesStream(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+
+	ch := make(chan []byte, 5)
+	ch <- []byte("data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"reasoning_content\":\"hmm\"}}]}\n\n")
+	ch <- []byte("data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hello\"}}]}\n\n")
+	ch <- []byte("data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}],\"usage\":{\"prompt_tokens\":2,\"completion_tokens\":4,\"total_tokens\":6}}\n\n")
+	ch <- []byte("data: [DONE]\n\n")
+	close(ch)
+
+	s.newProxy = func(*account.Account) proxyClient {
+		return &fakeProxy{stream: ch}
+	}
+
+	body := `{"model":"glm-5","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	out := rec.Body.String()
+	ordered := []string{
+		"event: message_start",
+		`"content_block":{"signature":"","thinking":"","type":"thinking"}`,
+		`"delta":{"thinking":"hmm","type":"thinking_delta"}`,
+		"event: content_block_stop",
+		`"content_block":{"text":"","type":"text"}`,
+		`"delta":{"text":"hello","type":"text_delta"}`,
+		`"stop_reason":"end_turn"`,
+		`"output_tokens":4`,
+		"event: message_stop",
+	}
+	pos := 0
+	for _, want := range ordered {
+		idx := strings.Index(out[pos:], want)
+		if idx < 0 {
+			t.Fatalf("stream missing %q after offset %d: %s", want, pos, out)
+		}
+		pos += idx + len(want)
+	}
+	if strings.Contains(out, "[DONE]") {
+		t.Fatalf("anthropic stream should not forward [DONE]: %s", out)
+	}
+}
+
+func TestHandleMessagesForwardsStopSequencesAndTopK(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+
+	finish := "stop"
+	fake := &fakeProxy{
+		chatResp: &types.ChatCompletionResponse{
+			Choices: []types.Choice{{
+				Message:      &types.Message{Role: "assistant", Content: "one, two"},
+				FinishReason: &finish,
+				Extra:        types.Extra{"stop_reason": json.RawMessage(`"three"`)},
+			}},
+		},
+	}
+	s.newProxy = func(*account.Account) proxyClient { return fake }
+
+	body := `{"model":"glm-5","max_tokens":64,"top_k":5,"stop_sequences":["three","END"],"messages":[{"role":"user","content":"count"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	req.Header.Set("x-api-key", acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	upstream, err := json.Marshal(fake.lastReq)
+	if err != nil {
+		t.Fatalf("encode upstream request: %v", err)
+	}
+	if !strings.Contains(string(upstream), `"stop":["three","END"]`) || !strings.Contains(string(upstream), `"top_k":5`) {
+		t.Fatalf("stop/top_k missing from upstream body: %s", upstream)
+	}
+
+	var resp map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp["stop_reason"] != "stop_sequence" || resp["stop_sequence"] != "three" {
+		t.Fatalf("stop_reason = %v, stop_sequence = %v, want stop_sequence/three", resp["stop_reason"], resp["stop_sequence"])
+	}
+}
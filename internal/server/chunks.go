@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+// decodeProxyChunk parses the raw SSE lines forwarded by the proxy into typed
+// chat completion chunks. The second return value reports whether the
+// upstream [DONE] marker was seen.
+func decodeProxyChunk(chunk []byte) ([]*types.ChatCompletionChunk, bool) {
+	lines := strings.Split(string(chunk), "\n")
+	decoded := make([]*types.ChatCompletionChunk, 0, 1)
+	done := false
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "data:") {
+			continue
+		}
+
+		payload := strings.TrimSpace(strings.TrimPrefix(trimmed, "data:"))
+		if payload == "" {
+			continue
+		}
+		if payload == "[DONE]" {
+			done = true
+			continue
+		}
+
+		var parsed types.ChatCompletionChunk
+		if err := json.Unmarshal([]byte(payload), &parsed); err != nil {
+			continue
+		}
+		decoded = append(decoded, &parsed)
+	}
+
+	return decoded, done
+}
+
+func messageText(content interface{}) string {
+	switch value := content.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case []interface{}:
+		var builder strings.Builder
+		for _, part := range value {
+			partMap, ok := part.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if text, ok := partMap["text"].(string); ok {
+				builder.WriteString(text)
+			}
+		}
+		return builder.String()
+	default:
+		return ""
+	}
+}
+
+func newObjectID(prefix string) string {
+	return prefix + strings.ReplaceAll(account.GenerateUUID(), "-", "")
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/rogeecn/iflow-go/internal/proxy"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+// upstreamFailure maps a proxy error onto the error returned to clients.
+// action prefixes messages of errors that did not come from iFlow itself.
+func upstreamFailure(err error, action string) proxy.OpenAIError {
+	if upstreamErr, ok := proxy.AsUpstreamError(err); ok {
+		return upstreamErr.OpenAI()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return proxy.OpenAIError{
+			Status:  http.StatusGatewayTimeout,
+			Type:    "timeout_error",
+			Code:    "upstream_timeout",
+			Message: fmt.Sprintf("%s: %v", action, err),
+		}
+	}
+	return proxy.OpenAIError{
+		Status:  http.StatusBadGateway,
+		Type:    "api_error",
+		Code:    "upstream_error",
+		Message: fmt.Sprintf("%s: %v", action, err),
+	}
+}
+
+func writeUpstreamError(w http.ResponseWriter, err error, action string) {
+	setUpstreamRetryAfter(w, err)
+	failure := upstreamFailure(err, action)
+	writeAPIError(w, failure.Status, failure.Message, failure.Type, failure.Code)
+}
+
+func writeAnthropicUpstreamError(w http.ResponseWriter, err error, action string) {
+	setUpstreamRetryAfter(w, err)
+	failure := upstreamFailure(err, action)
+	writeAnthropicError(w, failure.Status, anthropicErrorType(failure.Status), failure.Message)
+}
+
+func setUpstreamRetryAfter(w http.ResponseWriter, err error) {
+	upstreamErr, ok := proxy.AsUpstreamError(err)
+	if !ok || upstreamErr.RetryAfter <= 0 {
+		return
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(upstreamErr.RetryAfter.Seconds()))))
+}
+
+func anthropicErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case http.StatusServiceUnavailable:
+		return "overloaded_error"
+	default:
+		return "api_error"
+	}
+}
+
+// streamErrorFromChunks returns the first in-stream error carried by chunks.
+func streamErrorFromChunks(chunks []*types.ChatCompletionChunk) *types.ErrorDetail {
+	for _, chunk := range chunks {
+		if chunk.Error != nil {
+			return chunk.Error
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/internal/proxy"
+)
+
+func decodeErrorBody(t *testing.T, rec *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+
+	var body struct {
+		Error map[string]interface{} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode error body: %v: %s", err, rec.Body.String())
+	}
+	return body.Error
+}
+
+func TestChatCompletionsMapsModelNotFound(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+	s.newProxy = func(*account.Account) proxyClient {
+		return &fakeProxy{chatErr: &proxy.UpstreamError{
+			Op:         "chat completions",
+			StatusCode: http.StatusBadRequest,
+			Code:       "model_not_found",
+			Message:    "model glm-9 does not exist",
+		}}
+	}
+
+	body := `{"model":"glm-9","messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d, body=%s", rec.Code, http.StatusNotFound, rec.Body.String())
+	}
+	detail := decodeErrorBody(t, rec)
+	if detail["code"] != "model_not_found" || detail["type"] != "invalid_request_error" {
+		t.Fatalf("unexpected error detail: %v", detail)
+	}
+	if detail["message"] != "model glm-9 does not exist" {
+		t.Fatalf("message = %v", detail["message"])
+	}
+}
+
+func TestChatCompletionsStreamMapsRateLimit(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+	s.wait = func(context.Context, time.Duration) error { return nil }
+	s.newProxy = func(*account.Account) proxyClient {
+		return &fakeProxy{streamErr: &proxy.UpstreamError{
+			Op:         "chat stream",
+			StatusCode: http.StatusTooManyRequests,
+			Message:    "slow down",
+			RetryAfter: 1500 * time.Millisecond,
+		}}
+	}
+
+	body := `{"model":"glm-5","stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d, body=%s", rec.Code, http.StatusTooManyRequests, rec.Body.String())
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("Retry-After = %q, want 2", got)
+	}
+	if detail := decodeErrorBody(t, rec); detail["code"] != "rate_limit_exceeded" {
+		t.Fatalf("unexpected error detail: %v", detail)
+	}
+}
+
+func TestChatCompletionsStreamErrorEvent(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+
+	ch := make(chan []byte, 2)
+	ch <- []byte("data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hel\"}}]}\n\n")
+	ch <- []byte("data: {\"error\":{\"message\":\"upstream stream interrupted: EOF\",\"type\":\"api_error\",\"code\":\"stream_interrupted\"}}\n\n")
+	close(ch)
+	s.newProxy = func(*account.Account) proxyClient {
+		return &fakeProxy{stream: ch}
+	}
+
+	body := `{"model":"glm-5","stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	out := rec.Body.String()
+	if !strings.Contains(out, "event: error\ndata: {\"error\":") || !strings.Contains(out, `"code":"stream_interrupted"`) {
+		t.Fatalf("stream missing error event: %s", out)
+	}
+	if strings.Contains(out, "[DONE]") {
+		t.Fatalf("failed stream should not end with [DONE]: %s", out)
+	}
+}
+
+func TestMessagesMapsContextLength(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+	s.newProxy = func(*account.Account) proxyClient {
+		return &fakeProxy{chatErr: &proxy.UpstreamError{
+			Op:         "chat completions",
+			StatusCode: http.StatusBadRequest,
+			Message:    "input is too long for the maximum context length",
+		}}
+	}
+
+	body := `{"model":"glm-5","max_tokens":64,"messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	req.Header.Set("x-api-key", acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d, body=%s", rec.Code, http.StatusBadRequest, rec.Body.String())
+	}
+	if detail := decodeErrorBody(t, rec); detail["type"] != "invalid_request_error" {
+		t.Fatalf("unexpected error detail: %v", detail)
+	}
+}
+
+func TestMessagesStreamErrorEvent(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+
+	ch := make(chan []byte, 2)
+	ch <- []byte("data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hel\"}}]}\n\n")
+	ch <- []byte("data: {\"error\":{\"message\":\"upstream stream idle timeout after 2m0s\",\"type\":\"timeout_error\",\"code\":\"stream_timeout\"}}\n\n")
+	close(ch)
+	s.newProxy = func(*account.Account) proxyClient {
+		return &fakeProxy{stream: ch}
+	}
+
+	body := `{"model":"glm-5","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	req.Header.Set("x-api-key", acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	out := rec.Body.String()
+	if !strings.Contains(out, "event: error") || !strings.Contains(out, `"type":"api_error"`) {
+		t.Fatalf("stream missing error event: %s", out)
+	}
+	if strings.Contains(out, "event: message_stop") {
+		t.Fatalf("failed stream should not send message_stop: %s", out)
+	}
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/internal/apikey"
+	"github.com/rogeecn/iflow-go/internal/proxy"
+	"github.com/rogeecn/iflow-go/internal/ratelimit"
+	"github.com/rogeecn/iflow-go/pkg/types"
+	"github.com/rs/zerolog/log"
+)
+
+var errMissingAccount = errors.New("missing account context")
+
+const (
+	maxSameAccountRetries = 2
+	retryBaseDelay        = 500 * time.Millisecond
+	maxRetryDelay         = 10 * time.Second
+)
+
+// accountBinding tracks the account serving the current request. In pool
+// mode it can be rebound to another healthy account when the upstream fails
+// before any bytes reached the client.
+type accountBinding struct {
+	account *account.Account
+	lease   *account.Lease
+	pool    *account.Pool
+	key     *apikey.Key
+	tried   []string
+
+	// admission and slot hold the admission control slot of the serving
+	// account, which moves with the request on failover.
+	admission *Admission
+	slot      func()
+
+	// limiter checks the limits of each account the request moves to, so
+	// the account charged afterwards is also the one that was admitted.
+	limiter *ratelimit.Limiter
+}
+
+func (b *accountBinding) failover(ctx context.Context) bool {
+	if b.pool == nil {
+		return false
+	}
+
+	b.tried = append(b.tried, b.account.UUID)
+	lease, err := b.pool.Acquire(b.tried...)
+	if err != nil {
+		return false
+	}
+	if subject, ok := accountSubject(lease.Account); ok && b.limiter != nil {
+		if decision := b.limiter.Allow(subject); !decision.Allowed {
+			lease.Release()
+			log.Warn().
+				Str("account_uuid", lease.Account.UUID).
+				Str("reason", decision.Reason).
+				Msg("failover account rate limited")
+			return false
+		}
+	}
+
+	if b.admission != nil {
+		// Give up the old slot first so a saturated global limit cannot
+		// deadlock requests that wait while holding one.
+		b.releaseSlot()
+		slot, _, err := b.admission.Acquire(ctx, lease.Account.UUID)
+		if err != nil {
+			lease.Release()
+			log.Warn().
+				Err(err).
+				Str("account_uuid", lease.Account.UUID).
+				Msg("failover account not admitted")
+			return false
+		}
+		b.slot = slot
+	}
+
+	b.lease.Release()
+	b.lease = lease
+	b.account = lease.Account
+	return true
+}
+
+func (b *accountBinding) releaseSlot() {
+	if b.slot != nil {
+		b.slot()
+		b.slot = nil
+	}
+}
+
+// owner identifies the caller that stored responses belong to. Client keys
+// own their responses even when several share an account; other pool
+// requests land on a different account each time, so they share the pool.
+func (b *accountBinding) owner() string {
+	if b.key != nil {
+		return "key:" + b.key.ID
+	}
+	if b.pool != nil {
+		return "pool"
+	}
+	return b.account.UUID
+}
+
+func (b *accountBinding) release() {
+	b.lease.Release()
+}
+
+func (s *Server) chatWithFailover(ctx context.Context, w http.ResponseWriter, req *types.ChatCompletionRequest) (*types.ChatCompletionResponse, *account.Account, error) {
+	var resp *types.ChatCompletionResponse
+	acct, err := s.withFailover(ctx, w, req.Model, true, func(client proxyClient) error {
+		var callErr error
+		resp, callErr = client.ChatCompletions(ctx, req)
+		return callErr
+	})
+	return resp, acct, err
+}
+
+// streamWithFailover only retries while opening the stream; once chunks flow
+// the client already owns the response and errors are surfaced in-stream.
+func (s *Server) streamWithFailover(ctx context.Context, w http.ResponseWriter, req *types.ChatCompletionRequest) (<-chan []byte, *account.Account, error) {
+	var stream <-chan []byte
+	acct, err := s.withFailover(ctx, w, req.Model, false, func(client proxyClient) error {
+		var callErr error
+		stream, callErr = client.ChatCompletionsStream(ctx, req)
+		return callErr
+	})
+	return stream, acct, err
+}
+
+// withFailover returns the account that served the last attempt. It is never
+// nil, so callers may log it on every path.
+func (s *Server) withFailover(ctx context.Context, w http.ResponseWriter, model string, backoff bool, call func(proxyClient) error) (*account.Account, error) {
+	binding, ok := bindingFromContext(ctx)
+	if !ok {
+		return &account.Account{}, errMissingAccount
+	}
+
+	retries := 0
+	for {
+		acct := binding.account
+		err := call(s.newProxy(acct))
+		if err == nil {
+			return acct, nil
+		}
+
+		upstreamErr, ok := proxy.AsUpstreamError(err)
+		if !ok || !upstreamErr.Retryable() {
+			return acct, err
+		}
+
+		// Cool the account down only when another account takes over: with
+		// nothing to fail over to, a cooldown would just lock out the only
+		// account for later requests.
+		if binding.failover(ctx) {
+			until, markErr := s.accountMgr.MarkFailure(acct.UUID, upstreamErr.RetryAfter)
+			if markErr != nil {
+				log.Warn().
+					Err(markErr).
+					Str("account_uuid", acct.UUID).
+					Msg("failed to mark account failure")
+			}
+			setAccountHeader(w, binding.account.UUID)
+			observeAccount(ctx, binding.account.UUID)
+			log.Warn().
+				Int("status", upstreamErr.StatusCode).
+				Str("from_account", acct.UUID).
+				Str("to_account", binding.account.UUID).
+				Str("model", model).
+				Time("cooldown_until", until).
+				Msg("upstream request failed, failing over to next pool account")
+			continue
+		}
+
+		log.Warn().
+			Int("status", upstreamErr.StatusCode).
+			Str("account_uuid", acct.UUID).
+			Str("model", model).
+			Msg("upstream request failed, no other account to fail over to")
+
+		// A request whose slot was given up for a failover that was not
+		// admitted must not retry outside admission control.
+		if binding.admission != nil && binding.slot == nil {
+			return acct, err
+		}
+		if !backoff || upstreamErr.StatusCode == http.StatusUnauthorized || retries >= maxSameAccountRetries {
+			return acct, err
+		}
+		delay := retryDelay(retries, upstreamErr.RetryAfter)
+		if delay > maxRetryDelay {
+			return acct, err
+		}
+		retries++
+
+		log.Debug().
+			Str("account_uuid", acct.UUID).
+			Str("model", model).
+			Int("attempt", retries).
+			Dur("delay", delay).
+			Msg("retrying upstream request after backoff")
+		if waitErr := s.wait(ctx, delay); waitErr != nil {
+			return acct, err
+		}
+	}
+}
+
+func retryDelay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	return retryBaseDelay << attempt
+}
+
+func waitContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/internal/config"
+	"github.com/rogeecn/iflow-go/internal/proxy"
+	"github.com/rogeecn/iflow-go/internal/ratelimit"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+func TestPoolFailoverOnRateLimit(t *testing.T) {
+	cfg := &config.Config{
+		Host:         "127.0.0.1",
+		Port:         28000,
+		DataDir:      t.TempDir(),
+		PoolKey:      "pool-secret",
+		PoolStrategy: "round_robin",
+	}
+	s := New(cfg)
+	first := createTestAccount(t, s)
+	second := createTestAccount(t, s)
+
+	finish := "stop"
+	calls := map[string]int{}
+	s.newProxy = func(acct *account.Account) proxyClient {
+		calls[acct.UUID]++
+		if acct.UUID == first.UUID {
+			return &fakeProxy{chatErr: &proxy.UpstreamError{Op: "chat completions", StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute}}
+		}
+		return &fakeProxy{
+			chatResp: &types.ChatCompletionResponse{
+				ID:      "chat-failover",
+				Choices: []types.Choice{{Message: &types.Message{Role: "assistant", Content: "ok"}, FinishReason: &finish}},
+			},
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		body := `{"model":"glm-5","messages":[{"role":"user","content":"hello"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer pool-secret")
+		rec := httptest.NewRecorder()
+		s.httpServer.Handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		if got := rec.Header().Get(accountHeader); got != maskToken(second.UUID) {
+			t.Fatalf("%s = %s, want %s", accountHeader, got, maskToken(second.UUID))
+		}
+	}
+
+	if calls[first.UUID] != 1 {
+		t.Fatalf("cooling account called %d times, want 1", calls[first.UUID])
+	}
+	if s.pool.InFlight(first.UUID) != 0 || s.pool.InFlight(second.UUID) != 0 {
+		t.Fatal("leases should be released after failover")
+	}
+
+	failed, err := s.accountMgr.Get(first.UUID)
+	if err != nil {
+		t.Fatalf("reload account: %v", err)
+	}
+	if !failed.InCooldown(time.Now()) || failed.FailureCount != 1 {
+		t.Fatalf("unexpected cooldown state: until=%s failures=%d", failed.CooldownUntil, failed.FailureCount)
+	}
+}
+
+func TestPoolFailoverMovesAdmissionSlot(t *testing.T) {
+	cfg := &config.Config{
+		Host:         "127.0.0.1",
+		Port:         28000,
+		DataDir:      t.TempDir(),
+		PoolKey:      "pool-secret",
+		PoolStrategy: "round_robin",
+		Concurrency:  1,
+	}
+	s := New(cfg)
+	first := createTestAccount(t, s)
+	second := createTestAccount(t, s)
+
+	finish := "stop"
+	var during AdmissionStats
+	s.newProxy = func(acct *account.Account) proxyClient {
+		if acct.UUID == first.UUID {
+			return &fakeProxy{chatErr: &proxy.UpstreamError{Op: "chat completions", StatusCode: http.StatusTooManyRequests}}
+		}
+		during = s.admission.Stats()
+		return &fakeProxy{
+			chatResp: &types.ChatCompletionResponse{
+				ID:      "chat-failover",
+				Choices: []types.Choice{{Message: &types.Message{Role: "assistant", Content: "ok"}, FinishReason: &finish}},
+			},
+		}
+	}
+
+	body := `{"model":"glm-5","messages":[{"role":"user","content":"hello"}]}`
+	if rec := serveWithToken(s, http.MethodPost, "/v1/chat/completions", body, "pool-secret"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	if during.Active != 1 || during.Accounts[maskToken(second.UUID)] != 1 || during.Accounts[maskToken(first.UUID)] != 0 {
+		t.Fatalf("admission slot should follow the failover account: %+v", during)
+	}
+	if after := s.admission.Stats(); after.Active != 0 || len(after.Accounts) != 0 {
+		t.Fatalf("admission slot should be released: %+v", after)
+	}
+}
+
+func TestPoolFailoverChecksTargetAccountLimits(t *testing.T) {
+	cfg := &config.Config{
+		Host:         "127.0.0.1",
+		Port:         28000,
+		DataDir:      t.TempDir(),
+		PoolKey:      "pool-secret",
+		PoolStrategy: "round_robin",
+	}
+	s := New(cfg)
+	first := createTestAccount(t, s)
+	second := createTestAccount(t, s)
+	limits := ratelimit.Limits{DailyTokens: 40}
+	if err := s.accountMgr.SetLimits(second.UUID, limits); err != nil {
+		t.Fatalf("SetLimits() error = %v", err)
+	}
+	if err := s.limiter.Charge(ratelimit.Subject{Name: "account:" + second.UUID, Limits: limits}, 40); err != nil {
+		t.Fatalf("Charge() error = %v", err)
+	}
+
+	servedBySecond := false
+	s.newProxy = func(acct *account.Account) proxyClient {
+		if acct.UUID == first.UUID {
+			return &fakeProxy{chatErr: &proxy.UpstreamError{Op: "chat completions", StatusCode: http.StatusTooManyRequests}}
+		}
+		servedBySecond = true
+		return &fakeProxy{chatResp: &types.ChatCompletionResponse{ID: "chat-over-budget"}}
+	}
+
+	body := `{"model":"glm-5","messages":[{"role":"user","content":"hello"}]}`
+	rec := serveWithToken(s, http.MethodPost, "/v1/chat/completions", body, "pool-secret")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d, body=%s", rec.Code, http.StatusTooManyRequests, rec.Body.String())
+	}
+	if servedBySecond {
+		t.Fatal("failover should not use an account over its token budget")
+	}
+}
+
+func TestSingleAccountFailureSkipsCooldown(t *testing.T) {
+	cfg := &config.Config{
+		Host:         "127.0.0.1",
+		Port:         28000,
+		DataDir:      t.TempDir(),
+		PoolKey:      "pool-secret",
+		PoolStrategy: "round_robin",
+	}
+	s := New(cfg)
+	acct := createTestAccount(t, s)
+	s.wait = func(context.Context, time.Duration) error { return nil }
+
+	calls := 0
+	s.newProxy = func(*account.Account) proxyClient {
+		calls++
+		return &fakeProxy{chatErr: &proxy.UpstreamError{Op: "chat completions", StatusCode: http.StatusTooManyRequests}}
+	}
+
+	body := `{"model":"glm-5","messages":[{"role":"user","content":"hello"}]}`
+	for i := 0; i < 2; i++ {
+		if rec := serveWithToken(s, http.MethodPost, "/v1/chat/completions", body, "pool-secret"); rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("status = %d, want %d, body=%s", rec.Code, http.StatusTooManyRequests, rec.Body.String())
+		}
+	}
+	// Each request retried on the same account and none was refused by the pool.
+	if want := 2 * (maxSameAccountRetries + 1); calls != want {
+		t.Fatalf("upstream calls = %d, want %d", calls, want)
+	}
+
+	updated, err := s.accountMgr.Get(acct.UUID)
+	if err != nil {
+		t.Fatalf("reload account: %v", err)
+	}
+	if updated.InCooldown(time.Now()) || updated.FailureCount != 0 {
+		t.Fatalf("only account should not cool down: until=%s failures=%d", updated.CooldownUntil, updated.FailureCount)
+	}
+}
+
+func TestChatBackoffRetrySameAccount(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+
+	var delays []time.Duration
+	s.wait = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	finish := "stop"
+	attempts := 0
+	s.newProxy = func(*account.Account) proxyClient {
+		attempts++
+		if attempts < 3 {
+			return &fakeProxy{chatErr: &proxy.UpstreamError{Op: "chat completions", StatusCode: http.StatusServiceUnavailable}}
+		}
+		return &fakeProxy{
+			chatResp: &types.ChatCompletionResponse{
+				ID:      "chat-retry",
+				Choices: []types.Choice{{Message: &types.Message{Role: "assistant", Content: "ok"}, FinishReason: &finish}},
+			},
+		}
+	}
+
+	body := `{"model":"glm-5","messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if len(delays) != 2 || delays[0] != retryBaseDelay || delays[1] != 2*retryBaseDelay {
+		t.Fatalf("backoff delays = %v", delays)
+	}
+
+	updated, err := s.accountMgr.Get(acct.UUID)
+	if err != nil {
+		t.Fatalf("reload account: %v", err)
+	}
+	if updated.InCooldown(time.Now()) || updated.RequestCount != 1 {
+		t.Fatalf("success should clear cooldown: until=%s requests=%d", updated.CooldownUntil, updated.RequestCount)
+	}
+}
+
+func TestStreamDoesNotBackoff(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+	s.wait = func(context.Context, time.Duration) error {
+		t.Fatal("stream requests should not back off")
+		return nil
+	}
+
+	attempts := 0
+	s.newProxy = func(*account.Account) proxyClient {
+		attempts++
+		return &fakeProxy{streamErr: &proxy.UpstreamError{Op: "chat stream", StatusCode: http.StatusBadGateway}}
+	}
+
+	body := `{"model":"glm-5","stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if attempts != 1 {
+		t.Fatalf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestChatWithoutBindingReturnsAccount(t *testing.T) {
+	s := newTestServer(t)
+	rec := httptest.NewRecorder()
+
+	_, acct, err := s.chatWithFailover(context.Background(), rec, &types.ChatCompletionRequest{Model: "glm-5"})
+	if err == nil || acct == nil {
+		t.Fatalf("chatWithFailover() = %v, %v; want error and a non-nil account", acct, err)
+	}
+	// Handlers log the account on the error path.
+	rec = httptest.NewRecorder()
+	s.handleStreamChatCompletions(context.Background(), rec, &types.ChatCompletionRequest{Model: "glm-5", Stream: true})
+	if rec.Code < http.StatusBadRequest {
+		t.Fatalf("status = %d, want an error status", rec.Code)
+	}
+}
@@ -4,12 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/rogeecn/iflow-go/internal/proxy"
 	"github.com/rogeecn/iflow-go/pkg/types"
 	"github.com/rs/zerolog/log"
 )
@@ -51,19 +51,14 @@ func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
 		Str("account_uuid", acct.UUID).
 		Msg("serving models list")
 
-	models := s.newProxy(acct).Models()
+	models := s.modelCatalog.Models(r.Context(), acct.UUID, s.newProxy(acct).FetchModels)
 	now := time.Now().Unix()
 	data := make([]map[string]interface{}, 0, len(models))
 	for _, m := range models {
-		data = append(data, map[string]interface{}{
-			"id":         m.ID,
-			"object":     "model",
-			"created":    now,
-			"owned_by":   "iflow",
-			"permission": []interface{}{},
-			"root":       m.ID,
-			"parent":     nil,
-		})
+		if !modelAllowed(r.Context(), m.ID) {
+			continue
+		}
+		data = append(data, modelObject(m, now))
 	}
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
@@ -72,6 +67,82 @@ func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (s *Server) handleModel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		log.Warn().
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Msg("model endpoint rejected invalid method")
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed", "invalid_request_error", "method_not_allowed")
+		return
+	}
+
+	acct, ok := accountFromContext(r.Context())
+	if !ok {
+		log.Error().
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Msg("model endpoint missing account context")
+		writeAPIError(w, http.StatusUnauthorized, "missing account context", "invalid_request_error", "invalid_api_key")
+		return
+	}
+
+	id := strings.TrimSpace(r.PathValue("id"))
+	for _, m := range s.modelCatalog.Models(r.Context(), acct.UUID, s.newProxy(acct).FetchModels) {
+		if strings.EqualFold(m.ID, id) && modelAllowed(r.Context(), m.ID) {
+			writeJSON(w, http.StatusOK, modelObject(m, time.Now().Unix()))
+			return
+		}
+	}
+
+	log.Debug().
+		Str("account_uuid", acct.UUID).
+		Str("model", id).
+		Msg("model not found in catalog")
+	writeAPIError(w, http.StatusNotFound, "The model '"+id+"' does not exist", "invalid_request_error", "model_not_found")
+}
+
+func modelObject(m proxy.ModelConfig, created int64) map[string]interface{} {
+	object := map[string]interface{}{
+		"id":              m.ID,
+		"object":          "model",
+		"created":         created,
+		"owned_by":        "iflow",
+		"permission":      []interface{}{},
+		"root":            m.ID,
+		"parent":          nil,
+		"name":            m.Name,
+		"supports_vision": m.SupportsVision,
+	}
+	if m.Description != "" {
+		object["description"] = m.Description
+	}
+	if m.ContextLength > 0 {
+		object["context_length"] = m.ContextLength
+	}
+	return object
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		log.Warn().
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Msg("stats endpoint rejected invalid method")
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed", "invalid_request_error", "method_not_allowed")
+		return
+	}
+
+	payload := map[string]interface{}{
+		"object":    "stats",
+		"admission": nil,
+	}
+	if s.admission != nil {
+		payload["admission"] = s.admission.Stats()
+	}
+	writeJSON(w, http.StatusOK, payload)
+}
+
 func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		log.Warn().
@@ -117,7 +188,24 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 		writeAPIError(w, http.StatusBadRequest, "model and messages are required", "invalid_request_error", "bad_request")
 		return
 	}
+	if !modelAllowed(r.Context(), reqBody.Model) {
+		log.Warn().
+			Str("account_uuid", acct.UUID).
+			Str("model", reqBody.Model).
+			Msg("chat completions model not allowed for client key")
+		writeAPIError(w, http.StatusForbidden, "api key is not allowed to use model "+reqBody.Model, "permission_error", "model_not_allowed")
+		return
+	}
+	if err := applyReasoningOptions(r, &reqBody); err != nil {
+		log.Warn().
+			Err(err).
+			Str("account_uuid", acct.UUID).
+			Msg("chat completions invalid reasoning options")
+		writeAPIError(w, http.StatusBadRequest, err.Error(), "invalid_request_error", "bad_request")
+		return
+	}
 
+	observeModel(r.Context(), reqBody.Model)
 	log.Debug().
 		Str("account_uuid", acct.UUID).
 		Str("model", reqBody.Model).
@@ -125,29 +213,23 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 		Int("messages", len(reqBody.Messages)).
 		Msg("chat completions request accepted")
 
-	client := s.newProxy(acct)
 	if reqBody.Stream {
-		s.handleStreamChatCompletions(r.Context(), w, client, &reqBody, acct.UUID)
+		s.handleStreamChatCompletions(r.Context(), w, &reqBody)
 		return
 	}
 
-	resp, err := client.ChatCompletions(r.Context(), &reqBody)
+	resp, acct, err := s.chatWithFailover(r.Context(), w, &reqBody)
 	if err != nil {
 		log.Warn().
 			Err(err).
 			Str("account_uuid", acct.UUID).
 			Str("model", reqBody.Model).
 			Msg("chat completions upstream request failed")
-		writeAPIError(w, http.StatusBadGateway, fmt.Sprintf("upstream request failed: %v", err), "api_error", "upstream_error")
+		writeUpstreamError(w, err, "upstream request failed")
 		return
 	}
 
-	if err := s.accountMgr.UpdateUsage(acct.UUID); err != nil {
-		log.Warn().
-			Err(err).
-			Str("account_uuid", acct.UUID).
-			Msg("failed to update account usage")
-	}
+	s.recordUsage(r.Context(), acct.UUID, reqBody.Model, &resp.Usage)
 	log.Debug().
 		Str("account_uuid", acct.UUID).
 		Str("model", reqBody.Model).
@@ -155,17 +237,19 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, resp)
 }
 
-func (s *Server) handleStreamChatCompletions(ctx context.Context, w http.ResponseWriter, client proxyClient, reqBody *types.ChatCompletionRequest, uuid string) {
-	stream, err := client.ChatCompletionsStream(ctx, reqBody)
+func (s *Server) handleStreamChatCompletions(ctx context.Context, w http.ResponseWriter, reqBody *types.ChatCompletionRequest) {
+	stream, acct, err := s.streamWithFailover(ctx, w, reqBody)
 	if err != nil {
 		log.Warn().
 			Err(err).
-			Str("account_uuid", uuid).
+			Str("account_uuid", acct.UUID).
 			Str("model", reqBody.Model).
 			Msg("chat completions stream request failed")
-		writeAPIError(w, http.StatusBadGateway, fmt.Sprintf("upstream stream failed: %v", err), "api_error", "upstream_error")
+		writeUpstreamError(w, err, "upstream stream failed")
 		return
 	}
+	uuid := acct.UUID
+	defer trackStream(ctx)()
 
 	sse, err := NewSSEWriter(w)
 	if err != nil {
@@ -177,7 +261,11 @@ func (s *Server) handleStreamChatCompletions(ctx context.Context, w http.Respons
 		return
 	}
 
+	pings := newHeartbeat(s.config.SSEKeepalive)
+	defer pings.Stop()
+
 	doneWritten := false
+	var usage *types.Usage
 	for {
 		select {
 		case <-ctx.Done():
@@ -186,23 +274,31 @@ func (s *Server) handleStreamChatCompletions(ctx context.Context, w http.Respons
 				Str("model", reqBody.Model).
 				Msg("chat completions stream cancelled by context")
 			return
+		case <-pings.C():
+			if err := sse.WritePing(); err != nil {
+				log.Warn().
+					Err(err).
+					Str("account_uuid", uuid).
+					Msg("chat completions stream keepalive failed")
+				return
+			}
 		case chunk, ok := <-stream:
 			if !ok {
 				if !doneWritten {
 					_ = sse.WriteDone()
 				}
-				if err := s.accountMgr.UpdateUsage(uuid); err != nil {
-					log.Warn().
-						Err(err).
-						Str("account_uuid", uuid).
-						Msg("failed to update account usage")
-				}
+				s.recordUsage(ctx, uuid, reqBody.Model, usage)
 				log.Debug().
 					Str("account_uuid", uuid).
 					Str("model", reqBody.Model).
 					Msg("chat completions stream finished")
 				return
 			}
+			observeFirstToken(ctx)
+			pings.Reset()
+			if chunkUsage := lastChunkUsage(chunk); chunkUsage != nil {
+				usage = chunkUsage
+			}
 
 			wroteDone, writeErr := writeProxyChunkAsSSE(sse, chunk)
 			if writeErr != nil {
@@ -220,6 +316,16 @@ func (s *Server) handleStreamChatCompletions(ctx context.Context, w http.Respons
 	}
 }
 
+func isStreamError(payload string) bool {
+	if !strings.Contains(payload, `"error"`) {
+		return false
+	}
+	var probe struct {
+		Error *types.ErrorDetail `json:"error"`
+	}
+	return json.Unmarshal([]byte(payload), &probe) == nil && probe.Error != nil
+}
+
 func writeProxyChunkAsSSE(sse *SSEWriter, chunk []byte) (bool, error) {
 	lines := strings.Split(string(chunk), "\n")
 	doneWritten := false
@@ -239,6 +345,13 @@ func writeProxyChunkAsSSE(sse *SSEWriter, chunk []byte) (bool, error) {
 				doneWritten = true
 				continue
 			}
+			if isStreamError(payload) {
+				// An in-stream error ends the response; no [DONE] follows it.
+				if err := sse.WriteNamedEvent("error", payload); err != nil {
+					return doneWritten, err
+				}
+				return true, nil
+			}
 			if err := sse.WriteEvent(payload); err != nil {
 				return doneWritten, err
 			}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rogeecn/iflow-go/internal/metrics"
+)
+
+const observationContextKey contextKey = "observation"
+
+// requestObservation collects the labels of one request while it travels
+// through auth and the handler, and reports them once the response is done.
+type requestObservation struct {
+	route string
+	start time.Time
+
+	mu         sync.Mutex
+	model      string
+	account    string
+	firstToken sync.Once
+}
+
+func MetricsMiddleware(route string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			obs := &requestObservation{route: route, start: time.Now()}
+			rec := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), observationContextKey, obs)))
+
+			obs.mu.Lock()
+			model, acct := metrics.ModelLabel(obs.model), metrics.AccountLabel(obs.account)
+			obs.mu.Unlock()
+			status := metrics.StatusLabel(rec.statusCode)
+			metrics.HTTPRequests.WithLabelValues(route, status, model, acct).Inc()
+			metrics.HTTPDuration.WithLabelValues(route, status, model, acct).Observe(time.Since(obs.start).Seconds())
+		})
+	}
+}
+
+func observationFromContext(ctx context.Context) *requestObservation {
+	obs, _ := ctx.Value(observationContextKey).(*requestObservation)
+	return obs
+}
+
+func observeAccount(ctx context.Context, uuid string) {
+	if obs := observationFromContext(ctx); obs != nil {
+		obs.mu.Lock()
+		obs.account = uuid
+		obs.mu.Unlock()
+	}
+}
+
+func observeModel(ctx context.Context, model string) {
+	if obs := observationFromContext(ctx); obs != nil {
+		obs.mu.Lock()
+		obs.model = model
+		obs.mu.Unlock()
+	}
+}
+
+// observeFirstToken records time-to-first-token once per request.
+func observeFirstToken(ctx context.Context) {
+	obs := observationFromContext(ctx)
+	if obs == nil {
+		return
+	}
+	obs.firstToken.Do(func() {
+		obs.mu.Lock()
+		model, acct := metrics.ModelLabel(obs.model), metrics.AccountLabel(obs.account)
+		obs.mu.Unlock()
+		metrics.StreamTTFT.WithLabelValues(obs.route, model, acct).Observe(time.Since(obs.start).Seconds())
+	})
+}
+
+// trackStream marks a stream as in flight and returns the function that ends it.
+func trackStream(ctx context.Context) func() {
+	route := "unknown"
+	if obs := observationFromContext(ctx); obs != nil {
+		route = obs.route
+	}
+	gauge := metrics.StreamsInFlight.WithLabelValues(route)
+	gauge.Inc()
+	return gauge.Dec
+}
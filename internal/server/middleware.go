@@ -2,12 +2,16 @@ package server
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/netip"
 	"strings"
 	"time"
 
 	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/internal/apikey"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -17,6 +21,8 @@ type contextKey string
 const (
 	accountContextKey  contextKey = "account"
 	defaultMaxBodySize            = 4 << 20
+	accountHeader                 = "X-IFlow-Account"
+	anthropicRoute                = "/v1/messages"
 )
 
 type statusRecorder struct {
@@ -57,15 +63,26 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func AuthMiddleware(manager *account.Manager) func(http.Handler) http.Handler {
+// Authenticator resolves client credentials to the account serving a request.
+// Issued client keys are the normal credential; the shared pool key and raw
+// account UUIDs (when LegacyUUID is set) are kept for existing deployments.
+type Authenticator struct {
+	Manager    *account.Manager
+	Pool       *account.Pool
+	PoolKey    string
+	Keys       *apikey.Store
+	LegacyUUID bool
+}
+
+func AuthMiddleware(auth *Authenticator, route string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if manager == nil {
+			if auth == nil || auth.Manager == nil {
 				writeAPIError(w, http.StatusInternalServerError, "server misconfigured", "internal_error", "internal_error")
 				return
 			}
 
-			token, ok := parseBearerToken(r.Header.Get("Authorization"))
+			token, ok := requestToken(r, route)
 			if !ok {
 				log.Warn().
 					Str("method", r.Method).
@@ -76,7 +93,30 @@ func AuthMiddleware(manager *account.Manager) func(http.Handler) http.Handler {
 				return
 			}
 
-			acct, err := manager.Get(token)
+			if auth.Pool != nil && isPoolKey(token, auth.PoolKey) {
+				serveFromPool(w, r, next, auth.Pool, nil)
+				return
+			}
+
+			if auth.Keys != nil && strings.HasPrefix(token, apikey.Prefix) {
+				auth.serveClientKey(w, r, next, token, route)
+				return
+			}
+
+			if !auth.LegacyUUID {
+				event := log.Warn().
+					Str("method", r.Method).
+					Str("path", r.URL.Path).
+					Str("account_token", maskToken(token))
+				if account.IsValidUUID(token) {
+					event = event.Str("hint", "issue a client key with `iflow-go key create` or set IFLOW_LEGACY_UUID_AUTH=true")
+				}
+				event.Msg("request rejected: unknown client key")
+				writeAPIError(w, http.StatusUnauthorized, "invalid api key", "invalid_request_error", "invalid_api_key")
+				return
+			}
+
+			acct, err := auth.Manager.Get(token)
 			if err != nil {
 				log.Warn().
 					Err(err).
@@ -94,12 +134,145 @@ func AuthMiddleware(manager *account.Manager) func(http.Handler) http.Handler {
 				Str("account_uuid", acct.UUID).
 				Msg("request authenticated")
 
-			ctx := context.WithValue(r.Context(), accountContextKey, acct)
+			observeAccount(r.Context(), acct.UUID)
+			ctx := context.WithValue(r.Context(), accountContextKey, &accountBinding{account: acct})
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+func (auth *Authenticator) serveClientKey(w http.ResponseWriter, r *http.Request, next http.Handler, token, route string) {
+	key, err := auth.Keys.Authenticate(token)
+	if err != nil {
+		event := log.Warn().
+			Err(err).
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Str("account_token", maskToken(token))
+		if key != nil {
+			event = event.Str("key_id", key.ID)
+		}
+		event.Msg("request rejected: client key lookup failed")
+
+		switch {
+		case errors.Is(err, apikey.ErrRevoked):
+			writeAPIError(w, http.StatusUnauthorized, "api key revoked", "invalid_request_error", "invalid_api_key")
+		case errors.Is(err, apikey.ErrExpired):
+			writeAPIError(w, http.StatusUnauthorized, "api key expired", "invalid_request_error", "invalid_api_key")
+		case errors.Is(err, apikey.ErrNotFound):
+			writeAPIError(w, http.StatusUnauthorized, "invalid api key", "invalid_request_error", "invalid_api_key")
+		default:
+			writeAPIError(w, http.StatusInternalServerError, "api key lookup failed", "internal_error", "internal_error")
+		}
+		return
+	}
+
+	if !key.AllowsRoute(route) {
+		log.Warn().
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Str("key_id", key.ID).
+			Msg("request rejected: route not allowed for client key")
+		writeAPIError(w, http.StatusForbidden, "api key is not allowed to access "+route, "permission_error", "route_not_allowed")
+		return
+	}
+	if addr, ok := remoteAddr(r); !ok || !key.AllowsAddr(addr) {
+		log.Warn().
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Str("key_id", key.ID).
+			Str("remote_addr", r.RemoteAddr).
+			Msg("request rejected: client address not allowed for client key")
+		writeAPIError(w, http.StatusForbidden, "api key is not allowed from this address", "permission_error", "ip_not_allowed")
+		return
+	}
+
+	if key.Pool {
+		serveFromPool(w, r, next, auth.Pool, key)
+		return
+	}
+
+	acct, err := auth.Manager.Get(key.Account)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Str("key_id", key.ID).
+			Msg("request rejected: client key account lookup failed")
+		writeAPIError(w, http.StatusUnauthorized, "api key account not found", "invalid_request_error", "invalid_api_key")
+		return
+	}
+
+	log.Debug().
+		Str("method", r.Method).
+		Str("path", r.URL.Path).
+		Str("key_id", key.ID).
+		Str("account_uuid", acct.UUID).
+		Msg("request authenticated via client key")
+
+	observeAccount(r.Context(), acct.UUID)
+	ctx := context.WithValue(r.Context(), accountContextKey, &accountBinding{account: acct, key: key})
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func serveFromPool(w http.ResponseWriter, r *http.Request, next http.Handler, pool *account.Pool, key *apikey.Key) {
+	if pool == nil {
+		writeAPIError(w, http.StatusInternalServerError, "server misconfigured", "internal_error", "internal_error")
+		return
+	}
+
+	lease, err := pool.Acquire()
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Msg("request rejected: account pool exhausted")
+		writeAPIError(w, http.StatusServiceUnavailable, "no account available in pool", "api_error", "pool_exhausted")
+		return
+	}
+	binding := &accountBinding{account: lease.Account, lease: lease, pool: pool, key: key}
+	defer binding.release()
+
+	event := log.Debug().
+		Str("method", r.Method).
+		Str("path", r.URL.Path).
+		Str("account_uuid", lease.Account.UUID).
+		Str("strategy", string(pool.Strategy()))
+	if key != nil {
+		event = event.Str("key_id", key.ID)
+	}
+	event.Msg("request authenticated via account pool")
+
+	setAccountHeader(w, lease.Account.UUID)
+	observeAccount(r.Context(), lease.Account.UUID)
+	ctx := context.WithValue(r.Context(), accountContextKey, binding)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// remoteAddr returns the peer address of the connection. Forwarding headers
+// are not trusted, so CIDR scopes see the reverse proxy when one is in front.
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		return addrPort.Addr(), true
+	}
+	if addr, err := netip.ParseAddr(r.RemoteAddr); err == nil {
+		return addr, true
+	}
+	return netip.Addr{}, false
+}
+
+// modelAllowed reports whether the client key behind ctx may use model.
+// Pool-key and legacy UUID requests carry no key and are unrestricted.
+func modelAllowed(ctx context.Context, model string) bool {
+	binding, ok := bindingFromContext(ctx)
+	if !ok || binding.key == nil {
+		return true
+	}
+	return binding.key.AllowsModel(model)
+}
+
 func RequestSizeLimitMiddleware(max int64) func(http.Handler) http.Handler {
 	if max <= 0 {
 		max = defaultMaxBodySize
@@ -115,6 +288,31 @@ func RequestSizeLimitMiddleware(max int64) func(http.Handler) http.Handler {
 	}
 }
 
+// requestToken reads the client credential from the Authorization bearer
+// header. The Anthropic endpoint also accepts the x-api-key header its SDKs
+// send.
+func requestToken(r *http.Request, route string) (string, bool) {
+	if token, ok := parseBearerToken(r.Header.Get("Authorization")); ok {
+		return token, true
+	}
+	if route != anthropicRoute {
+		return "", false
+	}
+
+	token := strings.TrimSpace(r.Header.Get("x-api-key"))
+	if token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+func isPoolKey(token, poolKey string) bool {
+	if poolKey == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(poolKey)) == 1
+}
+
 func parseBearerToken(header string) (string, bool) {
 	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
 	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
@@ -129,11 +327,19 @@ func parseBearerToken(header string) (string, bool) {
 }
 
 func accountFromContext(ctx context.Context) (*account.Account, bool) {
-	acct, ok := ctx.Value(accountContextKey).(*account.Account)
-	if !ok || acct == nil {
+	binding, ok := bindingFromContext(ctx)
+	if !ok {
 		return nil, false
 	}
-	return acct, true
+	return binding.account, true
+}
+
+func bindingFromContext(ctx context.Context) (*accountBinding, bool) {
+	binding, ok := ctx.Value(accountContextKey).(*accountBinding)
+	if !ok || binding == nil || binding.account == nil {
+		return nil, false
+	}
+	return binding, true
 }
 
 func accessLogEvent(path string, statusCode int) *zerolog.Event {
@@ -142,13 +348,19 @@ func accessLogEvent(path string, statusCode int) *zerolog.Event {
 		return log.Error()
 	case statusCode >= http.StatusBadRequest:
 		return log.Warn()
-	case path == "/health":
+	case path == "/health" || path == "/metrics":
 		return log.Debug()
 	default:
 		return log.Info()
 	}
 }
 
+// setAccountHeader reports the serving account by its masked UUID only: under
+// legacy auth the UUID itself is a bearer credential.
+func setAccountHeader(w http.ResponseWriter, uuid string) {
+	w.Header().Set(accountHeader, maskToken(uuid))
+}
+
 func maskToken(token string) string {
 	token = strings.TrimSpace(token)
 	if token == "" {
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/internal/apikey"
+	"github.com/rogeecn/iflow-go/internal/config"
+	"github.com/rogeecn/iflow-go/internal/proxy"
+	"github.com/rogeecn/iflow-go/internal/ratelimit"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+func newClientKeyServer(t *testing.T) (*Server, *account.Account) {
+	t.Helper()
+
+	s := New(&config.Config{Host: "127.0.0.1", Port: 28000, DataDir: t.TempDir()})
+	acct := createTestAccount(t, s)
+	finish := "stop"
+	s.newProxy = func(*account.Account) proxyClient {
+		return &fakeProxy{
+			models: []proxy.ModelConfig{{ID: "glm-5"}, {ID: "kimi-k2"}},
+			chatResp: &types.ChatCompletionResponse{
+				ID:      "chat-key",
+				Choices: []types.Choice{{Message: &types.Message{Role: "assistant", Content: "ok"}, FinishReason: &finish}},
+			},
+		}
+	}
+	return s, acct
+}
+
+func serveWithToken(s *Server, method, path, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestClientKeyScopes(t *testing.T) {
+	s, acct := newClientKeyServer(t)
+	secret, key, err := s.auth.Keys.Create(apikey.Options{
+		Account: acct.UUID,
+		Models:  []string{"glm-*"},
+		Routes:  []string{"/v1/chat/completions", "/v1/models"},
+		CIDRs:   []string{"192.0.2.0/24"},
+	})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	if rec := serveWithToken(s, http.MethodGet, "/v1/models", "", acct.UUID); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("account uuid status = %d, want 401 without legacy auth", rec.Code)
+	}
+
+	chat := `{"model":"glm-5","messages":[{"role":"user","content":"hi"}]}`
+	if rec := serveWithToken(s, http.MethodPost, "/v1/chat/completions", chat, secret); rec.Code != http.StatusOK {
+		t.Fatalf("chat status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+
+	other := `{"model":"kimi-k2","messages":[{"role":"user","content":"hi"}]}`
+	rec := serveWithToken(s, http.MethodPost, "/v1/chat/completions", other, secret)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "model_not_allowed") {
+		t.Fatalf("disallowed model status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+
+	rec = serveWithToken(s, http.MethodGet, "/v1/models", "", secret)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "kimi-k2") || !strings.Contains(rec.Body.String(), "glm-5") {
+		t.Fatalf("models should be filtered, status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+
+	rec = serveWithToken(s, http.MethodGet, "/v1/usage", "", secret)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "route_not_allowed") {
+		t.Fatalf("disallowed route status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+
+	if _, err := s.auth.Keys.Revoke(key.ID); err != nil {
+		t.Fatalf("revoke key: %v", err)
+	}
+	rec = serveWithToken(s, http.MethodPost, "/v1/chat/completions", chat, secret)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "api key revoked") {
+		t.Fatalf("revoked key status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestClientKeyAddressAndPool(t *testing.T) {
+	s, acct := newClientKeyServer(t)
+
+	remote, _, err := s.auth.Keys.Create(apikey.Options{Account: acct.UUID, CIDRs: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	rec := serveWithToken(s, http.MethodGet, "/v1/models", "", remote)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "ip_not_allowed") {
+		t.Fatalf("disallowed address status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+
+	pooled, _, err := s.auth.Keys.Create(apikey.Options{Pool: true})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	rec = serveWithToken(s, http.MethodGet, "/v1/models", "", pooled)
+	if rec.Code != http.StatusOK || rec.Header().Get(accountHeader) != maskToken(acct.UUID) {
+		t.Fatalf("pool key status = %d, account = %q", rec.Code, rec.Header().Get(accountHeader))
+	}
+}
+
+func TestRateLimitPerKeyAndAccount(t *testing.T) {
+	s, acct := newClientKeyServer(t)
+	finish := "stop"
+	s.newProxy = func(*account.Account) proxyClient {
+		return &fakeProxy{
+			chatResp: &types.ChatCompletionResponse{
+				ID:      "chat-limited",
+				Choices: []types.Choice{{Message: &types.Message{Role: "assistant", Content: "ok"}, FinishReason: &finish}},
+				Usage:   types.Usage{PromptTokens: 30, CompletionTokens: 20, TotalTokens: 50},
+			},
+		}
+	}
+	secret, _, err := s.auth.Keys.Create(apikey.Options{Account: acct.UUID, Limits: ratelimit.Limits{RPM: 1}})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	chat := `{"model":"glm-5","messages":[{"role":"user","content":"hi"}]}`
+
+	rec := serveWithToken(s, http.MethodPost, "/v1/chat/completions", chat, secret)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first request status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("x-ratelimit-limit-requests") != "1" || rec.Header().Get("x-ratelimit-remaining-requests") != "0" {
+		t.Fatalf("unexpected rate limit headers: %v", rec.Header())
+	}
+
+	rec = serveWithToken(s, http.MethodPost, "/v1/chat/completions", chat, secret)
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "rate_limit_exceeded") {
+		t.Fatalf("second request status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("Retry-After = %q, want 60", rec.Header().Get("Retry-After"))
+	}
+
+	// Account budgets apply to every key bound to the account.
+	if err := s.accountMgr.SetLimits(acct.UUID, ratelimit.Limits{DailyTokens: 40}); err != nil {
+		t.Fatalf("set account limits: %v", err)
+	}
+	other, _, err := s.auth.Keys.Create(apikey.Options{Account: acct.UUID})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	rec = serveWithToken(s, http.MethodPost, "/v1/chat/completions", chat, other)
+	if rec.Code != http.StatusOK || rec.Header().Get("x-ratelimit-remaining-tokens") != "40" {
+		t.Fatalf("budget request status = %d, headers=%v", rec.Code, rec.Header())
+	}
+	rec = serveWithToken(s, http.MethodPost, "/v1/chat/completions", chat, other)
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "daily token budget exhausted") {
+		t.Fatalf("exhausted budget status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAPIKeyHeaderOnlyOnMessages(t *testing.T) {
+	s, acct := newClientKeyServer(t)
+	secret, _, err := s.auth.Keys.Create(apikey.Options{Account: acct.UUID})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	chat := `{"model":"glm-5","messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(chat))
+	req.Header.Set("x-api-key", secret)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("x-api-key on chat status = %d, want 401", rec.Code)
+	}
+
+	messages := `{"model":"glm-5","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`
+	req = httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(messages))
+	req.Header.Set("x-api-key", secret)
+	rec = httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("x-api-key on messages status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/internal/ratelimit"
+	"github.com/rs/zerolog/log"
+)
+
+// RateLimitMiddleware enforces the limits of the client key and the account
+// bound by AuthMiddleware, so it must run after it.
+func RateLimitMiddleware(limiter *ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			binding, ok := bindingFromContext(r.Context())
+			if limiter == nil || !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			binding.limiter = limiter
+			subjects := rateLimitSubjects(binding)
+			if len(subjects) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			decision := limiter.Allow(subjects...)
+			setRateLimitHeaders(w.Header(), decision.Status)
+			if !decision.Allowed {
+				log.Warn().
+					Str("method", r.Method).
+					Str("path", r.URL.Path).
+					Str("account_uuid", binding.account.UUID).
+					Str("reason", decision.Reason).
+					Dur("retry_after", decision.RetryAfter).
+					Msg("request rejected: rate limit exceeded")
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(decision.RetryAfter)))
+				writeAPIError(w, http.StatusTooManyRequests, "rate limit exceeded: "+decision.Reason, "rate_limit_error", "rate_limit_exceeded")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func rateLimitSubjects(binding *accountBinding) []ratelimit.Subject {
+	var subjects []ratelimit.Subject
+	if binding.key != nil && !binding.key.Limits.IsZero() {
+		subjects = append(subjects, ratelimit.Subject{Name: "key:" + binding.key.ID, Limits: binding.key.Limits})
+	}
+	if subject, ok := accountSubject(binding.account); ok {
+		subjects = append(subjects, subject)
+	}
+	return subjects
+}
+
+func accountSubject(acct *account.Account) (ratelimit.Subject, bool) {
+	if acct.Limits.IsZero() {
+		return ratelimit.Subject{}, false
+	}
+	return ratelimit.Subject{Name: "account:" + acct.UUID, Limits: acct.Limits}, true
+}
+
+// chargeRateLimits debits the tokens of a finished request. After a failover
+// the binding already points at the account that actually served it.
+func (s *Server) chargeRateLimits(ctx context.Context, tokens int64) {
+	binding, ok := bindingFromContext(ctx)
+	if s.limiter == nil || !ok {
+		return
+	}
+	for _, subject := range rateLimitSubjects(binding) {
+		if err := s.limiter.Charge(subject, tokens); err != nil {
+			log.Warn().
+				Err(err).
+				Str("subject", subject.Name).
+				Msg("failed to charge rate limit budget")
+		}
+	}
+}
+
+func setRateLimitHeaders(header http.Header, status ratelimit.Status) {
+	if status.LimitRequests > 0 {
+		header.Set("x-ratelimit-limit-requests", strconv.Itoa(status.LimitRequests))
+		header.Set("x-ratelimit-remaining-requests", strconv.Itoa(status.RemainingRequests))
+		header.Set("x-ratelimit-reset-requests", formatReset(status.ResetRequests))
+	}
+	if status.LimitTokens > 0 {
+		header.Set("x-ratelimit-limit-tokens", strconv.FormatInt(status.LimitTokens, 10))
+		header.Set("x-ratelimit-remaining-tokens", strconv.FormatInt(status.RemainingTokens, 10))
+		header.Set("x-ratelimit-reset-tokens", formatReset(status.ResetTokens))
+	}
+}
+
+// formatReset renders durations like OpenAI does, e.g. "1s" or "6m0s".
+func formatReset(d time.Duration) string {
+	if d <= 0 {
+		return "0s"
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+func retryAfterSeconds(d time.Duration) int {
+	return max(int(math.Ceil(d.Seconds())), 1)
+}
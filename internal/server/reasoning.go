@@ -0,0 +1,47 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rogeecn/iflow-go/internal/proxy"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+const (
+	reasoningEffortHeader   = "X-IFlow-Reasoning-Effort"
+	preserveReasoningHeader = "X-IFlow-Preserve-Reasoning"
+)
+
+// applyReasoningOptions validates the request's reasoning controls, applies
+// the per-request headers, which take precedence over the body, and rejects
+// controls the model's profile cannot honor.
+func applyReasoningOptions(r *http.Request, req *types.ChatCompletionRequest) error {
+	if req.ReasoningEffort != "" {
+		effort, err := proxy.ParseReasoningEffort(req.ReasoningEffort)
+		if err != nil {
+			return err
+		}
+		req.ReasoningEffort = effort
+	}
+
+	if value := strings.TrimSpace(r.Header.Get(reasoningEffortHeader)); value != "" {
+		effort, err := proxy.ParseReasoningEffort(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", reasoningEffortHeader, err)
+		}
+		req.ReasoningEffort = effort
+		req.Thinking = nil
+	}
+
+	if value := strings.TrimSpace(r.Header.Get(preserveReasoningHeader)); value != "" {
+		preserve, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", preserveReasoningHeader, value)
+		}
+		req.PreserveReasoning = &preserve
+	}
+	return proxy.CheckReasoningSupport(req)
+}
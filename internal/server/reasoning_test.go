@@ -0,0 +1,109 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+func TestChatCompletionsReasoningHeaders(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+	fake := &fakeProxy{chatResp: &types.ChatCompletionResponse{ID: "chat-1"}}
+	s.newProxy = func(*account.Account) proxyClient { return fake }
+
+	body := `{"model":"glm-5","reasoning_effort":"high","thinking":{"type":"enabled","budget_tokens":4000},"messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	req.Header.Set(reasoningEffortHeader, "off")
+	req.Header.Set(preserveReasoningHeader, "false")
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+	if fake.lastReq.ReasoningEffort != "none" || fake.lastReq.Thinking != nil {
+		t.Fatalf("header should override body reasoning: effort=%q thinking=%+v", fake.lastReq.ReasoningEffort, fake.lastReq.Thinking)
+	}
+	if fake.lastReq.PreserveReasoning == nil || *fake.lastReq.PreserveReasoning {
+		t.Fatalf("PreserveReasoning = %v, want false", fake.lastReq.PreserveReasoning)
+	}
+}
+
+func TestChatCompletionsRejectsInvalidReasoningEffort(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+
+	body := `{"model":"glm-5","reasoning_effort":"maximum","messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid reasoning effort") {
+		t.Fatalf("status = %d, body=%s, want 400", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMessagesForwardsThinking(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+	fake := &fakeProxy{chatResp: &types.ChatCompletionResponse{ID: "chat-1"}}
+	s.newProxy = func(*account.Account) proxyClient { return fake }
+
+	body := `{"model":"glm-5","max_tokens":64,"thinking":{"type":"enabled","budget_tokens":2048},"messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	req.Header.Set("x-api-key", acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+	if fake.lastReq.Thinking == nil || fake.lastReq.Thinking.BudgetTokens != 2048 {
+		t.Fatalf("thinking not forwarded: %+v", fake.lastReq.Thinking)
+	}
+	if fake.lastReq.PreserveReasoning == nil || !*fake.lastReq.PreserveReasoning {
+		t.Fatal("messages should preserve reasoning for thinking blocks")
+	}
+}
+
+func TestReasoningRejectsUnsupportedControls(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+	s.newProxy = func(*account.Account) proxyClient {
+		return &fakeProxy{chatResp: &types.ChatCompletionResponse{ID: "chat-1"}}
+	}
+
+	cases := []struct {
+		name, body, want string
+	}{
+		{"no thinking_off", `{"model":"qwen3-235b-a22b-thinking-2507","reasoning_effort":"none","messages":[{"role":"user","content":"hi"}]}`, "turning thinking off"},
+		{"no thinking_budget", `{"model":"glm-4.6","reasoning_effort":"low","messages":[{"role":"user","content":"hi"}]}`, "thinking budgets"},
+		{"no thinking mode", `{"model":"qwen3-4b","reasoning_effort":"high","messages":[{"role":"user","content":"hi"}]}`, "does not support thinking"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(tc.body))
+		req.Header.Set("Authorization", "Bearer "+acct.UUID)
+		rec := httptest.NewRecorder()
+		s.httpServer.Handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tc.want) {
+			t.Fatalf("%s: status = %d, body=%s, want 400 mentioning %q", tc.name, rec.Code, rec.Body.String(), tc.want)
+		}
+	}
+
+	body := `{"model":"glm-4.6","reasoning_effort":"high","messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("glm-4.6 high: status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+}
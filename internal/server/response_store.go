@@ -0,0 +1,220 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rogeecn/iflow-go/pkg/types"
+	"github.com/rs/zerolog/log"
+)
+
+var responseIDPattern = regexp.MustCompile(`^resp_[A-Za-z0-9]+$`)
+
+// maxResponsePruneInterval bounds how long an expired response can stay on
+// disk before the pruner removes it.
+const maxResponsePruneInterval = time.Hour
+
+var errResponseExpired = errors.New("response expired")
+
+type storedResponse struct {
+	Owner       string          `json:"owner,omitempty"`
+	AccountUUID string          `json:"account_uuid"`
+	Response    *types.Response `json:"response"`
+	Messages    []types.Message `json:"messages"`
+}
+
+// ownedBy reports whether owner may read or continue the response. Records
+// written before owners were tracked fall back to the serving account.
+func (r *storedResponse) ownedBy(owner string) bool {
+	if r.Owner == "" {
+		return r.AccountUUID == owner
+	}
+	return r.Owner == owner
+}
+
+// ResponseStore keeps responses under <dataDir>/responses. With a retention
+// set, responses older than it are no longer served and are pruned in the
+// background.
+type ResponseStore struct {
+	dataDir string
+	now     func() time.Time
+
+	mu        sync.Mutex
+	retention time.Duration
+	stopChan  chan struct{}
+	wg        sync.WaitGroup
+}
+
+func NewResponseStore(dataDir string) *ResponseStore {
+	return &ResponseStore{dataDir: dataDir, now: time.Now}
+}
+
+// StartPruner sets the retention and removes expired responses
+// periodically. A non-positive retention keeps responses forever.
+func (s *ResponseStore) StartPruner(retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopChan != nil {
+		return
+	}
+	s.retention = retention
+	s.stopChan = make(chan struct{})
+
+	s.wg.Add(1)
+	go s.pruneLoop(min(retention, maxResponsePruneInterval), s.stopChan)
+}
+
+// Close stops the pruner.
+func (s *ResponseStore) Close() {
+	s.mu.Lock()
+	stopChan := s.stopChan
+	s.stopChan = nil
+	s.mu.Unlock()
+
+	if stopChan != nil {
+		close(stopChan)
+		s.wg.Wait()
+	}
+}
+
+func (s *ResponseStore) pruneLoop(interval time.Duration, stopChan <-chan struct{}) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if removed, err := s.Prune(); err != nil {
+			log.Warn().
+				Err(err).
+				Msg("prune stored responses failed, will retry")
+		} else if removed > 0 {
+			log.Debug().
+				Int("removed", removed).
+				Msg("pruned expired responses")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+// Prune removes responses older than the retention and returns how many
+// were removed.
+func (s *ResponseStore) Prune() (int, error) {
+	retention := s.currentRetention()
+	if retention <= 0 {
+		return 0, nil
+	}
+
+	entries, err := os.ReadDir(s.responsesDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("prune responses: read dir: %w", err)
+	}
+
+	cutoff := s.now().Add(-retention)
+	removed := 0
+	var errs []error
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || (!strings.HasSuffix(name, ".json") && !strings.HasSuffix(name, ".tmp")) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.responsesDir(), name)); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+			continue
+		}
+		removed++
+	}
+	if len(errs) > 0 {
+		return removed, fmt.Errorf("prune responses: %w", errors.Join(errs...))
+	}
+	return removed, nil
+}
+
+func (s *ResponseStore) currentRetention() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.retention
+}
+
+func (s *ResponseStore) Save(record *storedResponse) error {
+	if record == nil || record.Response == nil {
+		return fmt.Errorf("save response: nil response")
+	}
+	if !responseIDPattern.MatchString(record.Response.ID) {
+		return fmt.Errorf("save response: invalid id %q", record.Response.ID)
+	}
+	if err := os.MkdirAll(s.responsesDir(), 0o755); err != nil {
+		return fmt.Errorf("save response: ensure responses dir: %w", err)
+	}
+
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("save response: marshal json: %w", err)
+	}
+
+	path := s.responsePath(record.Response.ID)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, payload, 0o600); err != nil {
+		return fmt.Errorf("save response: write temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("save response: rename temp file: %w", err)
+	}
+	return nil
+}
+
+func (s *ResponseStore) Load(id string) (*storedResponse, error) {
+	if !responseIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("load response: invalid id %q", id)
+	}
+
+	path := s.responsePath(id)
+	if retention := s.currentRetention(); retention > 0 {
+		// Expired responses stay unreadable even before the pruner runs.
+		if info, err := os.Stat(path); err == nil && info.ModTime().Before(s.now().Add(-retention)) {
+			return nil, fmt.Errorf("load response %s: %w", id, errResponseExpired)
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("load response: read file: %w", err)
+	}
+
+	var record storedResponse
+	if err := json.Unmarshal(content, &record); err != nil {
+		return nil, fmt.Errorf("load response: unmarshal json: %w", err)
+	}
+	return &record, nil
+}
+
+func (s *ResponseStore) responsesDir() string {
+	return filepath.Join(s.dataDir, "responses")
+}
+
+func (s *ResponseStore) responsePath(id string) string {
+	return filepath.Join(s.responsesDir(), id+".json")
+}
@@ -0,0 +1,764 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rogeecn/iflow-go/pkg/types"
+	"github.com/rs/zerolog/log"
+)
+
+func (s *Server) handleResponses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		log.Warn().
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Msg("responses endpoint rejected invalid method")
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed", "invalid_request_error", "method_not_allowed")
+		return
+	}
+
+	acct, ok := accountFromContext(r.Context())
+	if !ok {
+		log.Error().
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Msg("responses endpoint missing account context")
+		writeAPIError(w, http.StatusUnauthorized, "missing account context", "invalid_request_error", "invalid_api_key")
+		return
+	}
+
+	var reqBody types.ResponsesRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		if isBodyTooLarge(err) {
+			log.Warn().
+				Err(err).
+				Str("account_uuid", acct.UUID).
+				Msg("responses request body too large")
+			writeAPIError(w, http.StatusRequestEntityTooLarge, "request body too large", "invalid_request_error", "request_too_large")
+			return
+		}
+		log.Warn().
+			Err(err).
+			Str("account_uuid", acct.UUID).
+			Msg("responses invalid request body")
+		writeAPIError(w, http.StatusBadRequest, "invalid request body", "invalid_request_error", "bad_request")
+		return
+	}
+	if reqBody.Model == "" || len(reqBody.Input) == 0 {
+		log.Warn().
+			Str("account_uuid", acct.UUID).
+			Msg("responses missing required fields")
+		writeAPIError(w, http.StatusBadRequest, "model and input are required", "invalid_request_error", "bad_request")
+		return
+	}
+	if !modelAllowed(r.Context(), reqBody.Model) {
+		log.Warn().
+			Str("account_uuid", acct.UUID).
+			Str("model", reqBody.Model).
+			Msg("responses model not allowed for client key")
+		writeAPIError(w, http.StatusForbidden, "api key is not allowed to use model "+reqBody.Model, "permission_error", "model_not_allowed")
+		return
+	}
+
+	var history []types.Message
+	if reqBody.PreviousResponseID != "" {
+		previous, err := s.responseStore.Load(reqBody.PreviousResponseID)
+		if err != nil || !previous.ownedBy(responseOwner(r.Context())) {
+			log.Warn().
+				Err(err).
+				Str("account_uuid", acct.UUID).
+				Str("previous_response_id", reqBody.PreviousResponseID).
+				Msg("responses previous response not found")
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("previous response %s not found", reqBody.PreviousResponseID), "invalid_request_error", "previous_response_not_found")
+			return
+		}
+		history = previous.Messages
+	}
+
+	input, err := responsesInputToMessages(reqBody.Input)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("account_uuid", acct.UUID).
+			Msg("responses input conversion failed")
+		writeAPIError(w, http.StatusBadRequest, err.Error(), "invalid_request_error", "bad_request")
+		return
+	}
+
+	conversation := make([]types.Message, 0, len(history)+len(input))
+	conversation = append(conversation, history...)
+	conversation = append(conversation, input...)
+	chatReq := responsesToChatRequest(&reqBody, conversation)
+	if err := applyReasoningOptions(r, chatReq); err != nil {
+		log.Warn().
+			Err(err).
+			Str("account_uuid", acct.UUID).
+			Msg("responses invalid reasoning options")
+		writeAPIError(w, http.StatusBadRequest, err.Error(), "invalid_request_error", "bad_request")
+		return
+	}
+
+	observeModel(r.Context(), reqBody.Model)
+	log.Debug().
+		Str("account_uuid", acct.UUID).
+		Str("model", reqBody.Model).
+		Bool("stream", reqBody.Stream).
+		Int("messages", len(chatReq.Messages)).
+		Str("previous_response_id", reqBody.PreviousResponseID).
+		Msg("responses request accepted")
+
+	builder := newResponseBuilder(&reqBody)
+	if reqBody.Stream {
+		s.handleStreamResponses(r.Context(), w, chatReq, builder, conversation)
+		return
+	}
+
+	resp, acct, err := s.chatWithFailover(r.Context(), w, chatReq)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("account_uuid", acct.UUID).
+			Str("model", chatReq.Model).
+			Msg("responses upstream request failed")
+		writeUpstreamError(w, err, "upstream request failed")
+		return
+	}
+
+	if len(resp.Choices) > 0 && resp.Choices[0].Message != nil {
+		msg := resp.Choices[0].Message
+		_ = builder.reasoningDelta(msg.ReasoningContent)
+		_ = builder.textDelta(messageText(msg.Content))
+		for i, call := range msg.ToolCalls {
+			index := i
+			call.Index = &index
+			_ = builder.toolCallDelta(call)
+		}
+	}
+	usage := resp.Usage
+	_ = builder.finish(&usage)
+
+	s.recordUsage(r.Context(), acct.UUID, chatReq.Model, &usage)
+	s.storeResponse(&reqBody, builder, responseOwner(r.Context()), acct.UUID, conversation)
+	writeJSON(w, http.StatusOK, builder.response)
+}
+
+func (s *Server) handleStreamResponses(ctx context.Context, w http.ResponseWriter, reqBody *types.ChatCompletionRequest, builder *responseBuilder, conversation []types.Message) {
+	stream, acct, err := s.streamWithFailover(ctx, w, reqBody)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("account_uuid", acct.UUID).
+			Str("model", reqBody.Model).
+			Msg("responses stream request failed")
+		writeUpstreamError(w, err, "upstream stream failed")
+		return
+	}
+	uuid := acct.UUID
+	defer trackStream(ctx)()
+
+	sse, err := NewSSEWriter(w)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("account_uuid", uuid).
+			Msg("failed to initialize sse writer")
+		writeAPIError(w, http.StatusInternalServerError, err.Error(), "internal_error", "internal_error")
+		return
+	}
+
+	pings := newHeartbeat(s.config.SSEKeepalive)
+	defer pings.Stop()
+
+	builder.emit = func(event string, payload map[string]interface{}) error {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("responses stream encode %s: %w", event, err)
+		}
+		return sse.WriteNamedEvent(event, string(raw))
+	}
+	if err := builder.start(); err != nil {
+		log.Warn().
+			Err(err).
+			Str("account_uuid", uuid).
+			Msg("responses stream write failed")
+		return
+	}
+
+	var usage *types.Usage
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debug().
+				Str("account_uuid", uuid).
+				Str("model", reqBody.Model).
+				Msg("responses stream cancelled by context")
+			return
+		case <-pings.C():
+			if err := sse.WritePing(); err != nil {
+				log.Warn().
+					Err(err).
+					Str("account_uuid", uuid).
+					Msg("responses stream keepalive failed")
+				return
+			}
+		case chunk, ok := <-stream:
+			if !ok {
+				if err := builder.finish(usage); err != nil {
+					log.Warn().
+						Err(err).
+						Str("account_uuid", uuid).
+						Msg("responses stream write failed")
+				}
+				s.recordUsage(ctx, uuid, reqBody.Model, usage)
+				s.storeResponse(builder.request, builder, responseOwner(ctx), uuid, conversation)
+				log.Debug().
+					Str("account_uuid", uuid).
+					Str("model", reqBody.Model).
+					Msg("responses stream finished")
+				return
+			}
+			observeFirstToken(ctx)
+			pings.Reset()
+
+			chunks, _ := decodeProxyChunk(chunk)
+			if streamErr := streamErrorFromChunks(chunks); streamErr != nil {
+				log.Warn().
+					Str("account_uuid", uuid).
+					Str("model", reqBody.Model).
+					Str("error", streamErr.Message).
+					Msg("responses stream failed upstream")
+				if err := builder.fail(streamErr); err != nil {
+					log.Warn().
+						Err(err).
+						Str("account_uuid", uuid).
+						Msg("responses stream write failed")
+				}
+				return
+			}
+			for _, parsed := range chunks {
+				if parsed.Usage != nil {
+					usage = parsed.Usage
+				}
+				if err := builder.consume(parsed); err != nil {
+					log.Warn().
+						Err(err).
+						Str("account_uuid", uuid).
+						Str("model", reqBody.Model).
+						Msg("responses stream write failed")
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Server) handleGetResponse(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		log.Warn().
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Msg("response lookup endpoint rejected invalid method")
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed", "invalid_request_error", "method_not_allowed")
+		return
+	}
+
+	acct, ok := accountFromContext(r.Context())
+	if !ok {
+		log.Error().
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Msg("response lookup endpoint missing account context")
+		writeAPIError(w, http.StatusUnauthorized, "missing account context", "invalid_request_error", "invalid_api_key")
+		return
+	}
+
+	id := r.PathValue("id")
+	record, err := s.responseStore.Load(id)
+	if err != nil || !record.ownedBy(responseOwner(r.Context())) {
+		log.Debug().
+			Err(err).
+			Str("account_uuid", acct.UUID).
+			Str("response_id", id).
+			Msg("response lookup not found")
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("response %s not found", id), "invalid_request_error", "not_found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, record.Response)
+}
+
+func (s *Server) storeResponse(req *types.ResponsesRequest, builder *responseBuilder, owner, uuid string, conversation []types.Message) {
+	if req.Store != nil && !*req.Store {
+		return
+	}
+
+	messages := make([]types.Message, 0, len(conversation)+1)
+	messages = append(messages, conversation...)
+	messages = append(messages, builder.assistantMessage())
+
+	record := &storedResponse{
+		Owner:       owner,
+		AccountUUID: uuid,
+		Response:    builder.response,
+		Messages:    messages,
+	}
+	if err := s.responseStore.Save(record); err != nil {
+		log.Warn().
+			Err(err).
+			Str("account_uuid", uuid).
+			Str("response_id", builder.response.ID).
+			Msg("failed to store response")
+	}
+}
+
+func responsesToChatRequest(req *types.ResponsesRequest, conversation []types.Message) *types.ChatCompletionRequest {
+	chatReq := &types.ChatCompletionRequest{
+		Model:       req.Model,
+		Stream:      req.Stream,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		MaxTokens:   req.MaxOutputTokens,
+		User:        req.User,
+	}
+	// Reasoning output items need reasoning_content kept apart from the answer.
+	preserve := true
+	chatReq.PreserveReasoning = &preserve
+	if req.Reasoning != nil {
+		chatReq.ReasoningEffort = req.Reasoning.Effort
+	}
+
+	if strings.TrimSpace(req.Instructions) != "" {
+		chatReq.Messages = append(chatReq.Messages, types.Message{Role: "system", Content: req.Instructions})
+	}
+	chatReq.Messages = append(chatReq.Messages, conversation...)
+
+	for _, tool := range req.Tools {
+		if tool.Type != "function" {
+			continue
+		}
+		parameters := json.RawMessage(`{"type":"object","properties":{}}`)
+		if len(tool.Parameters) > 0 {
+			parameters = tool.Parameters
+		}
+		chatReq.Tools = append(chatReq.Tools, types.Tool{
+			Type: "function",
+			Function: types.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  parameters,
+			},
+		})
+	}
+
+	switch choice := req.ToolChoice.(type) {
+	case string:
+		chatReq.ToolChoice = choice
+	case map[string]interface{}:
+		if name, ok := choice["name"].(string); ok && choice["type"] == "function" {
+			chatReq.ToolChoice = map[string]interface{}{
+				"type":     "function",
+				"function": map[string]interface{}{"name": name},
+			}
+		}
+	}
+
+	return chatReq
+}
+
+func responsesInputToMessages(raw json.RawMessage) ([]types.Message, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []types.Message{{Role: "user", Content: text}}, nil
+	}
+
+	var items []types.ResponsesInputItem
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, fmt.Errorf("input: expected string or input items")
+	}
+
+	messages := make([]types.Message, 0, len(items))
+	for i, item := range items {
+		switch item.Type {
+		case "", "message":
+			content, err := responsesContent(item.Content)
+			if err != nil {
+				return nil, fmt.Errorf("input[%d]: %w", i, err)
+			}
+			role := item.Role
+			if role == "developer" {
+				role = "system"
+			}
+			messages = append(messages, types.Message{Role: role, Content: content})
+		case "function_call":
+			call := types.ToolCall{
+				ID:   item.CallID,
+				Type: "function",
+				Function: types.ToolCallFunction{
+					Name:      item.Name,
+					Arguments: item.Arguments,
+				},
+			}
+			if n := len(messages); n > 0 && messages[n-1].Role == "assistant" && len(messages[n-1].ToolCalls) > 0 {
+				messages[n-1].ToolCalls = append(messages[n-1].ToolCalls, call)
+				continue
+			}
+			messages = append(messages, types.Message{Role: "assistant", ToolCalls: []types.ToolCall{call}})
+		case "function_call_output":
+			output := string(item.Output)
+			var outputText string
+			if err := json.Unmarshal(item.Output, &outputText); err == nil {
+				output = outputText
+			}
+			messages = append(messages, types.Message{Role: "tool", Content: output, ToolCallID: item.CallID})
+		case "reasoning":
+			continue
+		default:
+			return nil, fmt.Errorf("input[%d]: unsupported item type %q", i, item.Type)
+		}
+	}
+	return messages, nil
+}
+
+func responsesContent(raw json.RawMessage) (interface{}, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+
+	var parts []types.ResponsesContentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return nil, fmt.Errorf("content: expected string or content parts")
+	}
+
+	converted := make([]interface{}, 0, len(parts))
+	hasImage := false
+	for _, part := range parts {
+		switch part.Type {
+		case "input_text", "output_text", "text":
+			converted = append(converted, map[string]interface{}{"type": "text", "text": part.Text})
+		case "input_image":
+			converted = append(converted, map[string]interface{}{
+				"type":      "image_url",
+				"image_url": map[string]interface{}{"url": part.ImageURL},
+			})
+			hasImage = true
+		}
+	}
+	if hasImage {
+		return converted, nil
+	}
+	return messageText(converted), nil
+}
+
+type responseBuilder struct {
+	request  *types.ResponsesRequest
+	response *types.Response
+	current  *types.ResponseOutputItem
+	toolKey  int
+	sequence int
+	emit     func(event string, payload map[string]interface{}) error
+}
+
+func newResponseBuilder(req *types.ResponsesRequest) *responseBuilder {
+	resp := &types.Response{
+		ID:         newObjectID("resp_"),
+		Object:     "response",
+		CreatedAt:  time.Now().Unix(),
+		Status:     "in_progress",
+		Model:      req.Model,
+		Output:     []types.ResponseOutputItem{},
+		Tools:      req.Tools,
+		ToolChoice: req.ToolChoice,
+		Metadata:   req.Metadata,
+	}
+	if resp.Tools == nil {
+		resp.Tools = []types.ResponsesTool{}
+	}
+	if resp.ToolChoice == nil {
+		resp.ToolChoice = "auto"
+	}
+	if resp.Metadata == nil {
+		resp.Metadata = map[string]interface{}{}
+	}
+	if req.Instructions != "" {
+		instructions := req.Instructions
+		resp.Instructions = &instructions
+	}
+	if req.PreviousResponseID != "" {
+		previous := req.PreviousResponseID
+		resp.PreviousResponseID = &previous
+	}
+
+	return &responseBuilder{
+		request:  req,
+		response: resp,
+		toolKey:  -1,
+	}
+}
+
+func (b *responseBuilder) start() error {
+	if err := b.send("response.created", map[string]interface{}{"response": b.response}); err != nil {
+		return err
+	}
+	return b.send("response.in_progress", map[string]interface{}{"response": b.response})
+}
+
+func (b *responseBuilder) consume(chunk *types.ChatCompletionChunk) error {
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 || choice.Delta == nil {
+			continue
+		}
+		if err := b.reasoningDelta(choice.Delta.ReasoningContent); err != nil {
+			return err
+		}
+		if err := b.textDelta(choice.Delta.Content); err != nil {
+			return err
+		}
+		for _, call := range choice.Delta.ToolCalls {
+			if err := b.toolCallDelta(call); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *responseBuilder) reasoningDelta(text string) error {
+	if text == "" {
+		return nil
+	}
+	if b.current == nil || b.current.Type != "reasoning" {
+		if err := b.open(types.ResponseOutputItem{
+			Type:    "reasoning",
+			ID:      newObjectID("rs_"),
+			Summary: []types.ResponseSummaryText{{Type: "summary_text"}},
+		}); err != nil {
+			return err
+		}
+	}
+
+	b.current.Summary[0].Text += text
+	return b.send("response.reasoning.delta", map[string]interface{}{
+		"item_id":      b.current.ID,
+		"output_index": b.outputIndex(),
+		"delta":        text,
+	})
+}
+
+func (b *responseBuilder) textDelta(text string) error {
+	if text == "" {
+		return nil
+	}
+	if b.current == nil || b.current.Type != "message" {
+		if err := b.open(types.ResponseOutputItem{
+			Type:   "message",
+			ID:     newObjectID("msg_"),
+			Status: "in_progress",
+			Role:   "assistant",
+		}); err != nil {
+			return err
+		}
+		b.current.Content = []types.ResponseOutputContent{{Type: "output_text", Annotations: []interface{}{}}}
+		if err := b.send("response.content_part.added", map[string]interface{}{
+			"item_id":       b.current.ID,
+			"output_index":  b.outputIndex(),
+			"content_index": 0,
+			"part":          b.current.Content[0],
+		}); err != nil {
+			return err
+		}
+	}
+
+	b.current.Content[0].Text += text
+	return b.send("response.output_text.delta", map[string]interface{}{
+		"item_id":       b.current.ID,
+		"output_index":  b.outputIndex(),
+		"content_index": 0,
+		"delta":         text,
+	})
+}
+
+func (b *responseBuilder) toolCallDelta(call types.ToolCall) error {
+	key := b.toolKey
+	if call.Index != nil {
+		key = *call.Index
+	}
+	if b.current == nil || b.current.Type != "function_call" || key != b.toolKey || call.ID != "" {
+		callID := call.ID
+		if callID == "" {
+			callID = newObjectID("call_")
+		}
+		if err := b.open(types.ResponseOutputItem{
+			Type:   "function_call",
+			ID:     newObjectID("fc_"),
+			Status: "in_progress",
+			CallID: callID,
+			Name:   call.Function.Name,
+		}); err != nil {
+			return err
+		}
+		b.toolKey = key
+	}
+
+	if call.Function.Arguments == "" {
+		return nil
+	}
+	b.current.Arguments += call.Function.Arguments
+	return b.send("response.function_call_arguments.delta", map[string]interface{}{
+		"item_id":      b.current.ID,
+		"output_index": b.outputIndex(),
+		"delta":        call.Function.Arguments,
+	})
+}
+
+func (b *responseBuilder) finish(usage *types.Usage) error {
+	if err := b.closeCurrent(); err != nil {
+		return err
+	}
+
+	b.response.Status = "completed"
+	if usage != nil {
+		b.response.Usage = &types.ResponseUsage{
+			InputTokens:  usage.PromptTokens,
+			OutputTokens: usage.CompletionTokens,
+			TotalTokens:  usage.TotalTokens,
+		}
+		if usage.CompletionTokensDetails != nil {
+			b.response.Usage.OutputTokensDetails.ReasoningTokens = usage.CompletionTokensDetails.ReasoningTokens
+		}
+	}
+	return b.send("response.completed", map[string]interface{}{"response": b.response})
+}
+
+// fail reports an upstream error raised after the stream started: an error
+// event followed by response.failed.
+func (b *responseBuilder) fail(detail *types.ErrorDetail) error {
+	code := fmt.Sprint(detail.Code)
+	if detail.Code == nil {
+		code = detail.Type
+	}
+	if err := b.send("error", map[string]interface{}{
+		"code":    code,
+		"message": detail.Message,
+		"param":   nil,
+	}); err != nil {
+		return err
+	}
+
+	b.response.Status = "failed"
+	b.response.Error = map[string]string{
+		"code":    code,
+		"message": detail.Message,
+	}
+	return b.send("response.failed", map[string]interface{}{"response": b.response})
+}
+
+func (b *responseBuilder) open(item types.ResponseOutputItem) error {
+	if err := b.closeCurrent(); err != nil {
+		return err
+	}
+
+	b.response.Output = append(b.response.Output, item)
+	b.current = &b.response.Output[len(b.response.Output)-1]
+	return b.send("response.output_item.added", map[string]interface{}{
+		"output_index": b.outputIndex(),
+		"item":         b.current,
+	})
+}
+
+func (b *responseBuilder) closeCurrent() error {
+	item := b.current
+	if item == nil {
+		return nil
+	}
+	b.current = nil
+
+	index := len(b.response.Output) - 1
+	switch item.Type {
+	case "message":
+		if err := b.send("response.output_text.done", map[string]interface{}{
+			"item_id":       item.ID,
+			"output_index":  index,
+			"content_index": 0,
+			"text":          item.Content[0].Text,
+		}); err != nil {
+			return err
+		}
+		if err := b.send("response.content_part.done", map[string]interface{}{
+			"item_id":       item.ID,
+			"output_index":  index,
+			"content_index": 0,
+			"part":          item.Content[0],
+		}); err != nil {
+			return err
+		}
+	case "function_call":
+		if err := b.send("response.function_call_arguments.done", map[string]interface{}{
+			"item_id":      item.ID,
+			"output_index": index,
+			"arguments":    item.Arguments,
+		}); err != nil {
+			return err
+		}
+	}
+
+	item.Status = "completed"
+	return b.send("response.output_item.done", map[string]interface{}{
+		"output_index": index,
+		"item":         item,
+	})
+}
+
+func (b *responseBuilder) outputIndex() int {
+	return len(b.response.Output) - 1
+}
+
+func (b *responseBuilder) assistantMessage() types.Message {
+	message := types.Message{Role: "assistant"}
+	var text strings.Builder
+	for _, item := range b.response.Output {
+		switch item.Type {
+		case "message":
+			for _, part := range item.Content {
+				text.WriteString(part.Text)
+			}
+		case "function_call":
+			message.ToolCalls = append(message.ToolCalls, types.ToolCall{
+				ID:   item.CallID,
+				Type: "function",
+				Function: types.ToolCallFunction{
+					Name:      item.Name,
+					Arguments: item.Arguments,
+				},
+			})
+		}
+	}
+	if text.Len() > 0 || len(message.ToolCalls) == 0 {
+		message.Content = text.String()
+	}
+	return message
+}
+
+func (b *responseBuilder) send(event string, payload map[string]interface{}) error {
+	if b.emit == nil {
+		return nil
+	}
+
+	payload["type"] = event
+	payload["sequence_number"] = b.sequence
+	b.sequence++
+	return b.emit(event, payload)
+}
+
+func responseOwner(ctx context.Context) string {
+	binding, ok := bindingFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return binding.owner()
+}
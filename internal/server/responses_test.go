@@ -0,0 +1,284 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/internal/apikey"
+	"github.com/rogeecn/iflow-go/internal/config"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+func TestResponsesInputToMessages(t *testing.T) {
+	raw := json.RawMessage(`[
+	  {"role":"developer","content":"be brief"},
+	  {"type":"message","role":"user","content":[{"type":"input_text","text":"weather?"}]},
+	  {"type":"function_call","call_id":"call_1","name":"get_weather","arguments":"{\"city\":\"hz\"}"},
+	  {"type":"function_call_output","call_id":"call_1","output":"sunny"}
+	]`)
+
+	messages, err := responsesInputToMessages(raw)
+	if err != nil {
+		t.Fatalf("responsesInputToMessages error: %v", err)
+	}
+	if len(messages) != 4 {
+		t.Fatalf("messages len = %d, want 4: %+v", len(messages), messages)
+	}
+	if messages[0].Role != "system" || messages[0].Content != "be brief" {
+		t.Fatalf("unexpected developer message: %+v", messages[0])
+	}
+	if messages[1].Content != "weather?" {
+		t.Fatalf("unexpected user message: %+v", messages[1])
+	}
+	if len(messages[2].ToolCalls) != 1 || messages[2].ToolCalls[0].ID != "call_1" {
+		t.Fatalf("unexpected function call message: %+v", messages[2])
+	}
+	if messages[3].Role != "tool" || messages[3].ToolCallID != "call_1" || messages[3].Content != "sunny" {
+		t.Fatalf("unexpected function output message: %+v", messages[3])
+	}
+}
+
+func TestHandleResponsesChainsPreviousResponse(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+
+	finish := "stop"
+	fake := &fakeProxy{
+		chatResp: &types.ChatCompletionResponse{
+			ID:    "chat-1",
+			Model: "glm-5",
+			Choices: []types.Choice{
+				{Message: &types.Message{Role: "assistant", Content: "first answer"}, FinishReason: &finish},
+			},
+			Usage: types.Usage{PromptTokens: 1, CompletionTokens: 2, TotalTokens: 3},
+		},
+	}
+	s.newProxy = func(*account.Account) proxyClient { return fake }
+
+	body := `{"model":"glm-5","instructions":"be brief","input":"hello","tools":[{"type":"function","name":"lookup","parameters":{"type":"object"}}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/responses", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var first types.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &first); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if first.Object != "response" || first.Status != "completed" {
+		t.Fatalf("unexpected response object: %+v", first)
+	}
+	if len(first.Output) != 1 || first.Output[0].Content[0].Text != "first answer" {
+		t.Fatalf("unexpected output: %+v", first.Output)
+	}
+	if first.Usage == nil || first.Usage.TotalTokens != 3 {
+		t.Fatalf("unexpected usage: %+v", first.Usage)
+	}
+	if len(fake.lastReq.Tools) != 1 || fake.lastReq.Messages[0].Role != "system" {
+		t.Fatalf("unexpected chat request: %+v", fake.lastReq)
+	}
+
+	body = `{"model":"glm-5","input":"again","previous_response_id":"` + first.ID + `"}`
+	req = httptest.NewRequest(http.MethodPost, "/v1/responses", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec = httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("chained status = %d, want %d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if len(fake.lastReq.Messages) != 3 {
+		t.Fatalf("chained messages len = %d, want 3: %+v", len(fake.lastReq.Messages), fake.lastReq.Messages)
+	}
+	if fake.lastReq.Messages[1].Content != "first answer" || fake.lastReq.Messages[2].Content != "again" {
+		t.Fatalf("unexpected chained history: %+v", fake.lastReq.Messages)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/responses/"+first.ID, nil)
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec = httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get status = %d, want %d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), first.ID) {
+		t.Fatalf("stored response mismatch: %s", rec.Body.String())
+	}
+}
+
+func TestHandleResponsesPoolAcrossAccounts(t *testing.T) {
+	s := New(&config.Config{
+		Host:         "127.0.0.1",
+		Port:         28000,
+		DataDir:      t.TempDir(),
+		PoolKey:      "pool-secret",
+		PoolStrategy: "round_robin",
+	})
+	createTestAccount(t, s)
+	createTestAccount(t, s)
+
+	finish := "stop"
+	fake := &fakeProxy{
+		chatResp: &types.ChatCompletionResponse{
+			ID:      "chat-pool",
+			Choices: []types.Choice{{Message: &types.Message{Role: "assistant", Content: "pooled"}, FinishReason: &finish}},
+		},
+	}
+	s.newProxy = func(*account.Account) proxyClient { return fake }
+
+	rec := serveWithToken(s, http.MethodPost, "/v1/responses", `{"model":"glm-5","input":"hello"}`, "pool-secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var first types.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &first); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	firstAccount := rec.Header().Get(accountHeader)
+
+	rec = serveWithToken(s, http.MethodPost, "/v1/responses", `{"model":"glm-5","input":"again","previous_response_id":"`+first.ID+`"}`, "pool-secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("chained status = %d, want %d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if rec.Header().Get(accountHeader) == firstAccount {
+		t.Fatal("round robin should serve the chained request from another account")
+	}
+	if len(fake.lastReq.Messages) != 3 {
+		t.Fatalf("chained messages len = %d, want 3", len(fake.lastReq.Messages))
+	}
+
+	rec = serveWithToken(s, http.MethodGet, "/v1/responses/"+first.ID, "", "pool-secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get status = %d, want %d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+}
+
+func TestHandleResponsesScopedToClientKey(t *testing.T) {
+	s, acct := newClientKeyServer(t)
+	owner, _, err := s.auth.Keys.Create(apikey.Options{Account: acct.UUID})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	other, _, err := s.auth.Keys.Create(apikey.Options{Account: acct.UUID})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	rec := serveWithToken(s, http.MethodPost, "/v1/responses", `{"model":"glm-5","input":"hello"}`, owner)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var first types.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &first); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	if rec := serveWithToken(s, http.MethodGet, "/v1/responses/"+first.ID, "", other); rec.Code != http.StatusNotFound {
+		t.Fatalf("other key get status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	rec = serveWithToken(s, http.MethodPost, "/v1/responses", `{"model":"glm-5","input":"again","previous_response_id":"`+first.ID+`"}`, other)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("other key chained status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := serveWithToken(s, http.MethodGet, "/v1/responses/"+first.ID, "", owner); rec.Code != http.StatusOK {
+		t.Fatalf("owner get status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestHandleResponsesUnknownPrevious(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+	s.newProxy = func(*account.Account) proxyClient { return &fakeProxy{} }
+
+	body := `{"model":"glm-5","input":"again","previous_response_id":"resp_missing"}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/responses", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if !strings.Contains(rec.Body.String(), "previous_response_not_found") {
+		t.Fatalf("unexpected body: %s", rec.Body.String())
+	}
+}
+
+func TestHandleResponsesStream(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+
+	ch := make(chan []byte, 5)
+	ch <- []byte("data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"reasoning_content\":\"hmm\"}}]}\n\n")
+	ch <- []byte("data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"}}]}\n\n")
+	ch <- []byte("data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_9\",\"type\":\"function\",\"function\":{\"name\":\"lookup\",\"arguments\":\"{}\"}}]},\"finish_reason\":\"tool_calls\"}]}\n\n")
+	ch <- []byte("data: [DONE]\n\n")
+	close(ch)
+	s.newProxy = func(*account.Account) proxyClient { return &fakeProxy{stream: ch} }
+
+	body := `{"model":"glm-5","input":"hello","stream":true}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/responses", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	out := rec.Body.String()
+	ordered := []string{
+		"event: response.created",
+		"event: response.in_progress",
+		"event: response.reasoning.delta",
+		"event: response.output_text.delta",
+		`"delta":"hi"`,
+		"event: response.output_text.done",
+		"event: response.function_call_arguments.delta",
+		"event: response.function_call_arguments.done",
+		"event: response.completed",
+	}
+	pos := 0
+	for _, want := range ordered {
+		idx := strings.Index(out[pos:], want)
+		if idx < 0 {
+			t.Fatalf("stream missing %q after offset %d: %s", want, pos, out)
+		}
+		pos += idx + len(want)
+	}
+}
+
+func TestResponseStoreRetention(t *testing.T) {
+	store := NewResponseStore(t.TempDir())
+	record := &storedResponse{AccountUUID: "acct", Response: &types.Response{ID: "resp_old"}}
+	if err := store.Save(record); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	store.retention = time.Hour
+
+	if _, err := store.Load("resp_old"); err != nil {
+		t.Fatalf("fresh response should load: %v", err)
+	}
+
+	store.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := store.Load("resp_old"); !errors.Is(err, errResponseExpired) {
+		t.Fatalf("Load() error = %v, want expired", err)
+	}
+	if removed, err := store.Prune(); err != nil || removed != 1 {
+		t.Fatalf("Prune() = %d, %v, want 1 removed", removed, err)
+	}
+	if _, err := os.Stat(store.responsePath("resp_old")); !os.IsNotExist(err) {
+		t.Fatalf("expired response still on disk: %v", err)
+	}
+}
@@ -1,6 +1,10 @@
 package server
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/rogeecn/iflow-go/internal/metrics"
+)
 
 func (s *Server) setupRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
@@ -10,19 +14,76 @@ func (s *Server) setupRoutes() *http.ServeMux {
 		LoggingMiddleware,
 	))
 
+	mux.Handle("/metrics", chain(
+		metrics.Handler(),
+		LoggingMiddleware,
+	))
+
 	mux.Handle("/v1/models", chain(
 		http.HandlerFunc(s.handleModels),
 		LoggingMiddleware,
-		AuthMiddleware(s.accountMgr),
+		MetricsMiddleware("/v1/models"),
+		AuthMiddleware(s.auth, "/v1/models"),
+	))
+
+	mux.Handle("/v1/models/{id}", chain(
+		http.HandlerFunc(s.handleModel),
+		LoggingMiddleware,
+		MetricsMiddleware("/v1/models/{id}"),
+		AuthMiddleware(s.auth, "/v1/models/{id}"),
+	))
+
+	mux.Handle("/v1/stats", chain(
+		http.HandlerFunc(s.handleStats),
+		LoggingMiddleware,
+		MetricsMiddleware("/v1/stats"),
+		AuthMiddleware(s.auth, "/v1/stats"),
+	))
+
+	mux.Handle("/v1/usage", chain(
+		http.HandlerFunc(s.handleUsage),
+		LoggingMiddleware,
+		MetricsMiddleware("/v1/usage"),
+		AuthMiddleware(s.auth, "/v1/usage"),
 	))
 
 	mux.Handle("/v1/chat/completions", chain(
 		http.HandlerFunc(s.handleChatCompletions),
 		LoggingMiddleware,
-		AuthMiddleware(s.accountMgr),
+		MetricsMiddleware("/v1/chat/completions"),
+		AuthMiddleware(s.auth, "/v1/chat/completions"),
+		RateLimitMiddleware(s.limiter),
+		AdmissionMiddleware(s.admission),
+		RequestSizeLimitMiddleware(defaultMaxBodySize),
+	))
+
+	mux.Handle("/v1/messages", chain(
+		http.HandlerFunc(s.handleMessages),
+		LoggingMiddleware,
+		MetricsMiddleware("/v1/messages"),
+		AuthMiddleware(s.auth, "/v1/messages"),
+		RateLimitMiddleware(s.limiter),
+		AdmissionMiddleware(s.admission),
+		RequestSizeLimitMiddleware(defaultMaxBodySize),
+	))
+
+	mux.Handle("/v1/responses", chain(
+		http.HandlerFunc(s.handleResponses),
+		LoggingMiddleware,
+		MetricsMiddleware("/v1/responses"),
+		AuthMiddleware(s.auth, "/v1/responses"),
+		RateLimitMiddleware(s.limiter),
+		AdmissionMiddleware(s.admission),
 		RequestSizeLimitMiddleware(defaultMaxBodySize),
 	))
 
+	mux.Handle("/v1/responses/{id}", chain(
+		http.HandlerFunc(s.handleGetResponse),
+		LoggingMiddleware,
+		MetricsMiddleware("/v1/responses/{id}"),
+		AuthMiddleware(s.auth, "/v1/responses/{id}"),
+	))
+
 	return mux
 }
 
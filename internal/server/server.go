@@ -4,10 +4,16 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/internal/apikey"
 	"github.com/rogeecn/iflow-go/internal/config"
 	"github.com/rogeecn/iflow-go/internal/proxy"
+	"github.com/rogeecn/iflow-go/internal/ratelimit"
+	"github.com/rogeecn/iflow-go/internal/usage"
 	"github.com/rogeecn/iflow-go/pkg/types"
 	"github.com/rs/zerolog/log"
 )
@@ -15,20 +21,35 @@ import (
 type proxyClient interface {
 	ChatCompletions(ctx context.Context, req *types.ChatCompletionRequest) (*types.ChatCompletionResponse, error)
 	ChatCompletionsStream(ctx context.Context, req *types.ChatCompletionRequest) (<-chan []byte, error)
-	Models() []proxy.ModelConfig
+	FetchModels(ctx context.Context) ([]proxy.ModelConfig, error)
 }
 
 type Server struct {
-	config     *config.Config
-	accountMgr *account.Manager
-	httpServer *http.Server
+	config        *config.Config
+	accountMgr    *account.Manager
+	pool          *account.Pool
+	auth          *Authenticator
+	limiter       *ratelimit.Limiter
+	admission     *Admission
+	telemetrySink *proxy.JSONLSink
+	responseStore *ResponseStore
+	usageLedger   *usage.Ledger
+	modelCatalog  *proxy.ModelCatalog
+	httpServer    *http.Server
 
 	newProxy   func(acct *account.Account) proxyClient
+	wait       func(ctx context.Context, d time.Duration) error
 	serveFn    func() error
 	shutdownFn func(ctx context.Context) error
 }
 
 func New(cfg *config.Config) *Server {
+	return NewWithManager(cfg, nil)
+}
+
+// NewWithManager builds a server on an already opened account manager. A nil
+// manager uses the file store under cfg.DataDir.
+func NewWithManager(cfg *config.Config, manager *account.Manager) *Server {
 	if cfg == nil {
 		cfg = &config.Config{
 			Host:                     "0.0.0.0",
@@ -48,12 +69,75 @@ func New(cfg *config.Config) *Server {
 		cfg.DataDir = "./data"
 	}
 
+	if manager == nil {
+		manager = account.NewManager(cfg.DataDir)
+	}
+
 	s := &Server{
-		config:     cfg,
-		accountMgr: account.NewManager(cfg.DataDir),
+		config:        cfg,
+		accountMgr:    manager,
+		responseStore: NewResponseStore(cfg.DataDir),
+		usageLedger:   usage.NewLedger(cfg.DataDir),
+		limiter:       ratelimit.NewLimiter(cfg.DataDir),
 		newProxy: func(acct *account.Account) proxyClient {
 			return proxy.NewProxyWithReasoning(acct, cfg.PreserveReasoningContent)
 		},
+		wait: waitContext,
+	}
+
+	strategy, err := account.ParsePoolStrategy(cfg.PoolStrategy)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("fallback_strategy", string(account.StrategyRoundRobin)).
+			Msg("invalid pool strategy, fallback to default")
+		strategy = account.StrategyRoundRobin
+	}
+	// Pool-bound client keys need the pool even without a shared pool key.
+	s.pool = account.NewPool(s.accountMgr, strategy)
+	if strings.TrimSpace(cfg.PoolKey) != "" {
+		log.Info().
+			Str("strategy", string(strategy)).
+			Msg("account pool enabled")
+	}
+	s.auth = &Authenticator{
+		Manager:    s.accountMgr,
+		Pool:       s.pool,
+		PoolKey:    strings.TrimSpace(cfg.PoolKey),
+		Keys:       apikey.NewStore(cfg.DataDir),
+		LegacyUUID: cfg.LegacyUUIDAuth,
+	}
+	if cfg.LegacyUUIDAuth {
+		log.Warn().Msg("legacy account uuid authentication enabled, prefer issued client keys")
+	}
+
+	s.usageLedger.StartFlusher(cfg.UsageFlushInterval)
+	s.limiter.StartFlusher(cfg.UsageFlushInterval)
+	s.responseStore.StartPruner(cfg.ResponseRetention)
+	s.configureTelemetry()
+	s.configureModelCatalog()
+	proxy.ConfigurePassthrough(proxy.ParseFieldList(cfg.PassthroughAllow), proxy.ParseFieldList(cfg.PassthroughDeny))
+	if err := proxy.ConfigureModelProfiles(cfg.ModelProfiles); err != nil {
+		log.Warn().
+			Err(err).
+			Str("path", cfg.ModelProfiles).
+			Msg("load model profiles failed, using built-in profiles until the file is fixed")
+	}
+	proxy.ConfigureTimeouts(proxy.Timeouts{
+		Connect:    cfg.ConnectTimeout,
+		FirstToken: cfg.FirstTokenTimeout,
+		Idle:       cfg.IdleTimeout,
+		Request:    cfg.RequestTimeout,
+	})
+
+	if cfg.Concurrency > 0 || cfg.GlobalConcurrency > 0 {
+		s.admission = NewAdmission(cfg.GlobalConcurrency, cfg.Concurrency, cfg.QueueSize, cfg.QueueTimeout)
+		log.Info().
+			Int("account_limit", cfg.Concurrency).
+			Int("global_limit", cfg.GlobalConcurrency).
+			Int("queue_size", cfg.QueueSize).
+			Dur("queue_timeout", cfg.QueueTimeout).
+			Msg("admission control enabled")
 	}
 
 	s.httpServer = &http.Server{
@@ -85,5 +169,76 @@ func (s *Server) Stop(ctx context.Context) error {
 	if err := s.shutdownFn(ctx); err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("stop server: %w", err)
 	}
+
+	if err := s.usageLedger.Close(); err != nil {
+		log.Warn().
+			Err(err).
+			Msg("flush token usage on shutdown failed")
+	}
+	s.responseStore.Close()
+	if err := s.limiter.Close(); err != nil {
+		log.Warn().
+			Err(err).
+			Msg("flush token budgets on shutdown failed")
+	}
+	proxy.FlushTelemetry()
+	if s.telemetrySink != nil {
+		if err := s.telemetrySink.Close(); err != nil {
+			return fmt.Errorf("stop server: close telemetry file: %w", err)
+		}
+	}
 	return nil
 }
+
+func (s *Server) configureModelCatalog() {
+	path := strings.TrimSpace(s.config.ModelOverrides)
+	if path == "" {
+		path = filepath.Join(s.config.DataDir, "model_overrides.json")
+	}
+	overrides, err := proxy.LoadModelOverrides(path)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("path", path).
+			Msg("load model overrides failed, overrides ignored")
+	}
+	s.modelCatalog = proxy.NewModelCatalog(s.config.DataDir, s.config.ModelCatalogTTL, overrides)
+}
+
+func (s *Server) configureTelemetry() {
+	mode, err := proxy.ParseTelemetryMode(s.config.Telemetry)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("fallback_mode", string(proxy.TelemetryOff)).
+			Msg("invalid telemetry mode, fallback to off")
+		mode = proxy.TelemetryOff
+	}
+
+	var sink proxy.TelemetrySink
+	if mode == proxy.TelemetryLocal {
+		path := strings.TrimSpace(s.config.TelemetryFile)
+		if path == "" {
+			path = filepath.Join(s.config.DataDir, "telemetry.jsonl")
+		}
+		fileSink, err := proxy.NewJSONLSink(path)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("path", path).
+				Msg("open telemetry file failed, telemetry disabled")
+			mode = proxy.TelemetryOff
+		} else {
+			s.telemetrySink = fileSink
+			sink = fileSink
+		}
+	}
+
+	if err := proxy.ConfigureTelemetry(mode, sink); err != nil {
+		log.Warn().Err(err).Msg("configure telemetry failed")
+		return
+	}
+	log.Info().
+		Str("mode", string(mode)).
+		Msg("telemetry configured")
+}
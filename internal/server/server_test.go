@@ -4,8 +4,11 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/rogeecn/iflow-go/internal/account"
 	"github.com/rogeecn/iflow-go/internal/config"
@@ -15,37 +18,47 @@ import (
 
 type fakeProxy struct {
 	models    []proxy.ModelConfig
+	modelsErr error
 	chatResp  *types.ChatCompletionResponse
 	chatErr   error
 	stream    <-chan []byte
 	streamErr error
+	lastReq   *types.ChatCompletionRequest
 }
 
-func (f *fakeProxy) ChatCompletions(_ context.Context, _ *types.ChatCompletionRequest) (*types.ChatCompletionResponse, error) {
+func (f *fakeProxy) ChatCompletions(_ context.Context, req *types.ChatCompletionRequest) (*types.ChatCompletionResponse, error) {
+	f.lastReq = req
 	if f.chatErr != nil {
 		return nil, f.chatErr
 	}
 	return f.chatResp, nil
 }
 
-func (f *fakeProxy) ChatCompletionsStream(_ context.Context, _ *types.ChatCompletionRequest) (<-chan []byte, error) {
+func (f *fakeProxy) ChatCompletionsStream(_ context.Context, req *types.ChatCompletionRequest) (<-chan []byte, error) {
+	f.lastReq = req
 	if f.streamErr != nil {
 		return nil, f.streamErr
 	}
 	return f.stream, nil
 }
 
-func (f *fakeProxy) Models() []proxy.ModelConfig {
-	return f.models
+func (f *fakeProxy) FetchModels(context.Context) ([]proxy.ModelConfig, error) {
+	if f.modelsErr != nil {
+		return nil, f.modelsErr
+	}
+	return f.models, nil
 }
 
 func newTestServer(t *testing.T) *Server {
 	t.Helper()
 
+	// Handler tests authenticate with the account UUID; client key auth is
+	// covered separately in TestClientKey*.
 	cfg := &config.Config{
-		Host:    "127.0.0.1",
-		Port:    28000,
-		DataDir: t.TempDir(),
+		Host:           "127.0.0.1",
+		Port:           28000,
+		DataDir:        t.TempDir(),
+		LegacyUUIDAuth: true,
 	}
 	return New(cfg)
 }
@@ -152,6 +165,39 @@ func TestHandleModels(t *testing.T) {
 	}
 }
 
+func TestHandleModelByID(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+
+	s.newProxy = func(*account.Account) proxyClient {
+		return &fakeProxy{
+			models: []proxy.ModelConfig{{ID: "glm-5", ContextLength: 128000}},
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/models/glm-5", nil)
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `"id":"glm-5"`) || !strings.Contains(body, `"context_length":128000`) || !strings.Contains(body, `"name":"GLM-5"`) {
+		t.Fatalf("unexpected model body: %s", body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/models/kimi-k2", nil)
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec = httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), `"code":"model_not_found"`) {
+		t.Fatalf("status = %d, body=%s, want 404 model_not_found", rec.Code, rec.Body.String())
+	}
+}
+
 func TestHandleChatCompletions(t *testing.T) {
 	s := newTestServer(t)
 	acct := createTestAccount(t, s)
@@ -250,3 +296,142 @@ func TestHandleChatCompletionsBodyLimit(t *testing.T) {
 		t.Fatalf("status = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
 	}
 }
+
+func TestPoolKeyRoutesToAccount(t *testing.T) {
+	cfg := &config.Config{
+		Host:         "127.0.0.1",
+		Port:         28000,
+		DataDir:      t.TempDir(),
+		PoolKey:      "pool-secret",
+		PoolStrategy: "round_robin",
+	}
+	s := New(cfg)
+	first := createTestAccount(t, s)
+	second := createTestAccount(t, s)
+
+	finish := "stop"
+	s.newProxy = func(*account.Account) proxyClient {
+		return &fakeProxy{
+			chatResp: &types.ChatCompletionResponse{
+				ID:      "chat-pool",
+				Choices: []types.Choice{{Message: &types.Message{Role: "assistant", Content: "ok"}, FinishReason: &finish}},
+			},
+		}
+	}
+
+	used := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		body := `{"model":"glm-5","messages":[{"role":"user","content":"hello"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer pool-secret")
+		rec := httptest.NewRecorder()
+		s.httpServer.Handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		used[rec.Header().Get("X-IFlow-Account")] = true
+	}
+	if !used[maskToken(first.UUID)] || !used[maskToken(second.UUID)] {
+		t.Fatalf("pool should rotate accounts, used = %v", used)
+	}
+
+	for _, uuid := range []string{first.UUID, second.UUID} {
+		acct, err := s.accountMgr.Get(uuid)
+		if err != nil {
+			t.Fatalf("reload account: %v", err)
+		}
+		if acct.RequestCount != 1 {
+			t.Fatalf("request_count for %s = %d, want 1", uuid, acct.RequestCount)
+		}
+	}
+}
+
+func TestServerLocalTelemetry(t *testing.T) {
+	cfg := &config.Config{
+		Host:           "127.0.0.1",
+		Port:           28000,
+		DataDir:        t.TempDir(),
+		Telemetry:      "local",
+		LegacyUUIDAuth: true,
+	}
+	s := New(cfg)
+	t.Cleanup(func() { _ = proxy.ConfigureTelemetry(proxy.TelemetryUpstream, nil) })
+
+	if s.telemetrySink == nil {
+		t.Fatal("local telemetry should open a jsonl sink")
+	}
+	if _, err := os.Stat(filepath.Join(cfg.DataDir, "telemetry.jsonl")); err != nil {
+		t.Fatalf("telemetry file not created: %v", err)
+	}
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+
+	ch := make(chan []byte, 2)
+	ch <- []byte("data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"}}]}\n\n")
+	ch <- []byte("data: [DONE]\n\n")
+	close(ch)
+	s.newProxy = func(*account.Account) proxyClient {
+		return &fakeProxy{stream: ch}
+	}
+
+	body := `{"model":"metrics-model","stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	s.httpServer.Handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	out := rec.Body.String()
+	accountLabel := acct.UUID[:8]
+	for _, want := range []string{
+		`iflow_http_requests_total{account="` + accountLabel + `",model="other",route="/v1/chat/completions",status="200"} 1`,
+		`iflow_stream_time_to_first_token_seconds_count{account="` + accountLabel + `",model="other",route="/v1/chat/completions"} 1`,
+		`iflow_streams_in_flight{route="/v1/chat/completions"} 0`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("metrics missing %s", want)
+		}
+	}
+	if strings.Contains(out, acct.UUID) {
+		t.Fatal("metrics must not expose the full account uuid")
+	}
+}
+
+func TestChatStreamKeepalive(t *testing.T) {
+	s := newTestServer(t)
+	s.config.SSEKeepalive = 10 * time.Millisecond
+	acct := createTestAccount(t, s)
+
+	ch := make(chan []byte)
+	go func() {
+		time.Sleep(80 * time.Millisecond)
+		ch <- []byte("data: {\"id\":\"chunk-1\",\"choices\":[{\"delta\":{\"content\":\"late\"}}]}\n\n")
+		close(ch)
+	}()
+	s.newProxy = func(*account.Account) proxyClient {
+		return &fakeProxy{stream: ch}
+	}
+
+	body := `{"model":"glm-5","messages":[{"role":"user","content":"hello"}],"stream":true}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	respBody := rec.Body.String()
+	pingAt := strings.Index(respBody, ": ping\n\n")
+	if pingAt < 0 || pingAt > strings.Index(respBody, "late") {
+		t.Fatalf("expected keepalive ping before the first chunk: %q", respBody)
+	}
+}
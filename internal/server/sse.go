@@ -3,6 +3,7 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"time"
 )
 
 type SSEWriter struct {
@@ -41,3 +42,55 @@ func (s *SSEWriter) WriteDone() error {
 	s.flusher.Flush()
 	return nil
 }
+
+func (s *SSEWriter) WriteNamedEvent(event, data string) error {
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return fmt.Errorf("sse write named event: %w", err)
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// WritePing writes an SSE comment frame. Clients ignore it, but it keeps
+// proxies and load balancers from closing a silent connection.
+func (s *SSEWriter) WritePing() error {
+	if _, err := s.w.Write([]byte(": ping\n\n")); err != nil {
+		return fmt.Errorf("sse write ping: %w", err)
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// heartbeat paces keepalive pings while a handler waits on the proxy
+// channel. A zero interval disables it; C then never fires.
+type heartbeat struct {
+	ticker   *time.Ticker
+	interval time.Duration
+}
+
+func newHeartbeat(interval time.Duration) *heartbeat {
+	if interval <= 0 {
+		return &heartbeat{}
+	}
+	return &heartbeat{ticker: time.NewTicker(interval), interval: interval}
+}
+
+func (h *heartbeat) C() <-chan time.Time {
+	if h.ticker == nil {
+		return nil
+	}
+	return h.ticker.C
+}
+
+// Reset postpones the next ping after real data was written.
+func (h *heartbeat) Reset() {
+	if h.ticker != nil {
+		h.ticker.Reset(h.interval)
+	}
+}
+
+func (h *heartbeat) Stop() {
+	if h.ticker != nil {
+		h.ticker.Stop()
+	}
+}
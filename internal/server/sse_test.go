@@ -28,3 +28,21 @@ func TestSSEWriter(t *testing.T) {
 		t.Fatalf("missing done marker: %s", body)
 	}
 }
+
+func TestSSEWriterPing(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sse, err := NewSSEWriter(rec)
+	if err != nil {
+		t.Fatalf("NewSSEWriter error: %v", err)
+	}
+	if err := sse.WritePing(); err != nil {
+		t.Fatalf("WritePing error: %v", err)
+	}
+	if rec.Body.String() != ": ping\n\n" {
+		t.Fatalf("unexpected ping frame: %q", rec.Body.String())
+	}
+
+	if newHeartbeat(0).C() != nil {
+		t.Fatal("disabled heartbeat should have a nil channel")
+	}
+}
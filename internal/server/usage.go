@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/rogeecn/iflow-go/internal/usage"
+	"github.com/rogeecn/iflow-go/pkg/types"
+	"github.com/rs/zerolog/log"
+)
+
+// recordUsage is the single place where a finished request is accounted:
+// it bumps the account request counter, adds the upstream token usage to
+// the daily ledger and charges the caller's rate limits.
+func (s *Server) recordUsage(ctx context.Context, uuid, model string, reported *types.Usage) {
+	if err := s.accountMgr.UpdateUsage(uuid); err != nil {
+		log.Warn().
+			Err(err).
+			Str("account_uuid", uuid).
+			Msg("failed to update account usage")
+	}
+
+	tokens := usage.Tokens{}
+	if reported != nil {
+		tokens.Prompt = reported.PromptTokens
+		tokens.Completion = reported.CompletionTokens
+		if reported.CompletionTokensDetails != nil {
+			tokens.Reasoning = reported.CompletionTokensDetails.ReasoningTokens
+		}
+	}
+	if err := s.usageLedger.Record(uuid, model, tokens); err != nil {
+		log.Warn().
+			Err(err).
+			Str("account_uuid", uuid).
+			Str("model", model).
+			Msg("failed to record token usage")
+	}
+	s.chargeRateLimits(ctx, int64(tokens.Prompt+tokens.Completion))
+}
+
+// lastChunkUsage returns the usage carried by a proxied stream chunk, if any.
+// iFlow reports usage on the final chunk only.
+func lastChunkUsage(chunk []byte) *types.Usage {
+	chunks, _ := decodeProxyChunk(chunk)
+	var found *types.Usage
+	for _, parsed := range chunks {
+		if parsed.Usage != nil {
+			found = parsed.Usage
+		}
+	}
+	return found
+}
+
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		log.Warn().
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Msg("usage endpoint rejected invalid method")
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed", "invalid_request_error", "method_not_allowed")
+		return
+	}
+
+	binding, ok := bindingFromContext(r.Context())
+	if !ok {
+		log.Error().
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Msg("usage endpoint missing account context")
+		writeAPIError(w, http.StatusUnauthorized, "missing account context", "invalid_request_error", "invalid_api_key")
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := usage.ParseDate(query.Get("from"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error(), "invalid_request_error", "bad_request")
+		return
+	}
+	to, err := usage.ParseDate(query.Get("to"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error(), "invalid_request_error", "bad_request")
+		return
+	}
+
+	// Account-bound callers only see their own usage. Across the pool only the
+	// operator's pool key may look; pool-bound client keys are per-client
+	// credentials and must not see other accounts.
+	filter := usage.Filter{From: from, To: to, Model: strings.TrimSpace(query.Get("model"))}
+	account := ""
+	if binding.pool == nil {
+		filter.Account = binding.account.UUID
+	} else if binding.key != nil {
+		writeAPIError(w, http.StatusForbidden, "pool usage requires the pool key", "permission_error", "usage_not_allowed")
+		return
+	} else {
+		account = strings.TrimSpace(query.Get("account"))
+	}
+
+	records, err := s.usageLedger.Query(filter)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("account_uuid", binding.account.UUID).
+			Msg("usage query failed")
+		writeAPIError(w, http.StatusInternalServerError, "usage query failed", "internal_error", "internal_error")
+		return
+	}
+
+	records = maskUsageRecords(records, account)
+
+	switch strings.ToLower(strings.TrimSpace(query.Get("format"))) {
+	case "", "json":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"object": "list",
+			"data":   records,
+			"total":  usage.Total(records),
+		})
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if err := usage.WriteCSV(w, records); err != nil {
+			log.Warn().Err(err).Msg("usage csv write failed")
+		}
+	default:
+		writeAPIError(w, http.StatusBadRequest, "format must be json or csv", "invalid_request_error", "bad_request")
+	}
+}
+
+// maskUsageRecords hides account UUIDs, which are credentials under legacy
+// auth, and applies the account filter, which accepts either the UUID or its
+// masked form as shown in X-IFlow-Account.
+func maskUsageRecords(records []usage.Record, account string) []usage.Record {
+	masked := make([]usage.Record, 0, len(records))
+	for _, record := range records {
+		if account != "" && record.AccountUUID != account && maskToken(record.AccountUUID) != account {
+			continue
+		}
+		record.AccountUUID = maskToken(record.AccountUUID)
+		masked = append(masked, record)
+	}
+	return masked
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rogeecn/iflow-go/internal/account"
+	"github.com/rogeecn/iflow-go/internal/apikey"
+	"github.com/rogeecn/iflow-go/internal/usage"
+	"github.com/rogeecn/iflow-go/pkg/types"
+)
+
+func TestChatRecordsTokenUsage(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+
+	finish := "stop"
+	s.newProxy = func(*account.Account) proxyClient {
+		return &fakeProxy{
+			chatResp: &types.ChatCompletionResponse{
+				ID:     "chat-1",
+				Object: "chat.completion",
+				Model:  "glm-5",
+				Choices: []types.Choice{
+					{Message: &types.Message{Role: "assistant", Content: "ok"}, FinishReason: &finish},
+				},
+				Usage: types.Usage{
+					PromptTokens:            12,
+					CompletionTokens:        8,
+					TotalTokens:             20,
+					CompletionTokensDetails: &types.CompletionTokensDetails{ReasoningTokens: 3},
+				},
+			},
+		}
+	}
+
+	body := `{"model":"glm-5","messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+
+	records, err := s.usageLedger.Query(usage.Filter{Account: acct.UUID})
+	if err != nil {
+		t.Fatalf("query usage: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("records = %d, want 1", len(records))
+	}
+	got := records[0]
+	if got.Model != "glm-5" || got.Requests != 1 || got.PromptTokens != 12 || got.CompletionTokens != 8 || got.ReasoningTokens != 3 || got.TotalTokens != 20 {
+		t.Fatalf("unexpected usage record: %+v", got)
+	}
+}
+
+func TestChatStreamRecordsFinalChunkUsage(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+
+	ch := make(chan []byte, 3)
+	ch <- []byte("data: {\"id\":\"chunk-1\",\"choices\":[{\"delta\":{\"content\":\"hello\"}}]}\n\n")
+	ch <- []byte("data: {\"id\":\"chunk-2\",\"choices\":[{\"delta\":{},\"finish_reason\":\"stop\"}],\"usage\":{\"prompt_tokens\":4,\"completion_tokens\":6,\"total_tokens\":10}}\n\n")
+	ch <- []byte("data: [DONE]\n\n")
+	close(ch)
+	s.newProxy = func(*account.Account) proxyClient {
+		return &fakeProxy{stream: ch}
+	}
+
+	body := `{"model":"glm-5","messages":[{"role":"user","content":"hello"}],"stream":true}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+
+	records, err := s.usageLedger.Query(usage.Filter{})
+	if err != nil {
+		t.Fatalf("query usage: %v", err)
+	}
+	if len(records) != 1 || records[0].PromptTokens != 4 || records[0].CompletionTokens != 6 {
+		t.Fatalf("unexpected usage records: %+v", records)
+	}
+}
+
+func TestHandleUsage(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+	other := createTestAccount(t, s)
+
+	if err := s.usageLedger.Record(acct.UUID, "glm-5", usage.Tokens{Prompt: 2, Completion: 3}); err != nil {
+		t.Fatalf("record usage: %v", err)
+	}
+	if err := s.usageLedger.Record(other.UUID, "glm-5", usage.Tokens{Prompt: 5, Completion: 5}); err != nil {
+		t.Fatalf("record usage: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/usage?account="+other.UUID, nil)
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+
+	var payload struct {
+		Data  []usage.Record `json:"data"`
+		Total usage.Record   `json:"total"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode usage: %v", err)
+	}
+	if len(payload.Data) != 1 || payload.Data[0].AccountUUID != maskToken(acct.UUID) {
+		t.Fatalf("account token should only see its own usage: %+v", payload.Data)
+	}
+	if payload.Total.TotalTokens != 5 {
+		t.Fatalf("total tokens = %d, want 5", payload.Total.TotalTokens)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/usage?format=csv", nil)
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec = httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("csv status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("unexpected Content-Type: %s", rec.Header().Get("Content-Type"))
+	}
+	if !strings.HasPrefix(rec.Body.String(), "date,account_uuid,model") {
+		t.Fatalf("unexpected csv body: %s", rec.Body.String())
+	}
+
+	if strings.Contains(rec.Body.String(), acct.UUID) {
+		t.Fatalf("csv should not expose account uuids: %s", rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/usage?from=bad", nil)
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec = httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad date status = %d, want 400", rec.Code)
+	}
+}
+
+func TestHandleUsagePoolCallers(t *testing.T) {
+	s, acct := newClientKeyServer(t)
+	s.auth.PoolKey = "pool-secret"
+	if err := s.usageLedger.Record(acct.UUID, "glm-5", usage.Tokens{Prompt: 2, Completion: 3}); err != nil {
+		t.Fatalf("record usage: %v", err)
+	}
+
+	rec := serveWithToken(s, http.MethodGet, "/v1/usage?account="+maskToken(acct.UUID), "", "pool-secret")
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), acct.UUID) || !strings.Contains(rec.Body.String(), maskToken(acct.UUID)) {
+		t.Fatalf("pool key usage status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+
+	pooled, _, err := s.auth.Keys.Create(apikey.Options{Pool: true})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	rec = serveWithToken(s, http.MethodGet, "/v1/usage", "", pooled)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("pool client key usage status = %d, want 403", rec.Code)
+	}
+}
+
+func TestChatStreamRecordsIncludeUsageChunk(t *testing.T) {
+	s := newTestServer(t)
+	acct := createTestAccount(t, s)
+
+	ch := make(chan []byte, 3)
+	ch <- []byte("data: {\"id\":\"chunk-1\",\"choices\":[{\"delta\":{\"content\":\"hello\"},\"finish_reason\":\"stop\"}]}\n\n")
+	ch <- []byte("data: {\"id\":\"chunk-1\",\"object\":\"chat.completion.chunk\",\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":2,\"total_tokens\":5,\"estimated\":true}}\n\n")
+	ch <- []byte("data: [DONE]\n\n")
+	close(ch)
+	fake := &fakeProxy{stream: ch}
+	s.newProxy = func(*account.Account) proxyClient { return fake }
+
+	body := `{"model":"glm-5","messages":[{"role":"user","content":"hello"}],"stream":true,"stream_options":{"include_usage":true}}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+acct.UUID)
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+	if fake.lastReq.StreamOptions == nil || !fake.lastReq.StreamOptions.IncludeUsage {
+		t.Fatalf("stream_options not passed to proxy: %+v", fake.lastReq.StreamOptions)
+	}
+	if !strings.Contains(rec.Body.String(), `"choices":[]`) {
+		t.Fatalf("usage chunk not forwarded: %s", rec.Body.String())
+	}
+
+	records, err := s.usageLedger.Query(usage.Filter{})
+	if err != nil {
+		t.Fatalf("query usage: %v", err)
+	}
+	if len(records) != 1 || records[0].TotalTokens != 5 {
+		t.Fatalf("unexpected usage records: %+v", records)
+	}
+}
@@ -0,0 +1,253 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Settings describes how outbound traffic reaches the internet.
+type Settings struct {
+	ProxyURL string
+	NoProxy  string
+}
+
+var (
+	defaultMu       sync.RWMutex
+	defaultSettings Settings
+)
+
+// SetDefault installs the process-wide upstream proxy used by every client
+// that does not carry its own proxy URL.
+func SetDefault(settings Settings) error {
+	if _, err := ParseProxyURL(settings.ProxyURL); err != nil {
+		return err
+	}
+
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultSettings = Settings{
+		ProxyURL: strings.TrimSpace(settings.ProxyURL),
+		NoProxy:  strings.TrimSpace(settings.NoProxy),
+	}
+	return nil
+}
+
+func Default() Settings {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultSettings
+}
+
+// transports caches one *http.Transport per resolved Settings (and phase
+// bounds) so clients built per request still share keep-alive connections.
+var transports sync.Map
+
+// Timeouts bound the connection phases of a transport from BoundedTransport.
+// A zero value disables that bound.
+type Timeouts struct {
+	Dial           time.Duration
+	TLSHandshake   time.Duration
+	ResponseHeader time.Duration
+}
+
+type transportKey struct {
+	settings Settings
+	timeouts Timeouts
+	bounded  bool
+}
+
+// NewClient builds an http.Client that egresses through proxyURL, or through
+// the default upstream proxy when proxyURL is empty. Clients with the same
+// effective proxy share one transport.
+func NewClient(timeout time.Duration, proxyURL string) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: SharedTransport(proxyURL),
+	}
+}
+
+// SharedTransport returns the cached transport for proxyURL. Callers must not
+// modify it; use NewTransport for a private copy.
+func SharedTransport(proxyURL string) *http.Transport {
+	return cachedTransport(transportKey{settings: resolve(proxyURL)})
+}
+
+// BoundedTransport is SharedTransport with the dial, TLS handshake and
+// response header phases bounded by timeouts instead of the library defaults.
+func BoundedTransport(proxyURL string, timeouts Timeouts) *http.Transport {
+	return cachedTransport(transportKey{settings: resolve(proxyURL), timeouts: timeouts, bounded: true})
+}
+
+func cachedTransport(key transportKey) *http.Transport {
+	if cached, ok := transports.Load(key); ok {
+		return cached.(*http.Transport)
+	}
+	base := newTransport(key.settings)
+	if key.bounded {
+		base.DialContext = (&net.Dialer{Timeout: key.timeouts.Dial, KeepAlive: 30 * time.Second}).DialContext
+		base.TLSHandshakeTimeout = key.timeouts.TLSHandshake
+		base.ResponseHeaderTimeout = key.timeouts.ResponseHeader
+	}
+	cached, _ := transports.LoadOrStore(key, base)
+	return cached.(*http.Transport)
+}
+
+// NewTransport returns a fresh transport for proxyURL that the caller owns.
+func NewTransport(proxyURL string) *http.Transport {
+	return newTransport(resolve(proxyURL))
+}
+
+// resolve applies the default proxy settings to proxyURL.
+func resolve(proxyURL string) Settings {
+	settings := Default()
+	if proxyURL = strings.TrimSpace(proxyURL); proxyURL != "" {
+		settings.ProxyURL = proxyURL
+	}
+	return settings
+}
+
+func newTransport(settings Settings) *http.Transport {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.Proxy = ProxyFunc(settings)
+	return base
+}
+
+// ProxyFunc resolves the proxy for each request. An invalid proxy URL fails
+// every request instead of silently connecting directly.
+func ProxyFunc(settings Settings) func(*http.Request) (*url.URL, error) {
+	proxyURL, err := ParseProxyURL(settings.ProxyURL)
+	noProxy := parseNoProxy(settings.NoProxy)
+
+	return func(req *http.Request) (*url.URL, error) {
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL == nil || noProxy.matches(req.URL) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}
+}
+
+// ParseProxyURL validates an http, https or socks5 proxy URL. Empty input
+// means no proxy.
+func ParseProxyURL(raw string) (*url.URL, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse proxy url: %w", err)
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("parse proxy url: unsupported scheme %q", parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("parse proxy url: missing host")
+	}
+	return parsed, nil
+}
+
+// RedactProxyURL hides proxy credentials for logging.
+func RedactProxyURL(raw string) string {
+	parsed, err := ParseProxyURL(raw)
+	if err != nil || parsed == nil {
+		return strings.TrimSpace(raw)
+	}
+	return parsed.Redacted()
+}
+
+type noProxyRules struct {
+	all      bool
+	hosts    []noProxyHost
+	networks []*net.IPNet
+}
+
+type noProxyHost struct {
+	domain string
+	port   string
+	suffix bool
+}
+
+// parseNoProxy follows the common NO_PROXY conventions: comma separated
+// hosts, ".domain" or "domain" suffixes, optional ports, IPs, CIDRs and "*".
+func parseNoProxy(value string) noProxyRules {
+	var rules noProxyRules
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			rules.all = true
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			rules.networks = append(rules.networks, network)
+			continue
+		}
+
+		host, port := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			host, port = h, p
+		}
+		host = strings.TrimPrefix(host, "*")
+		suffix := strings.HasPrefix(host, ".")
+		rules.hosts = append(rules.hosts, noProxyHost{
+			domain: strings.TrimPrefix(host, "."),
+			port:   port,
+			suffix: suffix,
+		})
+	}
+	return rules
+}
+
+func (r noProxyRules) matches(target *url.URL) bool {
+	if target == nil {
+		return false
+	}
+	if r.all {
+		return true
+	}
+
+	host := strings.ToLower(target.Hostname())
+	port := target.Port()
+	if port == "" {
+		switch target.Scheme {
+		case "https":
+			port = "443"
+		case "http":
+			port = "80"
+		}
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range r.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	for _, rule := range r.hosts {
+		if rule.port != "" && rule.port != port {
+			continue
+		}
+		if host == rule.domain && !rule.suffix {
+			return true
+		}
+		if strings.HasSuffix(host, "."+rule.domain) {
+			return true
+		}
+	}
+	return false
+}
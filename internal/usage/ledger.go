@@ -0,0 +1,352 @@
+package usage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const dateLayout = "2006-01-02"
+
+// Tokens is the token usage reported by upstream for one request.
+type Tokens struct {
+	Prompt     int
+	Completion int
+	Reasoning  int
+}
+
+// Record aggregates usage for one account and model on one UTC day.
+type Record struct {
+	Date             string `json:"date"`
+	AccountUUID      string `json:"account_uuid"`
+	Model            string `json:"model"`
+	Requests         int64  `json:"requests"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	ReasoningTokens  int64  `json:"reasoning_tokens"`
+	TotalTokens      int64  `json:"total_tokens"`
+}
+
+type Filter struct {
+	From    time.Time
+	To      time.Time
+	Account string
+	Model   string
+}
+
+// Ledger stores daily usage files under <dataDir>/usage/<date>.json. Once
+// StartFlusher runs, Record only aggregates in memory and the flusher merges
+// the totals into the files; otherwise every Record writes through.
+type Ledger struct {
+	dataDir string
+	now     func() time.Time
+
+	// mu guards pending; fileMu serializes access to the usage files so
+	// Record never waits on disk IO while batching.
+	mu       sync.Mutex
+	pending  map[recordKey]*Record
+	batched  bool
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+
+	fileMu sync.Mutex
+}
+
+type recordKey struct {
+	date, account, model string
+}
+
+func NewLedger(dataDir string) *Ledger {
+	return &Ledger{dataDir: dataDir, now: time.Now, pending: map[recordKey]*Record{}}
+}
+
+func (l *Ledger) Record(accountUUID, model string, tokens Tokens) error {
+	date := l.now().UTC().Format(dateLayout)
+
+	l.mu.Lock()
+	key := recordKey{date: date, account: accountUUID, model: model}
+	target := l.pending[key]
+	if target == nil {
+		target = &Record{Date: date, AccountUUID: accountUUID, Model: model}
+		l.pending[key] = target
+	}
+	target.add(Record{
+		Requests:         1,
+		PromptTokens:     int64(tokens.Prompt),
+		CompletionTokens: int64(tokens.Completion),
+		ReasoningTokens:  int64(tokens.Reasoning),
+		TotalTokens:      int64(tokens.Prompt + tokens.Completion),
+	})
+	batched := l.batched
+	l.mu.Unlock()
+
+	if batched {
+		return nil
+	}
+	if err := l.Flush(); err != nil {
+		return fmt.Errorf("record usage: %w", err)
+	}
+	return nil
+}
+
+// StartFlusher switches Record to in-memory aggregation and flushes the
+// totals every interval. A non-positive interval keeps writing through.
+func (l *Ledger) StartFlusher(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.batched {
+		return
+	}
+	l.batched = true
+	l.stopChan = make(chan struct{})
+
+	l.wg.Add(1)
+	go l.flushLoop(interval, l.stopChan)
+}
+
+// Flush merges pending usage into the daily files. Days that fail to save
+// stay pending for the next flush.
+func (l *Ledger) Flush() error {
+	l.mu.Lock()
+	pending := l.pending
+	l.pending = map[recordKey]*Record{}
+	l.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	days := map[string][]*Record{}
+	for _, record := range pending {
+		days[record.Date] = append(days[record.Date], record)
+	}
+
+	l.fileMu.Lock()
+	defer l.fileMu.Unlock()
+
+	var errs []error
+	for date, deltas := range days {
+		if err := l.mergeDay(date, deltas); err != nil {
+			errs = append(errs, err)
+			l.requeue(deltas)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("flush usage: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Close stops the flusher and writes pending usage.
+func (l *Ledger) Close() error {
+	l.mu.Lock()
+	stopChan := l.stopChan
+	l.stopChan = nil
+	l.batched = false
+	l.mu.Unlock()
+
+	if stopChan != nil {
+		close(stopChan)
+		l.wg.Wait()
+	}
+	return l.Flush()
+}
+
+func (l *Ledger) flushLoop(interval time.Duration, stopChan <-chan struct{}) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.Flush(); err != nil {
+				log.Warn().
+					Err(err).
+					Msg("flush token usage failed, will retry")
+			}
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+func (l *Ledger) mergeDay(date string, deltas []*Record) error {
+	records, err := l.loadDay(date)
+	if err != nil {
+		return err
+	}
+	for _, delta := range deltas {
+		var target *Record
+		for i := range records {
+			if records[i].AccountUUID == delta.AccountUUID && records[i].Model == delta.Model {
+				target = &records[i]
+				break
+			}
+		}
+		if target == nil {
+			records = append(records, Record{Date: date, AccountUUID: delta.AccountUUID, Model: delta.Model})
+			target = &records[len(records)-1]
+		}
+		target.add(*delta)
+	}
+	return l.saveDay(date, records)
+}
+
+func (l *Ledger) requeue(deltas []*Record) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, delta := range deltas {
+		key := recordKey{date: delta.Date, account: delta.AccountUUID, model: delta.Model}
+		if target := l.pending[key]; target != nil {
+			target.add(*delta)
+			continue
+		}
+		l.pending[key] = delta
+	}
+}
+
+func (r *Record) add(delta Record) {
+	r.Requests += delta.Requests
+	r.PromptTokens += delta.PromptTokens
+	r.CompletionTokens += delta.CompletionTokens
+	r.ReasoningTokens += delta.ReasoningTokens
+	r.TotalTokens += delta.TotalTokens
+}
+
+// Query returns records whose day lies in [From, To], ordered by date,
+// account and model. Zero From/To leave that side of the range open.
+func (l *Ledger) Query(filter Filter) ([]Record, error) {
+	if err := l.Flush(); err != nil {
+		return nil, fmt.Errorf("query usage: %w", err)
+	}
+
+	l.fileMu.Lock()
+	defer l.fileMu.Unlock()
+
+	entries, err := os.ReadDir(l.usageDir())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []Record{}, nil
+		}
+		return nil, fmt.Errorf("query usage: read dir: %w", err)
+	}
+
+	from, to := "", ""
+	if !filter.From.IsZero() {
+		from = filter.From.UTC().Format(dateLayout)
+	}
+	if !filter.To.IsZero() {
+		to = filter.To.UTC().Format(dateLayout)
+	}
+
+	result := make([]Record, 0)
+	for _, entry := range entries {
+		date, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		if _, err := time.Parse(dateLayout, date); err != nil {
+			continue
+		}
+		if (from != "" && date < from) || (to != "" && date > to) {
+			continue
+		}
+
+		records, err := l.loadDay(date)
+		if err != nil {
+			return nil, fmt.Errorf("query usage: %w", err)
+		}
+		for _, record := range records {
+			if filter.Account != "" && record.AccountUUID != filter.Account {
+				continue
+			}
+			if filter.Model != "" && record.Model != filter.Model {
+				continue
+			}
+			result = append(result, record)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Date != result[j].Date {
+			return result[i].Date < result[j].Date
+		}
+		if result[i].AccountUUID != result[j].AccountUUID {
+			return result[i].AccountUUID < result[j].AccountUUID
+		}
+		return result[i].Model < result[j].Model
+	})
+	return result, nil
+}
+
+// ParseDate parses a YYYY-MM-DD day. Empty input yields the zero time.
+func ParseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	parsed, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, want YYYY-MM-DD", value)
+	}
+	return parsed, nil
+}
+
+func (l *Ledger) loadDay(date string) ([]Record, error) {
+	content, err := os.ReadFile(l.dayPath(date))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read usage file: %w", err)
+	}
+
+	var records []Record
+	if err := json.Unmarshal(content, &records); err != nil {
+		return nil, fmt.Errorf("unmarshal usage file: %w", err)
+	}
+	return records, nil
+}
+
+func (l *Ledger) saveDay(date string, records []Record) error {
+	if err := os.MkdirAll(l.usageDir(), 0o755); err != nil {
+		return fmt.Errorf("ensure usage dir: %w", err)
+	}
+
+	payload, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal usage file: %w", err)
+	}
+
+	path := l.dayPath(date)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, payload, 0o600); err != nil {
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename temp file: %w", err)
+	}
+	return nil
+}
+
+func (l *Ledger) usageDir() string {
+	return filepath.Join(l.dataDir, "usage")
+}
+
+func (l *Ledger) dayPath(date string) string {
+	return filepath.Join(l.usageDir(), date+".json")
+}
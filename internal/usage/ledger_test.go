@@ -0,0 +1,139 @@
+package usage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLedgerRecordAndQuery(t *testing.T) {
+	ledger := NewLedger(t.TempDir())
+
+	day1 := time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC)
+	day2 := time.Date(2026, 3, 2, 1, 0, 0, 0, time.UTC)
+
+	ledger.now = func() time.Time { return day1 }
+	if err := ledger.Record("acct-a", "glm-5", Tokens{Prompt: 10, Completion: 5, Reasoning: 2}); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if err := ledger.Record("acct-a", "glm-5", Tokens{Prompt: 1, Completion: 1}); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	ledger.now = func() time.Time { return day2 }
+	if err := ledger.Record("acct-b", "kimi-k2", Tokens{Prompt: 7, Completion: 3}); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+
+	all, err := ledger.Query(Filter{})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("records = %d, want 2: %+v", len(all), all)
+	}
+	first := all[0]
+	if first.Date != "2026-03-01" || first.Requests != 2 || first.PromptTokens != 11 || first.CompletionTokens != 6 || first.ReasoningTokens != 2 || first.TotalTokens != 17 {
+		t.Fatalf("unexpected first record: %+v", first)
+	}
+
+	from, _ := ParseDate("2026-03-02")
+	ranged, err := ledger.Query(Filter{From: from})
+	if err != nil {
+		t.Fatalf("query range: %v", err)
+	}
+	if len(ranged) != 1 || ranged[0].AccountUUID != "acct-b" {
+		t.Fatalf("unexpected ranged records: %+v", ranged)
+	}
+
+	byModel, err := ledger.Query(Filter{Model: "glm-5", Account: "acct-a"})
+	if err != nil {
+		t.Fatalf("query model: %v", err)
+	}
+	if len(byModel) != 1 || byModel[0].Model != "glm-5" {
+		t.Fatalf("unexpected model records: %+v", byModel)
+	}
+
+	total := Total(all)
+	if total.Requests != 3 || total.TotalTokens != 27 {
+		t.Fatalf("unexpected total: %+v", total)
+	}
+}
+
+func TestLedgerFlusherBatchesWrites(t *testing.T) {
+	dataDir := t.TempDir()
+	ledger := NewLedger(dataDir)
+	ledger.StartFlusher(time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if err := ledger.Record("acct-a", "glm-5", Tokens{Prompt: 2, Completion: 1}); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+	// Another process reading the files sees nothing until a flush.
+	stored, err := NewLedger(dataDir).Query(Filter{})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(stored) != 0 {
+		t.Fatalf("records written before flush: %+v", stored)
+	}
+
+	// Query flushes first, so this process sees its own usage.
+	own, err := ledger.Query(Filter{})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(own) != 1 || own[0].Requests != 3 || own[0].TotalTokens != 9 {
+		t.Fatalf("unexpected own records: %+v", own)
+	}
+
+	if err := ledger.Record("acct-a", "glm-5", Tokens{Prompt: 1}); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if err := ledger.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	stored, err = NewLedger(dataDir).Query(Filter{})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(stored) != 1 || stored[0].Requests != 4 || stored[0].TotalTokens != 10 {
+		t.Fatalf("unexpected records after close: %+v", stored)
+	}
+}
+
+func TestLedgerQueryEmpty(t *testing.T) {
+	records, err := NewLedger(t.TempDir()).Query(Filter{})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("records = %d, want 0", len(records))
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	if _, err := ParseDate("2026/03/01"); err == nil {
+		t.Fatal("expected invalid date error")
+	}
+	parsed, err := ParseDate(" ")
+	if err != nil || !parsed.IsZero() {
+		t.Fatalf("empty date = %v, %v", parsed, err)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteCSV(&buf, []Record{{Date: "2026-03-01", AccountUUID: "acct-a", Model: "glm-5", Requests: 1, PromptTokens: 3, CompletionTokens: 4, TotalTokens: 7}})
+	if err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines = %d, want 2: %q", len(lines), buf.String())
+	}
+	if lines[1] != "2026-03-01,acct-a,glm-5,1,3,4,0,7" {
+		t.Fatalf("unexpected row: %q", lines[1])
+	}
+}
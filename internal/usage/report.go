@@ -0,0 +1,51 @@
+package usage
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+var csvHeader = []string{"date", "account_uuid", "model", "requests", "prompt_tokens", "completion_tokens", "reasoning_tokens", "total_tokens"}
+
+func WriteCSV(w io.Writer, records []Record) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return fmt.Errorf("write usage csv: %w", err)
+	}
+	for _, r := range records {
+		row := []string{
+			r.Date,
+			r.AccountUUID,
+			r.Model,
+			strconv.FormatInt(r.Requests, 10),
+			strconv.FormatInt(r.PromptTokens, 10),
+			strconv.FormatInt(r.CompletionTokens, 10),
+			strconv.FormatInt(r.ReasoningTokens, 10),
+			strconv.FormatInt(r.TotalTokens, 10),
+		}
+		if err := writer.Write(row); err != nil {
+			return fmt.Errorf("write usage csv: %w", err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("write usage csv: %w", err)
+	}
+	return nil
+}
+
+// Total sums the given records into a single record without date, account
+// or model.
+func Total(records []Record) Record {
+	var total Record
+	for _, r := range records {
+		total.Requests += r.Requests
+		total.PromptTokens += r.PromptTokens
+		total.CompletionTokens += r.CompletionTokens
+		total.ReasoningTokens += r.ReasoningTokens
+		total.TotalTokens += r.TotalTokens
+	}
+	return total
+}
@@ -0,0 +1,144 @@
+package types
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Extra holds JSON members that have no typed field. They are kept verbatim
+// so requests and responses survive a decode/encode round trip.
+type Extra map[string]json.RawMessage
+
+var knownFieldsCache sync.Map // reflect.Type -> map[string]struct{}
+
+// knownFields returns the JSON member names declared by struct type t.
+func knownFields(t reflect.Type) map[string]struct{} {
+	if cached, ok := knownFieldsCache.Load(t); ok {
+		return cached.(map[string]struct{})
+	}
+
+	fields := make(map[string]struct{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = struct{}{}
+	}
+	knownFieldsCache.Store(t, fields)
+	return fields
+}
+
+// decodeWithExtra decodes data into target (a pointer to an alias type
+// without custom unmarshalers) and returns the members target does not know.
+func decodeWithExtra(data []byte, target interface{}) (Extra, error) {
+	if err := json.Unmarshal(data, target); err != nil {
+		return nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	known := knownFields(reflect.TypeOf(target).Elem())
+	var extra Extra
+	for key, value := range raw {
+		if _, ok := known[key]; ok {
+			continue
+		}
+		if extra == nil {
+			extra = Extra{}
+		}
+		extra[key] = value
+	}
+	return extra, nil
+}
+
+// encodeWithExtra encodes value (an alias type without custom marshalers) and
+// merges extra members into the object. Typed fields win on conflicts.
+func encodeWithExtra(value interface{}, extra Extra) ([]byte, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil || len(extra) == 0 {
+		return encoded, err
+	}
+
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &merged); err != nil {
+		return nil, err
+	}
+	known := knownFields(reflect.TypeOf(value).Elem())
+	for key, raw := range extra {
+		if _, ok := known[key]; ok {
+			continue
+		}
+		merged[key] = raw
+	}
+	return json.Marshal(merged)
+}
+
+func (r *ChatCompletionRequest) UnmarshalJSON(data []byte) error {
+	type plain ChatCompletionRequest
+	extra, err := decodeWithExtra(data, (*plain)(r))
+	r.Extra = extra
+	return err
+}
+
+func (r ChatCompletionRequest) MarshalJSON() ([]byte, error) {
+	type plain ChatCompletionRequest
+	return encodeWithExtra((*plain)(&r), r.Extra)
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type plain Message
+	extra, err := decodeWithExtra(data, (*plain)(m))
+	m.Extra = extra
+	return err
+}
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	type plain Message
+	return encodeWithExtra((*plain)(&m), m.Extra)
+}
+
+func (r *ChatCompletionResponse) UnmarshalJSON(data []byte) error {
+	type plain ChatCompletionResponse
+	extra, err := decodeWithExtra(data, (*plain)(r))
+	r.Extra = extra
+	return err
+}
+
+func (r ChatCompletionResponse) MarshalJSON() ([]byte, error) {
+	type plain ChatCompletionResponse
+	return encodeWithExtra((*plain)(&r), r.Extra)
+}
+
+func (c *Choice) UnmarshalJSON(data []byte) error {
+	type plain Choice
+	extra, err := decodeWithExtra(data, (*plain)(c))
+	c.Extra = extra
+	return err
+}
+
+func (c Choice) MarshalJSON() ([]byte, error) {
+	type plain Choice
+	return encodeWithExtra((*plain)(&c), c.Extra)
+}
+
+func (u *Usage) UnmarshalJSON(data []byte) error {
+	type plain Usage
+	extra, err := decodeWithExtra(data, (*plain)(u))
+	u.Extra = extra
+	return err
+}
+
+func (u Usage) MarshalJSON() ([]byte, error) {
+	type plain Usage
+	return encodeWithExtra((*plain)(&u), u.Extra)
+}
@@ -0,0 +1,64 @@
+package types
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestChatCompletionRequestKeepsUnknownFields(t *testing.T) {
+	input := `{"model":"glm-5","messages":[{"role":"user","content":"hi","cache_control":{"type":"ephemeral"}}],"response_format":{"type":"json_object"},"seed":7,"stop":["END"]}`
+
+	var req ChatCompletionRequest
+	if err := json.Unmarshal([]byte(input), &req); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if req.Model != "glm-5" || len(req.Messages) != 1 {
+		t.Fatalf("typed fields not decoded: %+v", req)
+	}
+	if string(req.Extra["seed"]) != "7" || len(req.Extra) != 3 {
+		t.Fatalf("unexpected extra: %v", req.Extra)
+	}
+	if _, ok := req.Messages[0].Extra["cache_control"]; !ok {
+		t.Fatalf("message extra missing cache_control: %v", req.Messages[0].Extra)
+	}
+
+	encoded, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	for _, want := range []string{`"response_format":{"type":"json_object"}`, `"seed":7`, `"stop":["END"]`, `"cache_control":{"type":"ephemeral"}`} {
+		if !strings.Contains(string(encoded), want) {
+			t.Fatalf("encoded request missing %s: %s", want, encoded)
+		}
+	}
+}
+
+func TestChatCompletionResponseKeepsUnknownFields(t *testing.T) {
+	input := `{"id":"c1","object":"chat.completion","created":1,"model":"glm-5","service_tier":"default","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop","logprobs":{"content":[]}}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2,"prompt_tokens_details":{"cached_tokens":1}}}`
+
+	var resp ChatCompletionResponse
+	if err := json.Unmarshal([]byte(input), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	encoded, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	for _, want := range []string{`"service_tier":"default"`, `"logprobs":{"content":[]}`, `"prompt_tokens_details":{"cached_tokens":1}`} {
+		if !strings.Contains(string(encoded), want) {
+			t.Fatalf("encoded response missing %s: %s", want, encoded)
+		}
+	}
+}
+
+func TestExtraDoesNotOverrideTypedFields(t *testing.T) {
+	req := ChatCompletionRequest{Model: "glm-5", Extra: Extra{"model": json.RawMessage(`"other"`)}}
+	encoded, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if !strings.Contains(string(encoded), `"model":"glm-5"`) {
+		t.Fatalf("typed model should win: %s", encoded)
+	}
+}
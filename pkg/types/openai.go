@@ -1,26 +1,79 @@
 package types
 
+import "encoding/json"
+
 type ChatCompletionRequest struct {
-	Model            string        `json:"model"`
-	Messages         []Message     `json:"messages"`
-	Stream           bool          `json:"stream,omitempty"`
-	Temperature      *float64      `json:"temperature,omitempty"`
-	TopP             *float64      `json:"top_p,omitempty"`
-	MaxTokens        *int          `json:"max_tokens,omitempty"`
-	PresencePenalty  *float64      `json:"presence_penalty,omitempty"`
-	FrequencyPenalty *float64      `json:"frequency_penalty,omitempty"`
-	N                *int          `json:"n,omitempty"`
-	User             string        `json:"user,omitempty"`
-	Tools            []interface{} `json:"tools,omitempty"`
-	ToolChoice       interface{}   `json:"tool_choice,omitempty"`
+	Model             string               `json:"model"`
+	Messages          []Message            `json:"messages"`
+	Stream            bool                 `json:"stream,omitempty"`
+	StreamOptions     *StreamOptions       `json:"stream_options,omitempty"`
+	Temperature       *float64             `json:"temperature,omitempty"`
+	TopP              *float64             `json:"top_p,omitempty"`
+	MaxTokens         *int                 `json:"max_tokens,omitempty"`
+	PresencePenalty   *float64             `json:"presence_penalty,omitempty"`
+	FrequencyPenalty  *float64             `json:"frequency_penalty,omitempty"`
+	N                 *int                 `json:"n,omitempty"`
+	User              string               `json:"user,omitempty"`
+	Tools             []Tool               `json:"tools,omitempty"`
+	ToolChoice        interface{}          `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool                `json:"parallel_tool_calls,omitempty"`
+	Functions         []FunctionDefinition `json:"functions,omitempty"`
+	FunctionCall      interface{}          `json:"function_call,omitempty"`
+	ReasoningEffort   string               `json:"reasoning_effort,omitempty"`
+	Thinking          *AnthropicThinking   `json:"thinking,omitempty"`
+	Extra             Extra                `json:"-"`
+
+	// PreserveReasoning overrides the server-wide reasoning_content policy
+	// for this request. It is set from headers and never sent upstream.
+	PreserveReasoning *bool `json:"-"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage,omitempty"`
+}
+
+// Tool is an entry of the request "tools" array. Only "function" tools are
+// defined by the Chat Completions API.
+type Tool struct {
+	Type     string             `json:"type"`
+	Function FunctionDefinition `json:"function"`
+}
+
+type FunctionDefinition struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	Strict      *bool           `json:"strict,omitempty"`
+}
+
+// HasTools reports whether the request declares any tools or legacy functions.
+func (r *ChatCompletionRequest) HasTools() bool {
+	return r != nil && (len(r.Tools) > 0 || len(r.Functions) > 0)
 }
 
+// Message covers every chat role. Tool results use Role "tool" with
+// ToolCallID; the legacy "function" role uses Name.
 type Message struct {
-	Role             string      `json:"role"`
-	Content          interface{} `json:"content"`
-	ReasoningContent string      `json:"reasoning_content,omitempty"`
-	Name             string      `json:"name,omitempty"`
-	ToolCallID       string      `json:"tool_call_id,omitempty"`
+	Role             string            `json:"role"`
+	Content          interface{}       `json:"content"`
+	ReasoningContent string            `json:"reasoning_content,omitempty"`
+	Name             string            `json:"name,omitempty"`
+	ToolCalls        []ToolCall        `json:"tool_calls,omitempty"`
+	ToolCallID       string            `json:"tool_call_id,omitempty"`
+	FunctionCall     *ToolCallFunction `json:"function_call,omitempty"`
+	Extra            Extra             `json:"-"`
+}
+
+type ToolCall struct {
+	Index    *int             `json:"index,omitempty"`
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
 }
 
 type ChatCompletionResponse struct {
@@ -31,6 +84,7 @@ type ChatCompletionResponse struct {
 	Choices           []Choice `json:"choices"`
 	Usage             Usage    `json:"usage"`
 	SystemFingerprint string   `json:"system_fingerprint,omitempty"`
+	Extra             Extra    `json:"-"`
 }
 
 type Choice struct {
@@ -38,16 +92,45 @@ type Choice struct {
 	Message      *Message `json:"message,omitempty"`
 	Delta        *Delta   `json:"delta,omitempty"`
 	FinishReason *string  `json:"finish_reason"`
+	Extra        Extra    `json:"-"`
 }
 
 type Delta struct {
-	Role             string `json:"role,omitempty"`
-	Content          string `json:"content,omitempty"`
-	ReasoningContent string `json:"reasoning_content,omitempty"`
+	Role             string            `json:"role,omitempty"`
+	Content          string            `json:"content,omitempty"`
+	ReasoningContent string            `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall        `json:"tool_calls,omitempty"`
+	FunctionCall     *ToolCallFunction `json:"function_call,omitempty"`
 }
 
 type Usage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens            int                      `json:"prompt_tokens"`
+	CompletionTokens        int                      `json:"completion_tokens"`
+	TotalTokens             int                      `json:"total_tokens"`
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+	Estimated               bool                     `json:"estimated,omitempty"`
+	Extra                   Extra                    `json:"-"`
+}
+
+type CompletionTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+}
+
+type ChatCompletionChunk struct {
+	ID                string       `json:"id"`
+	Object            string       `json:"object"`
+	Created           int64        `json:"created"`
+	Model             string       `json:"model"`
+	Choices           []Choice     `json:"choices"`
+	Usage             *Usage       `json:"usage,omitempty"`
+	SystemFingerprint string       `json:"system_fingerprint,omitempty"`
+	Error             *ErrorDetail `json:"error,omitempty"`
+}
+
+// ErrorDetail is the OpenAI error object. Inside a stream it reports a
+// failure after the response headers were already sent.
+type ErrorDetail struct {
+	Message string      `json:"message"`
+	Type    string      `json:"type,omitempty"`
+	Code    interface{} `json:"code,omitempty"`
 }
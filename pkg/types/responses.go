@@ -0,0 +1,98 @@
+package types
+
+import "encoding/json"
+
+type ResponsesRequest struct {
+	Model              string                 `json:"model"`
+	Input              json.RawMessage        `json:"input"`
+	Instructions       string                 `json:"instructions,omitempty"`
+	Tools              []ResponsesTool        `json:"tools,omitempty"`
+	ToolChoice         interface{}            `json:"tool_choice,omitempty"`
+	PreviousResponseID string                 `json:"previous_response_id,omitempty"`
+	Stream             bool                   `json:"stream,omitempty"`
+	Store              *bool                  `json:"store,omitempty"`
+	Temperature        *float64               `json:"temperature,omitempty"`
+	TopP               *float64               `json:"top_p,omitempty"`
+	MaxOutputTokens    *int                   `json:"max_output_tokens,omitempty"`
+	Reasoning          *ResponsesReasoning    `json:"reasoning,omitempty"`
+	User               string                 `json:"user,omitempty"`
+	Metadata           map[string]interface{} `json:"metadata,omitempty"`
+}
+
+type ResponsesReasoning struct {
+	Effort string `json:"effort,omitempty"`
+}
+
+type ResponsesTool struct {
+	Type        string          `json:"type"`
+	Name        string          `json:"name,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	Strict      *bool           `json:"strict,omitempty"`
+}
+
+type ResponsesInputItem struct {
+	Type      string          `json:"type,omitempty"`
+	Role      string          `json:"role,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	CallID    string          `json:"call_id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Arguments string          `json:"arguments,omitempty"`
+	Output    json.RawMessage `json:"output,omitempty"`
+}
+
+type ResponsesContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+type Response struct {
+	ID                 string                 `json:"id"`
+	Object             string                 `json:"object"`
+	CreatedAt          int64                  `json:"created_at"`
+	Status             string                 `json:"status"`
+	Model              string                 `json:"model"`
+	Instructions       *string                `json:"instructions"`
+	PreviousResponseID *string                `json:"previous_response_id"`
+	Output             []ResponseOutputItem   `json:"output"`
+	Tools              []ResponsesTool        `json:"tools"`
+	ToolChoice         interface{}            `json:"tool_choice"`
+	Usage              *ResponseUsage         `json:"usage"`
+	Metadata           map[string]interface{} `json:"metadata"`
+	Error              interface{}            `json:"error"`
+}
+
+type ResponseOutputItem struct {
+	Type      string                  `json:"type"`
+	ID        string                  `json:"id"`
+	Status    string                  `json:"status,omitempty"`
+	Role      string                  `json:"role,omitempty"`
+	Content   []ResponseOutputContent `json:"content,omitempty"`
+	Summary   []ResponseSummaryText   `json:"summary,omitempty"`
+	CallID    string                  `json:"call_id,omitempty"`
+	Name      string                  `json:"name,omitempty"`
+	Arguments string                  `json:"arguments,omitempty"`
+}
+
+type ResponseOutputContent struct {
+	Type        string        `json:"type"`
+	Text        string        `json:"text"`
+	Annotations []interface{} `json:"annotations"`
+}
+
+type ResponseSummaryText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type ResponseUsage struct {
+	InputTokens         int                   `json:"input_tokens"`
+	OutputTokens        int                   `json:"output_tokens"`
+	TotalTokens         int                   `json:"total_tokens"`
+	OutputTokensDetails ResponseOutputDetails `json:"output_tokens_details"`
+}
+
+type ResponseOutputDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+}